@@ -0,0 +1,162 @@
+// Package analytics analyzes the content of cache paths, such as how their size is distributed.
+package analytics
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Entry is a file or directory with its size (the total size of the files under it in case of a directory)
+type Entry struct {
+	Path  string
+	Size  int64
+	IsDir bool
+}
+
+// Breakdown is the size of a cache path and its largest entries
+type Breakdown struct {
+	Entry
+	// Largest are the largest direct children of a directory, in descending order of size
+	Largest []Entry
+}
+
+// SizeBreakdown calculates the size of each path and lists the topN largest direct children of the directories.
+// Subdirectories are walked in parallel, concurrency limits the number of walkers (runtime.NumCPU() if not positive).
+// Symlinks are not followed and their size is not counted.
+func SizeBreakdown(paths []string, topN int, concurrency int) ([]Breakdown, error) {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	w := walker{semaphore: make(chan struct{}, concurrency)}
+
+	breakdowns := make([]Breakdown, len(paths))
+	for i, path := range paths {
+		breakdown, err := w.breakdown(path, topN)
+		if err != nil {
+			return nil, err
+		}
+		breakdowns[i] = breakdown
+	}
+	return breakdowns, nil
+}
+
+type walker struct {
+	semaphore chan struct{}
+}
+
+func (w walker) breakdown(path string, topN int) (Breakdown, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Breakdown{}, err
+	}
+	if !info.IsDir() {
+		return Breakdown{Entry: Entry{Path: path, Size: regularFileSize(info)}}, nil
+	}
+
+	children, err := os.ReadDir(path)
+	if err != nil {
+		return Breakdown{}, err
+	}
+
+	entries := make([]Entry, len(children))
+	errs := make([]error, len(children))
+	var wg sync.WaitGroup
+	for i, child := range children {
+		childPath := filepath.Join(path, child.Name())
+		entries[i] = Entry{Path: childPath, IsDir: child.IsDir()}
+		if !child.IsDir() {
+			info, err := child.Info()
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			entries[i].Size = regularFileSize(info)
+			continue
+		}
+
+		wg.Add(1)
+		w.semaphore <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-w.semaphore
+				wg.Done()
+			}()
+			entries[i].Size, errs[i] = dirSize(entries[i].Path)
+		}(i)
+	}
+	wg.Wait()
+
+	result := Breakdown{Entry: Entry{Path: path, IsDir: true}}
+	for i, entry := range entries {
+		if errs[i] != nil {
+			return Breakdown{}, errs[i]
+		}
+		result.Size += entry.Size
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Size > entries[j].Size
+	})
+	if topN < len(entries) {
+		entries = entries[:topN]
+	}
+	if len(entries) > 0 {
+		result.Largest = entries
+	}
+	return result, nil
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func regularFileSize(info fs.FileInfo) int64 {
+	if !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// Dominant returns the entries (paths and their largest children) that make up more than threshold
+// (a fraction between 0 and 1) of the total size of all paths
+func Dominant(breakdowns []Breakdown, threshold float64) []Entry {
+	var total int64
+	for _, breakdown := range breakdowns {
+		total += breakdown.Size
+	}
+	if total == 0 || len(breakdowns) == 0 {
+		return nil
+	}
+
+	var dominant []Entry
+	for _, breakdown := range breakdowns {
+		if len(breakdowns) > 1 && float64(breakdown.Size) > threshold*float64(total) {
+			dominant = append(dominant, breakdown.Entry)
+		}
+		for _, entry := range breakdown.Largest {
+			if float64(entry.Size) > threshold*float64(total) {
+				dominant = append(dominant, entry)
+			}
+		}
+	}
+	return dominant
+}
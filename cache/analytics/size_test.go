@@ -0,0 +1,71 @@
+package analytics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeBreakdown(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "cache", "large", "a.bin"), 60)
+	writeFile(t, filepath.Join(dir, "cache", "large", "nested", "b.bin"), 30)
+	writeFile(t, filepath.Join(dir, "cache", "small", "c.bin"), 5)
+	writeFile(t, filepath.Join(dir, "cache", "file.bin"), 2)
+	writeFile(t, filepath.Join(dir, "single.bin"), 3)
+	require.NoError(t, os.Symlink(filepath.Join(dir, "single.bin"), filepath.Join(dir, "cache", "link")))
+
+	// When
+	breakdowns, err := SizeBreakdown([]string{filepath.Join(dir, "cache"), filepath.Join(dir, "single.bin")}, 2, 2)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []Breakdown{
+		{
+			Entry: Entry{Path: filepath.Join(dir, "cache"), Size: 97, IsDir: true},
+			Largest: []Entry{
+				{Path: filepath.Join(dir, "cache", "large"), Size: 90, IsDir: true},
+				{Path: filepath.Join(dir, "cache", "small"), Size: 5, IsDir: true},
+			},
+		},
+		{
+			Entry: Entry{Path: filepath.Join(dir, "single.bin"), Size: 3},
+		},
+	}, breakdowns)
+}
+
+func TestSizeBreakdown_MissingPath(t *testing.T) {
+	_, err := SizeBreakdown([]string{filepath.Join(t.TempDir(), "missing")}, 1, 1)
+	require.Error(t, err)
+}
+
+func TestDominant(t *testing.T) {
+	breakdowns := []Breakdown{
+		{
+			Entry: Entry{Path: "/cache", Size: 90, IsDir: true},
+			Largest: []Entry{
+				{Path: "/cache/large", Size: 80, IsDir: true},
+				{Path: "/cache/small", Size: 10, IsDir: true},
+			},
+		},
+		{
+			Entry: Entry{Path: "/file", Size: 10},
+		},
+	}
+
+	require.Equal(t, []Entry{
+		{Path: "/cache", Size: 90, IsDir: true},
+		{Path: "/cache/large", Size: 80, IsDir: true},
+	}, Dominant(breakdowns, 0.5))
+	require.Empty(t, Dominant(breakdowns, 0.95))
+	require.Equal(t, []Entry{{Path: "/cache/large", Size: 80, IsDir: true}}, Dominant(breakdowns[:1], 0.5))
+}
+
+func writeFile(t *testing.T, path string, size int) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644))
+}
@@ -0,0 +1,111 @@
+// Package bazel helps caching Bazel's disk cache and repository cache with the cache package.
+package bazel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/keytemplate"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// DefaultDiskCacheDir is the recommended disk cache location, it has to be passed to Bazel with `--disk_cache`.
+const DefaultDiskCacheDir = "~/.cache/bazel-disk-cache"
+
+// The disk cache consists of the action cache (ac) and the content addressable storage (cas), both sharded by
+// the first two characters of the digest. Other directories (such as tmp) contain incomplete entries.
+var diskCacheDirs = []string{"ac", "cas"}
+
+// Files describing the Bazel version and the external dependencies of a workspace, in the order they appear in keys
+var workspaceFiles = []string{".bazelversion", "WORKSPACE", "WORKSPACE.bazel", "MODULE.bazel", "MODULE.bazel.lock"}
+
+// repositoryCacheDir returns the default location of Bazel's repository cache (downloaded external dependencies)
+func repositoryCacheDir() string {
+	if runtime.GOOS == "darwin" {
+		return "/private/var/tmp/_bazel_*/cache/repos"
+	}
+	return "~/.cache/bazel/_bazel_*/cache/repos"
+}
+
+// IncludePaths returns the recommended paths to cache: the complete entries of the disk cache and the repository cache.
+// Temporary files of the disk cache are left out.
+func IncludePaths(diskCacheDir string) []string {
+	var paths []string
+	for _, dir := range diskCacheDirs {
+		paths = append(paths, filepath.Join(diskCacheDir, dir))
+	}
+	return append(paths, repositoryCacheDir())
+}
+
+// KeyTemplate returns a cache key template based on the checksum of the Bazel version and workspace files found
+// in workspaceDir, such as `bazel-{{ .OS }}-{{ .Arch }}-{{ checksum "/src/.bazelversion" "/src/MODULE.bazel" }}`.
+func KeyTemplate(workspaceDir string) string {
+	var files []string
+	for _, name := range workspaceFiles {
+		path := filepath.Join(workspaceDir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			files = append(files, fmt.Sprintf("%q", path))
+		}
+	}
+
+	key := "bazel-{{ .OS }}-{{ .Arch }}"
+	if len(files) > 0 {
+		key += fmt.Sprintf("-{{ checksum %s }}", strings.Join(files, " "))
+	}
+	return key
+}
+
+// RestoreKeys returns KeyTemplate followed by its fallback keys. As the disk cache is content addressed,
+// restoring an older cache is still useful when the workspace files changed.
+func RestoreKeys(workspaceDir string) ([]string, error) {
+	return keytemplate.FallbackKeys(KeyTemplate(workspaceDir))
+}
+
+// PruneResult summarizes a disk cache pruning
+type PruneResult struct {
+	RemovedFiles int
+	RemovedBytes int64
+}
+
+// PruneDiskCache removes the disk cache entries that were not used in the last maxAge, so that stale entries
+// don't grow the cache archive forever. Bazel updates the modification time of the entries it reads.
+func PruneDiskCache(diskCacheDir string, maxAge time.Duration, logger log.Logger) (PruneResult, error) {
+	absDir, err := pathutil.NewPathModifier().AbsPath(diskCacheDir)
+	if err != nil {
+		return PruneResult{}, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	var result PruneResult
+	for _, dir := range diskCacheDirs {
+		err := filepath.Walk(filepath.Join(absDir, dir), func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+				return nil
+			}
+
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove stale entry: %w", err)
+			}
+			result.RemovedFiles++
+			result.RemovedBytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	logger.Printf("Removed %d disk cache entries not used in the last %s", result.RemovedFiles, maxAge)
+	return result, nil
+}
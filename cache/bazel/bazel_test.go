@@ -0,0 +1,101 @@
+package bazel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	emptyWorkspace := t.TempDir()
+	workspace := t.TempDir()
+	for _, name := range []string{".bazelversion", "MODULE.bazel"} {
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, name), []byte("7.1.0"), 0600))
+	}
+
+	tests := []struct {
+		name         string
+		workspaceDir string
+		want         string
+		wantRestore  []string
+	}{
+		{
+			name:         "No workspace files",
+			workspaceDir: emptyWorkspace,
+			want:         "bazel-{{ .OS }}-{{ .Arch }}",
+			wantRestore:  []string{"bazel-{{ .OS }}-{{ .Arch }}"},
+		},
+		{
+			name:         "Workspace files",
+			workspaceDir: workspace,
+			want: `bazel-{{ .OS }}-{{ .Arch }}-{{ checksum "` + filepath.Join(workspace, ".bazelversion") + `" "` +
+				filepath.Join(workspace, "MODULE.bazel") + `" }}`,
+			wantRestore: []string{
+				`bazel-{{ .OS }}-{{ .Arch }}-{{ checksum "` + filepath.Join(workspace, ".bazelversion") + `" "` +
+					filepath.Join(workspace, "MODULE.bazel") + `" }}`,
+				"bazel-{{.OS}}-{{.Arch}}-",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, KeyTemplate(tt.workspaceDir))
+
+			restoreKeys, err := RestoreKeys(tt.workspaceDir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRestore, restoreKeys)
+		})
+	}
+}
+
+func TestIncludePaths(t *testing.T) {
+	paths := IncludePaths("/disk-cache")
+
+	require.Len(t, paths, 3)
+	assert.Equal(t, []string{"/disk-cache/ac", "/disk-cache/cas"}, paths[:2])
+	assert.Contains(t, paths[2], "cache/repos")
+}
+
+func TestPruneDiskCache(t *testing.T) {
+	// Given
+	diskCacheDir := t.TempDir()
+	staleTime := time.Now().Add(-48 * time.Hour)
+	files := map[string]bool{
+		"ac/ab/stale":   true,
+		"ac/cd/fresh":   false,
+		"cas/ef/stale":  true,
+		"cas/ef/fresh":  false,
+		"tmp/untouched": false,
+	}
+	for name, stale := range files {
+		path := filepath.Join(diskCacheDir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte("entry"), 0600))
+		if stale || name == "tmp/untouched" {
+			require.NoError(t, os.Chtimes(path, staleTime, staleTime))
+		}
+	}
+
+	// When
+	result, err := PruneDiskCache(diskCacheDir, 24*time.Hour, log.NewLogger())
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, PruneResult{RemovedFiles: 2, RemovedBytes: 10}, result)
+	for name, stale := range files {
+		_, err := os.Stat(filepath.Join(diskCacheDir, name))
+		assert.Equal(t, stale, os.IsNotExist(err), name)
+	}
+}
+
+func TestPruneDiskCache_MissingDir(t *testing.T) {
+	result, err := PruneDiskCache(filepath.Join(t.TempDir(), "missing"), time.Hour, log.NewLogger())
+
+	require.NoError(t, err)
+	assert.Equal(t, PruneResult{}, result)
+}
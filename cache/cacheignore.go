@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+)
+
+const (
+	cacheIgnoreFileName = ".cacheignore"
+	sourceDirEnvVar     = "BITRISE_SOURCE_DIR"
+)
+
+// excludePatterns returns the absolute exclude patterns from SaveCacheInput.ExcludePaths, the .cacheignore file
+// of the repository (in BITRISE_SOURCE_DIR, or in the working directory if it's not set), the .cacheignore files
+// of the cached directories, and SaveCacheInput.IgnoreFiles
+func (s *saver) excludePatterns(excludePaths, cachePaths, ignoreFiles []string) ([]string, error) {
+	var patterns []string
+	for _, path := range excludePaths {
+		absPath, err := s.pathModifier.AbsPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude path %s: %w", path, err)
+		}
+		patterns = append(patterns, absPath)
+	}
+
+	root := s.envRepo.Get(sourceDirEnvVar)
+	if root == "" {
+		root = "."
+	}
+	root, err := s.pathModifier.AbsPath(root)
+	if err != nil {
+		return nil, err
+	}
+	ignoreFilePaths := []string{filepath.Join(root, cacheIgnoreFileName)}
+	for _, path := range cachePaths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() && path != root {
+			ignoreFilePaths = append(ignoreFilePaths, filepath.Join(path, cacheIgnoreFileName))
+		}
+	}
+
+	for _, path := range ignoreFiles {
+		absPath, err := s.pathModifier.AbsPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore file path %s: %w", path, err)
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			return nil, fmt.Errorf("ignore file %s: %w", path, err)
+		}
+		ignoreFilePaths = append(ignoreFilePaths, absPath)
+	}
+
+	for _, path := range ignoreFilePaths {
+		ignorePatterns, err := s.readIgnoreFile(path)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, ignorePatterns...)
+	}
+	return patterns, nil
+}
+
+// readIgnoreFile returns the patterns of an ignore file (relative to its directory), and no patterns if it doesn't exist
+func (s *saver) readIgnoreFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	patterns := parseCacheIgnore(string(content), filepath.Dir(path))
+	s.logger.Printf("Using %d exclude patterns from %s", len(patterns), path)
+	return patterns, nil
+}
+
+// parseCacheIgnore converts the lines of a .cacheignore file (gitignore syntax) to absolute "doublestar" patterns:
+// patterns with a slash are relative to root, other patterns match at any depth under root.
+// A trailing slash is ignored, so directory patterns match files with the same name too.
+// Negated patterns (!pattern) re-include the matching paths, see compression.IsExcluded.
+func parseCacheIgnore(content, root string) []string {
+	var patterns []string
+	root = filepath.ToSlash(root)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negation := ""
+		if strings.HasPrefix(line, "!") {
+			negation = "!"
+			line = strings.TrimPrefix(line, "!")
+		}
+
+		pattern := strings.TrimSuffix(line, "/")
+		if strings.Contains(pattern, "/") {
+			patterns = append(patterns, negation+root+"/"+strings.TrimPrefix(pattern, "/"))
+		} else {
+			patterns = append(patterns, negation+root+"/**/"+pattern)
+		}
+	}
+	return patterns
+}
+
+// filterExcludedPaths leaves out the cache paths that are excluded as a whole
+func (s *saver) filterExcludedPaths(paths []string, excludePatterns []string) []string {
+	if len(excludePatterns) == 0 {
+		return paths
+	}
+
+	var filtered []string
+	for _, path := range paths {
+		if compression.IsExcluded(path, excludePatterns) {
+			s.logger.Printf("Cache path is excluded: %s", path)
+			continue
+		}
+		filtered = append(filtered, path)
+	}
+	return filtered
+}
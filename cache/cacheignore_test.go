@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseCacheIgnore(t *testing.T) {
+	content := `# Build outputs
+build/
+*.tmp
+/app/intermediates
+!important.tmp
+`
+
+	patterns := parseCacheIgnore(content, "/root/project")
+
+	require.Equal(t, []string{
+		"/root/project/**/build",
+		"/root/project/**/*.tmp",
+		"/root/project/app/intermediates",
+		"!/root/project/**/important.tmp",
+	}, patterns)
+}
+
+func TestSaver_excludePatterns(t *testing.T) {
+	// Given
+	sourceDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, cacheIgnoreFileName), []byte("*.tmp\n"), 0644))
+	envRepo := fakeEnvRepo{envVars: map[string]string{sourceDirEnvVar: sourceDir}}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), nil)
+
+	// When
+	patterns, err := s.excludePatterns([]string{"/root/.gradle/caches/**/*.lock"}, nil, nil)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"/root/.gradle/caches/**/*.lock", filepath.ToSlash(sourceDir) + "/**/*.tmp"}, patterns)
+	require.Equal(t, []string{"/root/.gradle/caches"}, s.filterExcludedPaths([]string{"/root/.gradle/caches", sourceDir + "/a.tmp"}, patterns))
+}
+
+func TestSaver_excludePatterns_IgnoreFiles(t *testing.T) {
+	// Given
+	sourceDir := t.TempDir()
+	cacheDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, cacheIgnoreFileName), []byte("*.log\n!keep.log\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "ios"), 0755))
+	ignoreFile := filepath.Join(sourceDir, "ios", "pods.ignore")
+	require.NoError(t, os.WriteFile(ignoreFile, []byte("/Pods/Local\n"), 0644))
+	envRepo := fakeEnvRepo{envVars: map[string]string{sourceDirEnvVar: sourceDir}}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), nil)
+
+	// When
+	patterns, err := s.excludePatterns(nil, []string{cacheDir, filepath.Join(cacheDir, "missing")}, []string{ignoreFile})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.ToSlash(cacheDir) + "/**/*.log",
+		"!" + filepath.ToSlash(cacheDir) + "/**/keep.log",
+		filepath.ToSlash(sourceDir) + "/ios/Pods/Local",
+	}, patterns)
+
+	// When
+	_, err = s.excludePatterns(nil, nil, []string{filepath.Join(sourceDir, "missing.ignore")})
+
+	// Then
+	require.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-steputils/v2/export"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	// serviceFailuresEnvVar counts the failed cache operations of the build, it's exported for the next steps
+	serviceFailuresEnvVar = "BITRISE_CACHE_SERVICE_FAILURES"
+	// circuitBreakerThresholdEnvVar overrides the number of failures after which the cache is skipped, 0 disables it
+	circuitBreakerThresholdEnvVar  = "BITRISE_CACHE_CIRCUIT_BREAKER_THRESHOLD"
+	defaultCircuitBreakerThreshold = 2
+)
+
+// circuitBreaker skips the cache steps of the build after repeated cache service failures (such as an outage),
+// instead of each step going through its full retry cycle. The failure count is shared between the steps
+// of the build with an exported env var.
+type circuitBreaker struct {
+	envRepo    env.Repository
+	cmdFactory command.Factory
+	logger     log.Logger
+}
+
+func newCircuitBreaker(envRepo env.Repository, cmdFactory command.Factory, logger log.Logger) circuitBreaker {
+	if cmdFactory == nil {
+		cmdFactory = command.NewFactory(envRepo)
+	}
+	return circuitBreaker{envRepo: envRepo, cmdFactory: cmdFactory, logger: logger}
+}
+
+// isOpen reports whether the cache service failed too many times in the build, and the cache should be skipped
+func (b circuitBreaker) isOpen() bool {
+	threshold := b.threshold()
+	return threshold > 0 && b.failures() >= threshold
+}
+
+func (b circuitBreaker) threshold() int {
+	value := strings.TrimSpace(b.envRepo.Get(circuitBreakerThresholdEnvVar))
+	if value == "" {
+		return defaultCircuitBreakerThreshold
+	}
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 0 {
+		b.logger.Warnf("Invalid %s value: %s, using the default (%d)", circuitBreakerThresholdEnvVar, value, defaultCircuitBreakerThreshold)
+		return defaultCircuitBreakerThreshold
+	}
+	return threshold
+}
+
+func (b circuitBreaker) failures() int {
+	failures, err := strconv.Atoi(strings.TrimSpace(b.envRepo.Get(serviceFailuresEnvVar)))
+	if err != nil {
+		return 0
+	}
+	return failures
+}
+
+// recordResult counts the cache service failures, and resets the count when the service responds (including
+// a cache miss and a skipped download of an archive already restored). Errors not caused by the cache service (such as an oversized archive) are ignored.
+func (b circuitBreaker) recordResult(err error) {
+	switch {
+	case err == nil || errors.Is(err, network.ErrCacheNotFound) || errors.Is(err, network.ErrArchiveAlreadyRestored):
+		if b.failures() > 0 {
+			b.setFailures(0)
+		}
+	case isServiceFailure(err):
+		b.setFailures(b.failures() + 1)
+	}
+}
+
+func (b circuitBreaker) setFailures(failures int) {
+	value := strconv.Itoa(failures)
+	if err := b.envRepo.Set(serviceFailuresEnvVar, value); err != nil {
+		b.logger.Warnf("Failed to set %s: %s", serviceFailuresEnvVar, err)
+	}
+	exporter := export.NewExporter(b.cmdFactory)
+	if err := exporter.ExportOutput(serviceFailuresEnvVar, value); err != nil {
+		b.logger.Debugf("Failed to export %s: %s", serviceFailuresEnvVar, err)
+	}
+}
+
+// isServiceFailure reports whether the error counts as a cache service failure. Oversized archives, conflicting
+// saves (of parallel workflows) and cancellations are not caused by a failing service.
+func isServiceFailure(err error) bool {
+	return !errors.Is(err, network.ErrArchiveTooLarge) &&
+		!errors.Is(err, network.ErrConflict) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
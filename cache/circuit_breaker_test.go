@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker_recordResult(t *testing.T) {
+	// Given
+	envRepo := fakeEnvRepo{envVars: map[string]string{}}
+	breaker := newCircuitBreaker(envRepo, nil, log.NewLogger())
+
+	// When
+	breaker.recordResult(errors.New("HTTP 503: service unavailable"))
+	breaker.recordResult(fmt.Errorf("upload failed: %w", network.ErrArchiveTooLarge))
+	breaker.recordResult(fmt.Errorf("upload failed: %w", network.ErrConflict))
+
+	// Then
+	require.Equal(t, "1", envRepo.Get(serviceFailuresEnvVar))
+	require.False(t, breaker.isOpen())
+
+	// When
+	breaker.recordResult(errors.New("connection refused"))
+
+	// Then
+	require.Equal(t, "2", envRepo.Get(serviceFailuresEnvVar))
+	require.True(t, breaker.isOpen())
+
+	// When
+	breaker.recordResult(network.ErrCacheNotFound)
+
+	// Then
+	require.Equal(t, "0", envRepo.Get(serviceFailuresEnvVar))
+	require.False(t, breaker.isOpen())
+}
+
+func TestCircuitBreaker_threshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold string
+		failures  string
+		wantOpen  bool
+	}{
+		{name: "no failures", wantOpen: false},
+		{name: "default threshold", failures: "2", wantOpen: true},
+		{name: "custom threshold", threshold: "5", failures: "4", wantOpen: false},
+		{name: "disabled", threshold: "0", failures: "10", wantOpen: false},
+		{name: "invalid threshold", threshold: "never", failures: "2", wantOpen: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envRepo := fakeEnvRepo{envVars: map[string]string{
+				circuitBreakerThresholdEnvVar: tt.threshold,
+				serviceFailuresEnvVar:         tt.failures,
+			}}
+			breaker := newCircuitBreaker(envRepo, nil, log.NewLogger())
+			require.Equal(t, tt.wantOpen, breaker.isOpen())
+		})
+	}
+}
+
+func TestSaver_CircuitBreakerOpen(t *testing.T) {
+	// Given
+	envRepo := fakeEnvRepo{envVars: map[string]string{
+		"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+		"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+		serviceFailuresEnvVar:                     "3",
+	}}
+	uploader := &fakeUploader{}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), uploader, WithTracker(NewNoopTracker()))
+
+	// When
+	result, err := s.SaveWithResult(SaveCacheInput{
+		StepId:  "save-cache",
+		Key:     "cache-key-{{ .OS }}",
+		Paths:   []string{t.TempDir()},
+		Verbose: true,
+	})
+
+	// Then
+	require.NoError(t, err)
+	require.True(t, result.Skipped)
+	require.Equal(t, "cache_service_unavailable", result.SkipReason)
+	require.Empty(t, uploader.params.CacheKey)
+}
@@ -3,8 +3,16 @@ package cache
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-steputils/v2/export"
+	"github.com/bitrise-io/go-steputils/v2/stepconf"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
 )
 
 const cacheHitEnvVar = "BITRISE_CACHE_HIT"
@@ -12,6 +20,59 @@ const cacheHitEnvVar = "BITRISE_CACHE_HIT"
 // We need this prefix because there could be multiple restore steps in one workflow with multiple cache keys
 const cacheHitUniqueEnvVarPrefix = "BITRISE_CACHE_HIT__"
 
+// cacheHitEnvKey returns the env var exposing the archive checksum of the restored key, see cacheHitKey
+func cacheHitEnvKey(key string) string {
+	return cacheHitUniqueEnvVarPrefix + cacheHitKey(key)
+}
+
+// cacheHitKey returns the key as it appears in the name of its cache hit env var. Keys are free-form,
+// so the characters that are invalid in env var names (such as whitespace) are replaced.
+func cacheHitKey(key string) string {
+	return strings.TrimPrefix(export.SanitizeOutputName(cacheHitUniqueEnvVarPrefix+key), cacheHitUniqueEnvVarPrefix)
+}
+
+// Fleet-wide overrides, so that caching can be disabled or debugged without editing every workflow
+const (
+	cacheDisableEnvVar = "BITRISE_CACHE_DISABLE"
+	cacheVerboseEnvVar = "BITRISE_CACHE_VERBOSE"
+)
+
+func isEnvFlagSet(envRepo env.Repository, key string) bool {
+	value := strings.ToLower(strings.TrimSpace(envRepo.Get(key)))
+	return value == "true" || value == "1" || value == "yes"
+}
+
+// applyVerboseOverride enables debug logs if BITRISE_CACHE_VERBOSE is set, and returns the effective verbosity
+func applyVerboseOverride(envRepo env.Repository, logger log.Logger, verbose bool) bool {
+	if !isEnvFlagSet(envRepo, cacheVerboseEnvVar) {
+		return verbose
+	}
+	if !verbose {
+		logger.EnableDebugLog(true)
+		logger.Debugf("Verbose logging is enabled by %s", cacheVerboseEnvVar)
+	}
+	return true
+}
+
+// apiCredentials returns the cache API base URL and access token.
+// These are not needed (and might be undefined) when a storage backend other than the cache API is used.
+func apiCredentials(envRepo env.Repository) (stepconf.Secret, stepconf.Secret, error) {
+	if network.StorageName(envRepo) != network.BitriseStorageName {
+		return "", "", nil
+	}
+
+	apiBaseURL := envRepo.Get("BITRISEIO_ABCS_API_URL")
+	if apiBaseURL == "" {
+		return "", "", fmt.Errorf("the secret 'BITRISEIO_ABCS_API_URL' is not defined")
+	}
+	apiAccessToken := envRepo.Get("BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN")
+	if apiAccessToken == "" {
+		return "", "", fmt.Errorf("the secret 'BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN' is not defined")
+	}
+
+	return stepconf.Secret(apiBaseURL), stepconf.Secret(apiAccessToken), nil
+}
+
 func checksumOfFile(path string) (string, error) {
 	hash := sha256.New()
 
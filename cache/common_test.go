@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/stepconf"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_apiCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		envs      map[string]string
+		wantURL   stepconf.Secret
+		wantToken stepconf.Secret
+		wantErr   bool
+	}{
+		{
+			name: "API credentials",
+			envs: map[string]string{
+				"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+				"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+			},
+			wantURL:   "fake service URL",
+			wantToken: "fake access token",
+		},
+		{
+			name:    "Missing access token",
+			envs:    map[string]string{"BITRISEIO_ABCS_API_URL": "fake service URL"},
+			wantErr: true,
+		},
+		{
+			name: "Local cache directory doesn't need credentials",
+			envs: map[string]string{"BITRISEIO_DEPENDENCY_CACHE_LOCAL_DIR": "/tmp/cache"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, token, err := apiCredentials(fakeEnvRepo{envVars: tt.envs})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apiCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantURL, url)
+			assert.Equal(t, tt.wantToken, token)
+		})
+	}
+}
+
+func Test_isEnvFlagSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "", want: false},
+		{value: "false", want: false},
+		{value: "true", want: true},
+		{value: " TRUE ", want: true},
+		{value: "1", want: true},
+		{value: "yes", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			envRepo := fakeEnvRepo{envVars: map[string]string{cacheDisableEnvVar: tt.value}}
+			assert.Equal(t, tt.want, isEnvFlagSet(envRepo, cacheDisableEnvVar))
+		})
+	}
+}
+
+func Test_applyVerboseOverride(t *testing.T) {
+	verboseEnvRepo := fakeEnvRepo{envVars: map[string]string{cacheVerboseEnvVar: "true"}}
+	emptyEnvRepo := fakeEnvRepo{envVars: map[string]string{}}
+
+	assert.True(t, applyVerboseOverride(verboseEnvRepo, log.NewLogger(), false))
+	assert.True(t, applyVerboseOverride(emptyEnvRepo, log.NewLogger(), true))
+	assert.False(t, applyVerboseOverride(emptyEnvRepo, log.NewLogger(), false))
+}
+
+func TestSaver_Disabled(t *testing.T) {
+	// Given
+	envRepo := fakeEnvRepo{envVars: map[string]string{cacheDisableEnvVar: "true"}}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), nil)
+
+	// When
+	result, err := s.SaveWithResult(SaveCacheInput{Key: "my-key", Paths: []string{"/dev/null"}})
+
+	// Then
+	assert.NoError(t, err)
+	assert.Equal(t, SaveResult{Skipped: true, SkipReason: "cache_disabled"}, result)
+}
@@ -2,16 +2,21 @@ package compression
 
 import (
 	"archive/tar"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/klauspost/compress/zstd"
 )
 
@@ -38,7 +43,7 @@ func NewDependencyChecker(logger log.Logger, envRepo env.Repository) *Dependency
 
 // CheckDependencies ...
 func (dc *DependencyChecker) CheckDependencies() bool {
-	return dc.checkDepdendency("tar") && dc.checkDepdendency("zstd")
+	return dc.checkDepdendency(tarPath(dc.envRepo)) && dc.checkDepdendency(zstdPath(dc.envRepo))
 }
 
 func (dc *DependencyChecker) checkDepdendency(binaryName string) bool {
@@ -55,6 +60,10 @@ type Archiver struct {
 	logger                   log.Logger
 	envRepo                  env.Repository
 	archiveDependencyChecker ArchiveDependencyChecker
+
+	tarOnce sync.Once
+	tarInfo TarInfo
+	tarErr  error
 }
 
 // NewArchiver ...
@@ -67,49 +76,145 @@ func NewArchiver(logger log.Logger, envRepo env.Repository, archiveDependencyChe
 }
 
 // Compress creates a compressed archive from the provided files and folders using absolute paths.
+// Long-range mode is selected automatically, see CompressWithOptions.
 func (a *Archiver) Compress(archivePath string, includePaths []string, compressionLevel int, customTarArgs []string) error {
-	haveZstdAndTar := a.archiveDependencyChecker.CheckDependencies()
+	return a.CompressWithOptions(archivePath, includePaths, CompressOptions{
+		CompressionLevel: compressionLevel,
+		CustomTarArgs:    customTarArgs,
+	})
+}
 
-	if !haveZstdAndTar {
+// CompressWithOptions works like Compress, with additional zstd tuning options.
+func (a *Archiver) CompressWithOptions(archivePath string, includePaths []string, opts CompressOptions) error {
+	windowLog, err := opts.windowLog(includePaths)
+	if err != nil {
+		return err
+	}
+	if windowLog != 0 {
+		a.logger.Printf("Using zstd long-range mode with window log %d", windowLog)
+	}
+
+	var headerFilter func(*tar.Header)
+	useBinaries := a.useBinaries()
+	if opts.Deterministic {
+		epoch, err := deterministicTimestamp(a.envRepo)
+		if err != nil {
+			return err
+		}
+		headerFilter = deterministicHeader(epoch)
+		includePaths = sortedPaths(includePaths)
+		if useBinaries {
+			if tarInfo, _ := a.TarInfo(); !tarInfo.supports(tarFeatureDeterministic) {
+				a.logger.Infof("Deterministic archives are not supported by %s tar", tarInfo.Flavor)
+				useBinaries = false
+			} else {
+				opts.CustomTarArgs = append(deterministicTarArgs(epoch), opts.CustomTarArgs...)
+			}
+		}
+	}
+
+	if useBinaries && hasReincludePatterns(opts.ExcludePatterns) {
+		a.logger.Infof("Re-include (!) exclude patterns are not supported by tar")
+		useBinaries = false
+	}
+
+	if !useBinaries {
 		a.logger.Infof("Falling back to native implementation of zstd.")
-		if err := a.compressWithGoLib(archivePath, includePaths, compressionLevel); err != nil {
+		if err := a.compressWithGoLib(archivePath, includePaths, opts.CompressionLevel, windowLog, opts.ExcludePatterns, headerFilter, opts.Scan); err != nil {
 			return fmt.Errorf("compress files: %w", err)
 		}
 		return nil
 	}
 
 	a.logger.Infof("Using installed zstd binary")
-	if err := a.compressWithBinary(archivePath, includePaths, compressionLevel, customTarArgs); err != nil {
+	customTarArgs := append(tarExcludeArgs(opts.ExcludePatterns), opts.CustomTarArgs...)
+	if err := a.compressWithBinary(archivePath, includePaths, opts.CompressionLevel, customTarArgs, windowLog); err != nil {
 		return fmt.Errorf("compress files: %w", err)
 	}
 	return nil
 }
 
+// TarInfo returns the tar binary used by the Archiver (see BITRISE_CACHE_TAR_PATH), and a MissingTarFeaturesError
+// if it lacks the features needed for cache archives
+func (a *Archiver) TarInfo() (TarInfo, error) {
+	a.tarOnce.Do(func() {
+		a.tarInfo, a.tarErr = detectTar(command.NewFactory(a.envRepo), tarPath(a.envRepo))
+		a.logger.Debugf("Using %s tar: %s", a.tarInfo.Flavor, a.tarInfo.Version)
+	})
+	return a.tarInfo, a.tarErr
+}
+
+// useBinaries reports whether the tar and zstd binaries are installed and usable, otherwise the Go implementation is used
+func (a *Archiver) useBinaries() bool {
+	if !a.archiveDependencyChecker.CheckDependencies() {
+		return false
+	}
+	if _, err := a.TarInfo(); err != nil {
+		a.logger.Warnf("The tar binary can't be used: %s", err)
+		return false
+	}
+	return true
+}
+
 // Decompress takes an archive path and extracts files. This assumes an archive created with absolute file paths.
 func (a *Archiver) Decompress(archivePath string, destinationDirectory string) error {
-	haveZstdAndTar := a.archiveDependencyChecker.CheckDependencies()
-	if !haveZstdAndTar {
+	return a.DecompressPaths(archivePath, destinationDirectory, nil)
+}
+
+// DecompressPaths works like Decompress, but only extracts the archive entries matching one of the include patterns.
+// Patterns can contain "doublestar" globs (such as `/root/.gradle/caches/**`), and a pattern matching a directory
+// extracts everything under it. An empty pattern list extracts the whole archive.
+func (a *Archiver) DecompressPaths(archivePath string, destinationDirectory string, includePatterns []string) error {
+	if !a.useBinaries() {
+		a.logger.Infof("Falling back to native implementation of zstd.")
+		if err := a.decompressWithGolib(archivePath, destinationDirectory, includePatterns); err != nil {
+			return fmt.Errorf("decompress files: %w", err)
+		}
+		return nil
+	}
+
+	a.logger.Infof("Using installed zstd binary")
+	if err := a.decompressWithBinary(archivePath, nil, destinationDirectory, includePatterns); err != nil {
+		return fmt.Errorf("decompress files: %w", err)
+	}
+	return nil
+}
+
+// DecompressStream works like DecompressPaths, but reads the archive from r, so that it can be extracted while it's
+// being downloaded.
+func (a *Archiver) DecompressStream(r io.Reader, destinationDirectory string, includePatterns []string) error {
+	if !a.useBinaries() {
 		a.logger.Infof("Falling back to native implementation of zstd.")
-		if err := a.decompressWithGolib(archivePath, destinationDirectory); err != nil {
+		if err := a.decompressReaderWithGolib(r, destinationDirectory, includePatterns); err != nil {
 			return fmt.Errorf("decompress files: %w", err)
 		}
 		return nil
 	}
 
 	a.logger.Infof("Using installed zstd binary")
-	if err := a.decompressWithBinary(archivePath, destinationDirectory); err != nil {
+	if err := a.decompressWithBinary("-", r, destinationDirectory, includePatterns); err != nil {
 		return fmt.Errorf("decompress files: %w", err)
 	}
 	return nil
 }
 
-func (a *Archiver) compressWithGoLib(archivePath string, includePaths []string, compressionlevel int) error {
+// compressWithGoLib creates the archive with the Go implementation, headerFilter (if not nil) can modify the tar headers.
+// The files of the paths in scan (if not nil) are archived without walking the paths again.
+func (a *Archiver) compressWithGoLib(archivePath string, includePaths []string, compressionlevel int, windowLog int, excludePatterns []string, headerFilter func(*tar.Header), scan *Scan) error {
 	fileToWrite, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY, 0777)
 	if err != nil {
 		return fmt.Errorf("create archive file: %w", err)
 	}
 
 	opts := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compressionlevel))}
+	if windowLog != 0 {
+		// The Go implementation doesn't support windows as large as the binary does
+		windowSize := 1 << windowLog
+		if windowSize > zstd.MaxWindowSize {
+			windowSize = zstd.MaxWindowSize
+		}
+		opts = append(opts, zstd.WithWindowSize(windowSize))
+	}
 
 	zstdWriter, err := zstd.NewWriter(fileToWrite, opts...)
 	if err != nil {
@@ -117,52 +222,73 @@ func (a *Archiver) compressWithGoLib(archivePath string, includePaths []string,
 	}
 	tw := tar.NewWriter(zstdWriter)
 
-	for _, p := range includePaths {
-		path := filepath.Clean(p)
-		// walk through every file in the folder
-		if err := filepath.Walk(path, func(file string, fi os.FileInfo, e error) error {
-			// generate tar header
-			header, err := tar.FileInfoHeader(fi, file)
-			if err != nil {
-				return fmt.Errorf("create file info header: %w", err)
-			}
+	writeEntry := func(file string, fi os.FileInfo) error {
+		// generate tar header
+		header, err := tar.FileInfoHeader(fi, file)
+		if err != nil {
+			return fmt.Errorf("create file info header: %w", err)
+		}
 
-			path := filepath.Clean(file)
-			header.Name = path
+		path := filepath.Clean(file)
+		header.Name = path
 
-			var link string
-			if fi.Mode()&os.ModeSymlink != 0 {
-				if link, err = os.Readlink(file); err != nil {
-					return fmt.Errorf("read symlink: %w", err)
-				}
-			}
-			if link != "" {
-				header.Typeflag = tar.TypeSymlink
-				header.Linkname = link
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return fmt.Errorf("read symlink: %w", err)
 			}
+		}
+		if link != "" {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = link
+		}
+		if headerFilter != nil {
+			headerFilter(header)
+		}
 
-			// write header
-			if err := tw.WriteHeader(header); err != nil {
-				return fmt.Errorf("write tar file header: %w", err)
-			}
+		// write header
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("write tar file header: %w", err)
+		}
 
-			// nothing more to do for non-regular files or directories
-			if !fi.Mode().IsRegular() || fi.IsDir() {
-				return nil
-			}
+		// nothing more to do for non-regular files or directories
+		if !fi.Mode().IsRegular() || fi.IsDir() {
+			return nil
+		}
 
-			data, err := os.Open(file)
-			if err != nil {
-				return fmt.Errorf("open file: %w", err)
-			}
-			if _, err := io.Copy(tw, data); err != nil {
-				return fmt.Errorf("copy to file: %w", err)
-			}
-			if err := data.Close(); err != nil {
-				return fmt.Errorf("close file: %w", err)
+		data, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("open file: %w", err)
+		}
+		if _, err := io.Copy(tw, data); err != nil {
+			return fmt.Errorf("copy to file: %w", err)
+		}
+		if err := data.Close(); err != nil {
+			return fmt.Errorf("close file: %w", err)
+		}
+
+		return nil
+	}
+
+	for _, p := range includePaths {
+		path := filepath.Clean(p)
+		if scan != nil {
+			if files, ok := scan.Files(path); ok {
+				for _, file := range files {
+					if err := writeEntry(file.Path, file.Info); err != nil {
+						return fmt.Errorf("iterate on files: %w", err)
+					}
+				}
+				continue
 			}
+		}
 
-			return nil
+		// walk through every file in the folder
+		if err := filepath.Walk(path, func(file string, fi os.FileInfo, e error) error {
+			if skip, err := skipExcluded(file, fi, excludePatterns); skip {
+				return err
+			}
+			return writeEntry(file, fi)
 		}); err != nil {
 			return fmt.Errorf("iterate on files: %w", err)
 		}
@@ -183,7 +309,7 @@ func (a *Archiver) compressWithGoLib(archivePath string, includePaths []string,
 	return nil
 }
 
-func (a *Archiver) compressWithBinary(archivePath string, includePaths []string, compressionLevel int, customTarArgs []string) error {
+func (a *Archiver) compressWithBinary(archivePath string, includePaths []string, compressionLevel int, customTarArgs []string, windowLog int) error {
 	cmdFactory := command.NewFactory(a.envRepo)
 
 	/*
@@ -191,15 +317,20 @@ func (a *Archiver) compressWithBinary(archivePath string, includePaths []string,
 		--use-compress-program: Pipe the output to zstd instead of using the built-in gzip compression
 			--threads:0 Use CPU count threads
 			-[level]: compression level (1-19, default 3). Also use --fast if compression level is 1.
+			--long=[windowLog]: long-range mode with the given window size, if enabled
 		-P: Alias for --absolute-paths in BSD tar and --absolute-names in GNU tar (step runs on both Linux and macOS)
 			Storing absolute paths in the archive allows paths outside the current directory (such as ~/.gradle)
 		-c: Create archive
 		-f: Output file
+		--ignore-failed-read: Don't fail on files removed while creating the archive (GNU tar only)
 	*/
-	zstdArgs := fmt.Sprintf("zstd --threads=0 -%d", compressionLevel)
+	zstdArgs := fmt.Sprintf("%s --threads=0 -%d", zstdPath(a.envRepo), compressionLevel)
 	if compressionLevel == 1 {
 		zstdArgs += " --fast"
 	}
+	if windowLog != 0 {
+		zstdArgs += fmt.Sprintf(" --long=%d", windowLog)
+	}
 
 	tarArgs := []string{
 		"--use-compress-program", zstdArgs,
@@ -207,10 +338,13 @@ func (a *Archiver) compressWithBinary(archivePath string, includePaths []string,
 		"-c",
 		"-f", archivePath,
 	}
+	if tarInfo, _ := a.TarInfo(); tarInfo.supports(tarFeatureIgnoreFailedRead) {
+		tarArgs = append(tarArgs, "--ignore-failed-read")
+	}
 	tarArgs = append(tarArgs, customTarArgs...)
 	tarArgs = append(tarArgs, includePaths...)
 
-	cmd := cmdFactory.Create("tar", tarArgs, nil)
+	cmd := cmdFactory.Create(tarPath(a.envRepo), tarArgs, nil)
 
 	a.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
 
@@ -223,18 +357,29 @@ func (a *Archiver) compressWithBinary(archivePath string, includePaths []string,
 	return nil
 }
 
-func (a *Archiver) decompressWithGolib(archivePath string, destinationDirectory string) error {
+func (a *Archiver) decompressWithGolib(archivePath string, destinationDirectory string, includePatterns []string) error {
 	compressedFile, err := os.OpenFile(archivePath, os.O_RDWR, 0777)
 	if err != nil {
 		return fmt.Errorf("read file %s: %w", archivePath, err)
 	}
+	defer compressedFile.Close() //nolint:errcheck
 
-	zr, err := zstd.NewReader(compressedFile)
+	return a.decompressReaderWithGolib(compressedFile, destinationDirectory, includePatterns)
+}
+
+func (a *Archiver) decompressReaderWithGolib(r io.Reader, destinationDirectory string, includePatterns []string) error {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderMaxWindow(1<<maxWindowLog))
 	if err != nil {
 		return fmt.Errorf("create zstd reader: %w", err)
 	}
+	defer zr.Close()
 
-	tr := tar.NewReader(zr)
+	return extractTar(tar.NewReader(zr), destinationDirectory, includePatterns, nil)
+}
+
+// extractTar extracts the entries of the tar archive. rename (if not nil) maps the entry names to the paths
+// they are extracted to (before applying destinationDirectory), include patterns are matched against the mapped paths.
+func extractTar(tr *tar.Reader, destinationDirectory string, includePatterns []string, rename func(name string) string) error {
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
@@ -244,12 +389,27 @@ func (a *Archiver) decompressWithGolib(archivePath string, destinationDirectory
 			return fmt.Errorf("read tar file: %w", err)
 		}
 
+		if rename != nil {
+			header.Name = rename(header.Name)
+		}
+
+		if len(includePatterns) > 0 && !matchesAnyPattern(header.Name, includePatterns) {
+			continue
+		}
+
 		target := filepath.ToSlash(header.Name)
 
 		if destinationDirectory != "" {
 			target = filepath.Join(destinationDirectory, target)
 		}
 
+		// Parent directory entries might have been filtered out, or missing from archives of other tools
+		if (len(includePatterns) > 0 || rename != nil) && header.Typeflag != tar.TypeDir {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("create target directories: %w", err)
+			}
+		}
+
 		switch header.Typeflag {
 		// if its a dir and it doesn't exist create it (with 0755 permission)
 		case tar.TypeDir:
@@ -284,19 +444,25 @@ func (a *Archiver) decompressWithGolib(archivePath string, destinationDirectory
 	return nil
 }
 
-func (a *Archiver) decompressWithBinary(archivePath string, destinationDirectory string) error {
+// decompressWithBinary extracts the archive at archivePath, or from stdin if archivePath is "-"
+func (a *Archiver) decompressWithBinary(archivePath string, stdin io.Reader, destinationDirectory string, includePatterns []string) error {
+	if len(includePatterns) > 0 {
+		return a.decompressPatternsWithBinary(archivePath, stdin, destinationDirectory, includePatterns)
+	}
+
 	commandFactory := command.NewFactory(a.envRepo)
 
 	/*
 		tar arguments:
 		--use-compress-program: Pipe the input to zstd instead of using the built-in gzip compression
+			--long=[windowLog]: Allow decompressing archives created in long-range mode with a large window
 		-P: Alias for --absolute-paths in BSD tar and --absolute-names in GNU tar (step runs on both Linux and macOS)
 			Storing absolute paths in the archive allows paths outside the current directory (such as ~/.gradle)
 		-x: Extract archive
 		-f: Output file
 	*/
 	decompressTarArgs := []string{
-		"--use-compress-program", "zstd -d",
+		"--use-compress-program", fmt.Sprintf("%s -d --long=%d", zstdPath(a.envRepo), maxWindowLog),
 		"-x",
 		"-f", archivePath,
 		"-P",
@@ -306,7 +472,11 @@ func (a *Archiver) decompressWithBinary(archivePath string, destinationDirectory
 		decompressTarArgs = append(decompressTarArgs, "--directory", destinationDirectory)
 	}
 
-	cmd := commandFactory.Create("tar", decompressTarArgs, nil)
+	var opts *command.Opts
+	if stdin != nil {
+		opts = &command.Opts{Stdin: stdin}
+	}
+	cmd := commandFactory.Create(tarPath(a.envRepo), decompressTarArgs, opts)
 	a.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
 
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
@@ -318,6 +488,112 @@ func (a *Archiver) decompressWithBinary(archivePath string, destinationDirectory
 	return nil
 }
 
+// decompressPatternsWithBinary decompresses the archive with the zstd binary, but extracts the entries with
+// extractTar, so that the include patterns match the same entries as with the Go implementation
+// (tar has its own wildcard rules, and fails if a pattern matches no entry).
+func (a *Archiver) decompressPatternsWithBinary(archivePath string, stdin io.Reader, destinationDirectory string, includePatterns []string) error {
+	/*
+		zstd arguments:
+		-d: Decompress
+		--long=[windowLog]: Allow decompressing archives created in long-range mode with a large window
+		-c: Write to stdout
+	*/
+	args := []string{"-d", fmt.Sprintf("--long=%d", maxWindowLog), "-c", archivePath}
+
+	pr, pw := io.Pipe()
+	var stderr bytes.Buffer
+	cmd := command.NewFactory(a.envRepo).Create(zstdPath(a.envRepo), args, &command.Opts{Stdin: stdin, Stdout: pw, Stderr: &stderr})
+	a.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
+
+	cmdErr := make(chan error, 1)
+	go func() {
+		err := cmd.Run()
+		pw.CloseWithError(err) //nolint:errcheck
+		cmdErr <- err
+	}()
+
+	if err := extractTar(tar.NewReader(pr), destinationDirectory, includePatterns, nil); err != nil {
+		// Stop zstd if it's still running
+		pr.CloseWithError(err) //nolint:errcheck
+		if <-cmdErr != nil {
+			a.logger.Printf("Output: %s", strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	// tar stops reading at the end-of-archive marker, the rest of the output (padding) is drained so that zstd can exit
+	if _, err := io.Copy(io.Discard, pr); err != nil {
+		return err
+	}
+	if err := <-cmdErr; err != nil {
+		a.logger.Printf("Output: %s", strings.TrimSpace(stderr.String()))
+		return err
+	}
+	return nil
+}
+
+// matchesAnyPattern reports whether the archive entry or any of its parent directories match one of the patterns.
+func matchesAnyPattern(name string, patterns []string) bool {
+	name = strings.TrimSuffix(filepath.ToSlash(name), "/")
+	for _, pattern := range patterns {
+		for p := name; p != "" && p != "." && p != "/"; p = path.Dir(p) {
+			if match, err := doublestar.Match(pattern, p); err == nil && match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ListRoots returns the top-level paths stored in the archive (the entries without a parent directory entry),
+// which are the paths the archive was created from.
+func (a *Archiver) ListRoots(archivePath string) ([]string, error) {
+	compressedFile, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("read file %s: %w", archivePath, err)
+	}
+	defer compressedFile.Close() //nolint:errcheck
+
+	zr, err := zstd.NewReader(compressedFile, zstd.WithDecoderMaxWindow(1<<maxWindowLog))
+	if err != nil {
+		return nil, fmt.Errorf("create zstd reader: %w", err)
+	}
+	defer zr.Close()
+
+	var names []string
+	entries := map[string]bool{}
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar file: %w", err)
+		}
+
+		name := strings.TrimSuffix(filepath.ToSlash(header.Name), "/")
+		if !entries[name] {
+			entries[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var roots []string
+	for _, name := range names {
+		isRoot := true
+		for p := path.Dir(name); p != "." && p != "/"; p = path.Dir(p) {
+			if entries[p] {
+				isRoot = false
+				break
+			}
+		}
+		if isRoot {
+			roots = append(roots, filepath.FromSlash(name))
+		}
+	}
+	return roots, nil
+}
+
 // AreAllPathsEmpty checks if the provided paths are all nonexistent files or empty directories
 func AreAllPathsEmpty(includePaths []string) bool {
 	allEmpty := true
@@ -3,8 +3,13 @@ package compression
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
 )
 
 func TestAreAllPathsEmpty(t *testing.T) {
@@ -86,3 +91,277 @@ func TestAreAllPathsEmpty(t *testing.T) {
 		})
 	}
 }
+
+func Test_matchesAnyPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    string
+		patterns []string
+		want     bool
+	}{
+		{
+			name:     "exact match",
+			entry:    "/root/.gradle/caches",
+			patterns: []string{"/root/.gradle/caches"},
+			want:     true,
+		},
+		{
+			name:     "file in included directory",
+			entry:    "/root/.gradle/caches/modules-2/file.jar",
+			patterns: []string{"/root/.gradle/caches"},
+			want:     true,
+		},
+		{
+			name:     "directory entry with trailing slash",
+			entry:    "/root/.gradle/caches/",
+			patterns: []string{"/root/.gradle/caches"},
+			want:     true,
+		},
+		{
+			name:     "doublestar pattern",
+			entry:    "/root/.gradle/caches/modules-2/file.jar",
+			patterns: []string{"/root/.gradle/**/*.jar"},
+			want:     true,
+		},
+		{
+			name:     "sibling directory",
+			entry:    "/root/.gradle/wrapper/dists/gradle.zip",
+			patterns: []string{"/root/.gradle/caches"},
+			want:     false,
+		},
+		{
+			name:     "parent of included directory",
+			entry:    "/root/.gradle",
+			patterns: []string{"/root/.gradle/caches"},
+			want:     false,
+		},
+		{
+			name:     "second pattern matches",
+			entry:    "/root/.npm/_cacache/index",
+			patterns: []string{"/root/.gradle/caches", "/root/.npm"},
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesAnyPattern(tt.entry, tt.patterns); got != tt.want {
+				t.Errorf("matchesAnyPattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decompressWithGolib_includePatterns(t *testing.T) {
+	// Given
+	sourceDir := t.TempDir()
+	for _, p := range []string{"included/nested/file.txt", "excluded/file.txt"} {
+		path := filepath.Join(sourceDir, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte("hello"), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	archiver := NewArchiver(log.NewLogger(), env.NewRepository(), &ArchiveDependencyCheckerMock{})
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	if err := archiver.compressWithGoLib(archivePath, []string{sourceDir}, 3, 0, nil, nil, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// When
+	destinationDir := t.TempDir()
+	includePatterns := []string{filepath.Join(sourceDir, "included")}
+	err := archiver.decompressWithGolib(archivePath, destinationDir, includePatterns)
+
+	// Then
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(destinationDir, sourceDir, "included/nested/file.txt")); err != nil {
+		t.Errorf("included file is not extracted: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(destinationDir, sourceDir, "excluded")); !os.IsNotExist(err) {
+		t.Errorf("excluded directory is extracted")
+	}
+}
+
+func Test_decompressWithBinary_includePatterns(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+
+	// Given
+	sourceDir := t.TempDir()
+	for _, p := range []string{"included/nested/file.txt", "excluded/file.txt"} {
+		path := filepath.Join(sourceDir, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte("hello"), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	archiver := NewArchiver(log.NewLogger(), env.NewRepository(), &ArchiveDependencyCheckerMock{})
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	if err := archiver.compressWithGoLib(archivePath, []string{sourceDir}, 3, 0, nil, nil, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// When
+	destinationDir := t.TempDir()
+	includePatterns := []string{filepath.Join(sourceDir, "included/**/*.txt"), filepath.Join(sourceDir, "missing")}
+	err := archiver.decompressWithBinary(archivePath, nil, destinationDir, includePatterns)
+
+	// Then
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(destinationDir, sourceDir, "included/nested/file.txt")); err != nil {
+		t.Errorf("included file is not extracted: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(destinationDir, sourceDir, "excluded")); !os.IsNotExist(err) {
+		t.Errorf("excluded directory is extracted")
+	}
+}
+
+func TestArchiver_ListRoots(t *testing.T) {
+	// Given
+	firstDir := t.TempDir()
+	secondDir := t.TempDir()
+	for _, path := range []string{
+		filepath.Join(firstDir, "nested/file.txt"),
+		filepath.Join(secondDir, "file.txt"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte("hello"), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	singleFile := filepath.Join(secondDir, "file.txt")
+
+	archiver := NewArchiver(log.NewLogger(), env.NewRepository(), &ArchiveDependencyCheckerMock{})
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	if err := archiver.compressWithGoLib(archivePath, []string{firstDir, singleFile}, 3, 0, nil, nil, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// When
+	roots, err := archiver.ListRoots(archivePath)
+
+	// Then
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if want := []string{firstDir, singleFile}; !reflect.DeepEqual(roots, want) {
+		t.Errorf("ListRoots() = %v, want %v", roots, want)
+	}
+}
+
+func TestArchiver_DecompressStream(t *testing.T) {
+	// Given
+	sourceDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("hello"), 0700); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	archiver := NewArchiver(log.NewLogger(), env.NewRepository(), &ArchiveDependencyCheckerMock{})
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	if err := archiver.compressWithGoLib(archivePath, []string{sourceDir}, 3, 0, nil, nil, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer archive.Close() //nolint:errcheck
+
+	// When
+	destinationDir := t.TempDir()
+	err = archiver.DecompressStream(archive, destinationDir, nil)
+
+	// Then
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	content, err := ioutil.ReadFile(filepath.Join(destinationDir, sourceDir, "file.txt"))
+	if err != nil {
+		t.Fatalf("file is not extracted: %s", err)
+	}
+	if string(content) != "hello" {
+		t.Errorf("extracted content = %s, want hello", content)
+	}
+}
+
+func Test_compressWithGoLib_excludePatterns(t *testing.T) {
+	// Given
+	sourceDir := t.TempDir()
+	for _, p := range []string{"kept/file.txt", "kept/file.tmp", "build/file.txt"} {
+		path := filepath.Join(sourceDir, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte("hello"), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+
+	archiver := NewArchiver(log.NewLogger(), env.NewRepository(), &ArchiveDependencyCheckerMock{})
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	excludePatterns := []string{sourceDir + "/**/*.tmp", filepath.Join(sourceDir, "build")}
+
+	// When
+	err := archiver.compressWithGoLib(archivePath, []string{sourceDir}, 3, 0, excludePatterns, nil, nil)
+
+	// Then
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	destinationDir := t.TempDir()
+	if err := archiver.decompressWithGolib(archivePath, destinationDir, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(destinationDir, sourceDir, "kept/file.txt")); err != nil {
+		t.Errorf("kept file is not archived: %s", err)
+	}
+	for _, p := range []string{"kept/file.tmp", "build"} {
+		if _, err := os.Stat(filepath.Join(destinationDir, sourceDir, p)); !os.IsNotExist(err) {
+			t.Errorf("excluded path is archived: %s", p)
+		}
+	}
+}
+
+func Test_tarExcludeArgs(t *testing.T) {
+	got := tarExcludeArgs([]string{"/root/project/**/*.tmp", "/root/project/build"})
+
+	want := []string{"--exclude", "/root/project/*.tmp", "--exclude", "/root/project/build"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tarExcludeArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestIsExcluded_ReincludePatterns(t *testing.T) {
+	patterns := []string{"/root/project/**/*.log", "!/root/project/**/keep.log", "/root/project/build", "!/root/project/build/keep.log"}
+
+	tests := map[string]bool{
+		"/root/project/app.log":        true,
+		"/root/project/logs/keep.log":  false,
+		"/root/project/build/keep.log": true,
+		"/root/project/app.txt":        false,
+	}
+	for path, want := range tests {
+		if got := IsExcluded(path, patterns); got != want {
+			t.Errorf("IsExcluded(%s) = %v, want %v", path, got, want)
+		}
+	}
+
+	got := tarExcludeArgs(patterns)
+	want := []string{"--exclude", "/root/project/*.log", "--exclude", "/root/project/build"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tarExcludeArgs() = %v, want %v", got, want)
+	}
+}
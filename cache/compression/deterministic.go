@@ -0,0 +1,62 @@
+package compression
+
+import (
+	"archive/tar"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// sourceDateEpochEnvKey is the standard reproducible builds timestamp (https://reproducible-builds.org/specs/source-date-epoch/)
+const sourceDateEpochEnvKey = "SOURCE_DATE_EPOCH"
+
+// deterministicTimestamp returns the modification time limit of deterministic archives: SOURCE_DATE_EPOCH if it's set
+// (later modification times are clamped to it), otherwise nil (all modification times are zeroed).
+func deterministicTimestamp(envRepo env.Repository) (*time.Time, error) {
+	value := strings.TrimSpace(envRepo.Get(sourceDateEpochEnvKey))
+	if value == "" {
+		return nil, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %s", sourceDateEpochEnvKey, value)
+	}
+	epoch := time.Unix(seconds, 0)
+	return &epoch, nil
+}
+
+// deterministicHeader returns a tar header filter removing the metadata that differs between identical contents:
+// owners, access and change times, and modification times (zeroed, or clamped to epoch if it's not nil)
+func deterministicHeader(epoch *time.Time) func(*tar.Header) {
+	return func(header *tar.Header) {
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		switch {
+		case epoch == nil:
+			header.ModTime = time.Unix(0, 0)
+		case header.ModTime.After(*epoch):
+			header.ModTime = *epoch
+		}
+		header.ModTime = header.ModTime.Truncate(time.Second)
+	}
+}
+
+// deterministicTarArgs returns the GNU tar arguments producing the same archive as deterministicHeader
+func deterministicTarArgs(epoch *time.Time) []string {
+	args := []string{"--sort=name", "--owner=0", "--group=0", "--numeric-owner"}
+	if epoch == nil {
+		return append(args, "--mtime=@0")
+	}
+	return append(args, fmt.Sprintf("--mtime=@%d", epoch.Unix()), "--clamp-mtime")
+}
+
+func sortedPaths(paths []string) []string {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,90 @@
+package compression
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiver_Deterministic(t *testing.T) {
+	tests := []struct {
+		name         string
+		haveBinaries bool
+	}{
+		{name: "Go implementation", haveBinaries: false},
+		{name: "tar binary", haveBinaries: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.haveBinaries && !NewDependencyChecker(log.NewLogger(), env.NewRepository()).CheckDependencies() {
+				t.Skip("tar and zstd are not installed")
+			}
+			dependencyChecker := &ArchiveDependencyCheckerMock{CheckDependenciesFunc: func() bool { return tt.haveBinaries }}
+			archiver := NewArchiver(log.NewLogger(), env.NewRepository(), dependencyChecker)
+			if tarInfo, err := archiver.TarInfo(); tt.haveBinaries && (err != nil || tarInfo.Flavor != TarFlavorGNU) {
+				t.Skip("GNU tar is not installed")
+			}
+
+			// Given
+			dir := t.TempDir()
+			firstDir := filepath.Join(dir, "b")
+			secondDir := filepath.Join(dir, "a")
+			writeFiles(t, firstDir, secondDir)
+			paths := []string{firstDir, secondDir}
+
+			// When
+			firstChecksum := compressDeterministic(t, archiver, paths)
+			later := time.Now().Add(time.Hour)
+			require.NoError(t, os.Chtimes(filepath.Join(firstDir, "file.txt"), later, later))
+			secondChecksum := compressDeterministic(t, archiver, []string{secondDir, firstDir})
+
+			// Then
+			require.Equal(t, firstChecksum, secondChecksum)
+		})
+	}
+}
+
+func Test_deterministicHeader(t *testing.T) {
+	modTime := time.Unix(1700000000, 500)
+	epoch := time.Unix(1600000000, 0)
+	earlier := time.Unix(1500000000, 0)
+
+	header := &tar.Header{Uid: 501, Gid: 20, Uname: "vagrant", Gname: "staff", ModTime: modTime, AccessTime: modTime}
+	deterministicHeader(nil)(header)
+	require.Equal(t, &tar.Header{ModTime: time.Unix(0, 0)}, header)
+
+	header = &tar.Header{ModTime: modTime}
+	deterministicHeader(&epoch)(header)
+	require.Equal(t, epoch, header.ModTime)
+
+	header = &tar.Header{ModTime: earlier}
+	deterministicHeader(&epoch)(header)
+	require.Equal(t, earlier, header.ModTime)
+}
+
+func writeFiles(t *testing.T, dirs ...string) {
+	for _, dir := range dirs {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "nested"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content of "+filepath.Base(dir)), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "nested", "file.txt"), []byte("nested content"), 0644))
+	}
+}
+
+func compressDeterministic(t *testing.T, archiver *Archiver, paths []string) string {
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	err := archiver.CompressWithOptions(archivePath, paths, CompressOptions{CompressionLevel: 3, Deterministic: true})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	checksum := sha256.Sum256(content)
+	return hex.EncodeToString(checksum[:])
+}
@@ -0,0 +1,80 @@
+package compression
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// IsExcluded reports whether the path or any of its parent directories match one of the exclude patterns
+// (absolute "doublestar" globs, such as `/root/.gradle/caches/**/*.lock`).
+// Patterns starting with `!` re-include the paths they match (such as `!/root/.gradle/caches/**/keep.lock`),
+// but like in .gitignore files, a path can't be re-included if one of its parent directories is excluded.
+func IsExcluded(file string, excludePatterns []string) bool {
+	var excludes, includes []string
+	for _, pattern := range excludePatterns {
+		if strings.HasPrefix(pattern, "!") {
+			includes = append(includes, strings.TrimPrefix(pattern, "!"))
+		} else {
+			excludes = append(excludes, pattern)
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.ToSlash(file), "/")
+	for p := name; p != "" && p != "." && p != "/"; p = path.Dir(p) {
+		if matchesPattern(p, excludes) && !matchesPattern(p, includes) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if match, err := doublestar.Match(pattern, name); err == nil && match {
+			return true
+		}
+	}
+	return false
+}
+
+// hasReincludePatterns reports whether any of the exclude patterns re-includes paths (starts with `!`),
+// which tar can't express
+func hasReincludePatterns(excludePatterns []string) bool {
+	for _, pattern := range excludePatterns {
+		if strings.HasPrefix(pattern, "!") {
+			return true
+		}
+	}
+	return false
+}
+
+// tarExcludeArgs converts the exclude patterns to tar arguments. tar wildcards match `/` as well,
+// so `**/` (zero or more directories) becomes `*`, and `**` becomes `*`. Re-include patterns are left out,
+// see hasReincludePatterns.
+func tarExcludeArgs(excludePatterns []string) []string {
+	var args []string
+	for _, pattern := range excludePatterns {
+		if strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		pattern = strings.ReplaceAll(pattern, "**/", "*")
+		args = append(args, "--exclude", strings.ReplaceAll(pattern, "**", "*"))
+	}
+	return args
+}
+
+// skipExcluded is a filepath.WalkFunc helper: it reports whether the walked file should be skipped,
+// and the error to return from the walk function (filepath.SkipDir for an excluded directory)
+func skipExcluded(file string, fi os.FileInfo, excludePatterns []string) (bool, error) {
+	if len(excludePatterns) == 0 || !IsExcluded(file, excludePatterns) {
+		return false, nil
+	}
+	if fi != nil && fi.IsDir() {
+		return true, filepath.SkipDir
+	}
+	return true, nil
+}
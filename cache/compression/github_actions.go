@@ -0,0 +1,108 @@
+package compression
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// GitHubActionsLayout describes where the files of a GitHub Actions cache archive were on the GitHub runner and
+// where they should be restored. The archive (created by actions/cache) stores the paths relative to GITHUB_WORKSPACE,
+// so paths outside the workspace look like `../../../.npm/_cacache`.
+type GitHubActionsLayout struct {
+	// Workspace is the GITHUB_WORKSPACE of the job that saved the cache (such as /home/runner/work/repo/repo)
+	Workspace string
+	// Home is the home directory of the GitHub runner (such as /home/runner)
+	Home string
+	// TargetWorkspace is where the files under Workspace are restored to
+	TargetWorkspace string
+	// TargetHome is where the files under Home (but outside of Workspace) are restored to
+	TargetHome string
+}
+
+// targetPath returns the absolute path an archive entry is restored to. The workspace takes precedence over the home
+// directory, as the workspace is usually inside the home directory on GitHub runners.
+func (l GitHubActionsLayout) targetPath(name string) string {
+	originalPath := filepath.Join(l.Workspace, filepath.FromSlash(name))
+	if filepath.IsAbs(name) {
+		originalPath = filepath.Clean(name)
+	}
+
+	for _, mapping := range []struct{ from, to string }{
+		{l.Workspace, l.TargetWorkspace},
+		{l.Home, l.TargetHome},
+	} {
+		if mapping.from == "" || mapping.to == "" {
+			continue
+		}
+		from := filepath.Clean(mapping.from)
+		if originalPath == from {
+			return mapping.to
+		}
+		if strings.HasPrefix(originalPath, strings.TrimSuffix(from, string(filepath.Separator))+string(filepath.Separator)) {
+			return filepath.Join(mapping.to, strings.TrimPrefix(originalPath, from))
+		}
+	}
+	return originalPath
+}
+
+// DecompressGitHubActionsArchive restores a cache archive created by GitHub Actions (a zstd or gzip compressed tar),
+// mapping the paths of the GitHub runner according to the layout. includePatterns are matched against the
+// restored (absolute) paths. It always uses the native implementation, as the paths need to be rewritten.
+func (a *Archiver) DecompressGitHubActionsArchive(archivePath string, layout GitHubActionsLayout, includePatterns []string) error {
+	if layout.Workspace == "" {
+		return fmt.Errorf("the GitHub workspace of the archive is not provided")
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("open archive file %s: %w", archivePath, err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("read archive header: %w", err)
+	}
+
+	var decompressed io.Reader
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		a.logger.Debugf("Archive is zstd compressed")
+		zr, err := zstd.NewReader(reader, zstd.WithDecoderMaxWindow(1<<maxWindowLog))
+		if err != nil {
+			return fmt.Errorf("create zstd reader: %w", err)
+		}
+		defer zr.Close()
+		decompressed = zr
+	case bytes.HasPrefix(magic, gzipMagic):
+		a.logger.Debugf("Archive is gzip compressed")
+		gr, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("create gzip reader: %w", err)
+		}
+		defer gr.Close() //nolint:errcheck
+		decompressed = gr
+	default:
+		return fmt.Errorf("unknown archive compression, expected zstd or gzip")
+	}
+
+	if err := extractTar(tar.NewReader(decompressed), "", includePatterns, layout.targetPath); err != nil {
+		return fmt.Errorf("decompress files: %w", err)
+	}
+	return nil
+}
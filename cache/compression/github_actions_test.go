@@ -0,0 +1,125 @@
+package compression
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestArchiver_DecompressGitHubActionsArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress func(w io.Writer) (io.WriteCloser, error)
+	}{
+		{
+			name: "zstd",
+			compress: func(w io.Writer) (io.WriteCloser, error) {
+				return zstd.NewWriter(w)
+			},
+		},
+		{
+			name: "gzip",
+			compress: func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(w), nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+			writeGitHubActionsArchive(t, archivePath, tt.compress, map[string]string{
+				"node_modules/left-pad/index.js": "workspace",
+				"../../../.npm/_cacache/index":   "home",
+			})
+			layout := GitHubActionsLayout{
+				Workspace:       "/home/runner/work/repo/repo",
+				Home:            "/home/runner",
+				TargetWorkspace: t.TempDir(),
+				TargetHome:      t.TempDir(),
+			}
+			archiver := NewArchiver(log.NewLogger(), env.NewRepository(), &ArchiveDependencyCheckerMock{})
+
+			// When
+			err := archiver.DecompressGitHubActionsArchive(archivePath, layout, nil)
+
+			// Then
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			for path, want := range map[string]string{
+				filepath.Join(layout.TargetWorkspace, "node_modules/left-pad/index.js"): "workspace",
+				filepath.Join(layout.TargetHome, ".npm/_cacache/index"):                 "home",
+			} {
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Errorf("file is not restored: %s", err)
+				} else if string(content) != want {
+					t.Errorf("content of %s = %s, want %s", path, content, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGitHubActionsLayout_targetPath(t *testing.T) {
+	layout := GitHubActionsLayout{
+		Workspace:       "/home/runner/work/repo/repo",
+		Home:            "/home/runner",
+		TargetWorkspace: "/bitrise/src",
+		TargetHome:      "/root",
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "node_modules/a.js", want: "/bitrise/src/node_modules/a.js"},
+		{name: "../../../.gradle/caches", want: "/root/.gradle/caches"},
+		{name: "../../../../../opt/tool", want: "/opt/tool"},
+		{name: "/usr/local/share/file", want: "/usr/local/share/file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := layout.targetPath(tt.name); got != tt.want {
+				t.Errorf("targetPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeGitHubActionsArchive(t *testing.T, path string, compress func(w io.Writer) (io.WriteCloser, error), files map[string]string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer file.Close() //nolint:errcheck
+
+	cw, err := compress(file)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tw := tar.NewWriter(cw)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+}
@@ -0,0 +1,124 @@
+package compression
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+// LongRangeMode controls zstd long-range mode (long distance matching with a large window), which improves
+// the compression of huge archives containing far-apart redundancy (such as duplicated node_modules trees),
+// at the cost of more memory when compressing and decompressing.
+// Archives compressed with a window larger than 128 MB (window log 27) can't be decompressed by a plain `zstd -d`,
+// so only enable it if every restore step of the cache uses this package.
+type LongRangeMode int
+
+const (
+	// LongRangeDisabled never enables long-range mode, this is the default
+	LongRangeDisabled LongRangeMode = iota
+	// LongRangeAuto enables long-range mode for inputs larger than 1 GB, with a window size based on the input size
+	LongRangeAuto
+	// LongRangeEnabled always enables long-range mode
+	LongRangeEnabled
+)
+
+const (
+	// defaultWindowLog is the window log used by `zstd --long` (128 MB window)
+	defaultWindowLog = 27
+	// maxAutoWindowLog is the largest window log (512 MB window) selected automatically.
+	// This is also the largest window supported by the Go implementation.
+	maxAutoWindowLog = 29
+	// maxWindowLog is the largest window log supported by the zstd binary on 64-bit systems
+	maxWindowLog = 31
+	// minWindowLog is the smallest window log supported by zstd
+	minWindowLog = 10
+
+	longRangeAutoThreshold = 1 << 30
+)
+
+// CompressOptions ...
+type CompressOptions struct {
+	// CompressionLevel is the zstd compression level, between 1 and 19
+	CompressionLevel int
+	// CustomTarArgs are appended to the default tar arguments (only used with the tar binary)
+	CustomTarArgs []string
+	// LongRange selects when long-range mode is used. The default is LongRangeDisabled.
+	LongRange LongRangeMode
+	// WindowLog is the base 2 logarithm of the window size in long-range mode, between 10 and 31.
+	// If not provided (0), the default is 27, or a value based on the input size in LongRangeAuto mode.
+	WindowLog int
+	// ExcludePatterns are absolute "doublestar" globs of files and directories to leave out of the archive, see IsExcluded.
+	// Re-include patterns (starting with `!`) are not supported by tar, the Go implementation is used with them.
+	ExcludePatterns []string
+	// Deterministic creates the same archive for the same content, so that the checksums of archives can be compared:
+	// entries are sorted by name, owners are removed, and modification times are zeroed (or clamped to
+	// SOURCE_DATE_EPOCH if it's set). It requires GNU tar, the Go implementation is used with other tar binaries.
+	Deterministic bool
+	// Scan (if set) is the result of ScanPaths for the paths and ExcludePatterns. The Go implementation archives its
+	// files instead of walking the paths again.
+	Scan *Scan
+}
+
+// windowLog returns the window log to compress the provided paths with, or 0 if long-range mode should not be used.
+func (o CompressOptions) windowLog(includePaths []string) (int, error) {
+	if o.WindowLog != 0 && (o.WindowLog < minWindowLog || o.WindowLog > maxWindowLog) {
+		return 0, fmt.Errorf("window log should be between %d and %d", minWindowLog, maxWindowLog)
+	}
+
+	switch o.LongRange {
+	case LongRangeDisabled:
+		return 0, nil
+	case LongRangeEnabled:
+		if o.WindowLog != 0 {
+			return o.WindowLog, nil
+		}
+		return defaultWindowLog, nil
+	case LongRangeAuto:
+		var size int64
+		if o.Scan != nil {
+			size = o.Scan.Size()
+		} else {
+			size = inputSize(includePaths)
+		}
+		if size < longRangeAutoThreshold {
+			return 0, nil
+		}
+		if o.WindowLog != 0 {
+			return o.WindowLog, nil
+		}
+		return autoWindowLog(size), nil
+	default:
+		return 0, fmt.Errorf("unknown long-range mode: %d", o.LongRange)
+	}
+}
+
+// autoWindowLog selects a window covering 1/8 of the input, which is enough to find most of the far-apart
+// redundancy while keeping the memory usage reasonable: 1 GB -> 27 (128 MB), 2 GB -> 28, 4 GB and above -> 29 (512 MB).
+func autoWindowLog(size int64) int {
+	windowLog := bits.Len64(uint64(size-1)) - 3
+	if windowLog < defaultWindowLog {
+		return defaultWindowLog
+	}
+	if windowLog > maxAutoWindowLog {
+		return maxAutoWindowLog
+	}
+	return windowLog
+}
+
+// inputSize returns the total size of the regular files under the provided paths, it's only used without a Scan
+func inputSize(includePaths []string) int64 {
+	var size int64
+	for _, path := range includePaths {
+		_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.Mode().IsRegular() {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+	return size
+}
@@ -0,0 +1,109 @@
+package compression
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_autoWindowLog(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		want int
+	}{
+		{name: "1 GB", size: 1 << 30, want: 27},
+		{name: "1.5 GB", size: 3 << 29, want: 28},
+		{name: "2 GB", size: 2 << 30, want: 28},
+		{name: "4 GB", size: 4 << 30, want: 29},
+		{name: "32 GB", size: 32 << 30, want: 29},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, autoWindowLog(tt.size))
+		})
+	}
+}
+
+func TestCompressOptions_windowLog(t *testing.T) {
+	sourceDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("hello"), 0600))
+
+	tests := []struct {
+		name    string
+		opts    CompressOptions
+		want    int
+		wantErr bool
+	}{
+		{name: "Default", opts: CompressOptions{WindowLog: 30}, want: 0},
+		{name: "Auto mode, small input", opts: CompressOptions{LongRange: LongRangeAuto}, want: 0},
+		{name: "Auto mode, small input with window log", opts: CompressOptions{LongRange: LongRangeAuto, WindowLog: 30}, want: 0},
+		{name: "Enabled", opts: CompressOptions{LongRange: LongRangeEnabled}, want: 27},
+		{name: "Enabled with window log", opts: CompressOptions{LongRange: LongRangeEnabled, WindowLog: 31}, want: 31},
+		{name: "Disabled", opts: CompressOptions{LongRange: LongRangeDisabled, WindowLog: 30}, want: 0},
+		{name: "Invalid window log", opts: CompressOptions{LongRange: LongRangeEnabled, WindowLog: 32}, wantErr: true},
+		{name: "Unknown mode", opts: CompressOptions{LongRange: 42}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.windowLog([]string{sourceDir})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+type sizedFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i sizedFileInfo) Size() int64       { return i.size }
+func (i sizedFileInfo) Mode() os.FileMode { return 0644 }
+
+func TestCompressOptions_windowLog_UsesScanSize(t *testing.T) {
+	// Given
+	scan := &Scan{files: map[string][]ScannedFile{
+		"/cache": {{Path: "/cache/huge.bin", Info: sizedFileInfo{size: 2 << 30}}},
+	}}
+	opts := CompressOptions{LongRange: LongRangeAuto, Scan: scan}
+
+	// When
+	got, err := opts.windowLog([]string{"/cache"})
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, 28, got)
+}
+
+func Test_compressWithGoLib_longRange(t *testing.T) {
+	// Given
+	sourceDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("hello"), 0600))
+	archiver := NewArchiver(log.NewLogger(), env.NewRepository(), &ArchiveDependencyCheckerMock{})
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+
+	// When
+	err := archiver.CompressWithOptions(archivePath, []string{sourceDir}, CompressOptions{
+		CompressionLevel: 3,
+		LongRange:        LongRangeEnabled,
+		WindowLog:        31,
+	})
+
+	// Then
+	require.NoError(t, err)
+	destinationDir := t.TempDir()
+	require.NoError(t, archiver.decompressWithGolib(archivePath, destinationDir, nil))
+	content, err := os.ReadFile(filepath.Join(destinationDir, sourceDir, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+}
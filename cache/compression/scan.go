@@ -0,0 +1,164 @@
+package compression
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// ScannedFile is a file, directory or symlink found by ScanPaths, Info is the result of os.Lstat
+type ScannedFile struct {
+	Path string
+	Info fs.FileInfo
+}
+
+// Scan is the list of files under the cache paths, collected once (see ScanPaths) and shared by the steps of a save
+// that would otherwise walk the paths one by one (the disk space estimate, the empty path check and the Go
+// implementation of compression), saving hundreds of thousands of stat calls for node_modules-scale trees.
+type Scan struct {
+	// files are the files of each scanned path (the path itself first) in the order of filepath.Walk
+	files map[string][]ScannedFile
+}
+
+// ScanPaths walks the paths in parallel (concurrency limits the number of walkers, runtime.NumCPU() if not positive),
+// leaving out the excluded files and directories (see IsExcluded). Missing paths are skipped,
+// and symlinks are not followed.
+func ScanPaths(paths []string, excludePatterns []string, concurrency int) (*Scan, error) {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	s := scanner{excludePatterns: excludePatterns, semaphore: make(chan struct{}, concurrency)}
+
+	scan := &Scan{files: map[string][]ScannedFile{}}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		info, err := os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var files []ScannedFile
+		if !s.isExcluded(path) {
+			root := &scanNode{file: ScannedFile{Path: path, Info: info}}
+			if info.IsDir() {
+				if err := s.scanDir(root); err != nil {
+					return nil, err
+				}
+			}
+			files = root.flatten(nil)
+		}
+		scan.files[path] = files
+	}
+	return scan, nil
+}
+
+// Files returns the files of the scanned path (the path itself first) in the order of filepath.Walk,
+// and false if the path was not scanned
+func (s *Scan) Files(path string) ([]ScannedFile, bool) {
+	files, ok := s.files[filepath.Clean(path)]
+	return files, ok
+}
+
+// Size returns the total size of the regular files
+func (s *Scan) Size() int64 {
+	var size int64
+	for _, files := range s.files {
+		for _, file := range files {
+			if file.Info.Mode().IsRegular() {
+				size += file.Info.Size()
+			}
+		}
+	}
+	return size
+}
+
+// IsEmpty reports whether the scanned paths are all missing, excluded or empty directories, see AreAllPathsEmpty
+func (s *Scan) IsEmpty() bool {
+	for _, files := range s.files {
+		if len(files) > 1 || (len(files) == 1 && !files[0].Info.IsDir()) {
+			return false
+		}
+	}
+	return true
+}
+
+type scanner struct {
+	excludePatterns []string
+	semaphore       chan struct{}
+}
+
+type scanNode struct {
+	file     ScannedFile
+	children []*scanNode
+}
+
+func (s scanner) isExcluded(path string) bool {
+	return len(s.excludePatterns) > 0 && IsExcluded(path, s.excludePatterns)
+}
+
+func (s scanner) scanDir(node *scanNode) error {
+	entries, err := os.ReadDir(node.file.Path)
+	if err != nil {
+		return err
+	}
+
+	node.children = make([]*scanNode, 0, len(entries))
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		path := filepath.Join(node.file.Path, entry.Name())
+		if s.isExcluded(path) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// The subdirectories already being scanned are waited for below
+			errs[i] = err
+			break
+		}
+		child := &scanNode{file: ScannedFile{Path: path, Info: info}}
+		node.children = append(node.children, child)
+		if !info.IsDir() {
+			continue
+		}
+
+		// Subdirectories are scanned on a new walker if one is free, otherwise on this one,
+		// as waiting for a free walker while holding one could deadlock
+		select {
+		case s.semaphore <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-s.semaphore
+					wg.Done()
+				}()
+				errs[i] = s.scanDir(child)
+			}(i)
+		default:
+			errs[i] = s.scanDir(child)
+		}
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flatten appends the file of the node and its children (depth-first, in the lexical order of os.ReadDir)
+func (n *scanNode) flatten(files []ScannedFile) []ScannedFile {
+	files = append(files, n.file)
+	for _, child := range n.children {
+		files = child.flatten(files)
+	}
+	return files
+}
@@ -0,0 +1,121 @@
+package compression
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+func createScanTree(t *testing.T) string {
+	sourceDir := t.TempDir()
+	for _, p := range []string{"a/b/file.txt", "a/c.tmp", "a/d/e/f/file.txt", "g.txt", "h/i/file.txt"} {
+		path := filepath.Join(sourceDir, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte("hello"), 0700); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if err := os.Symlink("a", filepath.Join(sourceDir, "link")); err != nil {
+		t.Fatalf(err.Error())
+	}
+	return sourceDir
+}
+
+func TestScanPaths(t *testing.T) {
+	// Given
+	sourceDir := createScanTree(t)
+	excludePatterns := []string{sourceDir + "/**/*.tmp", filepath.Join(sourceDir, "h")}
+	missingPath := filepath.Join(sourceDir, "missing")
+
+	// When
+	scan, err := ScanPaths([]string{sourceDir, missingPath}, excludePatterns, 2)
+
+	// Then
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var want []string
+	if err := filepath.Walk(sourceDir, func(file string, fi os.FileInfo, err error) error {
+		if skip, err := skipExcluded(file, fi, excludePatterns); skip {
+			return err
+		}
+		want = append(want, file)
+		return nil
+	}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	files, ok := scan.Files(sourceDir)
+	if !ok {
+		t.Fatalf("path is not scanned: %s", sourceDir)
+	}
+	var got []string
+	for _, file := range files {
+		got = append(got, file.Path)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Files() = %v, want %v", got, want)
+	}
+	if _, ok := scan.Files(missingPath); ok {
+		t.Errorf("missing path is scanned")
+	}
+	if size := scan.Size(); size != 15 {
+		t.Errorf("Size() = %d, want 15", size)
+	}
+	if scan.IsEmpty() {
+		t.Errorf("IsEmpty() = true, want false")
+	}
+}
+
+func TestScanPaths_Empty(t *testing.T) {
+	emptyDir := t.TempDir()
+
+	scan, err := ScanPaths([]string{emptyDir, filepath.Join(emptyDir, "missing")}, nil, 0)
+
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !scan.IsEmpty() {
+		t.Errorf("IsEmpty() = false, want true")
+	}
+}
+
+func Test_compressWithGoLib_scan(t *testing.T) {
+	// Given
+	sourceDir := createScanTree(t)
+	excludePatterns := []string{sourceDir + "/**/*.tmp"}
+	scan, err := ScanPaths([]string{sourceDir}, excludePatterns, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	archiver := NewArchiver(log.NewLogger(), env.NewRepository(), &ArchiveDependencyCheckerMock{})
+	walkedArchive := filepath.Join(t.TempDir(), "walked.tzst")
+	scannedArchive := filepath.Join(t.TempDir(), "scanned.tzst")
+
+	// When
+	if err := archiver.compressWithGoLib(walkedArchive, []string{sourceDir}, 3, 0, excludePatterns, nil, nil); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := archiver.compressWithGoLib(scannedArchive, []string{sourceDir}, 3, 0, excludePatterns, nil, scan); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// Then
+	walked, err := ioutil.ReadFile(walkedArchive)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	scanned, err := ioutil.ReadFile(scannedArchive)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !reflect.DeepEqual(walked, scanned) {
+		t.Errorf("the archive created from the scan differs from the walked archive")
+	}
+}
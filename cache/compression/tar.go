@@ -0,0 +1,120 @@
+package compression
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// Overrides of the tar and zstd binaries, for example to use a GNU tar installed next to the system's BSD tar
+const (
+	tarPathEnvKey  = "BITRISE_CACHE_TAR_PATH"
+	zstdPathEnvKey = "BITRISE_CACHE_ZSTD_PATH"
+)
+
+// TarFlavor is the implementation of the tar binary
+type TarFlavor string
+
+const (
+	// TarFlavorGNU is GNU tar, the default on most Linux distributions
+	TarFlavorGNU TarFlavor = "gnu"
+	// TarFlavorBSD is bsdtar (libarchive), the default on macOS
+	TarFlavorBSD TarFlavor = "bsd"
+	// TarFlavorUnknown is any other implementation, such as BusyBox tar
+	TarFlavorUnknown TarFlavor = "unknown"
+)
+
+type tarFeature string
+
+const (
+	tarFeatureCompressProgram  tarFeature = "--use-compress-program"
+	tarFeatureAbsolutePaths    tarFeature = "-P"
+	tarFeatureIgnoreFailedRead tarFeature = "--ignore-failed-read"
+	tarFeatureDeterministic    tarFeature = "--sort, --mtime, --owner, --group"
+)
+
+// requiredTarFeatures are needed for creating and extracting cache archives with the tar binary
+var requiredTarFeatures = []tarFeature{tarFeatureCompressProgram, tarFeatureAbsolutePaths}
+
+var tarFeaturesByFlavor = map[TarFlavor][]tarFeature{
+	TarFlavorGNU: {tarFeatureCompressProgram, tarFeatureAbsolutePaths, tarFeatureIgnoreFailedRead, tarFeatureDeterministic},
+	TarFlavorBSD: {tarFeatureCompressProgram, tarFeatureAbsolutePaths},
+}
+
+// TarInfo describes the tar binary used by the Archiver
+type TarInfo struct {
+	// Path is the tar binary, as set in BITRISE_CACHE_TAR_PATH (defaults to tar on the PATH)
+	Path   string
+	Flavor TarFlavor
+	// Version is the first line of `tar --version`
+	Version string
+}
+
+func (t TarInfo) supports(feature tarFeature) bool {
+	for _, f := range tarFeaturesByFlavor[t.Flavor] {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingTarFeaturesError means that the tar binary doesn't support the features needed by the Archiver,
+// the Go implementation is used instead
+type MissingTarFeaturesError struct {
+	Tar      TarInfo
+	Features []string
+}
+
+func (e MissingTarFeaturesError) Error() string {
+	return fmt.Sprintf("%s (%s tar) doesn't support: %s", e.Tar.Path, e.Tar.Flavor, strings.Join(e.Features, ", "))
+}
+
+func tarPath(envRepo env.Repository) string {
+	return binaryPath(envRepo, tarPathEnvKey, "tar")
+}
+
+func zstdPath(envRepo env.Repository) string {
+	return binaryPath(envRepo, zstdPathEnvKey, "zstd")
+}
+
+func binaryPath(envRepo env.Repository, envKey, defaultPath string) string {
+	if path := strings.TrimSpace(envRepo.Get(envKey)); path != "" {
+		return path
+	}
+	return defaultPath
+}
+
+// detectTar identifies the tar binary by its version output, and checks that it has the required features
+func detectTar(cmdFactory command.Factory, path string) (TarInfo, error) {
+	info := TarInfo{Path: path, Flavor: TarFlavorUnknown}
+
+	// BusyBox tar doesn't know --version, its output is still useful for the error
+	out, _ := cmdFactory.Create(path, []string{"--version"}, nil).RunAndReturnTrimmedCombinedOutput()
+	info.Version = strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+	info.Flavor = parseTarFlavor(out)
+
+	var missing []string
+	for _, feature := range requiredTarFeatures {
+		if !info.supports(feature) {
+			missing = append(missing, string(feature))
+		}
+	}
+	if len(missing) > 0 {
+		return info, MissingTarFeaturesError{Tar: info, Features: missing}
+	}
+	return info, nil
+}
+
+func parseTarFlavor(versionOutput string) TarFlavor {
+	switch {
+	case strings.Contains(versionOutput, "GNU tar"):
+		return TarFlavorGNU
+	case strings.Contains(versionOutput, "bsdtar"):
+		return TarFlavorBSD
+	default:
+		return TarFlavorUnknown
+	}
+}
@@ -0,0 +1,79 @@
+package compression
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseTarFlavor(t *testing.T) {
+	tests := []struct {
+		name          string
+		versionOutput string
+		want          TarFlavor
+	}{
+		{name: "GNU tar", versionOutput: "tar (GNU tar) 1.34\nCopyright (C) 2021 Free Software Foundation, Inc.", want: TarFlavorGNU},
+		{name: "bsdtar", versionOutput: "bsdtar 3.5.3 - libarchive 3.5.3 zlib/1.2.11 liblzma/5.0.5 bz2lib/1.0.8", want: TarFlavorBSD},
+		{name: "BusyBox", versionOutput: "tar: unrecognized option '--version'\nBusyBox v1.36.1 multi-call binary.", want: TarFlavorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parseTarFlavor(tt.versionOutput))
+		})
+	}
+}
+
+func Test_detectTar(t *testing.T) {
+	// Given
+	gnuTar := fakeTar(t, "tar (GNU tar) 1.34")
+	busyBoxTar := fakeTar(t, "BusyBox v1.36.1 multi-call binary.")
+	cmdFactory := command.NewFactory(env.NewRepository())
+
+	// When
+	gnuInfo, gnuErr := detectTar(cmdFactory, gnuTar)
+	busyBoxInfo, busyBoxErr := detectTar(cmdFactory, busyBoxTar)
+
+	// Then
+	require.NoError(t, gnuErr)
+	require.Equal(t, TarInfo{Path: gnuTar, Flavor: TarFlavorGNU, Version: "tar (GNU tar) 1.34"}, gnuInfo)
+	require.True(t, gnuInfo.supports(tarFeatureIgnoreFailedRead))
+
+	var missingFeaturesErr MissingTarFeaturesError
+	require.ErrorAs(t, busyBoxErr, &missingFeaturesErr)
+	require.Equal(t, []string{"--use-compress-program", "-P"}, missingFeaturesErr.Features)
+	require.Equal(t, TarFlavorUnknown, busyBoxInfo.Flavor)
+}
+
+func TestArchiver_FallsBackWithUnsupportedTar(t *testing.T) {
+	// Given
+	t.Setenv(tarPathEnvKey, fakeTar(t, "BusyBox v1.36.1 multi-call binary."))
+	dependencyChecker := &ArchiveDependencyCheckerMock{CheckDependenciesFunc: func() bool { return true }}
+	archiver := NewArchiver(log.NewLogger(), env.NewRepository(), dependencyChecker)
+
+	dir := t.TempDir()
+	cachedFile := filepath.Join(dir, "cached.txt")
+	require.NoError(t, os.WriteFile(cachedFile, []byte("cached content"), 0644))
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+
+	// When
+	err := archiver.Compress(archivePath, []string{cachedFile}, 3, nil)
+
+	// Then
+	require.NoError(t, err)
+	roots, err := archiver.ListRoots(archivePath)
+	require.NoError(t, err)
+	require.Equal(t, []string{cachedFile}, roots)
+}
+
+// fakeTar creates a script printing versionOutput, and failing on anything else than --version
+func fakeTar(t *testing.T, versionOutput string) string {
+	path := filepath.Join(t.TempDir(), "tar")
+	script := "#!/bin/sh\nif [ \"$1\" = \"--version\" ]; then echo '" + versionOutput + "'; exit 0; fi\nexit 1\n"
+	require.NoError(t, os.WriteFile(path, []byte(script), 0755))
+	return path
+}
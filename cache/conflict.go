@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+)
+
+// ConflictPolicy is what the save does when the key was saved by another build (such as a parallel workflow) since
+// this build restored it, see SaveCacheInput.ConflictPolicy
+type ConflictPolicy int
+
+const (
+	// ConflictPolicyOverwrite turns off the conflict check, the last upload overwrites the archive of the key
+	ConflictPolicyOverwrite ConflictPolicy = iota
+	// ConflictPolicySkip skips the save, keeping the archive of the other build
+	ConflictPolicySkip
+	// ConflictPolicyRetry uploads the archive again, replacing the archive of the other build. The retry is checked
+	// against the archive reported by the cache API, so that it doesn't overwrite the save of a third build.
+	ConflictPolicyRetry
+)
+
+// uploadWithConflictCheck uploads the archive with the checksum of the restored archive of the key as the base of
+// the conflict check (an empty checksum if the key wasn't restored), and applies the conflict policy.
+// It reports whether there was a conflict.
+func (s *saver) uploadWithConflictCheck(ctx context.Context, params network.UploadParams, config saveCacheConfig) (bool, error) {
+	if config.ConflictPolicy == ConflictPolicyOverwrite {
+		return false, s.uploader.Upload(ctx, params, s.logger)
+	}
+	if config.EncryptionKey != "" {
+		// The restore exposes the checksum of the decrypted archive, which is not the checksum of the stored archive
+		s.logger.Debugf("Conflicts are not checked for encrypted archives")
+		return false, s.uploader.Upload(ctx, params, s.logger)
+	}
+
+	baseChecksum := s.getCacheHits()[cacheHitKey(config.Key)]
+	params.BaseArchiveChecksum = &baseChecksum
+	err := s.uploader.Upload(ctx, params, s.logger)
+	if !errors.Is(err, network.ErrConflict) {
+		return false, err
+	}
+	if config.ConflictPolicy != ConflictPolicyRetry {
+		return true, err
+	}
+
+	s.logger.Warnf("The key was saved by another build since the restore, uploading the archive again")
+	var apiErr *network.APIError
+	if errors.As(err, &apiErr) && apiErr.CurrentArchiveChecksum() != "" {
+		currentChecksum := apiErr.CurrentArchiveChecksum()
+		params.BaseArchiveChecksum = &currentChecksum
+	} else {
+		s.logger.Debugf("The cache API didn't report the current archive of the key, overwriting it")
+		params.BaseArchiveChecksum = nil
+	}
+	return true, s.uploader.Upload(ctx, params, s.logger)
+}
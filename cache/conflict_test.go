@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+// conflictingUploader rejects the uploads whose base checksum is not the current archive of the key
+type conflictingUploader struct {
+	currentChecksum string
+	baseChecksums   []*string
+}
+
+func (u *conflictingUploader) Upload(_ context.Context, params network.UploadParams, _ log.Logger) error {
+	u.baseChecksums = append(u.baseChecksums, params.BaseArchiveChecksum)
+	if params.BaseArchiveChecksum != nil && *params.BaseArchiveChecksum != u.currentChecksum {
+		return network.NewAPIError(http.StatusConflict, `{"current_archive_checksum":"`+u.currentChecksum+`"}`)
+	}
+	return nil
+}
+
+func TestSaver_ConflictPolicy(t *testing.T) {
+	tests := []struct {
+		name              string
+		policy            ConflictPolicy
+		wantSkipped       bool
+		wantConflict      bool
+		wantBaseChecksums []string
+	}{
+		{name: "overwrite", policy: ConflictPolicyOverwrite, wantBaseChecksums: []string{"<nil>"}},
+		{name: "skip", policy: ConflictPolicySkip, wantSkipped: true, wantConflict: true, wantBaseChecksums: []string{"restored"}},
+		{name: "retry", policy: ConflictPolicyRetry, wantConflict: true, wantBaseChecksums: []string{"restored", "newer"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			path := filepath.Join(t.TempDir(), "cached.txt")
+			require.NoError(t, os.WriteFile(path, []byte("cached content"), 0644))
+
+			envRepo := fakeEnvRepo{envVars: map[string]string{
+				"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+				"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+				cacheHitUniqueEnvVarPrefix + "test-key":   "restored",
+			}}
+			uploader := &conflictingUploader{currentChecksum: "newer"}
+			s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), uploader, WithTracker(NewNoopTracker()))
+
+			// When
+			result, err := s.SaveWithResult(SaveCacheInput{Key: "test-key", Paths: []string{path}, ConflictPolicy: tt.policy})
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSkipped, result.Skipped)
+			require.Equal(t, tt.wantConflict, result.Conflict)
+			var baseChecksums []string
+			for _, checksum := range uploader.baseChecksums {
+				if checksum == nil {
+					baseChecksums = append(baseChecksums, "<nil>")
+				} else {
+					baseChecksums = append(baseChecksums, *checksum)
+				}
+			}
+			require.Equal(t, tt.wantBaseChecksums, baseChecksums)
+		})
+	}
+}
+
+func TestSaver_ConflictPolicySkip_DoesNotCountServiceFailure(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "cached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("cached content"), 0644))
+
+	envRepo := fakeEnvRepo{envVars: map[string]string{
+		"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+		"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+		cacheHitUniqueEnvVarPrefix + "test-key":   "restored",
+		serviceFailuresEnvVar:                     "1",
+	}}
+	uploader := &conflictingUploader{currentChecksum: "newer"}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), uploader, WithTracker(NewNoopTracker()))
+
+	// When
+	result, err := s.SaveWithResult(SaveCacheInput{Key: "test-key", Paths: []string{path}, ConflictPolicy: ConflictPolicySkip})
+
+	// Then
+	require.NoError(t, err)
+	require.True(t, result.Conflict)
+	require.Equal(t, "0", envRepo.Get(serviceFailuresEnvVar))
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"errors"
+	"os"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/analytics"
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+)
+
+// LowDiskSpaceAction is what the save does when the free disk space is likely not enough for creating the archive,
+// see SaveCacheInput.LowDiskSpace
+type LowDiskSpaceAction int
+
+const (
+	// LowDiskSpaceWarn logs a warning and continues with the save
+	LowDiskSpaceWarn LowDiskSpaceAction = iota
+	// LowDiskSpaceSkip skips the save
+	LowDiskSpaceSkip
+	// LowDiskSpaceIgnore turns off the free disk space check
+	LowDiskSpaceIgnore
+)
+
+// errDiskSpaceUnknown means that the free disk space can't be queried on this platform
+var errDiskSpaceUnknown = errors.New("free disk space is not available on this platform")
+
+// freeDiskSpace is replaced in tests
+var freeDiskSpace = availableDiskSpace
+
+// hasEnoughDiskSpace estimates the space needed for creating the archive of paths in the temp dir, and compares it
+// with the free space there. The estimate is the size of the paths before compression (twice that if the archive is
+// encrypted, because the encrypted copy is written next to it), so it errs on the safe side.
+// It warns about low disk space, and reports true if the space can't be checked.
+// The size is taken from the scan of the paths if it's not nil.
+func (s *saver) hasEnoughDiskSpace(paths []string, scan *compression.Scan, encrypted bool) bool {
+	tempDir := os.TempDir()
+	free, err := freeDiskSpace(tempDir)
+	if err != nil {
+		s.logger.Debugf("Failed to check the free disk space: %s", err)
+		return true
+	}
+
+	var required int64
+	if scan != nil {
+		required = scan.Size()
+	} else {
+		breakdowns, err := analytics.SizeBreakdown(paths, 0, 0)
+		if err != nil {
+			s.logger.Debugf("Failed to estimate the size of the archive: %s", err)
+			return true
+		}
+		for _, breakdown := range breakdowns {
+			required += breakdown.Size
+		}
+	}
+	if encrypted {
+		required *= 2
+	}
+
+	s.logger.Debugf("Free disk space in %s: %s, estimated archive size: %s", tempDir, humanSize(free), humanSize(required))
+	if free >= required {
+		return true
+	}
+
+	s.logger.Println()
+	s.logger.Warnf("Low disk space: %s is free in %s, but creating the archive might need up to %s",
+		humanSize(free), tempDir, humanSize(required))
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package cache
+
+func availableDiskSpace(string) (int64, error) {
+	return 0, errDiskSpaceUnknown
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func stubFreeDiskSpace(t *testing.T, free int64, err error) {
+	freeDiskSpace = func(string) (int64, error) {
+		return free, err
+	}
+	t.Cleanup(func() { freeDiskSpace = availableDiskSpace })
+}
+
+func TestSaver_hasEnoughDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), make([]byte, 100), 0644))
+	paths := []string{filepath.Join(dir, "a.txt")}
+
+	tests := []struct {
+		name      string
+		free      int64
+		freeErr   error
+		encrypted bool
+		want      bool
+	}{
+		{name: "enough space", free: 100, want: true},
+		{name: "low space", free: 99, want: false},
+		{name: "low space for the encrypted copy", free: 150, encrypted: true, want: false},
+		{name: "unknown free space", freeErr: errDiskSpaceUnknown, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			stubFreeDiskSpace(t, tt.free, tt.freeErr)
+			s := &saver{logger: log.NewLogger()}
+
+			// When
+			got := s.hasEnoughDiskSpace(paths, nil, tt.encrypted)
+
+			// Then
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSaver_SkipOnLowDiskSpace(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("12345"), 0644))
+	stubFreeDiskSpace(t, 1, nil)
+
+	envRepo := fakeEnvRepo{envVars: map[string]string{
+		"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+		"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+		"BITRISE_GIT_COMMIT":                      "8d722f4cc4e70373bd0b42139fa428d43e0527f0",
+	}}
+	uploader := &fakeUploader{}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(),
+		uploader, WithTracker(NewNoopTracker()))
+
+	// When
+	result, err := s.SaveWithResult(SaveCacheInput{
+		Key:          "test-key-{{ .CommitHash }}",
+		Paths:        []string{filepath.Join(dir, "a.txt")},
+		LowDiskSpace: LowDiskSpaceSkip,
+	})
+
+	// Then
+	require.NoError(t, err)
+	require.True(t, result.Skipped)
+	require.Equal(t, reasonLowDiskSpace.String(), result.SkipReason)
+	require.Empty(t, uploader.params.ArchivePath)
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// defaultVolumeHelperImage is the image of the temporary containers accessing the volumes, it only needs tar
+const defaultVolumeHelperImage = "busybox"
+
+var volumeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// DockerVolumes moves the contents of named Docker volumes in and out of the cache, so that Docker based workflows
+// can cache package manager state living inside volumes.
+// A volume is exported to a tar file (with a temporary container bound to it), which is saved with the usual
+// Saver by adding its path to SaveCacheInput.Paths. After the Restorer restored the file, it's imported back into
+// the volume. File ownership and permissions are kept.
+//
+// Example:
+//
+//	path, err := volumes.Export("gradle-cache")
+//	err = saver.Save(cache.SaveCacheInput{Key: key, Paths: []string{path}})
+//	...
+//	err = restorer.Restore(cache.RestoreCacheInput{Keys: keys})
+//	err = volumes.Import("gradle-cache")
+type DockerVolumes struct {
+	cmdFactory command.Factory
+	logger     log.Logger
+	// HelperImage is the image of the temporary containers, it has to provide tar (busybox by default)
+	HelperImage string
+	// StagingDir is where the volume archives are stored between Export (or restore) and Import.
+	// It has to be the same in the saving and restoring builds, by default it's in the temp dir.
+	StagingDir string
+}
+
+// NewDockerVolumes ...
+func NewDockerVolumes(cmdFactory command.Factory, logger log.Logger) DockerVolumes {
+	return DockerVolumes{
+		cmdFactory:  cmdFactory,
+		logger:      logger,
+		HelperImage: defaultVolumeHelperImage,
+		StagingDir:  filepath.Join(os.TempDir(), "docker-volume-cache"),
+	}
+}
+
+// ArchivePath returns the path of the volume's archive, this is the path to cache
+func (v DockerVolumes) ArchivePath(volume string) string {
+	return filepath.Join(v.StagingDir, volume+".tar")
+}
+
+// Export archives the contents of the volume, and returns the path of the archive
+func (v DockerVolumes) Export(volume string) (string, error) {
+	if err := validateVolumeName(volume); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(v.StagingDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create staging dir: %w", err)
+	}
+
+	path := v.ArchivePath(volume)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create volume archive: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	v.logger.Printf("Exporting Docker volume %s", volume)
+	if err := v.run(v.exportCommand(volume, file)); err != nil {
+		return "", fmt.Errorf("failed to export volume %s: %w", volume, err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write volume archive: %w", err)
+	}
+	return path, nil
+}
+
+// Import extracts the restored archive of the volume into the volume, creating the volume if it doesn't exist.
+// It returns os.ErrNotExist if there is no restored archive for the volume (for example on a cache miss).
+func (v DockerVolumes) Import(volume string) error {
+	if err := validateVolumeName(volume); err != nil {
+		return err
+	}
+
+	file, err := os.Open(v.ArchivePath(volume))
+	if err != nil {
+		return fmt.Errorf("no archive of volume %s: %w", volume, err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	v.logger.Printf("Importing Docker volume %s", volume)
+	if err := v.run(v.cmdFactory.Create("docker", []string{"volume", "create", volume}, nil)); err != nil {
+		return fmt.Errorf("failed to create volume %s: %w", volume, err)
+	}
+	if err := v.run(v.importCommand(volume, file)); err != nil {
+		return fmt.Errorf("failed to import volume %s: %w", volume, err)
+	}
+	return nil
+}
+
+func (v DockerVolumes) exportCommand(volume string, archive *os.File) command.Command {
+	args := []string{"run", "--rm", "-v", volume + ":/volume:ro", v.HelperImage, "tar", "-cf", "-", "-C", "/volume", "."}
+	return v.cmdFactory.Create("docker", args, &command.Opts{Stdout: archive})
+}
+
+func (v DockerVolumes) importCommand(volume string, archive *os.File) command.Command {
+	args := []string{"run", "--rm", "-i", "-v", volume + ":/volume", v.HelperImage, "tar", "-xpf", "-", "-C", "/volume"}
+	return v.cmdFactory.Create("docker", args, &command.Opts{Stdin: archive})
+}
+
+func (v DockerVolumes) run(cmd command.Command) error {
+	v.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
+	return cmd.Run()
+}
+
+func validateVolumeName(volume string) error {
+	if !volumeNameRegex.MatchString(volume) {
+		return fmt.Errorf("invalid Docker volume name: %s", volume)
+	}
+	return nil
+}
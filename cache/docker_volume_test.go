@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerVolumes_commands(t *testing.T) {
+	// Given
+	volumes := NewDockerVolumes(command.NewFactory(env.NewRepository()), log.NewLogger())
+	volumes.HelperImage = "alpine"
+
+	// When
+	exportCmd := volumes.exportCommand("gradle-cache", nil)
+	importCmd := volumes.importCommand("gradle-cache", nil)
+
+	// Then
+	require.Equal(t, `docker "run" "--rm" "-v" "gradle-cache:/volume:ro" "alpine" "tar" "-cf" "-" "-C" "/volume" "."`, exportCmd.PrintableCommandArgs())
+	require.Equal(t, `docker "run" "--rm" "-i" "-v" "gradle-cache:/volume" "alpine" "tar" "-xpf" "-" "-C" "/volume"`, importCmd.PrintableCommandArgs())
+}
+
+func TestDockerVolumes_InvalidVolumeName(t *testing.T) {
+	volumes := NewDockerVolumes(command.NewFactory(env.NewRepository()), log.NewLogger())
+
+	for _, name := range []string{"", "-volume", "../volume", "vol:/host"} {
+		_, err := volumes.Export(name)
+		require.Error(t, err, name)
+		require.Error(t, volumes.Import(name), name)
+	}
+}
+
+func TestDockerVolumes_ImportWithoutArchive(t *testing.T) {
+	// Given
+	volumes := NewDockerVolumes(command.NewFactory(env.NewRepository()), log.NewLogger())
+	volumes.StagingDir = t.TempDir()
+
+	// When
+	err := volumes.Import("gradle-cache")
+
+	// Then
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Equal(t, filepath.Join(volumes.StagingDir, "gradle-cache.tar"), volumes.ArchivePath("gradle-cache"))
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const encryptionKeyEnvVar = "BITRISEIO_DEPENDENCY_CACHE_ENCRYPTION_KEY"
+
+// Encrypted archive format:
+// magic | 8 byte random nonce prefix | chunks...
+// Each chunk is a 4 byte big-endian header (the highest bit marks the final chunk, the rest is the ciphertext length)
+// followed by the AES-256-GCM sealed chunk. The nonce of a chunk is the nonce prefix followed by the 4 byte chunk index,
+// and the final chunk flag is authenticated as additional data, so reordered, dropped or truncated chunks are detected.
+var encryptionMagic = []byte("BRCENC1\n")
+
+const (
+	encryptionChunkSize   = 64 * 1024
+	encryptionNoncePrefix = 8
+	finalChunkFlag        = uint32(1 << 31)
+)
+
+// ErrDecryptionFailed means that the archive was encrypted with a different key, or it is corrupted
+var ErrDecryptionFailed = errors.New("failed to decrypt cache archive, the encryption key might be wrong")
+
+// encryptionKey derives the AES-256 key from the user-provided key
+func encryptionKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+func newGCM(key string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encryptionKey(key))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, encryptionNoncePrefix+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNoncePrefix:], index)
+	return nonce
+}
+
+func finalChunkAdditionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encrypt streams src into dst as an encrypted archive
+func encrypt(dst io.Writer, src io.Reader, key string) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, encryptionNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	if _, err := dst.Write(encryptionMagic); err != nil {
+		return err
+	}
+	if _, err := dst.Write(prefix); err != nil {
+		return err
+	}
+
+	// One chunk is read ahead, to know which chunk is the final one
+	reader := bufio.NewReaderSize(src, encryptionChunkSize)
+	plaintext := make([]byte, encryptionChunkSize)
+	header := make([]byte, 4)
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(reader, plaintext)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, peekErr := reader.Peek(1)
+		final := peekErr != nil
+		if final && peekErr != io.EOF {
+			return peekErr
+		}
+
+		ciphertext := gcm.Seal(nil, chunkNonce(prefix, index), plaintext[:n], finalChunkAdditionalData(final))
+		length := uint32(len(ciphertext))
+		if final {
+			length |= finalChunkFlag
+		}
+		binary.BigEndian.PutUint32(header, length)
+		if _, err := dst.Write(header); err != nil {
+			return err
+		}
+		if _, err := dst.Write(ciphertext); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+// decrypt streams the encrypted archive src into dst
+func decrypt(dst io.Writer, src io.Reader, key string) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(src, magic); err != nil || string(magic) != string(encryptionMagic) {
+		return fmt.Errorf("%w: the archive is not encrypted", ErrDecryptionFailed)
+	}
+	prefix := make([]byte, encryptionNoncePrefix)
+	if _, err := io.ReadFull(src, prefix); err != nil {
+		return fmt.Errorf("%w: %s", ErrDecryptionFailed, err)
+	}
+
+	header := make([]byte, 4)
+	maxLength := uint32(encryptionChunkSize + gcm.Overhead())
+	for index := uint32(0); ; index++ {
+		if _, err := io.ReadFull(src, header); err != nil {
+			return fmt.Errorf("%w: archive is truncated", ErrDecryptionFailed)
+		}
+		length := binary.BigEndian.Uint32(header)
+		final := length&finalChunkFlag != 0
+		length &^= finalChunkFlag
+		if length > maxLength {
+			return fmt.Errorf("%w: invalid chunk size", ErrDecryptionFailed)
+		}
+
+		ciphertext := make([]byte, length)
+		if _, err := io.ReadFull(src, ciphertext); err != nil {
+			return fmt.Errorf("%w: archive is truncated", ErrDecryptionFailed)
+		}
+		plaintext, err := gcm.Open(nil, chunkNonce(prefix, index), ciphertext, finalChunkAdditionalData(final))
+		if err != nil {
+			return ErrDecryptionFailed
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+// encryptFile encrypts the file at path to a new file next to it, and returns the new file's path
+func encryptFile(path, key string) (string, error) {
+	return transformFile(path, path+".enc", key, encrypt)
+}
+
+// decryptFile decrypts the file at path to a new file next to it, and returns the new file's path
+func decryptFile(path, key string) (string, error) {
+	return transformFile(path, path+".dec", key, decrypt)
+}
+
+func transformFile(srcPath, dstPath, key string, transform func(io.Writer, io.Reader, string) error) (string, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close() //nolint:errcheck
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return "", err
+	}
+
+	writer := bufio.NewWriter(dst)
+	if err := transform(writer, bufio.NewReader(src), key); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(dstPath)
+		return "", err
+	}
+	if err := writer.Flush(); err != nil {
+		_ = dst.Close()
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+
+	return dstPath, nil
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_encryptDecrypt(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "Empty archive", size: 0},
+		{name: "Smaller than a chunk", size: 100},
+		{name: "Exactly one chunk", size: encryptionChunkSize},
+		{name: "Multiple chunks", size: 3*encryptionChunkSize + 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			plaintext := make([]byte, tt.size)
+			_, err := rand.Read(plaintext)
+			require.NoError(t, err)
+
+			// When
+			var encrypted bytes.Buffer
+			require.NoError(t, encrypt(&encrypted, bytes.NewReader(plaintext), "secret"))
+			var decrypted bytes.Buffer
+			err = decrypt(&decrypted, bytes.NewReader(encrypted.Bytes()), "secret")
+
+			// Then
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(plaintext, decrypted.Bytes()))
+			if tt.size > 0 {
+				assert.False(t, bytes.Contains(encrypted.Bytes(), plaintext))
+			}
+		})
+	}
+}
+
+func Test_decrypt_Errors(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("cache content"), encryptionChunkSize/4)
+	var encrypted bytes.Buffer
+	require.NoError(t, encrypt(&encrypted, bytes.NewReader(plaintext), "secret"))
+
+	tests := []struct {
+		name  string
+		input []byte
+		key   string
+	}{
+		{name: "Wrong key", input: encrypted.Bytes(), key: "other secret"},
+		{name: "Truncated archive", input: encrypted.Bytes()[:encrypted.Len()-100], key: "secret"},
+		{name: "Dropped final chunk", input: encrypted.Bytes()[:len(encryptionMagic)+encryptionNoncePrefix+4+encryptionChunkSize+16], key: "secret"},
+		{name: "Unencrypted archive", input: plaintext, key: "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decrypted bytes.Buffer
+			err := decrypt(&decrypted, bytes.NewReader(tt.input), tt.key)
+
+			assert.ErrorIs(t, err, ErrDecryptionFailed)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package cache_test
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/go-steputils/v2/cache"
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+func ExampleNewSaver() {
+	envRepo := env.NewRepository()
+	logger := log.NewLogger()
+
+	// A nil uploader selects the storage backend by env vars (the Bitrise cache API by default)
+	saver := cache.NewSaver(envRepo, logger, pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), nil)
+	result, err := saver.SaveWithResult(cache.SaveCacheInput{
+		StepId:      "save-gradle-cache",
+		Key:         `gradle-{{ .OS }}-{{ checksum "**/*.gradle*" }}`,
+		Paths:       []string{"~/.gradle/caches", "~/.gradle/wrapper"},
+		IsKeyUnique: true,
+	})
+	if err != nil {
+		logger.Errorf("Failed to save cache: %s", err)
+		return
+	}
+	fmt.Printf("skipped: %t, reason: %s\n", result.Skipped, result.SkipReason)
+}
+
+func ExampleNewRestorer() {
+	envRepo := env.NewRepository()
+	logger := log.NewLogger()
+
+	// LocalStorage keeps the archives in a directory, which is handy for trying out a step locally
+	storage := network.LocalStorage{RootDir: "/tmp/cache-archives"}
+	restorer := cache.NewRestorer(envRepo, logger, command.NewFactory(envRepo), storage)
+	result, err := restorer.RestoreWithResult(cache.RestoreCacheInput{
+		StepId:               "restore-gradle-cache",
+		Keys:                 []string{`gradle-{{ .OS }}-{{ checksum "**/*.gradle*" }}`},
+		GenerateFallbackKeys: true,
+	})
+	if err != nil {
+		logger.Errorf("Failed to restore cache: %s", err)
+		return
+	}
+	// The cache hit is also exported as BITRISE_CACHE_HIT for the next steps
+	fmt.Printf("hit: %s, key: %s\n", result.Hit, result.MatchedKey)
+}
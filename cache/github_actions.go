@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// ArchiveFormat is the format of the restored cache archive
+type ArchiveFormat string
+
+const (
+	// ArchiveFormatBitrise is the format of the archives created by Saver
+	ArchiveFormatBitrise ArchiveFormat = ""
+	// ArchiveFormatGitHubActions is the format of the archives created by the actions/cache GitHub Action,
+	// for restoring caches imported from GitHub Actions without a cold cache period
+	ArchiveFormatGitHubActions ArchiveFormat = "github-actions"
+)
+
+// gitHubActionsLayout returns where the files of a GitHub Actions cache archive are restored to: files of the GitHub
+// workspace go to the source dir (or the current working directory), files of the runner's home go to the home dir.
+func (r *restorer) gitHubActionsLayout(gitHubWorkspace string) (compression.GitHubActionsLayout, error) {
+	if gitHubWorkspace == "" {
+		return compression.GitHubActionsLayout{}, fmt.Errorf("GitHubActionsWorkspace is required for restoring GitHub Actions archives")
+	}
+
+	targetWorkspace := r.envRepo.Get("BITRISE_SOURCE_DIR")
+	if targetWorkspace == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return compression.GitHubActionsLayout{}, err
+		}
+		targetWorkspace = wd
+	}
+	targetWorkspace, err := pathutil.NewPathModifier().AbsPath(targetWorkspace)
+	if err != nil {
+		return compression.GitHubActionsLayout{}, err
+	}
+
+	targetHome, err := os.UserHomeDir()
+	if err != nil {
+		return compression.GitHubActionsLayout{}, err
+	}
+
+	return compression.GitHubActionsLayout{
+		Workspace:       filepath.Clean(gitHubWorkspace),
+		Home:            gitHubRunnerHome(gitHubWorkspace),
+		TargetWorkspace: targetWorkspace,
+		TargetHome:      targetHome,
+	}, nil
+}
+
+// gitHubRunnerHome returns the home directory of the GitHub runner, based on the workspace path
+// (which is $HOME/work/<repo>/<repo> on hosted runners)
+func gitHubRunnerHome(gitHubWorkspace string) string {
+	workspace := filepath.ToSlash(filepath.Clean(gitHubWorkspace))
+	if i := strings.LastIndex(workspace, "/work/"); i > 0 {
+		return filepath.FromSlash(workspace[:i])
+	}
+	if runtime.GOOS == "darwin" {
+		return "/Users/runner"
+	}
+	return "/home/runner"
+}
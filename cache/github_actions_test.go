@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"runtime"
+	"testing"
+)
+
+func Test_gitHubRunnerHome(t *testing.T) {
+	defaultHome := "/home/runner"
+	if runtime.GOOS == "darwin" {
+		defaultHome = "/Users/runner"
+	}
+
+	tests := []struct {
+		name      string
+		workspace string
+		want      string
+	}{
+		{
+			name:      "Hosted Linux runner",
+			workspace: "/home/runner/work/repo/repo",
+			want:      "/home/runner",
+		},
+		{
+			name:      "Hosted macOS runner",
+			workspace: "/Users/runner/work/repo/repo/",
+			want:      "/Users/runner",
+		},
+		{
+			name:      "Custom workspace",
+			workspace: "/src",
+			want:      defaultHome,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gitHubRunnerHome(tt.workspace); got != tt.want {
+				t.Errorf("gitHubRunnerHome() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
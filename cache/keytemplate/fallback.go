@@ -0,0 +1,120 @@
+package keytemplate
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Template parts ordered from the most specific to the least specific one.
+// Fallback keys are created by cutting the template at the first occurrence of these.
+var fallbackCutGroups = [][]string{
+	{"checksum", "CommitHash"},
+	{"Branch"},
+}
+
+// FallbackKeys returns an ordered list of restore keys for a single key template: the template itself, followed by
+// shorter and shorter prefixes of it. Each prefix drops the part starting with a checksum or commit hash, then the
+// part starting with the branch, so that a restore can fall back to the most recent related cache entry
+// (restore keys are matched by prefix).
+// Example: `npm-{{ .Branch }}-{{ checksum "package-lock.json" }}` results in
+// `npm-{{ .Branch }}-{{ checksum "package-lock.json" }}`, `npm-{{.Branch}}-` and `npm-`.
+func FallbackKeys(keyTemplate string) ([]string, error) {
+	funcMap := template.FuncMap{
+		"getenv":   func(string) string { return "" },
+		"checksum": func(...string) string { return "" },
+	}
+	tmpl, err := template.New("").Funcs(funcMap).Parse(keyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	keys := []string{keyTemplate}
+	if tmpl.Tree == nil || tmpl.Tree.Root == nil {
+		return keys, nil
+	}
+
+	nodes := tmpl.Tree.Root.Nodes
+	previousCut := len(nodes)
+	for _, group := range fallbackCutGroups {
+		cut := firstNodeUsing(nodes, group)
+		if cut >= previousCut {
+			continue
+		}
+		previousCut = cut
+
+		key := joinNodes(nodes[:cut])
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func firstNodeUsing(nodes []parse.Node, identifiers []string) int {
+	for i, node := range nodes {
+		for _, used := range identifiersOf(node) {
+			for _, identifier := range identifiers {
+				if used == identifier {
+					return i
+				}
+			}
+		}
+	}
+	return len(nodes)
+}
+
+func joinNodes(nodes []parse.Node) string {
+	var b strings.Builder
+	for _, node := range nodes {
+		b.WriteString(node.String())
+	}
+	return b.String()
+}
+
+// identifiersOf returns the function names and fields (such as `checksum` or `Branch`) referenced by a template node
+func identifiersOf(node parse.Node) []string {
+	var identifiers []string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			identifiers = append(identifiers, identifiersOf(child)...)
+		}
+	case *parse.ActionNode:
+		identifiers = identifiersOf(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			identifiers = append(identifiers, identifiersOf(cmd)...)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			identifiers = append(identifiers, identifiersOf(arg)...)
+		}
+	case *parse.IdentifierNode:
+		identifiers = append(identifiers, n.Ident)
+	case *parse.FieldNode:
+		identifiers = append(identifiers, n.Ident...)
+	case *parse.IfNode:
+		identifiers = append(identifiers, identifiersOfBranch(&n.BranchNode)...)
+	case *parse.RangeNode:
+		identifiers = append(identifiers, identifiersOfBranch(&n.BranchNode)...)
+	case *parse.WithNode:
+		identifiers = append(identifiers, identifiersOfBranch(&n.BranchNode)...)
+	}
+	return identifiers
+}
+
+func identifiersOfBranch(n *parse.BranchNode) []string {
+	identifiers := identifiersOf(n.Pipe)
+	identifiers = append(identifiers, identifiersOf(n.List)...)
+	return append(identifiers, identifiersOf(n.ElseList)...)
+}
@@ -0,0 +1,77 @@
+package keytemplate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFallbackKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		keyTemplate string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name:        "Static key",
+			keyTemplate: "my-cache-key",
+			want:        []string{"my-cache-key"},
+		},
+		{
+			name:        "Checksum key",
+			keyTemplate: `npm-{{ .OS }}-{{ checksum "package-lock.json" }}`,
+			want: []string{
+				`npm-{{ .OS }}-{{ checksum "package-lock.json" }}`,
+				"npm-{{.OS}}-",
+			},
+		},
+		{
+			name:        "Branch and checksum key",
+			keyTemplate: `npm-{{ .Branch }}-{{ checksum "package-lock.json" }}`,
+			want: []string{
+				`npm-{{ .Branch }}-{{ checksum "package-lock.json" }}`,
+				"npm-{{.Branch}}-",
+				"npm-",
+			},
+		},
+		{
+			name:        "Checksum before branch",
+			keyTemplate: `npm-{{ checksum "package-lock.json" }}-{{ .Branch }}`,
+			want: []string{
+				`npm-{{ checksum "package-lock.json" }}-{{ .Branch }}`,
+				"npm-",
+			},
+		},
+		{
+			name:        "Commit hash and branch in a condition",
+			keyTemplate: `gradle-{{ .Workflow }}-{{ if .Branch }}{{ .Branch }}{{ end }}-{{ .CommitHash }}`,
+			want: []string{
+				`gradle-{{ .Workflow }}-{{ if .Branch }}{{ .Branch }}{{ end }}-{{ .CommitHash }}`,
+				"gradle-{{.Workflow}}-{{if .Branch}}{{.Branch}}{{end}}-",
+				"gradle-{{.Workflow}}-",
+			},
+		},
+		{
+			name:        "Nothing left before the checksum",
+			keyTemplate: `{{ checksum "package-lock.json" }}`,
+			want:        []string{`{{ checksum "package-lock.json" }}`},
+		},
+		{
+			name:        "Invalid template",
+			keyTemplate: "npm-{{ .Branch",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FallbackKeys(tt.keyTemplate)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FallbackKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FallbackKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
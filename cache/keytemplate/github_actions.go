@@ -0,0 +1,66 @@
+package keytemplate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	gitHubExpressionPattern = regexp.MustCompile(`\$\{\{\s*(.*?)\s*\}\}`)
+	gitHubHashFilesPattern  = regexp.MustCompile(`^hashFiles\((.*)\)$`)
+	gitHubEnvPattern        = regexp.MustCompile(`^env\.([A-Za-z_][A-Za-z0-9_]*)$`)
+	gitHubStringArgPattern  = regexp.MustCompile(`'((?:[^']|'')*)'`)
+)
+
+// GitHub Actions context values and the template variables with the same meaning
+var gitHubContextVariables = map[string]string{
+	"runner.os":       "{{ .OS }}",
+	"runner.arch":     "{{ .Arch }}",
+	"github.workflow": "{{ .Workflow }}",
+	"github.ref_name": "{{ .Branch }}",
+	"github.head_ref": "{{ .Branch }}",
+	"github.sha":      "{{ .CommitHash }}",
+}
+
+// FromGitHubActionsKey converts an actions/cache key (such as `${{ runner.os }}-npm-${{ hashFiles('**/package-lock.json') }}`)
+// to a key template (`{{ .OS }}-npm-{{ checksum "**/package-lock.json" }}`), to ease migrating workflows.
+// The evaluated key is not the same as on GitHub (for example .OS is `linux` instead of `Linux` and the checksum
+// algorithm is different), so existing GitHub caches are only matched if they are imported with the converted key.
+func FromGitHubActionsKey(key string) (string, error) {
+	var conversionErr error
+	converted := gitHubExpressionPattern.ReplaceAllStringFunc(key, func(expression string) string {
+		template, err := fromGitHubExpression(gitHubExpressionPattern.FindStringSubmatch(expression)[1])
+		if err != nil && conversionErr == nil {
+			conversionErr = err
+		}
+		return template
+	})
+	if conversionErr != nil {
+		return "", conversionErr
+	}
+	return converted, nil
+}
+
+func fromGitHubExpression(expression string) (string, error) {
+	if variable, ok := gitHubContextVariables[expression]; ok {
+		return variable, nil
+	}
+
+	if match := gitHubEnvPattern.FindStringSubmatch(expression); match != nil {
+		return fmt.Sprintf("{{ getenv %q }}", match[1]), nil
+	}
+
+	if match := gitHubHashFilesPattern.FindStringSubmatch(expression); match != nil {
+		var paths []string
+		for _, arg := range gitHubStringArgPattern.FindAllStringSubmatch(match[1], -1) {
+			paths = append(paths, fmt.Sprintf("%q", strings.ReplaceAll(arg[1], "''", "'")))
+		}
+		if len(paths) == 0 {
+			return "", fmt.Errorf("hashFiles without file patterns: %s", expression)
+		}
+		return fmt.Sprintf("{{ checksum %s }}", strings.Join(paths, " ")), nil
+	}
+
+	return "", fmt.Errorf("unsupported GitHub Actions expression: %s", expression)
+}
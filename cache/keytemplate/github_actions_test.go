@@ -0,0 +1,50 @@
+package keytemplate
+
+import "testing"
+
+func TestFromGitHubActionsKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Static key",
+			key:  "my-cache-key",
+			want: "my-cache-key",
+		},
+		{
+			name: "Runner OS and hashFiles",
+			key:  "${{ runner.os }}-npm-${{ hashFiles('**/package-lock.json') }}",
+			want: `{{ .OS }}-npm-{{ checksum "**/package-lock.json" }}`,
+		},
+		{
+			name: "hashFiles with multiple patterns",
+			key:  "gradle-${{hashFiles('**/*.gradle*', '**/gradle-wrapper.properties')}}",
+			want: `gradle-{{ checksum "**/*.gradle*" "**/gradle-wrapper.properties" }}`,
+		},
+		{
+			name: "Context and env values",
+			key:  "${{ runner.arch }}-${{ github.ref_name }}-${{ github.sha }}-${{ env.CACHE_VERSION }}",
+			want: `{{ .Arch }}-{{ .Branch }}-{{ .CommitHash }}-{{ getenv "CACHE_VERSION" }}`,
+		},
+		{
+			name:    "Unsupported expression",
+			key:     "${{ matrix.node }}-npm",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromGitHubActionsKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FromGitHubActionsKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("FromGitHubActionsKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package keytemplate
+
+import "strings"
+
+const (
+	stackIDEnvKey      = "BITRISEIO_STACK_ID"
+	xcodeVersionEnvKey = "BITRISE_XCODE_VERSION"
+)
+
+// KeyScope configures the scope data added to evaluated cache keys, so that caches are only restored on compatible
+// runners (for example a Linux-built cache is not restored on a macOS runner).
+// The scope is added as a prefix, because restore keys are matched by prefix: a scoped fallback key still matches
+// the scoped keys starting with it.
+type KeyScope struct {
+	// Namespace is a custom scope, such as the name of a project in a monorepo
+	Namespace string
+	// Stack adds the stack ID (BITRISEIO_STACK_ID)
+	Stack bool
+	// OS adds the operating system (.OS)
+	OS bool
+	// Arch adds the CPU architecture (.Arch)
+	Arch bool
+	// XcodeVersion adds the Xcode version (BITRISE_XCODE_VERSION), it's left out if not set (such as on Linux stacks)
+	XcodeVersion bool
+}
+
+// ScopePrefix returns the prefix of the scoped keys, such as `my-project-osx-xcode-15.0.x-darwin-arm64-`.
+// Empty scope values are left out.
+func (m Model) ScopePrefix(scope KeyScope) string {
+	var parts []string
+	add := func(name, value string) {
+		if value == "" {
+			m.logger.Debugf("Key scope %s is empty, leaving it out", name)
+			return
+		}
+		parts = append(parts, value)
+	}
+
+	if scope.Namespace != "" {
+		add("namespace", scope.Namespace)
+	}
+	if scope.Stack {
+		add("stack", m.envRepo.Get(stackIDEnvKey))
+	}
+	if scope.XcodeVersion {
+		add("Xcode version", m.envRepo.Get(xcodeVersionEnvKey))
+	}
+	if scope.OS {
+		add("OS", m.os)
+	}
+	if scope.Arch {
+		add("arch", m.arch)
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, "-") + "-"
+}
@@ -0,0 +1,52 @@
+package keytemplate
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+func TestModel_ScopePrefix(t *testing.T) {
+	envVars := map[string]string{
+		"BITRISEIO_STACK_ID":    "osx-xcode-15.0.x",
+		"BITRISE_XCODE_VERSION": "15.0.1",
+	}
+	tests := []struct {
+		name    string
+		scope   KeyScope
+		envVars map[string]string
+		want    string
+	}{
+		{
+			name:    "No scope",
+			scope:   KeyScope{},
+			envVars: envVars,
+			want:    "",
+		},
+		{
+			name:    "Full scope",
+			scope:   KeyScope{Namespace: "ios-app", Stack: true, OS: true, Arch: true, XcodeVersion: true},
+			envVars: envVars,
+			want:    "ios-app-osx-xcode-15.0.x-15.0.1-darwin-arm64-",
+		},
+		{
+			name:    "Missing scope values are left out",
+			scope:   KeyScope{Stack: true, OS: true, XcodeVersion: true},
+			envVars: map[string]string{},
+			want:    "darwin-",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := Model{
+				envRepo: envRepository{envVars: tt.envVars},
+				logger:  log.NewLogger(),
+				os:      "darwin",
+				arch:    "arm64",
+			}
+			if got := model.ScopePrefix(tt.scope); got != tt.want {
+				t.Errorf("ScopePrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
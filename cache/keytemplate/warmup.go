@@ -0,0 +1,50 @@
+package keytemplate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// WarmupKeyPrefix starts the keys of the warm-up archives.
+//
+// Warm-up archives are saved by a scheduled "builder" workflow (for example a nightly build of the default branch),
+// so that the first build of a new branch or pull request doesn't start with an empty cache. The conventions are:
+//   - the scheduled workflow saves the cache with the key WarmupKey(name), such as `warmup-npm-{{ .CommitHash }}`,
+//   - the regular workflows restore their own keys first, and set RestoreCacheInput.WarmupName to the same name,
+//     so that WarmupRestoreKey(name) (`warmup-npm-`) is tried after all of their keys,
+//   - as restore keys are matched by prefix, and the most recently saved match wins, the warm-up restore key
+//     matches the freshest warm-up archive.
+//
+// The warm-up keys of different caches must not be prefixes of each other, this is why names can't contain `-`.
+const WarmupKeyPrefix = "warmup-"
+
+var warmupNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// WarmupKey returns the key template of the warm-up archive of a cache, see WarmupKeyPrefix.
+// The parts are joined with `-` after the prefix, for example WarmupKey("npm", `{{ checksum "package-lock.json" }}`)
+// returns `warmup-npm-{{ checksum "package-lock.json" }}`. Without parts the key ends with the commit hash,
+// so that each scheduled build saves a new archive.
+func WarmupKey(name string, parts ...string) (string, error) {
+	prefix, err := WarmupRestoreKey(name)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 0 {
+		parts = []string{"{{ .CommitHash }}"}
+	}
+	return prefix + strings.Join(parts, "-"), nil
+}
+
+// WarmupRestoreKey returns the restore key matching the freshest warm-up archive of a cache, see WarmupKeyPrefix
+func WarmupRestoreKey(name string) (string, error) {
+	if !warmupNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid warm-up cache name: %q, use only letters, digits, `_` and `.`", name)
+	}
+	return WarmupKeyPrefix + name + "-", nil
+}
+
+// IsWarmupKey reports whether the (evaluated, unscoped) key belongs to a warm-up archive
+func IsWarmupKey(key string) bool {
+	return strings.HasPrefix(key, WarmupKeyPrefix)
+}
@@ -0,0 +1,47 @@
+package keytemplate
+
+import (
+	"testing"
+)
+
+func TestWarmupKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		cache   string
+		parts   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "Commit hash by default", cache: "npm", want: "warmup-npm-{{ .CommitHash }}"},
+		{name: "Custom parts", cache: "gradle", parts: []string{"{{ .OS }}", `{{ checksum "**/*.gradle*" }}`}, want: `warmup-gradle-{{ .OS }}-{{ checksum "**/*.gradle*" }}`},
+		{name: "Name with dash", cache: "npm-ci", wantErr: true},
+		{name: "Empty name", cache: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WarmupKey(tt.cache, tt.parts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WarmupKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("WarmupKey() = %s, want %s", got, tt.want)
+			}
+			if !tt.wantErr && !IsWarmupKey(got) {
+				t.Errorf("IsWarmupKey(%s) = false, want true", got)
+			}
+		})
+	}
+}
+
+func TestWarmupRestoreKey(t *testing.T) {
+	restoreKey, err := WarmupRestoreKey("npm")
+	if err != nil {
+		t.Fatalf("WarmupRestoreKey() error = %v", err)
+	}
+	if restoreKey != "warmup-npm-" {
+		t.Errorf("WarmupRestoreKey() = %s, want warmup-npm-", restoreKey)
+	}
+	if IsWarmupKey("npm-main-abc") {
+		t.Errorf("IsWarmupKey(npm-main-abc) = true, want false")
+	}
+}
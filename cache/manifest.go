@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+)
+
+// manifestDir is where the manifests are stored inside the archive, see manifestPath
+const manifestDir = "~/.bitrise/cache-manifests"
+
+// manifestPath returns the path of the manifest of the archive saved with the (evaluated) key. The name is unique to
+// the key, so that the restore step finds the manifest of the matched archive after extraction, and not the manifest
+// of another cache. The file is removed after the archive is created and after the restore.
+func manifestPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return manifestDir + "/" + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// Manifest describes the files of a cache archive. It can be used to verify restored content and to compare
+// two versions of the same cache.
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is a single regular file in the cache archive
+type ManifestEntry struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// ManifestDiff lists the file paths that changed between two manifests
+type ManifestDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// IsEmpty returns true if the two compared manifests describe the same content
+func (d ManifestDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// NewManifest walks the provided absolute paths and records every regular file with its size and SHA-256 checksum.
+// Directories and symlinks are not recorded.
+func NewManifest(paths []string) (Manifest, error) {
+	return newManifest(paths, nil, nil)
+}
+
+// newManifest records the regular files of the scan, so that the manifest lists the same files as the archive.
+// If the scan is nil, the paths are scanned with the exclude patterns.
+func newManifest(paths []string, scan *compression.Scan, excludePatterns []string) (Manifest, error) {
+	if scan == nil {
+		var err error
+		if scan, err = compression.ScanPaths(paths, excludePatterns, 0); err != nil {
+			return Manifest{}, fmt.Errorf("failed to scan paths: %w", err)
+		}
+	}
+
+	var files []ManifestEntry
+	for _, p := range paths {
+		scannedFiles, _ := scan.Files(p)
+		for _, file := range scannedFiles {
+			if !file.Info.Mode().IsRegular() {
+				continue
+			}
+
+			checksum, err := checksumOfFile(file.Path)
+			if err != nil {
+				return Manifest{}, err
+			}
+			files = append(files, ManifestEntry{
+				Path:     file.Path,
+				Size:     file.Info.Size(),
+				Checksum: checksum,
+			})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	return Manifest{Files: files}, nil
+}
+
+// ReadManifest reads a manifest previously written with Manifest.Write
+func ReadManifest(path string) (Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// Write saves the manifest as JSON to the provided path, creating the parent directories if needed
+func (m Manifest) Write(path string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// Verify compares the files on disk with the manifest and returns the paths that are missing or have different content
+func (m Manifest) Verify() []string {
+	var mismatches []string
+	for _, entry := range m.Files {
+		info, err := os.Stat(entry.Path)
+		if err != nil || info.Size() != entry.Size {
+			mismatches = append(mismatches, entry.Path)
+			continue
+		}
+
+		checksum, err := checksumOfFile(entry.Path)
+		if err != nil || checksum != entry.Checksum {
+			mismatches = append(mismatches, entry.Path)
+		}
+	}
+	return mismatches
+}
+
+// Diff returns the changes needed to get from the `other` manifest to this one
+func (m Manifest) Diff(other Manifest) ManifestDiff {
+	otherChecksums := map[string]string{}
+	for _, entry := range other.Files {
+		otherChecksums[entry.Path] = entry.Checksum
+	}
+
+	var diff ManifestDiff
+	for _, entry := range m.Files {
+		checksum, ok := otherChecksums[entry.Path]
+		if !ok {
+			diff.Added = append(diff.Added, entry.Path)
+		} else if checksum != entry.Checksum {
+			diff.Changed = append(diff.Changed, entry.Path)
+		}
+		delete(otherChecksums, entry.Path)
+	}
+	for path := range otherChecksums {
+		diff.Removed = append(diff.Removed, path)
+	}
+	sort.Strings(diff.Removed)
+
+	return diff
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewManifest(t *testing.T) {
+	testdataAbsPath, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	manifest, err := NewManifest([]string{testdataAbsPath})
+	require.NoError(t, err)
+
+	assert.Equal(t, []ManifestEntry{
+		{
+			Path:     filepath.Join(testdataAbsPath, "dummy_file.txt"),
+			Size:     9,
+			Checksum: "9a30a503b2862c51c3c5acd7fbce2f1f784cf4658ccf8e87d5023a90c21c0714",
+		},
+		{
+			Path:     filepath.Join(testdataAbsPath, "subfolder", "nested_file.txt"),
+			Size:     11,
+			Checksum: "2aba0fa4e3b6aa9f23baaa5f838c3475142f33dc42dc3da3a4ec4b01ac2e5beb",
+		},
+	}, manifest.Files)
+}
+
+func Test_newManifest_LeavesOutExcludedFiles(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cached.txt"), []byte("cached"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "build"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "build", "output.o"), []byte("excluded"), 0644))
+
+	// When
+	manifest, err := newManifest([]string{dir}, nil, []string{filepath.Join(dir, "build")})
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 1)
+	assert.Equal(t, filepath.Join(dir, "cached.txt"), manifest.Files[0].Path)
+}
+
+func TestManifest_WriteAndRead(t *testing.T) {
+	manifest := Manifest{Files: []ManifestEntry{
+		{Path: "/root/.gradle/caches/file.jar", Size: 10, Checksum: "abc"},
+	}}
+	path := filepath.Join(t.TempDir(), "nested", "manifest.json")
+
+	require.NoError(t, manifest.Write(path))
+	got, err := ReadManifest(path)
+
+	require.NoError(t, err)
+	assert.Equal(t, manifest, got)
+}
+
+func TestManifest_Verify(t *testing.T) {
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	changed := filepath.Join(dir, "changed.txt")
+	missing := filepath.Join(dir, "missing.txt")
+	for _, path := range []string{unchanged, changed, missing} {
+		require.NoError(t, os.WriteFile(path, []byte("original"), 0644))
+	}
+	manifest, err := NewManifest([]string{dir})
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(changed, []byte("modified"), 0644))
+	require.NoError(t, os.Remove(missing))
+
+	assert.Equal(t, []string{changed, missing}, manifest.Verify())
+}
+
+func TestManifest_Diff(t *testing.T) {
+	old := Manifest{Files: []ManifestEntry{
+		{Path: "/a", Checksum: "1"},
+		{Path: "/b", Checksum: "2"},
+		{Path: "/c", Checksum: "3"},
+	}}
+	current := Manifest{Files: []ManifestEntry{
+		{Path: "/a", Checksum: "1"},
+		{Path: "/b", Checksum: "changed"},
+		{Path: "/d", Checksum: "4"},
+	}}
+
+	diff := current.Diff(old)
+
+	assert.Equal(t, ManifestDiff{
+		Added:   []string{"/d"},
+		Removed: []string{"/c"},
+		Changed: []string{"/b"},
+	}, diff)
+	assert.False(t, diff.IsEmpty())
+	assert.True(t, old.Diff(old).IsEmpty())
+}
+
+func Test_manifestPath(t *testing.T) {
+	assert.NotEqual(t, manifestPath("key-1"), manifestPath("key-2"))
+	assert.Equal(t, manifestPath("key-1"), manifestPath("key-1"))
+	assert.Equal(t, manifestDir, filepath.Dir(manifestPath("key-1")))
+}
+
+func TestSaver_GenerateManifest_RemovesManifest(t *testing.T) {
+	// Given
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "cached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("cached content"), 0644))
+
+	envRepo := fakeEnvRepo{envVars: map[string]string{
+		"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+		"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+	}}
+	uploader := &conflictingUploader{}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), uploader, WithTracker(NewNoopTracker()))
+
+	// When
+	_, err := s.SaveWithResult(SaveCacheInput{Key: "test-key", Paths: []string{path}, GenerateManifest: true})
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, uploader.baseChecksums, 1)
+	manifestFile, err := pathutil.NewPathModifier().AbsPath(manifestPath("test-key"))
+	require.NoError(t, err)
+	require.NoFileExists(t, manifestFile)
+}
@@ -0,0 +1,323 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/progress"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	// metricsDirEnvVar is the fallback of SaveCacheInput.MetricsDir and RestoreCacheInput.MetricsDir
+	metricsDirEnvVar = "BITRISE_CACHE_METRICS_DIR"
+	// MetricsFileName is the Prometheus textfile written into the metrics dir
+	MetricsFileName = "bitrise_cache.prom"
+)
+
+type metricFamily struct {
+	name string
+	kind string
+	help string
+}
+
+// metricFamilies are the metrics of the textfile, in the order they are written
+var metricFamilies = []metricFamily{
+	{name: "bitrise_cache_operations_total", kind: "counter", help: "Number of cache saves and restores."},
+	{name: "bitrise_cache_restore_hits_total", kind: "counter", help: "Number of cache restores that matched a key, by hit type."},
+	{name: "bitrise_cache_restore_misses_total", kind: "counter", help: "Number of cache restores that didn't match any key."},
+	{name: "bitrise_cache_save_skips_total", kind: "counter", help: "Number of skipped cache saves and uploads, by reason."},
+	{name: "bitrise_cache_uploaded_bytes_total", kind: "counter", help: "Size of the uploaded cache archives in bytes."},
+	{name: "bitrise_cache_downloaded_bytes_total", kind: "counter", help: "Size of the downloaded cache archives in bytes."},
+	{name: "bitrise_cache_retries_total", kind: "counter", help: "Number of retried requests and phases, by phase."},
+	{name: "bitrise_cache_phase_duration_seconds", kind: "histogram", help: "Duration of the compression, upload, download and extraction phases."},
+}
+
+// metricsDurationBuckets are the upper bounds (in seconds) of the phase duration histogram buckets
+var metricsDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800}
+
+// metricsCollector collects the metrics of a save or a restore (as its Tracker and progress.Reporter), and writes them
+// into a Prometheus textfile (for node_exporter's textfile collector) when the operation finishes.
+// The metrics are added to the values of the existing file, so that the counters keep growing on persistent runners.
+type metricsCollector struct {
+	Tracker
+	reporter  progress.Reporter
+	dir       string
+	operation UsageOperation
+	logger    log.Logger
+
+	mu      sync.Mutex
+	samples metricSamples
+}
+
+func newMetricsCollector(dir string, operation UsageOperation, tracker Tracker, reporter progress.Reporter, logger log.Logger) *metricsCollector {
+	c := &metricsCollector{
+		Tracker:   tracker,
+		reporter:  reporter,
+		dir:       dir,
+		operation: operation,
+		logger:    logger,
+	}
+	c.add("bitrise_cache_operations_total", c.labels(), 1)
+	return c
+}
+
+// metricsDir returns the dir of the metrics textfile, or an empty string if metrics are disabled
+func metricsDir(inputDir, envDir string) string {
+	if inputDir != "" {
+		return inputDir
+	}
+	return strings.TrimSpace(envDir)
+}
+
+// LogArchiveCompressed ...
+func (c *metricsCollector) LogArchiveCompressed(compressionTime time.Duration, pathCount int) {
+	c.observeDuration(progress.PhaseCompression, compressionTime)
+	c.Tracker.LogArchiveCompressed(compressionTime, pathCount)
+}
+
+// LogArchiveUploaded ...
+func (c *metricsCollector) LogArchiveUploaded(uploadTime time.Duration, archiveSize int64, pathCount int) {
+	c.observeDuration(progress.PhaseUpload, uploadTime)
+	c.add("bitrise_cache_uploaded_bytes_total", c.labels(), float64(archiveSize))
+	c.Tracker.LogArchiveUploaded(uploadTime, archiveSize, pathCount)
+}
+
+// LogArchiveDownloaded ...
+func (c *metricsCollector) LogArchiveDownloaded(downloadTime time.Duration, archiveSize int64, keyCount int) {
+	c.observeDuration(progress.PhaseDownload, downloadTime)
+	c.add("bitrise_cache_downloaded_bytes_total", c.labels(), float64(archiveSize))
+	c.Tracker.LogArchiveDownloaded(downloadTime, archiveSize, keyCount)
+}
+
+// LogArchiveExtracted ...
+func (c *metricsCollector) LogArchiveExtracted(extractionTime time.Duration, keyCount int) {
+	c.observeDuration(progress.PhaseExtraction, extractionTime)
+	c.Tracker.LogArchiveExtracted(extractionTime, keyCount)
+}
+
+// LogRestoreResult ...
+func (c *metricsCollector) LogRestoreResult(isMatch bool, matchedKey string, evaluatedKeys []string) {
+	switch {
+	case !isMatch:
+		c.add("bitrise_cache_restore_misses_total", c.labels(), 1)
+	case len(evaluatedKeys) > 0 && matchedKey == evaluatedKeys[0]:
+		c.add("bitrise_cache_restore_hits_total", c.labels("hit", string(CacheHitExact)), 1)
+	default:
+		c.add("bitrise_cache_restore_hits_total", c.labels("hit", string(CacheHitPartial)), 1)
+	}
+	c.Tracker.LogRestoreResult(isMatch, matchedKey, evaluatedKeys)
+}
+
+// LogSkipSaveResult ...
+func (c *metricsCollector) LogSkipSaveResult(isSaveSkipped bool, reason string) {
+	if isSaveSkipped {
+		c.add("bitrise_cache_save_skips_total", c.labels("stage", "save", "reason", reason), 1)
+	}
+	c.Tracker.LogSkipSaveResult(isSaveSkipped, reason)
+}
+
+// LogSkipUploadResult ...
+func (c *metricsCollector) LogSkipUploadResult(isUploadSkipped bool, reason string) {
+	if isUploadSkipped {
+		c.add("bitrise_cache_save_skips_total", c.labels("stage", "upload", "reason", reason), 1)
+	}
+	c.Tracker.LogSkipUploadResult(isUploadSkipped, reason)
+}
+
+// Wait waits for the wrapped tracker, and writes the metrics file. Failing to write it is not an error of the
+// cache operation, it's only logged.
+func (c *metricsCollector) Wait() {
+	c.Tracker.Wait()
+
+	if err := c.write(); err != nil {
+		c.logger.Warnf("Failed to write cache metrics: %s", err)
+	}
+}
+
+// PhaseStarted ...
+func (c *metricsCollector) PhaseStarted(phase progress.Phase) {
+	c.reporter.PhaseStarted(phase)
+}
+
+// Progress ...
+func (c *metricsCollector) Progress(phase progress.Phase, doneBytes, totalBytes int64) {
+	c.reporter.Progress(phase, doneBytes, totalBytes)
+}
+
+// Retry ...
+func (c *metricsCollector) Retry(phase progress.Phase, attempt uint, err error) {
+	c.add("bitrise_cache_retries_total", c.labels("phase", string(phase)), 1)
+	c.reporter.Retry(phase, attempt, err)
+}
+
+// PhaseFinished ...
+func (c *metricsCollector) PhaseFinished(phase progress.Phase, err error) {
+	c.reporter.PhaseFinished(phase, err)
+}
+
+// labels returns the label set of a sample with the operation label and the given name-value pairs
+func (c *metricsCollector) labels(pairs ...string) string {
+	labels := []string{fmt.Sprintf(`operation="%s"`, c.operation)}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], escapeLabelValue(pairs[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
+
+func (c *metricsCollector) observeDuration(phase progress.Phase, duration time.Duration) {
+	const name = "bitrise_cache_phase_duration_seconds"
+	labels := c.labels("phase", string(phase))
+	seconds := duration.Seconds()
+	for _, bound := range metricsDurationBuckets {
+		value := 0.0
+		if seconds <= bound {
+			value = 1
+		}
+		c.add(name+"_bucket", labels+fmt.Sprintf(`,le="%s"`, formatMetricValue(bound)), value)
+	}
+	c.add(name+"_bucket", labels+`,le="+Inf"`, 1)
+	c.add(name+"_sum", labels, seconds)
+	c.add(name+"_count", labels, 1)
+}
+
+func (c *metricsCollector) add(name, labels string, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.samples.add(name+"{"+labels+"}", value)
+}
+
+func (c *metricsCollector) write() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(c.dir, MetricsFileName)
+
+	samples, err := readMetricSamples(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the previous metrics: %w", err)
+	}
+	for _, series := range c.samples.order {
+		samples.add(series, c.samples.values[series])
+	}
+
+	// The textfile collector may read the file at any time, so it's replaced in one step
+	tmpFile, err := os.CreateTemp(c.dir, MetricsFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) //nolint:errcheck
+
+	if _, err := tmpFile.WriteString(samples.render()); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// metricSamples are the values of the series (such as `bitrise_cache_operations_total{operation="save"}`),
+// in the order they were first added
+type metricSamples struct {
+	order  []string
+	values map[string]float64
+}
+
+func (s *metricSamples) add(series string, value float64) {
+	if s.values == nil {
+		s.values = map[string]float64{}
+	}
+	if _, ok := s.values[series]; !ok {
+		s.order = append(s.order, series)
+	}
+	s.values[series] += value
+}
+
+func (s metricSamples) render() string {
+	var b strings.Builder
+	for _, family := range metricFamilies {
+		var lines []string
+		for _, series := range s.order {
+			if metricFamilyName(series) == family.name {
+				lines = append(lines, series+" "+formatMetricValue(s.values[series]))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		b.WriteString(strings.Join(lines, "\n") + "\n")
+	}
+	return b.String()
+}
+
+// readMetricSamples reads the samples of a textfile written by a previous cache operation
+func readMetricSamples(path string) (metricSamples, error) {
+	var samples metricSamples
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return samples, nil
+	}
+	if err != nil {
+		return samples, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			return samples, fmt.Errorf("invalid sample: %s", line)
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			return samples, fmt.Errorf("invalid sample: %s", line)
+		}
+		samples.add(line[:i], value)
+	}
+	return samples, scanner.Err()
+}
+
+// metricFamilyName returns the metric family of a series, such as `bitrise_cache_phase_duration_seconds`
+// for `bitrise_cache_phase_duration_seconds_bucket{le="1"}`
+func metricFamilyName(series string) string {
+	name := series
+	if i := strings.Index(series, "{"); i >= 0 {
+		name = series[:i]
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(name, suffix) && strings.HasSuffix(strings.TrimSuffix(name, suffix), "_seconds") {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+	return name
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
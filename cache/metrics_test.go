@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/progress"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	// Given
+	dir := filepath.Join(t.TempDir(), "metrics")
+	restore := func(isMatch bool) {
+		c := newMetricsCollector(dir, UsageOperationRestore, NewNoopTracker(), progress.NewSilentReporter(), log.NewLogger())
+		c.Retry(progress.PhaseDownload, 1, errors.New("connection reset"))
+		if isMatch {
+			c.LogArchiveDownloaded(3*time.Second, 1024, 2)
+		}
+		c.LogRestoreResult(isMatch, "key-1", []string{"key-1", "key-"})
+		c.Wait()
+	}
+
+	// When
+	restore(true)
+	restore(false)
+
+	// Then
+	content, err := os.ReadFile(filepath.Join(dir, MetricsFileName))
+	require.NoError(t, err)
+	require.Equal(t, `# HELP bitrise_cache_operations_total Number of cache saves and restores.
+# TYPE bitrise_cache_operations_total counter
+bitrise_cache_operations_total{operation="restore"} 2
+# HELP bitrise_cache_restore_hits_total Number of cache restores that matched a key, by hit type.
+# TYPE bitrise_cache_restore_hits_total counter
+bitrise_cache_restore_hits_total{operation="restore",hit="exact"} 1
+# HELP bitrise_cache_restore_misses_total Number of cache restores that didn't match any key.
+# TYPE bitrise_cache_restore_misses_total counter
+bitrise_cache_restore_misses_total{operation="restore"} 1
+# HELP bitrise_cache_downloaded_bytes_total Size of the downloaded cache archives in bytes.
+# TYPE bitrise_cache_downloaded_bytes_total counter
+bitrise_cache_downloaded_bytes_total{operation="restore"} 1024
+# HELP bitrise_cache_retries_total Number of retried requests and phases, by phase.
+# TYPE bitrise_cache_retries_total counter
+bitrise_cache_retries_total{operation="restore",phase="download"} 2
+# HELP bitrise_cache_phase_duration_seconds Duration of the compression, upload, download and extraction phases.
+# TYPE bitrise_cache_phase_duration_seconds histogram
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="1"} 0
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="5"} 1
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="15"} 1
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="30"} 1
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="60"} 1
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="120"} 1
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="300"} 1
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="600"} 1
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="1800"} 1
+bitrise_cache_phase_duration_seconds_bucket{operation="restore",phase="download",le="+Inf"} 1
+bitrise_cache_phase_duration_seconds_sum{operation="restore",phase="download"} 3
+bitrise_cache_phase_duration_seconds_count{operation="restore",phase="download"} 1
+`, string(content))
+}
+
+func TestMetricsCollector_SaveSkip(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	c := newMetricsCollector(dir, UsageOperationSave, NewNoopTracker(), progress.NewSilentReporter(), log.NewLogger())
+
+	// When
+	c.LogSkipSaveResult(false, "")
+	c.LogSkipUploadResult(true, reasonNewArchiveChecksumMatch.String())
+	c.Wait()
+
+	// Then
+	samples, err := readMetricSamples(filepath.Join(dir, MetricsFileName))
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{
+		`bitrise_cache_operations_total{operation="save"}`:                                                                        1,
+		`bitrise_cache_save_skips_total{operation="save",stage="upload",reason="` + reasonNewArchiveChecksumMatch.String() + `"}`: 1,
+	}, samples.values)
+}
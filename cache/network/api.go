@@ -1,7 +1,9 @@
 package network
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -18,11 +20,20 @@ import (
 const maxKeyLength = 512
 const maxKeyCount = 8
 
+// maxRestoreRequestSize is the body size limit of restore requests
+const maxRestoreRequestSize = 64 * 1024
+
+// maxRestoreURLLength is the URL length limit of restore requests sending the keys in the query. Common proxies
+// reject (or truncate) longer URLs.
+const maxRestoreURLLength = 8 * 1024
+
 type prepareUploadRequest struct {
 	CacheKey           string `json:"cache_key"`
 	ArchiveFileName    string `json:"archive_filename"`
 	ArchiveContentType string `json:"archive_content_type"`
 	ArchiveSizeInBytes int64  `json:"archive_size_in_bytes"`
+	// BaseArchiveChecksum enables the conflict check, see UploadParams.BaseArchiveChecksum
+	BaseArchiveChecksum *string `json:"base_archive_checksum,omitempty"`
 }
 
 type prepareUploadResponse struct {
@@ -37,9 +48,15 @@ type acknowledgeResponse struct {
 	Severity string `json:"severity"`
 }
 
+type restoreRequest struct {
+	CacheKeys []string `json:"cache_keys"`
+}
+
 type restoreResponse struct {
 	URL        string `json:"url"`
 	MatchedKey string `json:"matched_cache_key"`
+	// ArchiveChecksum is the SHA-256 checksum of the archive. It might be empty for older cache entries.
+	ArchiveChecksum string `json:"archive_checksum"`
 }
 
 type apiClient struct {
@@ -97,19 +114,28 @@ func (c apiClient) prepareUpload(requestBody prepareUploadRequest) (prepareUploa
 	return response, nil
 }
 
-func (c apiClient) uploadArchive(archivePath, uploadMethod, uploadURL string, headers map[string]string) error {
+func (c apiClient) uploadArchive(ctx context.Context, archivePath string, maxSize int64, expectContinue bool, uploadMethod, uploadURL string, headers map[string]string) error {
 	file, err := os.Open(archivePath)
 	if err != nil {
 		return err
 	}
+	defer file.Close() //nolint:errcheck
 
-	req, err := retryablehttp.NewRequest(uploadMethod, uploadURL, file)
+	var body io.ReadSeeker = file
+	if maxSize > 0 {
+		body = &sizeLimitedReader{ReadSeeker: file, limit: maxSize}
+	}
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, uploadMethod, uploadURL, body)
 	if err != nil {
 		return err
 	}
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
+	if expectContinue {
+		req.Header.Set("Expect", "100-continue")
+	}
 
 	// Add Content-Length header manually because retryablehttp doesn't do it automatically
 	fileInfo, err := os.Stat(archivePath)
@@ -181,17 +207,49 @@ func (c apiClient) acknowledgeUpload(uploadID string) (acknowledgeResponse, erro
 	return response, nil
 }
 
+// restore matches the keys with a GET request, sending the keys in the query. If the URL would be too long for proxies
+// limiting the length of URLs, the keys are sent in the body of a POST request instead.
 func (c apiClient) restore(cacheKeys []string) (restoreResponse, error) {
-	keysInQuery, err := validateKeys(cacheKeys)
+	keys, err := validateKeys(cacheKeys)
 	if err != nil {
 		return restoreResponse{}, err
 	}
-	apiURL := fmt.Sprintf("%s/restore?cache_keys=%s", c.baseURL, keysInQuery)
 
-	req, err := retryablehttp.NewRequest(http.MethodGet, apiURL, nil)
+	apiURL := fmt.Sprintf("%s/restore?cache_keys=%s", c.baseURL, url.QueryEscape(strings.Join(keys, ",")))
+	if len(apiURL) <= maxRestoreURLLength {
+		req, err := retryablehttp.NewRequest(http.MethodGet, apiURL, nil)
+		if err != nil {
+			return restoreResponse{}, err
+		}
+		return c.doRestore(req)
+	}
+
+	c.logger.Debugf("The restore request URL would be too long (%d characters, limit: %d characters), sending the keys in the body", len(apiURL), maxRestoreURLLength)
+	response, err := c.restoreWithBody(keys)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusMethodNotAllowed || apiErr.StatusCode == http.StatusNotImplemented) {
+		return restoreResponse{}, fmt.Errorf("restore request URL is too long (%d characters, limit: %d characters) and the cache API doesn't support sending the keys in the body, use fewer or shorter keys: %w", len(apiURL), maxRestoreURLLength, err)
+	}
+	return response, err
+}
+
+func (c apiClient) restoreWithBody(keys []string) (restoreResponse, error) {
+	body, err := json.Marshal(restoreRequest{CacheKeys: keys})
 	if err != nil {
 		return restoreResponse{}, err
 	}
+	if len(body) > maxRestoreRequestSize {
+		return restoreResponse{}, fmt.Errorf("restore request is too large (%d bytes, limit: %d bytes), use fewer or shorter keys", len(body), maxRestoreRequestSize)
+	}
+	req, err := retryablehttp.NewRequest(http.MethodPost, fmt.Sprintf("%s/restore", c.baseURL), body)
+	if err != nil {
+		return restoreResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.doRestore(req)
+}
+
+func (c apiClient) doRestore(req *retryablehttp.Request) (restoreResponse, error) {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.accessToken))
 
 	resp, err := c.httpClient.Do(req)
@@ -226,17 +284,27 @@ func unwrapError(resp *http.Response) error {
 	if err != nil {
 		return err
 	}
-	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, errorResp)
+	return NewAPIError(resp.StatusCode, string(errorResp))
+}
+
+// unwrapStorageError is unwrapError for the responses of the archive URL, see newStorageError
+func unwrapStorageError(resp *http.Response) error {
+	errorResp, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return newStorageError(resp.StatusCode, string(errorResp))
 }
 
-func validateKeys(keys []string) (string, error) {
+// validateKeys checks the number of keys and truncates the long ones
+func validateKeys(keys []string) ([]string, error) {
 	if len(keys) > maxKeyCount {
-		return "", fmt.Errorf("maximum number of keys is %d, %d provided", maxKeyCount, len(keys))
+		return nil, fmt.Errorf("maximum number of keys is %d, %d provided", maxKeyCount, len(keys))
 	}
 	truncatedKeys := make([]string, 0, len(keys))
 	for _, key := range keys {
 		if strings.Contains(key, ",") {
-			return "", fmt.Errorf("commas are not allowed in keys (invalid key: %s)", key)
+			return nil, fmt.Errorf("commas are not allowed in keys (invalid key: %s)", key)
 		}
 		if len(key) > maxKeyLength {
 			truncatedKeys = append(truncatedKeys, key[:maxKeyLength])
@@ -245,5 +313,5 @@ func validateKeys(keys []string) (string, error) {
 		}
 	}
 
-	return url.QueryEscape(strings.Join(truncatedKeys, ",")), nil
+	return truncatedKeys, nil
 }
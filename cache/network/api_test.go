@@ -0,0 +1,73 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/retryhttp"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_apiClient_restore(t *testing.T) {
+	longKeys := make([]string, maxKeyCount)
+	for i := range longKeys {
+		longKeys[i] = strings.Repeat("/", maxKeyLength)
+	}
+
+	tests := []struct {
+		name         string
+		keys         []string
+		supportsPost bool
+		wantMethods  []string
+		wantErr      string
+	}{
+		{name: "keys in the query", keys: []string{"key-1", "key-2"}, supportsPost: true, wantMethods: []string{http.MethodGet}},
+		{name: "long keys in the body", keys: longKeys, supportsPost: true, wantMethods: []string{http.MethodPost}},
+		{name: "long keys without body support", keys: longKeys, supportsPost: false, wantMethods: []string{http.MethodPost}, wantErr: "restore request URL is too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			var methods []string
+			apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				methods = append(methods, r.Method)
+				require.Equal(t, "/restore", r.URL.Path)
+				require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+				var keys string
+				if r.Method == http.MethodPost {
+					if !tt.supportsPost {
+						w.WriteHeader(http.StatusMethodNotAllowed)
+						return
+					}
+					var request restoreRequest
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+					keys = strings.Join(request.CacheKeys, ",")
+				} else {
+					keys = r.URL.Query().Get("cache_keys")
+				}
+				require.Equal(t, strings.Join(tt.keys, ","), keys)
+				require.NoError(t, json.NewEncoder(w).Encode(restoreResponse{URL: "https://storage/archive", MatchedKey: tt.keys[1]}))
+			}))
+			defer apiServer.Close()
+			logger := log.NewLogger()
+			client := newAPIClient(retryhttp.NewClient(logger), apiServer.URL, "token", logger)
+
+			// When
+			response, err := client.restore(tt.keys)
+
+			// Then
+			require.Equal(t, tt.wantMethods, methods)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.keys[1], response.MatchedKey)
+		})
+	}
+}
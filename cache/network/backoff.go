@@ -0,0 +1,56 @@
+package network
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The longest Retry-After wait honored, so that a misconfigured CDN can't stall the download
+const maxRetryAfter = time.Minute
+
+// backoffWithJitter is a retryablehttp.Backoff: it honors the Retry-After header of 429 and 503 responses,
+// otherwise it waits exponentially longer between attempts with a random jitter (between half and the full wait time),
+// so that many runners retrying at the same time don't hit the CDN in waves.
+// The archive download sends its chunk requests through the same client, so chunk retries use this backoff too.
+func backoffWithJitter(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if wait > maxRetryAfter {
+				wait = maxRetryAfter
+			}
+			return wait
+		}
+	}
+
+	wait := math.Pow(2, float64(attemptNum)) * float64(min)
+	if wait > float64(max) {
+		wait = float64(max)
+	}
+	half := wait / 2
+	return time.Duration(half + rand.Float64()*half)
+}
+
+// parseRetryAfter parses a Retry-After header value, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	wait := date.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
@@ -0,0 +1,82 @@
+package network
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_backoffWithJitter(t *testing.T) {
+	min, max := time.Second, 30*time.Second
+
+	tests := []struct {
+		name       string
+		attemptNum int
+		resp       *http.Response
+		wantMin    time.Duration
+		wantMax    time.Duration
+	}{
+		{name: "first retry", attemptNum: 0, wantMin: 500 * time.Millisecond, wantMax: time.Second},
+		{name: "exponential", attemptNum: 3, wantMin: 4 * time.Second, wantMax: 8 * time.Second},
+		{name: "capped at max", attemptNum: 10, wantMin: 15 * time.Second, wantMax: 30 * time.Second},
+		{
+			name:       "Retry-After of 429",
+			attemptNum: 0,
+			resp:       responseWithRetryAfter(http.StatusTooManyRequests, "5"),
+			wantMin:    5 * time.Second,
+			wantMax:    5 * time.Second,
+		},
+		{
+			name:       "Retry-After of 503 is capped",
+			attemptNum: 0,
+			resp:       responseWithRetryAfter(http.StatusServiceUnavailable, "3600"),
+			wantMin:    maxRetryAfter,
+			wantMax:    maxRetryAfter,
+		},
+		{
+			name:       "Retry-After of other status codes is ignored",
+			attemptNum: 0,
+			resp:       responseWithRetryAfter(http.StatusInternalServerError, "5"),
+			wantMin:    500 * time.Millisecond,
+			wantMax:    time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backoffWithJitter(min, max, tt.attemptNum, tt.resp)
+			assert.GreaterOrEqual(t, got, tt.wantMin)
+			assert.LessOrEqual(t, got, tt.wantMax)
+		})
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "seconds", value: "120", want: 2 * time.Minute, wantOk: true},
+		{name: "HTTP date", value: "Sun, 01 Jan 2023 12:00:30 GMT", want: 30 * time.Second, wantOk: true},
+		{name: "HTTP date in the past", value: "Sun, 01 Jan 2023 11:00:00 GMT", want: 0, wantOk: true},
+		{name: "negative seconds", value: "-1", wantOk: false},
+		{name: "empty", value: "", wantOk: false},
+		{name: "invalid", value: "soon", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func responseWithRetryAfter(statusCode int, retryAfter string) *http.Response {
+	return &http.Response{StatusCode: statusCode, Header: http.Header{"Retry-After": []string{retryAfter}}}
+}
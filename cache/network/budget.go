@@ -0,0 +1,84 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// RemainingBuildTimeEnvKey holds the time left until the build times out, as seconds or as a duration (such as 25m).
+const RemainingBuildTimeEnvKey = "BITRISE_BUILD_REMAINING_TIME"
+
+// Conservative upload throughput used to estimate whether an upload can finish in time
+const estimatedUploadBytesPerSecond = 10 * 1000 * 1000
+
+// TimeBudget derives the deadlines of cache operations from the remaining build time,
+// so that a cache operation doesn't push the build over its timeout
+type TimeBudget struct {
+	deadline time.Time
+}
+
+// NewTimeBudget returns a budget ending reserve (time kept for the rest of the build) before the remaining time runs out.
+// A zero remaining time means that the remaining time is unknown, and the budget is unlimited.
+func NewTimeBudget(remaining, reserve time.Duration) TimeBudget {
+	if remaining <= 0 {
+		return TimeBudget{}
+	}
+	return TimeBudget{deadline: time.Now().Add(remaining - reserve)}
+}
+
+// RemainingBuildTime returns the remaining build time from RemainingBuildTimeEnvKey, and 0 if it's not set
+func RemainingBuildTime(envRepo env.Repository) (time.Duration, error) {
+	value := strings.TrimSpace(envRepo.Get(RemainingBuildTimeEnvKey))
+	if value == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	remaining, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value: %s", RemainingBuildTimeEnvKey, value)
+	}
+	return remaining, nil
+}
+
+// IsLimited reports whether the remaining build time is known
+func (b TimeBudget) IsLimited() bool {
+	return !b.deadline.IsZero()
+}
+
+// Available returns the time left for cache operations (it's negative when the budget is exceeded)
+func (b TimeBudget) Available() time.Duration {
+	if !b.IsLimited() {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Until(b.deadline)
+}
+
+// CanUpload reports whether an archive of the given size can likely be uploaded in the available time
+func (b TimeBudget) CanUpload(archiveSize int64) bool {
+	if !b.IsLimited() {
+		return true
+	}
+	return EstimatedUploadTime(archiveSize) < b.Available()
+}
+
+// EstimatedUploadTime returns a conservative estimate of the upload time of an archive
+func EstimatedUploadTime(archiveSize int64) time.Duration {
+	return time.Duration(float64(archiveSize) / estimatedUploadBytesPerSecond * float64(time.Second))
+}
+
+// WithDeadline returns a copy of ctx that is cancelled when the budget runs out
+func (b TimeBudget) WithDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if !b.IsLimited() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, b.deadline)
+}
@@ -0,0 +1,65 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemainingBuildTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "Not set", value: "", want: 0},
+		{name: "Seconds", value: "1500", want: 25 * time.Minute},
+		{name: "Duration", value: " 25m ", want: 25 * time.Minute},
+		{name: "Invalid", value: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(RemainingBuildTimeEnvKey, tt.value)
+
+			got, err := RemainingBuildTime(env.NewRepository())
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTimeBudget(t *testing.T) {
+	// Given
+	unlimited := NewTimeBudget(0, time.Minute)
+	enough := NewTimeBudget(time.Hour, time.Minute)
+	exceeded := NewTimeBudget(30*time.Second, time.Minute)
+
+	// Then
+	require.False(t, unlimited.IsLimited())
+	require.True(t, unlimited.CanUpload(100*1000*1000*1000))
+
+	require.True(t, enough.IsLimited())
+	require.True(t, enough.CanUpload(1000*1000*1000))
+	require.False(t, enough.CanUpload(100*1000*1000*1000))
+
+	require.True(t, exceeded.Available() < 0)
+	require.False(t, exceeded.CanUpload(1))
+
+	ctx, cancel := exceeded.WithDeadline(context.Background())
+	defer cancel()
+	require.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+
+	ctx, cancel = unlimited.WithDeadline(context.Background())
+	defer cancel()
+	_, hasDeadline := ctx.Deadline()
+	require.False(t, hasDeadline)
+}
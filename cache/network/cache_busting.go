@@ -0,0 +1,34 @@
+package network
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// cacheBustedURL adds a unique query param to the archive URL, so that a retry isn't served from the same
+// (possibly stale or truncated) CDN edge cache entry
+func cacheBustedURL(rawURL, param string, now time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid download URL: %w", err)
+	}
+	query := u.Query()
+	query.Set(param, fmt.Sprintf("%d", now.UnixNano()))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// revalidatingTransport asks the CDN to revalidate the cached object with the origin instead of serving it from
+// the edge cache, used when retrying a download that produced a corrupt archive
+type revalidatingTransport struct {
+	next http.RoundTripper
+}
+
+func (t revalidatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Pragma", "no-cache")
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,264 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+const (
+	// streamChunkSize is the size of the range requests of a parallel archive stream
+	streamChunkSize = 8 * 1024 * 1024
+	// defaultStreamConcurrency is the number of chunks downloaded (or buffered) ahead of the reader
+	// when DownloadParams.MaxConcurrency is not set
+	defaultStreamConcurrency = 4
+	maxChunkAttempts         = 3
+	// The chunk size is increased up to maxStreamChunkSize when the archive would be downloaded in more than
+	// maxPlannedChunks chunks over a link with at least highLatencyThreshold latency, as the latency of each range
+	// request would dominate the download time. The buffered chunks take up to concurrency * maxStreamChunkSize memory.
+	maxStreamChunkSize   = 64 * 1024 * 1024
+	maxPlannedChunks     = 256
+	highLatencyThreshold = 150 * time.Millisecond
+)
+
+// openArchiveStream requests the archive, and if concurrency is more than 1 and the storage supports range requests,
+// downloads the rest of it with parallel range requests, see chunkedStream.
+func openArchiveStream(ctx context.Context, httpClient *retryablehttp.Client, url string, concurrency uint, chunkSize int64, watchdog *MemoryWatchdog, logger log.Logger) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if concurrency > 1 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", chunkSize-1))
+	}
+
+	requestStart := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	latency := time.Since(requestStart)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		logger.Debugf("Streaming archive in a single request")
+		return &cancelingReadCloser{ReadCloser: resp.Body, cancel: cancel}, nil
+	case http.StatusPartialContent:
+		totalSize, err := parseContentRangeSize(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close() //nolint:errcheck
+			cancel()
+			return nil, err
+		}
+		restChunkSize := adjustedChunkSize(totalSize, chunkSize, latency)
+		if restChunkSize != chunkSize {
+			logger.Debugf("Increased the chunk size to %d bytes, as the archive would take more than %d chunks with %s latency", restChunkSize, maxPlannedChunks, latency.Round(time.Millisecond))
+		}
+		logger.Debugf("Streaming archive in %d byte chunks (concurrency: %d)", restChunkSize, concurrency)
+		return newChunkedStream(ctx, cancel, httpClient, url, resp.Body, totalSize, chunkSize, restChunkSize, concurrency, watchdog, logger), nil
+	default:
+		defer resp.Body.Close() //nolint:errcheck
+		cancel()
+		return nil, unwrapStorageError(resp)
+	}
+}
+
+// adjustedChunkSize returns the chunk size of the ranges after the first one: the chunk size is doubled (up to
+// maxStreamChunkSize) while the archive would take more than maxPlannedChunks chunks on a high latency link.
+// The latency is sampled by the first range request.
+func adjustedChunkSize(totalSize, chunkSize int64, latency time.Duration) int64 {
+	if latency < highLatencyThreshold {
+		return chunkSize
+	}
+	for totalSize/chunkSize > maxPlannedChunks && 2*chunkSize <= maxStreamChunkSize {
+		chunkSize *= 2
+	}
+	return chunkSize
+}
+
+// parseContentRangeSize returns the complete size from a Content-Range header, like `bytes 0-1023/4096`
+func parseContentRangeSize(contentRange string) (int64, error) {
+	i := strings.LastIndex(contentRange, "/")
+	if i == -1 {
+		return 0, fmt.Errorf("invalid Content-Range header: %s", contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unknown archive size in Content-Range header: %s", contentRange)
+	}
+	return size, nil
+}
+
+type cancelingReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+type streamChunk struct {
+	offset int64
+	size   int64
+	data   []byte
+	err    error
+	done   chan struct{}
+}
+
+// chunkedStream delivers the archive in order: the first chunk is read straight from the first response,
+// while the following chunks are downloaded in parallel and are delivered as soon as all the chunks before them
+// have been read. At most `concurrency` chunks are downloaded or buffered ahead of the reader.
+type chunkedStream struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	httpClient *retryablehttp.Client
+	url        string
+
+	first     io.ReadCloser
+	firstSize int64
+	firstRead int64
+
+	chunks []*streamChunk
+	// slots limits the chunks downloaded ahead of the reader, a slot is released when a chunk is read
+	slots chan struct{}
+	// watchdog (if not nil) pauses new chunks on low memory
+	watchdog *MemoryWatchdog
+	logger   log.Logger
+	current  int
+	pos      int
+}
+
+func newChunkedStream(ctx context.Context, cancel context.CancelFunc, httpClient *retryablehttp.Client, url string, first io.ReadCloser, totalSize, firstSize, chunkSize int64, concurrency uint, watchdog *MemoryWatchdog, logger log.Logger) *chunkedStream {
+	s := &chunkedStream{
+		ctx:        ctx,
+		cancel:     cancel,
+		httpClient: httpClient,
+		url:        url,
+		first:      first,
+		firstSize:  firstSize,
+		slots:      make(chan struct{}, concurrency),
+		watchdog:   watchdog,
+		logger:     logger,
+	}
+	if totalSize < firstSize {
+		s.firstSize = totalSize
+	}
+	for offset := s.firstSize; offset < totalSize; offset += chunkSize {
+		size := chunkSize
+		if offset+size > totalSize {
+			size = totalSize - offset
+		}
+		s.chunks = append(s.chunks, &streamChunk{offset: offset, size: size, done: make(chan struct{})})
+	}
+
+	go s.dispatch()
+	return s
+}
+
+func (s *chunkedStream) dispatch() {
+	for _, chunk := range s.chunks {
+		select {
+		case s.slots <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+		// Pausing only helps if other chunks are in flight or buffered, their memory is released as they are read
+		if s.watchdog != nil && len(s.slots) > 1 {
+			if err := s.watchdog.Wait(s.ctx, s.logger); err != nil {
+				return
+			}
+		}
+		go s.fetch(chunk)
+	}
+}
+
+func (s *chunkedStream) fetch(chunk *streamChunk) {
+	defer close(chunk.done)
+	for attempt := 1; attempt <= maxChunkAttempts; attempt++ {
+		chunk.data, chunk.err = s.fetchRange(chunk.offset, chunk.size)
+		if chunk.err == nil || s.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *chunkedStream) fetchRange(offset, size int64) ([]byte, error) {
+	req, err := retryablehttp.NewRequestWithContext(s.ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("failed to download archive chunk at %d: %w", offset, unwrapStorageError(resp))
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("failed to download archive chunk at %d: %w", offset, err)
+	}
+	return data, nil
+}
+
+// Read implements io.Reader.
+func (s *chunkedStream) Read(p []byte) (int, error) {
+	if s.firstRead < s.firstSize {
+		n, err := s.first.Read(p)
+		s.firstRead += int64(n)
+		if errors.Is(err, io.EOF) && s.firstRead < s.firstSize {
+			return n, fmt.Errorf("%w (first chunk: %d of %d bytes)", ErrSizeMismatch, s.firstRead, s.firstSize)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		return n, nil
+	}
+
+	for s.current < len(s.chunks) {
+		chunk := s.chunks[s.current]
+		select {
+		case <-chunk.done:
+		case <-s.ctx.Done():
+			return 0, s.ctx.Err()
+		}
+		if chunk.err != nil {
+			return 0, chunk.err
+		}
+
+		if s.pos < len(chunk.data) {
+			n := copy(p, chunk.data[s.pos:])
+			s.pos += n
+			return n, nil
+		}
+
+		chunk.data = nil
+		s.current++
+		s.pos = 0
+		<-s.slots
+	}
+	return 0, io.EOF
+}
+
+// Close stops the download of the remaining chunks.
+func (s *chunkedStream) Close() error {
+	s.cancel()
+	return s.first.Close()
+}
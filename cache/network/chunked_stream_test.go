@@ -0,0 +1,165 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/retryhttp"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_openArchiveStream(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+
+	tests := []struct {
+		name         string
+		concurrency  uint
+		rangeSupport bool
+		wantRequests int64
+	}{
+		{name: "parallel chunks", concurrency: 3, rangeSupport: true, wantRequests: 7},
+		{name: "no range support", concurrency: 3, rangeSupport: false, wantRequests: 1},
+		{name: "single request", concurrency: 1, rangeSupport: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			var requests atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if !tt.rangeSupport {
+					_, _ = io.WriteString(w, content)
+					return
+				}
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+			}))
+			defer server.Close()
+			logger := log.NewLogger()
+
+			// When
+			stream, err := openArchiveStream(context.Background(), retryhttp.NewClient(logger), server.URL, tt.concurrency, 150, nil, logger)
+			require.NoError(t, err)
+			streamed, err := io.ReadAll(stream)
+			require.NoError(t, stream.Close())
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, content, string(streamed))
+			require.Equal(t, tt.wantRequests, requests.Load())
+		})
+	}
+}
+
+func Test_openArchiveStream_RetriesFailedChunk(t *testing.T) {
+	// Given
+	content := bytes.Repeat([]byte("abcdefghij"), 30)
+	var failed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=200-299" && !failed.Swap(true) {
+			// Truncated response
+			w.Header().Set("Content-Range", "bytes 200-299/300")
+			w.Header().Set("Content-Length", "100")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content[200:250])
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	logger := log.NewLogger()
+
+	// When
+	stream, err := openArchiveStream(context.Background(), retryhttp.NewClient(logger), server.URL, 2, 100, nil, logger)
+	require.NoError(t, err)
+	defer stream.Close() //nolint:errcheck
+	streamed, err := io.ReadAll(stream)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, content, streamed)
+	require.True(t, failed.Load())
+}
+
+func Test_openArchiveStream_MemoryWatchdog(t *testing.T) {
+	// Given
+	content := bytes.Repeat([]byte("abcdefghij"), 50)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	logger := log.NewLogger()
+	watchdog := &MemoryWatchdog{MinAvailable: 100, PollInterval: time.Millisecond, availableMemory: fakeAvailableMemory(10, 10, 500)}
+
+	// When
+	stream, err := openArchiveStream(context.Background(), retryhttp.NewClient(logger), server.URL, 3, 100, watchdog, logger)
+	require.NoError(t, err)
+	defer stream.Close() //nolint:errcheck
+	streamed, err := io.ReadAll(stream)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, content, streamed)
+}
+
+func Test_openArchiveStream_HighLatencyIncreasesChunkSize(t *testing.T) {
+	// Given
+	content := strings.Repeat("0123456789", 3000)
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			time.Sleep(highLatencyThreshold)
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+	logger := log.NewLogger()
+
+	// When
+	stream, err := openArchiveStream(context.Background(), retryhttp.NewClient(logger), server.URL, 4, 100, nil, logger)
+	require.NoError(t, err)
+	streamed, err := io.ReadAll(stream)
+	require.NoError(t, stream.Close())
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, content, string(streamed))
+	// The first 100 byte chunk, then 200 byte chunks
+	require.Equal(t, int64(151), requests.Load())
+}
+
+func Test_adjustedChunkSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		totalSize int64
+		latency   time.Duration
+		want      int64
+	}{
+		{name: "Low latency", totalSize: 10 * 1024 * streamChunkSize, latency: 20 * time.Millisecond, want: streamChunkSize},
+		{name: "Few chunks", totalSize: 100 * streamChunkSize, latency: time.Second, want: streamChunkSize},
+		{name: "Many chunks", totalSize: 1000 * streamChunkSize, latency: time.Second, want: 4 * streamChunkSize},
+		{name: "Too many chunks", totalSize: 100000 * streamChunkSize, latency: time.Second, want: maxStreamChunkSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, adjustedChunkSize(tt.totalSize, streamChunkSize, tt.latency))
+		})
+	}
+}
+
+func Test_parseContentRangeSize(t *testing.T) {
+	size, err := parseContentRangeSize("bytes 0-1023/4096")
+	require.NoError(t, err)
+	require.Equal(t, int64(4096), size)
+
+	_, err = parseContentRangeSize("bytes 0-1023/*")
+	require.Error(t, err)
+}
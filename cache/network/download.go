@@ -2,14 +2,20 @@ package network
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"net"
+	"hash"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/bitrise-io/go-steputils/v2/cache/progress"
 	"github.com/bitrise-io/go-utils/retry"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-io/go-utils/v2/retryhttp"
@@ -18,52 +24,223 @@ import (
 )
 
 // DefaultDownloader ...
-type DefaultDownloader struct{}
+type DefaultDownloader struct {
+	httpClient *http.Client
+}
+
+// WithHTTPClient returns a downloader sending all requests (cache API calls and the archive download) with the provided
+// client, for example to add instrumentation or a custom transport. Requests are still retried by the downloader.
+// DownloadParams.Transport (including the connection tuning) is not applied to the provided client.
+func (d DefaultDownloader) WithHTTPClient(client *http.Client) DefaultDownloader {
+	d.httpClient = client
+	return d
+}
 
 // DownloadParams ...
 type DownloadParams struct {
-	APIBaseURL     string
-	Token          string
-	CacheKeys      []string
-	DownloadPath   string
-	NumFullRetries int
-	MaxConcurrency uint
+	APIBaseURL string
+	// FailoverAPIBaseURLs are tried in order when APIBaseURL is unreachable
+	FailoverAPIBaseURLs []string
+	Token               string
+	CacheKeys           []string
+	DownloadPath        string
+	NumFullRetries      int
+	MaxConcurrency      uint
+	// Transport configures the proxy, TLS and connection tuning (see TransportProfile) settings, see TransportConfig
+	Transport TransportConfig
+	// ProgressFunc (if set) is called every second during the archive download, for example to render a progress bar.
+	// It's not called by DownloadStream.
+	ProgressFunc func(DownloadProgress)
+	// Reporter (if set) receives the retries of the download, see progress.Reporter
+	Reporter progress.Reporter
+	// CacheBustingQueryParam (if set) is added to the archive URL with a unique value when the download is retried
+	// from scratch, so that the retry isn't served from the same CDN edge cache entry. Only set it if the signature
+	// scheme of the archive URLs ignores unsigned query params. Retries always ask the CDN to revalidate the archive.
+	CacheBustingQueryParam string
+	// TraceFile (if set) records the requests of the download, see UploadParams.TraceFile
+	TraceFile string
+	// MemoryWatchdog (if set) pauses the parallel chunk downloads of DownloadStream on low memory
+	MemoryWatchdog *MemoryWatchdog
+	// ArchiveMatched (if set) is called with the matched key and the checksum of the archive (empty if the cache API
+	// doesn't provide one) before the archive is downloaded. If it returns true, the archive is not downloaded, and
+	// the matched key is returned with ErrArchiveAlreadyRestored. LocalStorage doesn't call it.
+	ArchiveMatched func(matchedKey, archiveChecksum string) (skip bool)
 }
 
 // ErrCacheNotFound ...
 var ErrCacheNotFound = errors.New("no cache archive found for the provided keys")
 
+// ErrArchiveAlreadyRestored means that the download was skipped by DownloadParams.ArchiveMatched
+var ErrArchiveAlreadyRestored = errors.New("the matched archive is already restored")
+
+// ErrChecksumMismatch means that the downloaded archive is different from the one stored in the cache
+var ErrChecksumMismatch = errors.New("downloaded archive checksum doesn't match the expected checksum")
+
+// ErrSizeMismatch means that the downloaded archive file is not the size reported by the storage,
+// for example because of a truncated chunk. The download is retried from scratch.
+var ErrSizeMismatch = errors.New("downloaded archive size doesn't match the expected size")
+
 // Download archive from the cache API based on the provided keys in params.
 // If there is no match for any of the keys, the error is ErrCacheNotFound.
-func (d DefaultDownloader) Download(ctx context.Context, params DownloadParams, logger log.Logger) (string, error) {
-	retryableHTTPClient := retryhttp.NewClient(logger)
+func (d DefaultDownloader) Download(ctx context.Context, params DownloadParams, logger log.Logger) (path string, err error) {
+	retryableHTTPClient, err := d.newHTTPClient(params, logger)
+	if err != nil {
+		return "", err
+	}
+	trace := startTrace(retryableHTTPClient, params.TraceFile, "download")
+	defer func() { trace.dumpOnError(err, logger) }()
 
 	return downloadWithClient(ctx, retryableHTTPClient, params, logger)
 }
 
-func downloadWithClient(ctx context.Context, httpClient *retryablehttp.Client, params DownloadParams, logger log.Logger) (string, error) {
+// DownloadStream returns the archive as a stream from the cache API, see StreamDownloader.
+// If the storage supports range requests, the archive is downloaded in chunks with up to MaxConcurrency parallel
+// requests (and as many chunks buffered in memory), and the stream delivers them in order as soon as the leading
+// chunks complete. Failed requests are retried until the response starts, and failed chunks are retried a few times,
+// but the stream is not retried from scratch.
+// The archive checksum (if provided by the API) is verified at the end of the stream: reading the last part of the
+// stream fails with ErrChecksumMismatch in case of a mismatch.
+func (d DefaultDownloader) DownloadStream(ctx context.Context, params DownloadParams, logger log.Logger) (stream io.ReadCloser, matchedKey string, err error) {
+	retryableHTTPClient, err := d.newHTTPClient(params, logger)
+	if err != nil {
+		return nil, "", err
+	}
+	// Only the failures before the stream starts are traced
+	trace := startTrace(retryableHTTPClient, params.TraceFile, "download")
+	defer func() { trace.dumpOnError(err, logger) }()
+
+	return downloadStreamWithClient(ctx, retryableHTTPClient, params, logger)
+}
+
+func (d DefaultDownloader) newHTTPClient(params DownloadParams, logger log.Logger) (*retryablehttp.Client, error) {
+	retryableHTTPClient := retryhttp.NewClient(logger)
+	retryableHTTPClient.Backoff = backoffWithJitter
+	reportRetries(retryableHTTPClient, params.Reporter, progress.PhaseDownload)
+	if d.httpClient != nil {
+		if params.Transport != (TransportConfig{}) {
+			logger.Warnf("Transport config is ignored when a custom HTTP client is used")
+		}
+		retryableHTTPClient.HTTPClient = d.httpClient
+		return retryableHTTPClient, nil
+	}
+
+	// The chunks are downloaded on parallel HTTP/1.1 connections,
+	// unless the transport profile or BITRISEIO_DEPENDENCY_CACHE_FORCE_ATTEMPT_HTTP2 enables HTTP/2
+	retryableHTTPClient.HTTPClient.Transport.(*http.Transport).ForceAttemptHTTP2 = false
+	if err := configureTransport(retryableHTTPClient, params.Transport, logger); err != nil {
+		return nil, err
+	}
+	return retryableHTTPClient, nil
+}
+
+func validateDownloadParams(params DownloadParams) error {
 	if params.APIBaseURL == "" {
-		return "", fmt.Errorf("API base URL is empty")
+		return fmt.Errorf("API base URL is empty")
 	}
 
 	if params.Token == "" {
-		return "", fmt.Errorf("API token is empty")
+		return fmt.Errorf("API token is empty")
 	}
 
 	if len(params.CacheKeys) == 0 {
-		return "", fmt.Errorf("cache key list is empty")
+		return fmt.Errorf("cache key list is empty")
 	}
 
+	return nil
+}
+
+func restoreWithFailover(httpClient *retryablehttp.Client, params DownloadParams, logger log.Logger) (restoreResponse, error) {
+	var response restoreResponse
+	_, err := withAPIFailover(httpClient, params.APIBaseURL, params.FailoverAPIBaseURLs, logger, func(baseURL string) error {
+		client := newAPIClient(httpClient, baseURL, params.Token, logger)
+		var err error
+		response, err = client.restore(params.CacheKeys)
+		return err
+	})
+	return response, err
+}
+
+func downloadStreamWithClient(ctx context.Context, httpClient *retryablehttp.Client, params DownloadParams, logger log.Logger) (io.ReadCloser, string, error) {
+	if err := validateDownloadParams(params); err != nil {
+		return nil, "", err
+	}
+
+	logger = newOperationLogger(logger, "download", newCorrelationID(), 0)
+	// The previous logger is not restored, the chunks of the stream are downloaded with the same client
+	useLogger(httpClient, logger)
+
+	logger.Debugf("Fetching download URL...")
+	restoreResponse, err := restoreWithFailover(httpClient, params, logger)
+	if err != nil {
+		if errors.Is(err, ErrCacheNotFound) {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("failed to get download URL: %w", err)
+	}
+	if params.ArchiveMatched != nil && params.ArchiveMatched(restoreResponse.MatchedKey, restoreResponse.ArchiveChecksum) {
+		return nil, restoreResponse.MatchedKey, ErrArchiveAlreadyRestored
+	}
+
+	logger.Debugf("Streaming archive...")
+	concurrency := params.MaxConcurrency
+	if concurrency == 0 {
+		concurrency = defaultStreamConcurrency
+	}
+	stream, err := openArchiveStream(ctx, httpClient, restoreResponse.URL, concurrency, streamChunkSize, params.MemoryWatchdog, logger)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download archive: %w", err)
+	}
+
+	if restoreResponse.ArchiveChecksum == "" {
+		logger.Debugf("No archive checksum provided by the cache service, skipping verification")
+		return stream, restoreResponse.MatchedKey, nil
+	}
+	return &checksumVerifyingReader{
+		ReadCloser: stream,
+		hash:       sha256.New(),
+		expected:   restoreResponse.ArchiveChecksum,
+	}, restoreResponse.MatchedKey, nil
+}
+
+// checksumVerifyingReader returns ErrChecksumMismatch instead of io.EOF if the content doesn't match the expected checksum
+type checksumVerifyingReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (r *checksumVerifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n]) //nolint:errcheck
+	if err == io.EOF {
+		if checksum := hex.EncodeToString(r.hash.Sum(nil)); checksum != r.expected {
+			return n, fmt.Errorf("%w (expected: %s, actual: %s)", ErrChecksumMismatch, r.expected, checksum)
+		}
+	}
+	return n, err
+}
+
+func downloadWithClient(ctx context.Context, httpClient *retryablehttp.Client, params DownloadParams, logger log.Logger) (string, error) {
+	if err := validateDownloadParams(params); err != nil {
+		return "", err
+	}
+
+	reporter := progress.OrSilent(params.Reporter)
 	matchedKey := ""
-	err := retry.Times(uint(params.NumFullRetries)).Wait(5 * time.Second).TryWithAbort(func(attempt uint) (error, bool) {
+	var lastErr error
+	// The log lines of each attempt are prefixed with the same correlation ID and the number of the attempt
+	correlationID := newCorrelationID()
+	err := retry.Times(uint(params.NumFullRetries)).Wait(5 * time.Second).TryWithAbort(func(attempt uint) (err error, abort bool) {
+		defer func() { lastErr = err }()
+		logger := newOperationLogger(logger, "download", correlationID, attempt+1)
+		defer useLogger(httpClient, logger)()
 		if attempt != 0 {
 			logger.Debugf("Retrying archive download... (attempt %d)", attempt+1)
+			reporter.Retry(progress.PhaseDownload, attempt, lastErr)
 		}
 
-		client := newAPIClient(httpClient, params.APIBaseURL, params.Token, logger)
-
 		logger.Debugf("Fetching download URL...")
-		restoreResponse, err := client.restore(params.CacheKeys)
+		restoreResponse, err := restoreWithFailover(httpClient, params, logger)
 		if err != nil {
 			if errors.Is(err, ErrCacheNotFound) {
 				return err, true // Do not retry if cache key not found
@@ -73,13 +250,39 @@ func downloadWithClient(ctx context.Context, httpClient *retryablehttp.Client, p
 			return fmt.Errorf("failed to get download URL: %w", err), false
 		}
 
+		if params.ArchiveMatched != nil && params.ArchiveMatched(restoreResponse.MatchedKey, restoreResponse.ArchiveChecksum) {
+			matchedKey = restoreResponse.MatchedKey
+			return ErrArchiveAlreadyRestored, true
+		}
+
+		downloadURL := restoreResponse.URL
+		if attempt != 0 && params.CacheBustingQueryParam != "" {
+			downloadURL, err = cacheBustedURL(downloadURL, params.CacheBustingQueryParam, time.Now())
+			if err != nil {
+				return err, true
+			}
+		}
+
 		logger.Debugf("Downloading archive...")
-		downloadErr := downloadFile(ctx, httpClient, restoreResponse.URL, params.DownloadPath, params.MaxConcurrency, logger)
+		downloadErr := downloadFile(ctx, httpClient, downloadURL, params.DownloadPath, params.MaxConcurrency, attempt != 0, params.ProgressFunc, logger)
 		if downloadErr != nil {
 			logger.Debugf("Failed to download archive: %s", downloadErr)
+			if errors.Is(downloadErr, ErrSizeMismatch) {
+				if removeErr := os.Remove(params.DownloadPath); removeErr != nil {
+					logger.Debugf("Failed to remove truncated archive: %s", removeErr)
+				}
+			}
 			return fmt.Errorf("failed to download archive: %w", downloadErr), false
 		}
 
+		if err := verifyChecksum(params.DownloadPath, restoreResponse.ArchiveChecksum, logger); err != nil {
+			logger.Debugf("Failed to verify archive: %s", err)
+			if removeErr := os.Remove(params.DownloadPath); removeErr != nil {
+				logger.Debugf("Failed to remove corrupted archive: %s", removeErr)
+			}
+			return err, false
+		}
+
 		matchedKey = restoreResponse.MatchedKey
 		return nil, false
 	})
@@ -87,43 +290,42 @@ func downloadWithClient(ctx context.Context, httpClient *retryablehttp.Client, p
 	return matchedKey, err
 }
 
-func downloadFile(ctx context.Context, httpClient *retryablehttp.Client, url string, dest string, maxConcurrency uint, logger log.Logger) error {
-	env := os.Getenv("BITRISEIO_DEPENDENCY_CACHE_MAX_IDLE_CONNS_PER_HOST")
-	maxIdleConnsPerHost, err := strconv.Atoi(env)
-	if err == nil {
-		httpClient.HTTPClient.Transport.(*http.Transport).MaxIdleConnsPerHost = maxIdleConnsPerHost
-	}
-
-	env = os.Getenv("BITRISEIO_DEPENDENCY_CACHE_MAX_IDLE_CONNS")
-	maxIdleConns, err := strconv.Atoi(env)
-	if err == nil {
-		httpClient.HTTPClient.Transport.(*http.Transport).MaxIdleConns = maxIdleConns
+// downloadFile downloads the archive in chunks. revalidate asks the CDN to revalidate the archive with the origin,
+// instead of serving it from its cache.
+func downloadFile(ctx context.Context, httpClient *retryablehttp.Client, url string, dest string, maxConcurrency uint, revalidate bool, progressFunc func(DownloadProgress), logger log.Logger) error {
+	client := httpClient.StandardClient()
+	if revalidate {
+		client.Transport = revalidatingTransport{next: client.Transport}
 	}
+	singleStreamClient := *client
 
-	env = os.Getenv("BITRISEIO_DEPENDENCY_CACHE_FORCE_ATTEMPT_HTTP2")
-	forceAttemptHTTP2 := env == "true" || env == "1"
-	httpClient.HTTPClient.Transport.(*http.Transport).ForceAttemptHTTP2 = forceAttemptHTTP2
+	// The chunked download is cancelled as soon as the storage ignores a range request
+	chunksCtx, cancelChunks := context.WithCancel(ctx)
+	defer cancelChunks()
+	var rangeIgnored atomic.Bool
+	client.Transport = rangeCheckingTransport{next: client.Transport, onIgnored: func() {
+		rangeIgnored.Store(true)
+		cancelChunks()
+	}}
 
-	env = os.Getenv("BITRISEIO_DEPENDENCY_CACHE_DUALSTACK")
-	dualStack := env == "true" || env == "1"
-	httpClient.HTTPClient.Transport.(*http.Transport).DialContext = (&net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
-		DualStack: dualStack,
-	}).DialContext
-	
 	downloader := got.New()
-	downloader.Client = httpClient.StandardClient()
+	downloader.Client = client
+	if progressFunc != nil {
+		downloader.ProgressFunc = func(d *got.Download) {
+			progressFunc(newDownloadProgress(d))
+		}
+	}
 
-	gDownload := got.NewDownload(ctx, url, dest)
+	gDownload := got.NewDownload(chunksCtx, url, dest)
 	// Client has to be set on "Download" as well,
 	// as depending on how downloader is called
 	// either the Client from the downloader or from the Download will be used.
-	gDownload.Client = httpClient.StandardClient()
+	gDownload.Client = client
 	gDownload.Concurrency = maxConcurrency
 	gDownload.Logger = logger
+	gDownload.Interval = uint64(progressInterval.Milliseconds())
 
-	env = os.Getenv("BITRISEIO_DEPENDENCY_CACHE_MAX_RETRY_PER_CHUNK")
+	env := os.Getenv("BITRISEIO_DEPENDENCY_CACHE_MAX_RETRY_PER_CHUNK")
 	if val, err := strconv.Atoi(env); err == nil {
 		gDownload.MaxRetryPerChunk = val
 	} else {
@@ -137,5 +339,73 @@ func downloadFile(ctx context.Context, httpClient *retryablehttp.Client, url str
 		gDownload.ChunkRetryThreshold = 10 * time.Second
 	}
 
-	return downloader.Do(gDownload)
+	if err := downloader.Do(gDownload); err != nil {
+		if rangeIgnored.Load() {
+			logger.Warnf("The storage ignored the range requests of the chunked download, downloading the archive in a single request")
+			return downloadSingleStream(ctx, &singleStreamClient, url, dest, logger)
+		}
+		return gotStatusError(err)
+	}
+
+	return verifySize(dest, gDownload.TotalSize(), logger)
+}
+
+// gotStatusError returns ErrArchiveUnreadable for got's errors of unreadable archive responses
+// (got only reports the status code in the error message)
+func gotStatusError(err error) error {
+	const prefix = "Response status code is not ok: "
+	msg := err.Error()
+	i := strings.Index(msg, prefix)
+	if i == -1 {
+		return err
+	}
+	statusCode, convErr := strconv.Atoi(strings.TrimSpace(msg[i+len(prefix):]))
+	if convErr != nil || !isUnreadableStatus(statusCode) {
+		return err
+	}
+	return newStorageError(statusCode, msg)
+}
+
+// verifySize checks the size of the downloaded file, got doesn't verify that the chunks add up to the whole file
+func verifySize(path string, expectedSize uint64, logger log.Logger) error {
+	if expectedSize == 0 {
+		logger.Debugf("Archive size is unknown, skipping size verification")
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if uint64(info.Size()) != expectedSize {
+		return fmt.Errorf("%w (expected: %d bytes, actual: %d bytes)", ErrSizeMismatch, expectedSize, info.Size())
+	}
+
+	return nil
+}
+
+func verifyChecksum(path, expectedChecksum string, logger log.Logger) error {
+	if expectedChecksum == "" {
+		logger.Debugf("No archive checksum provided by the cache service, skipping verification")
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close() //nolint:errcheck
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("failed to compute archive checksum: %w", err)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != expectedChecksum {
+		return fmt.Errorf("%w (expected: %s, actual: %s)", ErrChecksumMismatch, expectedChecksum, checksum)
+	}
+	logger.Debugf("Archive checksum verified: %s", checksum)
+
+	return nil
 }
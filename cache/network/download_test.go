@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-io/go-utils/v2/mocks"
@@ -156,7 +158,7 @@ func Test_downloadFile_multipart_retrycheck(t *testing.T) {
 	downloadURL := svr.URL
 
 	// When
-	err := downloadFile(context.Background(), retryableHTTPClient, downloadURL, tmpFile, 5, log.NewLogger())
+	err := downloadFile(context.Background(), retryableHTTPClient, downloadURL, tmpFile, 5, false, nil, log.NewLogger())
 
 	// Then
 	require.True(t, isCheckRetryCalled.Load())
@@ -275,3 +277,389 @@ func Test_downloadWithClient_WhenCacheKeyNotFound_ThenWillNotRetry(t *testing.T)
 
 	require.Equal(t, uint64(1), apiServerCalled.Load(), "no retries were done")
 }
+
+func Test_downloadWithClient_WhenChecksumMismatch_ThenWillDoFullRetry(t *testing.T) {
+	// Given
+	logger := log.NewLogger()
+	logger.EnableDebugLog(true)
+
+	retryableHTTPClient := retryhttp.NewClient(logger)
+
+	tmpPath := t.TempDir()
+	tmpFile := filepath.Join(tmpPath, "testfile.bin")
+	testDummyFileContent := "archive content"
+	cacheKey := "test-cache-key"
+
+	var numCorruptedLeft atomic.Int64
+	numCorruptedLeft.Store(1)
+
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("[fileserver] Server called. Method=%s; Header=%#v", r.Method, r.Header)
+		content := testDummyFileContent
+		if numCorruptedLeft.Load() > 0 {
+			numCorruptedLeft.Add(-1)
+			content = "corrupted content"
+		}
+
+		w.Header().Add("Content-Length", fmt.Sprintf("%d", len(content)))
+		_, err := fmt.Fprint(w, content)
+		require.NoError(t, err)
+	}))
+	defer fileServer.Close()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := restoreResponse{
+			URL:             fileServer.URL,
+			MatchedKey:      cacheKey,
+			ArchiveChecksum: "fa868b2818c90263b5c2c8e056180232a6f3c34547ca49b7f3ca10599a52db3d", // sha256 of "archive content"
+		}
+
+		w.WriteHeader(http.StatusOK)
+		err := json.NewEncoder(w).Encode(resp)
+		require.NoError(t, err)
+	}))
+	defer apiServer.Close()
+
+	downloadParams := DownloadParams{
+		APIBaseURL:     apiServer.URL,
+		Token:          "netok",
+		CacheKeys:      []string{cacheKey},
+		DownloadPath:   tmpFile,
+		NumFullRetries: 1,
+	}
+
+	// When
+	gotMatchedKeys, err := downloadWithClient(context.Background(), retryableHTTPClient, downloadParams, logger)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, cacheKey, gotMatchedKeys)
+	require.Equal(t, int64(0), numCorruptedLeft.Load(), "corrupted download should be retried")
+
+	downloadedContents, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+	require.Equal(t, testDummyFileContent, string(downloadedContents))
+}
+
+func Test_downloadWithClient_WhenArchiveAlreadyRestored_ThenSkipsDownload(t *testing.T) {
+	// Given
+	logger := log.NewLogger()
+	retryableHTTPClient := retryhttp.NewClient(logger)
+	cacheKey := "test-cache-key"
+	checksum := "fa868b2818c90263b5c2c8e056180232a6f3c34547ca49b7f3ca10599a52db3d"
+
+	var fileServerCalled atomic.Uint64
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileServerCalled.Add(1)
+	}))
+	defer fileServer.Close()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := restoreResponse{URL: fileServer.URL, MatchedKey: cacheKey, ArchiveChecksum: checksum}
+		err := json.NewEncoder(w).Encode(resp)
+		require.NoError(t, err)
+	}))
+	defer apiServer.Close()
+
+	var gotKey, gotChecksum string
+	downloadParams := DownloadParams{
+		APIBaseURL:     apiServer.URL,
+		Token:          "netok",
+		CacheKeys:      []string{cacheKey},
+		DownloadPath:   filepath.Join(t.TempDir(), "testfile.bin"),
+		NumFullRetries: 3,
+		ArchiveMatched: func(matchedKey, archiveChecksum string) bool {
+			gotKey, gotChecksum = matchedKey, archiveChecksum
+			return true
+		},
+	}
+
+	// When
+	matchedKey, err := downloadWithClient(context.Background(), retryableHTTPClient, downloadParams, logger)
+	_, streamMatchedKey, streamErr := downloadStreamWithClient(context.Background(), retryableHTTPClient, downloadParams, logger)
+
+	// Then
+	require.ErrorIs(t, err, ErrArchiveAlreadyRestored)
+	require.Equal(t, cacheKey, matchedKey)
+	require.ErrorIs(t, streamErr, ErrArchiveAlreadyRestored)
+	require.Equal(t, cacheKey, streamMatchedKey)
+	require.Equal(t, cacheKey, gotKey)
+	require.Equal(t, checksum, gotChecksum)
+	require.Equal(t, uint64(0), fileServerCalled.Load(), "the archive should not be downloaded")
+}
+
+func Test_downloadWithClient_WhenRetried_ThenBypassesCDNCache(t *testing.T) {
+	// Given
+	logger := log.NewLogger()
+	retryableHTTPClient := retryhttp.NewClient(logger)
+
+	tmpFile := filepath.Join(t.TempDir(), "testfile.bin")
+	testDummyFileContent := "archive content"
+	cacheKey := "test-cache-key"
+
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := "corrupted content" // Served from a stale edge cache
+		if r.URL.Query().Get("cache-bust") != "" && r.Header.Get("Cache-Control") == "no-cache" {
+			content = testDummyFileContent
+		}
+
+		w.Header().Add("Content-Length", fmt.Sprintf("%d", len(content)))
+		_, err := fmt.Fprint(w, content)
+		require.NoError(t, err)
+	}))
+	defer fileServer.Close()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := restoreResponse{
+			URL:             fileServer.URL + "?signature=abc",
+			MatchedKey:      cacheKey,
+			ArchiveChecksum: "fa868b2818c90263b5c2c8e056180232a6f3c34547ca49b7f3ca10599a52db3d", // sha256 of "archive content"
+		}
+		err := json.NewEncoder(w).Encode(resp)
+		require.NoError(t, err)
+	}))
+	defer apiServer.Close()
+
+	downloadParams := DownloadParams{
+		APIBaseURL:             apiServer.URL,
+		Token:                  "netok",
+		CacheKeys:              []string{cacheKey},
+		DownloadPath:           tmpFile,
+		NumFullRetries:         1,
+		CacheBustingQueryParam: "cache-bust",
+	}
+
+	// When
+	matchedKey, err := downloadWithClient(context.Background(), retryableHTTPClient, downloadParams, logger)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, cacheKey, matchedKey)
+	downloadedContents, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+	require.Equal(t, testDummyFileContent, string(downloadedContents))
+}
+
+func Test_cacheBustedURL(t *testing.T) {
+	busted, err := cacheBustedURL("https://cdn.example.com/archive.tzst?signature=abc", "cb", time.Unix(0, 42))
+
+	require.NoError(t, err)
+	require.Equal(t, "https://cdn.example.com/archive.tzst?cb=42&signature=abc", busted)
+}
+
+func Test_verifyChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.tzst")
+	require.NoError(t, os.WriteFile(path, []byte("archive content"), 0644))
+
+	require.NoError(t, verifyChecksum(path, "", log.NewLogger()))
+	require.NoError(t, verifyChecksum(path, "fa868b2818c90263b5c2c8e056180232a6f3c34547ca49b7f3ca10599a52db3d", log.NewLogger()))
+	require.ErrorIs(t, verifyChecksum(path, "abc", log.NewLogger()), ErrChecksumMismatch)
+}
+
+func Test_verifySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.tzst")
+	require.NoError(t, os.WriteFile(path, []byte("archive content"), 0644))
+
+	require.NoError(t, verifySize(path, 0, log.NewLogger()))
+	require.NoError(t, verifySize(path, 15, log.NewLogger()))
+	require.ErrorIs(t, verifySize(path, 20, log.NewLogger()), ErrSizeMismatch)
+}
+
+type countingRoundTripper struct {
+	calls atomic.Uint64
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.calls.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestDefaultDownloader_WithHTTPClient(t *testing.T) {
+	// Given
+	logger := log.NewLogger()
+	tmpFile := filepath.Join(t.TempDir(), "testfile.bin")
+	cacheKey := "test-cache-key"
+
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprint(w, "archive content")
+		require.NoError(t, err)
+	}))
+	defer fileServer.Close()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := json.NewEncoder(w).Encode(restoreResponse{URL: fileServer.URL, MatchedKey: cacheKey})
+		require.NoError(t, err)
+	}))
+	defer apiServer.Close()
+
+	transport := &countingRoundTripper{}
+	downloader := DefaultDownloader{}.WithHTTPClient(&http.Client{Transport: transport})
+
+	// When
+	matchedKey, err := downloader.Download(context.Background(), DownloadParams{
+		APIBaseURL:     apiServer.URL,
+		Token:          "netok",
+		CacheKeys:      []string{cacheKey},
+		DownloadPath:   tmpFile,
+		NumFullRetries: 1,
+	}, logger)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, cacheKey, matchedKey)
+	require.GreaterOrEqual(t, transport.calls.Load(), uint64(2), "API call and archive download use the custom client")
+}
+
+func Test_downloadStreamWithClient(t *testing.T) {
+	tests := []struct {
+		name            string
+		archiveChecksum string
+		wantErr         error
+	}{
+		{
+			name:            "matching checksum",
+			archiveChecksum: "fa868b2818c90263b5c2c8e056180232a6f3c34547ca49b7f3ca10599a52db3d", // sha256 of "archive content"
+		},
+		{
+			name:            "no checksum",
+			archiveChecksum: "",
+		},
+		{
+			name:            "checksum mismatch",
+			archiveChecksum: "0000000000000000000000000000000000000000000000000000000000000000",
+			wantErr:         ErrChecksumMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			logger := log.NewLogger()
+			cacheKey := "test-cache-key"
+			content := "archive content"
+
+			fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := fmt.Fprint(w, content)
+				require.NoError(t, err)
+			}))
+			defer fileServer.Close()
+
+			apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				err := json.NewEncoder(w).Encode(restoreResponse{
+					URL:             fileServer.URL,
+					MatchedKey:      cacheKey,
+					ArchiveChecksum: tt.archiveChecksum,
+				})
+				require.NoError(t, err)
+			}))
+			defer apiServer.Close()
+
+			params := DownloadParams{
+				APIBaseURL: apiServer.URL,
+				Token:      "token",
+				CacheKeys:  []string{cacheKey},
+			}
+
+			// When
+			stream, matchedKey, err := downloadStreamWithClient(context.Background(), retryhttp.NewClient(logger), params, logger)
+			require.NoError(t, err)
+			defer stream.Close() //nolint:errcheck
+			streamed, err := io.ReadAll(stream)
+
+			// Then
+			require.Equal(t, cacheKey, matchedKey)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, content, string(streamed))
+		})
+	}
+}
+
+func Test_downloadStreamWithClient_WhenCacheKeyNotFound(t *testing.T) {
+	// Given
+	logger := log.NewLogger()
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer apiServer.Close()
+
+	params := DownloadParams{
+		APIBaseURL: apiServer.URL,
+		Token:      "token",
+		CacheKeys:  []string{"missing-key"},
+	}
+
+	// When
+	_, _, err := downloadStreamWithClient(context.Background(), retryhttp.NewClient(logger), params, logger)
+
+	// Then
+	require.ErrorIs(t, err, ErrCacheNotFound)
+}
+
+func Test_downloadStreamWithClient_WhenArchiveUnreadable(t *testing.T) {
+	// Given
+	logger := log.NewLogger()
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer fileServer.Close()
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := json.NewEncoder(w).Encode(restoreResponse{URL: fileServer.URL, MatchedKey: "test-cache-key"})
+		require.NoError(t, err)
+	}))
+	defer apiServer.Close()
+	params := DownloadParams{APIBaseURL: apiServer.URL, Token: "token", CacheKeys: []string{"test-cache-key"}}
+
+	// When
+	_, _, err := downloadStreamWithClient(context.Background(), retryhttp.NewClient(logger), params, logger)
+
+	// Then
+	require.ErrorIs(t, err, ErrArchiveUnreadable)
+}
+
+func Test_gotStatusError(t *testing.T) {
+	err := gotStatusError(errors.New("Response status code is not ok: 404"))
+	require.ErrorIs(t, err, ErrArchiveUnreadable)
+
+	err = gotStatusError(errors.New("Response status code is not ok: 500"))
+	require.False(t, errors.Is(err, ErrArchiveUnreadable))
+	require.EqualError(t, err, "Response status code is not ok: 500")
+}
+
+func Test_downloadFile_WhenStorageIgnoresRange_ThenDownloadsInSingleRequest(t *testing.T) {
+	// Given
+	content := strings.Repeat("archive content ", 64*1024) // 1MB
+	var singleRequests atomic.Int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Range") {
+		case "bytes=0-0":
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-0/%d", len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, err := fmt.Fprint(w, content[:1])
+			require.NoError(t, err)
+		case "":
+			singleRequests.Add(1)
+			_, err := fmt.Fprint(w, content)
+			require.NoError(t, err)
+		default:
+			// The range of the chunk is ignored
+			_, err := fmt.Fprint(w, content)
+			require.NoError(t, err)
+		}
+	}))
+	defer svr.Close()
+	dest := filepath.Join(t.TempDir(), "archive.tzst")
+	logger := log.NewLogger()
+
+	// When
+	err := downloadFile(context.Background(), retryhttp.NewClient(logger), svr.URL, dest, 4, false, nil, logger)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, int32(1), singleRequests.Load())
+	downloaded, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, content, string(downloaded))
+}
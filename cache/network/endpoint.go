@@ -0,0 +1,74 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+var (
+	workingAPIBaseURLLock sync.Mutex
+	// workingAPIBaseURL is the last API base URL that responded, it's tried first for the rest of the process
+	workingAPIBaseURL string
+)
+
+// apiBaseURLs returns the API base URLs in the order they should be tried: the primary one followed by the failovers,
+// except when one of them is already known to be working, which is moved to the front.
+func apiBaseURLs(primary string, failovers []string) []string {
+	urls := []string{primary}
+	for _, url := range failovers {
+		if url != "" && url != primary {
+			urls = append(urls, url)
+		}
+	}
+
+	workingAPIBaseURLLock.Lock()
+	working := workingAPIBaseURL
+	workingAPIBaseURLLock.Unlock()
+
+	for i, url := range urls {
+		if i > 0 && url == working {
+			reordered := []string{working}
+			reordered = append(reordered, urls[:i]...)
+			return append(reordered, urls[i+1:]...)
+		}
+	}
+	return urls
+}
+
+// withAPIFailover calls fn with each API base URL in order until one of them succeeds, or fails with an error that is
+// not a connection error (such as an HTTP error response). The API base URL of the last call is returned.
+func withAPIFailover(httpClient *retryablehttp.Client, primary string, failovers []string, logger log.Logger, fn func(baseURL string) error) (string, error) {
+	urls := apiBaseURLs(primary, failovers)
+
+	var err error
+	for i, url := range urls {
+		if i > 0 {
+			logger.Warnf("Cache API endpoint is unreachable, trying failover endpoint (%d/%d)", i, len(urls)-1)
+			// Drop pooled connections, so that the next endpoint is resolved and dialed from scratch
+			httpClient.HTTPClient.CloseIdleConnections()
+		}
+
+		err = fn(url)
+		if err == nil || !isConnectionError(err) {
+			if err == nil {
+				workingAPIBaseURLLock.Lock()
+				workingAPIBaseURL = url
+				workingAPIBaseURLLock.Unlock()
+			}
+			return url, err
+		}
+		logger.Debugf("Connection error with API endpoint: %s", err)
+	}
+
+	return urls[len(urls)-1], err
+}
+
+func isConnectionError(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || errors.As(err, &opErr)
+}
@@ -0,0 +1,84 @@
+package network
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/retryhttp"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_apiBaseURLs(t *testing.T) {
+	t.Cleanup(func() { workingAPIBaseURL = "" })
+
+	workingAPIBaseURL = ""
+	assert.Equal(t, []string{"primary", "failover1", "failover2"}, apiBaseURLs("primary", []string{"", "failover1", "primary", "failover2"}))
+
+	workingAPIBaseURL = "failover2"
+	assert.Equal(t, []string{"failover2", "primary", "failover1"}, apiBaseURLs("primary", []string{"failover1", "failover2"}))
+
+	workingAPIBaseURL = "unrelated"
+	assert.Equal(t, []string{"primary", "failover1"}, apiBaseURLs("primary", []string{"failover1"}))
+}
+
+func Test_withAPIFailover(t *testing.T) {
+	t.Cleanup(func() { workingAPIBaseURL = "" })
+	workingAPIBaseURL = ""
+
+	unreachableServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unreachableServer.Close()
+
+	var failoverCalls int
+	failoverServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failoverCalls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer failoverServer.Close()
+
+	errorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer errorServer.Close()
+
+	httpClient := retryhttp.NewClient(log.NewLogger())
+	httpClient.RetryMax = 0
+	get := func(baseURL string) error {
+		resp, err := httpClient.Get(baseURL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close() //nolint:errcheck
+		if resp.StatusCode != http.StatusOK {
+			return unwrapError(resp)
+		}
+		return nil
+	}
+
+	// Unreachable primary endpoint fails over
+	usedURL, err := withAPIFailover(httpClient, unreachableServer.URL, []string{failoverServer.URL}, log.NewLogger(), get)
+	require.NoError(t, err)
+	assert.Equal(t, failoverServer.URL, usedURL)
+	assert.Equal(t, 1, failoverCalls)
+
+	// The working endpoint is remembered
+	assert.Equal(t, []string{failoverServer.URL, unreachableServer.URL}, apiBaseURLs(unreachableServer.URL, []string{failoverServer.URL}))
+
+	// HTTP errors don't fail over
+	usedURL, err = withAPIFailover(httpClient, errorServer.URL, []string{unreachableServer.URL}, log.NewLogger(), get)
+	require.Error(t, err)
+	assert.Equal(t, errorServer.URL, usedURL)
+	assert.Equal(t, 1, failoverCalls)
+}
+
+func Test_isConnectionError(t *testing.T) {
+	client := retryablehttp.NewClient()
+	client.RetryMax = 0
+	_, err := client.Get("http://127.0.0.1:1")
+
+	assert.True(t, isConnectionError(err))
+	assert.False(t, isConnectionError(ErrCacheNotFound))
+}
@@ -0,0 +1,106 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Typed errors of unsuccessful cache API and storage responses, check them with errors.Is to give users actionable
+// messages. The error is an *APIError with the status code and the response body.
+var (
+	// ErrUnauthorized means that the credentials are missing, invalid or expired (HTTP 401 and 403)
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrQuotaExceeded means that the cache storage quota of the workspace is used up (HTTP 402, or 403 and 429
+	// responses mentioning the quota)
+	ErrQuotaExceeded = errors.New("cache quota exceeded")
+	// ErrServiceUnavailable means that the cache service is temporarily down or overloaded
+	// (HTTP 429, 502, 503 and 504, after retries)
+	ErrServiceUnavailable = errors.New("cache service unavailable")
+	// ErrConflict means that the key was saved with another archive since the build restored it
+	// (HTTP 409 and 412), see UploadParams.BaseArchiveChecksum
+	ErrConflict = errors.New("cache key was saved by another build")
+	// ErrArchiveUnreadable means that the key matched an archive, but the storage can't serve it (HTTP 404 and 410
+	// responses of the archive URL), for example because the archive of a cancelled save was never published,
+	// or it was deleted since
+	ErrArchiveUnreadable = errors.New("cache archive exists but is unreadable")
+)
+
+// APIError is an unsuccessful response of the cache API or the storage
+type APIError struct {
+	StatusCode int
+	Body       string
+	// kind is one of the typed errors (such as ErrUnauthorized), or nil
+	kind error
+}
+
+// Error ...
+func (e *APIError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// Unwrap returns the typed error of the response, such as ErrUnauthorized
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// CurrentArchiveChecksum returns the checksum of the archive the key is saved with, as reported by a conflict
+// response (see ErrConflict), or an empty string if the response doesn't include it
+func (e *APIError) CurrentArchiveChecksum() string {
+	var body struct {
+		CurrentArchiveChecksum string `json:"current_archive_checksum"`
+	}
+	if err := json.Unmarshal([]byte(e.Body), &body); err != nil {
+		return ""
+	}
+	return body.CurrentArchiveChecksum
+}
+
+// NewAPIError returns the error of an unsuccessful response with the typed error of its status code and body,
+// for example for custom Uploader and Downloader implementations
+func NewAPIError(statusCode int, body string) *APIError {
+	return &APIError{StatusCode: statusCode, Body: body, kind: classifyStatus(statusCode, body)}
+}
+
+// newStorageError returns the error of an unsuccessful archive download response. Unlike the cache API,
+// the storage responds with HTTP 404 and 410 for archives it can't serve.
+func newStorageError(statusCode int, body string) *APIError {
+	err := NewAPIError(statusCode, body)
+	if isUnreadableStatus(statusCode) {
+		err.kind = ErrArchiveUnreadable
+	}
+	return err
+}
+
+func isUnreadableStatus(statusCode int) bool {
+	return statusCode == http.StatusNotFound || statusCode == http.StatusGone
+}
+
+func classifyStatus(statusCode int, body string) error {
+	mentionsQuota := strings.Contains(strings.ToLower(body), "quota")
+	switch statusCode {
+	case http.StatusPaymentRequired:
+		return ErrQuotaExceeded
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		if mentionsQuota {
+			return ErrQuotaExceeded
+		}
+		return ErrUnauthorized
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ErrConflict
+	case http.StatusRequestEntityTooLarge:
+		return ErrArchiveTooLarge
+	case http.StatusTooManyRequests:
+		if mentionsQuota {
+			return ErrQuotaExceeded
+		}
+		return ErrServiceUnavailable
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrServiceUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		body       string
+		want       error
+	}{
+		{statusCode: http.StatusUnauthorized, body: "invalid token", want: ErrUnauthorized},
+		{statusCode: http.StatusForbidden, body: "access denied", want: ErrUnauthorized},
+		{statusCode: http.StatusForbidden, body: "Storage quota exceeded", want: ErrQuotaExceeded},
+		{statusCode: http.StatusPaymentRequired, body: "", want: ErrQuotaExceeded},
+		{statusCode: http.StatusRequestEntityTooLarge, body: "", want: ErrArchiveTooLarge},
+		{statusCode: http.StatusConflict, body: "key was updated", want: ErrConflict},
+		{statusCode: http.StatusPreconditionFailed, body: "", want: ErrConflict},
+		{statusCode: http.StatusTooManyRequests, body: "slow down", want: ErrServiceUnavailable},
+		{statusCode: http.StatusServiceUnavailable, body: "maintenance", want: ErrServiceUnavailable},
+		{statusCode: http.StatusInternalServerError, body: "internal error", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("HTTP %d %s", tt.statusCode, tt.body), func(t *testing.T) {
+			err := fmt.Errorf("failed to get upload URL: %w", NewAPIError(tt.statusCode, tt.body))
+
+			var apiErr *APIError
+			require.True(t, errors.As(err, &apiErr))
+			require.Equal(t, tt.statusCode, apiErr.StatusCode)
+			require.Equal(t, fmt.Sprintf("failed to get upload URL: HTTP %d: %s", tt.statusCode, tt.body), err.Error())
+			if tt.want == nil {
+				require.Nil(t, errors.Unwrap(apiErr))
+			} else {
+				require.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIError_CurrentArchiveChecksum(t *testing.T) {
+	err := NewAPIError(http.StatusConflict, `{"message":"key was updated","current_archive_checksum":"abc123"}`)
+	require.Equal(t, "abc123", err.CurrentArchiveChecksum())
+
+	err = NewAPIError(http.StatusConflict, "key was updated")
+	require.Equal(t, "", err.CurrentArchiveChecksum())
+}
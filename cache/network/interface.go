@@ -2,6 +2,7 @@ package network
 
 import (
 	"context"
+	"io"
 
 	"github.com/bitrise-io/go-utils/v2/log"
 )
@@ -15,3 +16,10 @@ type Uploader interface {
 type Downloader interface {
 	Download(context.Context, DownloadParams, log.Logger) (string, error)
 }
+
+// StreamDownloader is implemented by downloaders that can stream the archive, so that it can be extracted while
+// it's being downloaded, instead of saving it to DownloadParams.DownloadPath first.
+// It returns the archive stream and the matched key. If there is no match for any of the keys, the error is ErrCacheNotFound.
+type StreamDownloader interface {
+	DownloadStream(context.Context, DownloadParams, log.Logger) (io.ReadCloser, string, error)
+}
@@ -0,0 +1,145 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const localArchiveExtension = ".tzst"
+
+// LocalStorage saves and restores cache archives in a local (or network mounted) directory instead of the cache API.
+// It implements both Uploader and Downloader, so it can be used for testing cache steps locally and on air-gapped
+// self-hosted runners. The API related params (base URL and token) are ignored.
+type LocalStorage struct {
+	RootDir string
+}
+
+// Upload copies the archive to the root directory and associates it with the provided cache key.
+// An existing archive with the same key is overwritten.
+func (s LocalStorage) Upload(_ context.Context, params UploadParams, logger log.Logger) error {
+	validatedKey, err := validateKey(params.CacheKey, logger)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.RootDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Copy to a temporary file first, so that a concurrent restore never sees a partially written archive
+	destination := filepath.Join(s.RootDir, localArchiveName(validatedKey))
+	tmpDestination := destination + ".tmp"
+	if err := copyFile(params.ArchivePath, tmpDestination); err != nil {
+		return fmt.Errorf("failed to copy archive: %w", err)
+	}
+	if err := os.Rename(tmpDestination, destination); err != nil {
+		return fmt.Errorf("failed to move archive in place: %w", err)
+	}
+	logger.Debugf("Archive saved to %s", destination)
+
+	return nil
+}
+
+// Download copies the archive matching the first possible key to the download path.
+// Keys are matched exactly first, then by prefix (the most recently saved archive wins),
+// the same way the cache API matches them. If there is no match for any of the keys, the error is ErrCacheNotFound.
+func (s LocalStorage) Download(_ context.Context, params DownloadParams, logger log.Logger) (string, error) {
+	if len(params.CacheKeys) == 0 {
+		return "", fmt.Errorf("cache key list is empty")
+	}
+	if _, err := validateKeys(params.CacheKeys); err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(s.RootDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrCacheNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list cache directory: %w", err)
+	}
+
+	for _, key := range params.CacheKeys {
+		if len(key) > maxKeyLength {
+			key = key[:maxKeyLength]
+		}
+
+		matchedKey, path := s.findArchive(entries, key)
+		if path == "" {
+			continue
+		}
+
+		logger.Debugf("Found archive %s for key %s", path, key)
+		if err := copyFile(path, params.DownloadPath); err != nil {
+			return "", fmt.Errorf("failed to copy archive: %w", err)
+		}
+		return matchedKey, nil
+	}
+
+	return "", ErrCacheNotFound
+}
+
+func (s LocalStorage) findArchive(entries []os.DirEntry, key string) (string, string) {
+	exactName := localArchiveName(key)
+	prefix := strings.TrimSuffix(exactName, localArchiveExtension)
+
+	var matchedKey, matchedPath string
+	var matchedModTime int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, localArchiveExtension) || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		entryKey, err := url.PathUnescape(strings.TrimSuffix(name, localArchiveExtension))
+		if err != nil {
+			continue
+		}
+		if name == exactName {
+			return entryKey, filepath.Join(s.RootDir, name)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if matchedPath == "" || info.ModTime().UnixNano() > matchedModTime {
+			matchedKey = entryKey
+			matchedPath = filepath.Join(s.RootDir, name)
+			matchedModTime = info.ModTime().UnixNano()
+		}
+	}
+
+	return matchedKey, matchedPath
+}
+
+func localArchiveName(key string) string {
+	return url.PathEscape(key) + localArchiveExtension
+}
+
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint:errcheck
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close() //nolint:errcheck
+		return err
+	}
+	return out.Close()
+}
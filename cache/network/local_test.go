@@ -0,0 +1,91 @@
+package network
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage_UploadAndDownload(t *testing.T) {
+	// Given
+	storage := LocalStorage{RootDir: filepath.Join(t.TempDir(), "cache")}
+	logger := log.NewLogger()
+	archives := map[string]string{
+		"npm-cache-main":         "main archive",
+		"npm-cache-feature/some": "feature archive",
+	}
+	for key, content := range archives {
+		archivePath := filepath.Join(t.TempDir(), "archive.tzst")
+		require.NoError(t, os.WriteFile(archivePath, []byte(content), 0644))
+		require.NoError(t, storage.Upload(context.Background(), UploadParams{ArchivePath: archivePath, CacheKey: key}, logger))
+	}
+	// Make the feature archive the most recent one
+	featurePath := filepath.Join(storage.RootDir, localArchiveName("npm-cache-feature/some"))
+	require.NoError(t, os.Chtimes(featurePath, time.Now().Add(time.Hour), time.Now().Add(time.Hour)))
+
+	tests := []struct {
+		name        string
+		keys        []string
+		wantKey     string
+		wantContent string
+		wantErr     error
+	}{
+		{
+			name:        "exact match",
+			keys:        []string{"npm-cache-main"},
+			wantKey:     "npm-cache-main",
+			wantContent: "main archive",
+		},
+		{
+			name:        "exact match for fallback key",
+			keys:        []string{"npm-cache-develop", "npm-cache-main"},
+			wantKey:     "npm-cache-main",
+			wantContent: "main archive",
+		},
+		{
+			name:        "prefix match picks the most recent archive",
+			keys:        []string{"npm-cache-"},
+			wantKey:     "npm-cache-feature/some",
+			wantContent: "feature archive",
+		},
+		{
+			name:    "no match",
+			keys:    []string{"gradle-cache"},
+			wantErr: ErrCacheNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			downloadPath := filepath.Join(t.TempDir(), "downloaded.tzst")
+
+			// When
+			matchedKey, err := storage.Download(context.Background(), DownloadParams{CacheKeys: tt.keys, DownloadPath: downloadPath}, logger)
+
+			// Then
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantKey, matchedKey)
+			content, err := os.ReadFile(downloadPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantContent, string(content))
+		})
+	}
+}
+
+func TestLocalStorage_DownloadFromMissingDir(t *testing.T) {
+	storage := LocalStorage{RootDir: filepath.Join(t.TempDir(), "nonexistent")}
+	params := DownloadParams{CacheKeys: []string{"key"}, DownloadPath: filepath.Join(t.TempDir(), "archive.tzst")}
+
+	_, err := storage.Download(context.Background(), params, log.NewLogger())
+
+	assert.ErrorIs(t, err, ErrCacheNotFound)
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/docker/go-units"
+)
+
+const (
+	defaultMemoryPollInterval = time.Second
+	defaultMaxMemoryPause     = 2 * time.Minute
+)
+
+// MemoryWatchdog pauses launching new parallel chunk downloads while the available memory (of the container's cgroup
+// if it's limited, otherwise of the system) is below MinAvailable, to avoid OOM kills on small runners.
+// Chunks already in flight are not affected, their buffers are released as the archive is read.
+type MemoryWatchdog struct {
+	// MinAvailable is the available memory in bytes below which new chunks are paused
+	MinAvailable uint64
+	// PollInterval is how often the available memory is checked during a pause, one second if not set
+	PollInterval time.Duration
+	// MaxPause is the longest pause, after that the download continues anyway (two minutes if not set)
+	MaxPause time.Duration
+
+	// availableMemory is replaced in tests
+	availableMemory func() (uint64, error)
+}
+
+// NewMemoryWatchdog returns a watchdog pausing new chunks while the available memory is below minAvailable bytes
+func NewMemoryWatchdog(minAvailable uint64) *MemoryWatchdog {
+	return &MemoryWatchdog{MinAvailable: minAvailable}
+}
+
+// Wait returns when the available memory is above MinAvailable, MaxPause has passed, or ctx is done.
+// It never pauses if the available memory can't be determined (for example on macOS).
+func (w *MemoryWatchdog) Wait(ctx context.Context, logger log.Logger) error {
+	available := w.availableMemory
+	if available == nil {
+		available = availableMemory
+	}
+	pollInterval := w.PollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultMemoryPollInterval
+	}
+	maxPause := w.MaxPause
+	if maxPause == 0 {
+		maxPause = defaultMaxMemoryPause
+	}
+
+	var pauseStart time.Time
+	for {
+		free, err := available()
+		if err != nil || free >= w.MinAvailable {
+			if !pauseStart.IsZero() {
+				logger.Debugf("Resuming chunk downloads after %s", time.Since(pauseStart).Round(time.Second))
+			}
+			return nil
+		}
+
+		if pauseStart.IsZero() {
+			pauseStart = time.Now()
+			logger.Warnf("Low memory (%s available), pausing new chunk downloads", units.BytesSize(float64(free)))
+		} else if time.Since(pauseStart) >= maxPause {
+			logger.Warnf("Memory is still low after %s, continuing the download", maxPause)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// availableMemory returns the memory available to the process: the lower of the system's available memory and the
+// remaining memory of the cgroup (v2 or v1) if it's limited
+func availableMemory() (uint64, error) {
+	available, err := memInfoAvailable("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	for _, files := range [][2]string{
+		{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory.current"},
+		{"/sys/fs/cgroup/memory/memory.limit_in_bytes", "/sys/fs/cgroup/memory/memory.usage_in_bytes"},
+	} {
+		if remaining, ok := cgroupRemainingMemory(files[0], files[1]); ok {
+			if remaining < available {
+				available = remaining
+			}
+			break
+		}
+	}
+	return available, nil
+}
+
+// memInfoAvailable returns the MemAvailable value of /proc/meminfo in bytes
+func memInfoAvailable(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemAvailable value: %s", fields[1])
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("MemAvailable not found")
+}
+
+// cgroupRemainingMemory returns the limit minus the usage of the cgroup, and false if the cgroup is not limited
+func cgroupRemainingMemory(limitPath, usagePath string) (uint64, bool) {
+	limit, err := readUintFile(limitPath)
+	if err != nil {
+		// "max" in cgroup v2 means no limit
+		return 0, false
+	}
+	usage, err := readUintFile(usagePath)
+	if err != nil {
+		return 0, false
+	}
+	if usage >= limit {
+		return 0, true
+	}
+	return limit - usage, true
+}
+
+func readUintFile(path string) (uint64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
@@ -0,0 +1,89 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeAvailableMemory(values ...uint64) func() (uint64, error) {
+	return func() (uint64, error) {
+		value := values[0]
+		if len(values) > 1 {
+			values = values[1:]
+		}
+		return value, nil
+	}
+}
+
+func TestMemoryWatchdog_Wait(t *testing.T) {
+	// Given
+	w := &MemoryWatchdog{MinAvailable: 100, PollInterval: time.Millisecond, availableMemory: fakeAvailableMemory(10, 50, 200)}
+
+	// When
+	err := w.Wait(context.Background(), log.NewLogger())
+
+	// Then
+	require.NoError(t, err)
+}
+
+func TestMemoryWatchdog_Wait_MaxPause(t *testing.T) {
+	// Given
+	w := &MemoryWatchdog{MinAvailable: 100, PollInterval: time.Millisecond, MaxPause: 10 * time.Millisecond, availableMemory: fakeAvailableMemory(10)}
+
+	// When
+	err := w.Wait(context.Background(), log.NewLogger())
+
+	// Then
+	require.NoError(t, err)
+}
+
+func TestMemoryWatchdog_Wait_Cancelled(t *testing.T) {
+	// Given
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := &MemoryWatchdog{MinAvailable: 100, PollInterval: time.Hour, availableMemory: fakeAvailableMemory(10)}
+
+	// When
+	err := w.Wait(ctx, log.NewLogger())
+
+	// Then
+	require.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestMemoryWatchdog_Wait_UnknownMemory(t *testing.T) {
+	w := &MemoryWatchdog{MinAvailable: 100, availableMemory: func() (uint64, error) { return 0, errors.New("not supported") }}
+
+	require.NoError(t, w.Wait(context.Background(), log.NewLogger()))
+}
+
+func Test_memInfoAvailable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meminfo")
+	require.NoError(t, os.WriteFile(path, []byte("MemTotal:       16384000 kB\nMemFree:         1024000 kB\nMemAvailable:    2048000 kB\n"), 0644))
+
+	available, err := memInfoAvailable(path)
+
+	require.NoError(t, err)
+	require.Equal(t, uint64(2048000*1024), available)
+}
+
+func Test_cgroupRemainingMemory(t *testing.T) {
+	dir := t.TempDir()
+	limitPath, usagePath := filepath.Join(dir, "memory.max"), filepath.Join(dir, "memory.current")
+	require.NoError(t, os.WriteFile(usagePath, []byte("300\n"), 0644))
+
+	require.NoError(t, os.WriteFile(limitPath, []byte("max\n"), 0644))
+	_, limited := cgroupRemainingMemory(limitPath, usagePath)
+	require.False(t, limited)
+
+	require.NoError(t, os.WriteFile(limitPath, []byte("1000\n"), 0644))
+	remaining, limited := cgroupRemainingMemory(limitPath, usagePath)
+	require.True(t, limited)
+	require.Equal(t, uint64(700), remaining)
+}
@@ -0,0 +1,127 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// operationLogger prefixes every log line with the operation, its correlation ID and the number of the attempt,
+// like `[download 3f9a1c2e #2]`, so that the lines of an operation (and of its retries) can be told apart in the
+// build log
+type operationLogger struct {
+	log.Logger
+	prefix string
+}
+
+// newCorrelationID returns a random ID identifying an upload or download in the logs
+func newCorrelationID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(b)
+}
+
+// newOperationLogger returns a logger prefixing the lines with the operation and its correlation ID,
+// and with the attempt number if attempt is not 0
+func newOperationLogger(logger log.Logger, operation, correlationID string, attempt uint) log.Logger {
+	if l, ok := logger.(operationLogger); ok {
+		logger = l.Logger
+	}
+	prefix := fmt.Sprintf("[%s %s] ", operation, correlationID)
+	if attempt != 0 {
+		prefix = fmt.Sprintf("[%s %s #%d] ", operation, correlationID, attempt)
+	}
+	return operationLogger{Logger: logger, prefix: prefix}
+}
+
+// prefixed adds the prefix to the format, unless it's an empty (spacing) line
+func (l operationLogger) prefixed(format string) string {
+	if strings.TrimSpace(format) == "" {
+		return format
+	}
+	return l.prefix + format
+}
+
+// useLogger makes the HTTP client log its request retries with logger, and returns a function restoring the
+// previous logger
+func useLogger(client *retryablehttp.Client, logger log.Logger) func() {
+	previous := client.Logger
+	client.Logger = httpLogAdaptor{logger: logger}
+	return func() { client.Logger = previous }
+}
+
+// httpLogAdaptor adapts the retryablehttp.Logger interface to the go-utils logger, like retryhttp.NewClient does
+type httpLogAdaptor struct {
+	logger log.Logger
+}
+
+// Printf implements the retryablehttp.Logger interface
+func (a httpLogAdaptor) Printf(format string, v ...interface{}) {
+	a.logger.Debugf(format, v...)
+}
+
+// Infof ...
+func (l operationLogger) Infof(format string, v ...interface{}) {
+	l.Logger.Infof(l.prefixed(format), v...)
+}
+
+// Warnf ...
+func (l operationLogger) Warnf(format string, v ...interface{}) {
+	l.Logger.Warnf(l.prefixed(format), v...)
+}
+
+// Printf ...
+func (l operationLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Printf(l.prefixed(format), v...)
+}
+
+// Donef ...
+func (l operationLogger) Donef(format string, v ...interface{}) {
+	l.Logger.Donef(l.prefixed(format), v...)
+}
+
+// Debugf ...
+func (l operationLogger) Debugf(format string, v ...interface{}) {
+	l.Logger.Debugf(l.prefixed(format), v...)
+}
+
+// Errorf ...
+func (l operationLogger) Errorf(format string, v ...interface{}) {
+	l.Logger.Errorf(l.prefixed(format), v...)
+}
+
+// TInfof ...
+func (l operationLogger) TInfof(format string, v ...interface{}) {
+	l.Logger.TInfof(l.prefixed(format), v...)
+}
+
+// TWarnf ...
+func (l operationLogger) TWarnf(format string, v ...interface{}) {
+	l.Logger.TWarnf(l.prefixed(format), v...)
+}
+
+// TPrintf ...
+func (l operationLogger) TPrintf(format string, v ...interface{}) {
+	l.Logger.TPrintf(l.prefixed(format), v...)
+}
+
+// TDonef ...
+func (l operationLogger) TDonef(format string, v ...interface{}) {
+	l.Logger.TDonef(l.prefixed(format), v...)
+}
+
+// TDebugf ...
+func (l operationLogger) TDebugf(format string, v ...interface{}) {
+	l.Logger.TDebugf(l.prefixed(format), v...)
+}
+
+// TErrorf ...
+func (l operationLogger) TErrorf(format string, v ...interface{}) {
+	l.Logger.TErrorf(l.prefixed(format), v...)
+}
@@ -0,0 +1,45 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/retryhttp"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newOperationLogger(t *testing.T) {
+	// Given
+	mockLogger := new(MockLogger)
+	mockLogger.On("Debugf", "[download 3f9a1c2e #2] Downloading archive...", mock.Anything).Return()
+	mockLogger.On("Warnf", "[upload 3f9a1c2e] Key is too long", mock.Anything).Return()
+	mockLogger.On("Debugf", "", mock.Anything).Return()
+
+	// When
+	downloadLogger := newOperationLogger(mockLogger, "download", "3f9a1c2e", 1)
+	downloadLogger = newOperationLogger(downloadLogger, "download", "3f9a1c2e", 2)
+	downloadLogger.Debugf("Downloading archive...")
+	downloadLogger.Debugf("")
+	newOperationLogger(mockLogger, "upload", "3f9a1c2e", 0).Warnf("Key is too long")
+
+	// Then
+	mockLogger.AssertExpectations(t)
+}
+
+func Test_useLogger(t *testing.T) {
+	// Given
+	mockLogger := new(MockLogger)
+	mockLogger.On("Debugf", "[download 3f9a1c2e #1] retrying", mock.Anything).Return()
+	client := retryhttp.NewClient(mockLogger)
+	previous := client.Logger
+
+	// When
+	restore := useLogger(client, newOperationLogger(mockLogger, "download", "3f9a1c2e", 1))
+	client.Logger.(httpLogAdaptor).Printf("retrying")
+	restore()
+
+	// Then
+	mockLogger.AssertExpectations(t)
+	require.Equal(t, previous, client.Logger)
+	require.Len(t, newCorrelationID(), 8)
+}
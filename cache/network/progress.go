@@ -0,0 +1,56 @@
+package network
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/progress"
+	"github.com/bitrise-io/got"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// Interval of the DownloadParams.ProgressFunc calls
+const progressInterval = time.Second
+
+// DownloadProgress is the state of an archive download, see DownloadParams.ProgressFunc
+type DownloadProgress struct {
+	// DownloadedBytes is the number of bytes downloaded so far (in all chunks)
+	DownloadedBytes uint64
+	// TotalBytes is the size of the archive, 0 if unknown
+	TotalBytes uint64
+	// BytesPerSecond is the average download speed
+	BytesPerSecond uint64
+	Elapsed        time.Duration
+	// ETA is the estimated remaining time based on the average speed, 0 if unknown
+	ETA time.Duration
+}
+
+func newDownloadProgress(d *got.Download) DownloadProgress {
+	progress := DownloadProgress{
+		DownloadedBytes: d.Size(),
+		TotalBytes:      d.TotalSize(),
+		BytesPerSecond:  d.AvgSpeed(),
+		Elapsed:         d.TotalCost(),
+	}
+	progress.ETA = estimatedTimeLeft(progress.DownloadedBytes, progress.TotalBytes, progress.BytesPerSecond)
+	return progress
+}
+
+func estimatedTimeLeft(downloaded, total, bytesPerSecond uint64) time.Duration {
+	if total == 0 || bytesPerSecond == 0 || downloaded >= total {
+		return 0
+	}
+	return time.Duration(float64(total-downloaded) / float64(bytesPerSecond) * float64(time.Second))
+}
+
+// reportRetries reports the retried requests of the client (including the chunk requests of the archive download)
+func reportRetries(client *retryablehttp.Client, reporter progress.Reporter, phase progress.Phase) {
+	if reporter == nil {
+		return
+	}
+	client.RequestLogHook = func(_ retryablehttp.Logger, _ *http.Request, attempt int) {
+		if attempt > 0 {
+			reporter.Retry(phase, uint(attempt), nil)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_estimatedTimeLeft(t *testing.T) {
+	tests := []struct {
+		name           string
+		downloaded     uint64
+		total          uint64
+		bytesPerSecond uint64
+		want           time.Duration
+	}{
+		{name: "half downloaded", downloaded: 50, total: 100, bytesPerSecond: 10, want: 5 * time.Second},
+		{name: "unknown total size", downloaded: 50, total: 0, bytesPerSecond: 10, want: 0},
+		{name: "no speed yet", downloaded: 0, total: 100, bytesPerSecond: 0, want: 0},
+		{name: "finished", downloaded: 100, total: 100, bytesPerSecond: 10, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, estimatedTimeLeft(tt.downloaded, tt.total, tt.bytesPerSecond))
+		})
+	}
+}
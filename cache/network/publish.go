@@ -0,0 +1,64 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// verifyPublish checks that the published key matches an archive of the uploaded size, and that the storage serves it
+func verifyPublish(ctx context.Context, client apiClient, key, archivePath string, logger log.Logger) error {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.restore([]string{key})
+	if err != nil {
+		return fmt.Errorf("the published key is not matchable: %w", err)
+	}
+	if response.MatchedKey != key {
+		return fmt.Errorf("the published key is not matchable, %s matched instead", response.MatchedKey)
+	}
+
+	size, err := probeArchive(ctx, client.httpClient, response.URL)
+	if err != nil {
+		return err
+	}
+	if size >= 0 && size != info.Size() {
+		// Not an error: a parallel build might have saved the same key since
+		logger.Warnf("The published archive is %d bytes instead of %d bytes, the key was probably saved by another build", size, info.Size())
+		return nil
+	}
+	logger.Debugf("Published archive verified (%d bytes)", size)
+	return nil
+}
+
+// probeArchive requests the first byte of the archive, and returns the size of the whole archive (-1 if unknown)
+func probeArchive(ctx context.Context, httpClient *retryablehttp.Client, url string) (int64, error) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The storage ignored the range, the body is not read
+		return resp.ContentLength, nil
+	case http.StatusPartialContent:
+		return parseContentRangeSize(resp.Header.Get("Content-Range"))
+	default:
+		return 0, unwrapStorageError(resp)
+	}
+}
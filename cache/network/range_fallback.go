@@ -0,0 +1,84 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// errRangeIgnored means that the storage answered a chunk's range request with the whole archive
+var errRangeIgnored = errors.New("the storage ignored the range request")
+
+// rangeCheckingTransport fails the chunk requests of a download that are answered with HTTP 200 and a body of
+// another size than the requested range (such as the whole archive), as got can't use them. The first request
+// (bytes=0-0) is not checked: got downloads the archive in a single request if that one is not ranged.
+type rangeCheckingTransport struct {
+	next      http.RoundTripper
+	onIgnored func()
+}
+
+func (t rangeCheckingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	rangeHeader := req.Header.Get("Range")
+	if rangeHeader == "" || rangeHeader == "bytes=0-0" || resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	if length, ok := rangeLength(rangeHeader); ok && resp.ContentLength == length {
+		return resp, nil
+	}
+	resp.Body.Close() //nolint:errcheck
+	t.onIgnored()
+	return nil, errRangeIgnored
+}
+
+// rangeLength returns the length of a single range Range header, like `bytes=100-199`
+func rangeLength(rangeHeader string) (int64, bool) {
+	var start, end int64
+	if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end); err != nil || end < start {
+		return 0, false
+	}
+	return end - start + 1, true
+}
+
+// downloadSingleStream downloads the archive in a single request, for storages that don't support range requests
+// reliably (see rangeCheckingTransport)
+func downloadSingleStream(ctx context.Context, client *http.Client, url string, dest string, logger log.Logger) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return unwrapStorageError(resp)
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to download archive: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	expectedSize := uint64(0)
+	if resp.ContentLength > 0 {
+		expectedSize = uint64(resp.ContentLength)
+	}
+	return verifySize(dest, expectedSize, logger)
+}
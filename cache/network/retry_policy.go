@@ -0,0 +1,95 @@
+package network
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// RetryPolicy decides which failed requests of an upload are retried, and how long to wait before the retries,
+// so that the uploader can be tuned for the backend it talks to (such as the cache API or presigned storage URLs).
+// See ExponentialJitterRetryPolicy and RetryAfterRetryPolicy.
+type RetryPolicy interface {
+	// MaxRetries is the number of retries of a request
+	MaxRetries() int
+	// ShouldRetry decides whether the request is retried, based on its response or error. The returned error
+	// (if any) is returned instead of the request's error.
+	ShouldRetry(ctx context.Context, resp *http.Response, err error) (bool, error)
+	// Delay is the wait before the given retry (0 is the first one), resp is the failed response (if any)
+	Delay(retry int, resp *http.Response) time.Duration
+}
+
+// ExponentialJitterRetryPolicy retries connection errors, HTTP 429 and 5xx responses (except 501), and waits
+// exponentially longer between the attempts (from Min up to Max) with a random jitter between half and the full wait,
+// so that many runners retrying at the same time don't hit the backend in waves
+type ExponentialJitterRetryPolicy struct {
+	Retries int
+	Min     time.Duration
+	Max     time.Duration
+}
+
+// NewExponentialJitterRetryPolicy returns a policy with 4 retries, waiting between 1 and 30 seconds
+func NewExponentialJitterRetryPolicy() ExponentialJitterRetryPolicy {
+	return ExponentialJitterRetryPolicy{Retries: 4, Min: time.Second, Max: 30 * time.Second}
+}
+
+// MaxRetries ...
+func (p ExponentialJitterRetryPolicy) MaxRetries() int {
+	return p.Retries
+}
+
+// ShouldRetry ...
+func (p ExponentialJitterRetryPolicy) ShouldRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+}
+
+// Delay ...
+func (p ExponentialJitterRetryPolicy) Delay(retry int, _ *http.Response) time.Duration {
+	wait := math.Pow(2, float64(retry)) * float64(p.Min)
+	if wait > float64(p.Max) {
+		wait = float64(p.Max)
+	}
+	half := wait / 2
+	return time.Duration(half + rand.Float64()*half)
+}
+
+// RetryAfterRetryPolicy is an ExponentialJitterRetryPolicy honoring the Retry-After header of HTTP 429 and 503
+// responses (up to a minute), as sent by rate limiting APIs
+type RetryAfterRetryPolicy struct {
+	ExponentialJitterRetryPolicy
+}
+
+// NewRetryAfterRetryPolicy returns a policy with the defaults of NewExponentialJitterRetryPolicy
+func NewRetryAfterRetryPolicy() RetryAfterRetryPolicy {
+	return RetryAfterRetryPolicy{ExponentialJitterRetryPolicy: NewExponentialJitterRetryPolicy()}
+}
+
+// Delay ...
+func (p RetryAfterRetryPolicy) Delay(retry int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if wait > maxRetryAfter {
+				wait = maxRetryAfter
+			}
+			return wait
+		}
+	}
+	return p.ExponentialJitterRetryPolicy.Delay(retry, resp)
+}
+
+// applyRetryPolicy configures the client to retry with the policy. It has to be applied before the hooks
+// wrapping CheckRetry (such as the trace of the request).
+func applyRetryPolicy(client *retryablehttp.Client, policy RetryPolicy) {
+	if policy == nil {
+		return
+	}
+	client.RetryMax = policy.MaxRetries()
+	client.CheckRetry = policy.ShouldRetry
+	client.Backoff = func(_, _ time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		return policy.Delay(attemptNum, resp)
+	}
+}
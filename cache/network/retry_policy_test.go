@@ -0,0 +1,59 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExponentialJitterRetryPolicy_Delay(t *testing.T) {
+	policy := ExponentialJitterRetryPolicy{Retries: 3, Min: time.Second, Max: 5 * time.Second}
+
+	for retry, maxWait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		delay := policy.Delay(retry, nil)
+		require.GreaterOrEqual(t, delay, maxWait/2)
+		require.LessOrEqual(t, delay, maxWait)
+	}
+}
+
+func TestRetryAfterRetryPolicy_Delay(t *testing.T) {
+	policy := NewRetryAfterRetryPolicy()
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"12"}}}
+	require.Equal(t, 12*time.Second, policy.Delay(0, resp))
+
+	resp.Header.Set("Retry-After", "3600")
+	require.Equal(t, maxRetryAfter, policy.Delay(0, resp))
+
+	resp.StatusCode = http.StatusInternalServerError
+	require.LessOrEqual(t, policy.Delay(0, resp), time.Second)
+}
+
+func TestDefaultUploader_RetryPolicy(t *testing.T) {
+	// Given
+	var requests atomic.Int32
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer apiServer.Close()
+
+	// When
+	err := DefaultUploader{}.Upload(context.Background(), UploadParams{
+		APIBaseURL:  apiServer.URL,
+		Token:       "netok",
+		ArchivePath: "cache.tzst",
+		ArchiveSize: 1024,
+		CacheKey:    "test-cache-key",
+		RetryPolicy: ExponentialJitterRetryPolicy{Retries: 1, Min: time.Millisecond, Max: time.Millisecond},
+	}, log.NewLogger())
+
+	// Then
+	require.ErrorIs(t, err, ErrServiceUnavailable)
+	require.Equal(t, int32(2), requests.Load())
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+const (
+	// BitriseStorageName is the default storage backend, the Bitrise cache API
+	BitriseStorageName = "bitrise"
+	// LocalStorageName is the storage backend saving archives to the directory set in BITRISEIO_DEPENDENCY_CACHE_LOCAL_DIR
+	LocalStorageName = "local"
+
+	storageEnvKey  = "BITRISEIO_DEPENDENCY_CACHE_BACKEND"
+	localDirEnvKey = "BITRISEIO_DEPENDENCY_CACHE_LOCAL_DIR"
+)
+
+// Storage is a cache archive backend that can both save and restore archives
+type Storage interface {
+	Uploader
+	Downloader
+}
+
+// StorageFactory creates a Storage configured from env vars
+type StorageFactory func(envRepo env.Repository) (Storage, error)
+
+// DefaultStorage saves and restores archives with the Bitrise cache API
+type DefaultStorage struct {
+	DefaultUploader
+	DefaultDownloader
+}
+
+var (
+	storageFactoriesLock sync.RWMutex
+	storageFactories     = map[string]StorageFactory{
+		BitriseStorageName: func(env.Repository) (Storage, error) {
+			return DefaultStorage{}, nil
+		},
+		LocalStorageName: func(envRepo env.Repository) (Storage, error) {
+			rootDir := envRepo.Get(localDirEnvKey)
+			if rootDir == "" {
+				return nil, fmt.Errorf("%s is not defined", localDirEnvKey)
+			}
+			return LocalStorage{RootDir: rootDir}, nil
+		},
+	}
+)
+
+// RegisterStorage makes a storage backend available under the provided name, so that it can be selected with
+// the BITRISEIO_DEPENDENCY_CACHE_BACKEND env var. Registering an existing name replaces the previous backend.
+func RegisterStorage(name string, factory StorageFactory) {
+	storageFactoriesLock.Lock()
+	defer storageFactoriesLock.Unlock()
+
+	storageFactories[name] = factory
+}
+
+// StorageName returns the name of the configured storage backend. This is the value of
+// BITRISEIO_DEPENDENCY_CACHE_BACKEND if set, otherwise the local backend if BITRISEIO_DEPENDENCY_CACHE_LOCAL_DIR is set,
+// otherwise the Bitrise cache API.
+func StorageName(envRepo env.Repository) string {
+	if name := envRepo.Get(storageEnvKey); name != "" {
+		return name
+	}
+	if envRepo.Get(localDirEnvKey) != "" {
+		return LocalStorageName
+	}
+	return BitriseStorageName
+}
+
+// NewStorage creates the storage backend configured by env vars, see StorageName
+func NewStorage(envRepo env.Repository) (Storage, error) {
+	name := StorageName(envRepo)
+
+	storageFactoriesLock.RLock()
+	factory, ok := storageFactories[name]
+	var names []string
+	for n := range storageFactories {
+		names = append(names, n)
+	}
+	storageFactoriesLock.RUnlock()
+
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown cache backend: %s (available: %s)", name, strings.Join(names, ", "))
+	}
+	return factory(envRepo)
+}
@@ -0,0 +1,76 @@
+package network
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStorage struct{}
+
+func (fakeStorage) Upload(context.Context, UploadParams, log.Logger) error {
+	return nil
+}
+
+func (fakeStorage) Download(context.Context, DownloadParams, log.Logger) (string, error) {
+	return "", ErrCacheNotFound
+}
+
+func TestNewStorage(t *testing.T) {
+	RegisterStorage("fake", func(env.Repository) (Storage, error) {
+		return fakeStorage{}, nil
+	})
+
+	tests := []struct {
+		name    string
+		envs    map[string]string
+		want    Storage
+		wantErr bool
+	}{
+		{
+			name: "Default backend",
+			want: DefaultStorage{},
+		},
+		{
+			name: "Local directory",
+			envs: map[string]string{"BITRISEIO_DEPENDENCY_CACHE_LOCAL_DIR": "/tmp/cache"},
+			want: LocalStorage{RootDir: "/tmp/cache"},
+		},
+		{
+			name:    "Local backend without directory",
+			envs:    map[string]string{"BITRISEIO_DEPENDENCY_CACHE_BACKEND": "local"},
+			wantErr: true,
+		},
+		{
+			name: "Registered backend",
+			envs: map[string]string{"BITRISEIO_DEPENDENCY_CACHE_BACKEND": "fake"},
+			want: fakeStorage{},
+		},
+		{
+			name:    "Unknown backend",
+			envs:    map[string]string{"BITRISEIO_DEPENDENCY_CACHE_BACKEND": "unknown"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BITRISEIO_DEPENDENCY_CACHE_BACKEND", "")
+			t.Setenv("BITRISEIO_DEPENDENCY_CACHE_LOCAL_DIR", "")
+			for k, v := range tt.envs {
+				t.Setenv(k, v)
+			}
+
+			got, err := NewStorage(env.NewRepository())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
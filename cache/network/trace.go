@@ -0,0 +1,186 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// traceFileEnvKey turns on request tracing for every upload and download, see UploadParams.TraceFile
+const traceFileEnvKey = "BITRISEIO_DEPENDENCY_CACHE_TRACE_FILE"
+
+const redacted = "[REDACTED]"
+
+// Header names containing these are redacted in the trace
+var sensitiveHeaderParts = []string{"authorization", "cookie", "token", "signature", "key", "credential", "secret"}
+
+// traceEvent is a request or a retry decision of the retryable HTTP client
+type traceEvent struct {
+	Type            string            `json:"type"`
+	Time            time.Time         `json:"time"`
+	Method          string            `json:"method,omitempty"`
+	URL             string            `json:"url,omitempty"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	StatusCode      int               `json:"status_code,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	DurationMs      int64             `json:"duration_ms,omitempty"`
+	Retry           *bool             `json:"retry,omitempty"`
+	Error           string            `json:"error,omitempty"`
+}
+
+const (
+	traceEventRequest       = "request"
+	traceEventRetryDecision = "retry_decision"
+)
+
+// requestTrace records the requests of an upload or download (API calls and archive transfer, including the chunks),
+// and writes them as JSON to a file if the operation fails. Credentials in headers and URL query params are redacted.
+type requestTrace struct {
+	Operation string       `json:"operation"`
+	Events    []traceEvent `json:"events"`
+	path      string
+	mu        sync.Mutex
+}
+
+// startTrace starts tracing the requests of the client if path (or the traceFileEnvKey env var) is set,
+// otherwise it returns nil, which is a no-op trace
+func startTrace(client *retryablehttp.Client, path, operation string) *requestTrace {
+	if path == "" {
+		path = os.Getenv(traceFileEnvKey)
+	}
+	if path == "" {
+		return nil
+	}
+
+	trace := &requestTrace{Operation: operation, path: path}
+
+	// The client might be provided by the caller (see WithHTTPClient), it's copied instead of modified
+	httpClient := *client.HTTPClient
+	next := httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	httpClient.Transport = tracingTransport{next: next, trace: trace}
+	client.HTTPClient = &httpClient
+
+	checkRetry := client.CheckRetry
+	if checkRetry == nil {
+		checkRetry = retryablehttp.DefaultRetryPolicy
+	}
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		retry, checkErr := checkRetry(ctx, resp, err)
+		event := traceEvent{Type: traceEventRetryDecision, Time: time.Now(), Retry: &retry}
+		if resp != nil {
+			event.StatusCode = resp.StatusCode
+			if resp.Request != nil {
+				event.Method, event.URL = resp.Request.Method, redactURL(resp.Request.URL)
+			}
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		trace.add(event)
+		return retry, checkErr
+	}
+
+	return trace
+}
+
+func (t *requestTrace) add(event traceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Events = append(t.Events, event)
+}
+
+// dumpOnError writes the trace to its file if err is not nil (a cache miss is not considered a failure)
+func (t *requestTrace) dumpOnError(err error, logger log.Logger) {
+	if t == nil || err == nil || errors.Is(err, ErrCacheNotFound) || errors.Is(err, ErrArchiveAlreadyRestored) {
+		return
+	}
+
+	t.mu.Lock()
+	content, marshalErr := json.MarshalIndent(t, "", "  ")
+	t.mu.Unlock()
+	if marshalErr != nil {
+		logger.Warnf("Failed to create the request trace: %s", marshalErr)
+		return
+	}
+	if writeErr := os.WriteFile(t.path, content, 0600); writeErr != nil {
+		logger.Warnf("Failed to write the request trace: %s", writeErr)
+		return
+	}
+	logger.Printf("The requests of the failed %s are traced in %s", t.Operation, t.path)
+}
+
+type tracingTransport struct {
+	next  http.RoundTripper
+	trace *requestTrace
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	event := traceEvent{
+		Type:           traceEventRequest,
+		Time:           start,
+		Method:         req.Method,
+		URL:            redactURL(req.URL),
+		RequestHeaders: redactHeaders(req.Header),
+		DurationMs:     time.Since(start).Milliseconds(),
+	}
+	if resp != nil {
+		event.StatusCode = resp.StatusCode
+		event.ResponseHeaders = redactHeaders(resp.Header)
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	t.trace.add(event)
+
+	return resp, err
+}
+
+// redactURL keeps the names of the query params, but not their values, as signed storage URLs carry credentials there
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redactedURL := *u
+	query := redactedURL.Query()
+	for key := range query {
+		query.Set(key, redacted)
+	}
+	redactedURL.RawQuery = query.Encode()
+	redactedURL.User = nil
+	return redactedURL.String()
+}
+
+func redactHeaders(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		lowerName := strings.ToLower(name)
+		for _, part := range sensitiveHeaderParts {
+			if strings.Contains(lowerName, part) {
+				value = redacted
+				break
+			}
+		}
+		headers[name] = value
+	}
+	return headers
+}
@@ -0,0 +1,225 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+const (
+	proxyURLEnvKey           = "BITRISEIO_DEPENDENCY_CACHE_PROXY_URL"
+	caCertPathEnvKey         = "BITRISEIO_DEPENDENCY_CACHE_CA_CERT_PATH"
+	insecureSkipVerifyEnvKey = "BITRISEIO_DEPENDENCY_CACHE_INSECURE_SKIP_VERIFY"
+	transportProfileEnvKey   = "BITRISEIO_DEPENDENCY_CACHE_TRANSPORT_PROFILE"
+
+	maxIdleConnsPerHostEnvKey = "BITRISEIO_DEPENDENCY_CACHE_MAX_IDLE_CONNS_PER_HOST"
+	maxIdleConnsEnvKey        = "BITRISEIO_DEPENDENCY_CACHE_MAX_IDLE_CONNS"
+	forceAttemptHTTP2EnvKey   = "BITRISEIO_DEPENDENCY_CACHE_FORCE_ATTEMPT_HTTP2"
+)
+
+// TransportProfile is a named set of connection tuning settings (HTTP/2, connection pooling, timeouts),
+// see the TransportProfile* constants
+type TransportProfile string
+
+const (
+	// TransportProfileDefault keeps the default connection settings: downloads use HTTP/1.1 with a connection per
+	// parallel chunk, and uploads use the pooled transport of the HTTP client (attempting HTTP/2)
+	TransportProfileDefault TransportProfile = "default"
+	// TransportProfileHighLatency multiplexes the requests over HTTP/2 and keeps the connections alive for longer,
+	// to save TLS handshakes when the storage is far from the runner (such as self-hosted runners in other regions).
+	TransportProfileHighLatency TransportProfile = "high-latency"
+	// TransportProfileManySmallChunks keeps a large pool of HTTP/1.1 connections, so that the many parallel requests
+	// of small chunks don't wait for new connections
+	TransportProfileManySmallChunks TransportProfile = "many-small-chunks"
+)
+
+// transportTuning is the connection tuning of a transport profile, the zero fields keep the setting of the transport
+type transportTuning struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	tlsHandshakeTimeout time.Duration
+	dialTimeout         time.Duration
+	forceAttemptHTTP2   *bool
+}
+
+var transportProfiles = map[TransportProfile]transportTuning{
+	TransportProfileDefault: {},
+	TransportProfileHighLatency: {
+		maxIdleConns:        100,
+		maxIdleConnsPerHost: 16,
+		idleConnTimeout:     180 * time.Second,
+		tlsHandshakeTimeout: 30 * time.Second,
+		dialTimeout:         60 * time.Second,
+		forceAttemptHTTP2:   boolPtr(true),
+	},
+	TransportProfileManySmallChunks: {
+		maxIdleConns:        256,
+		maxIdleConnsPerHost: 64,
+		forceAttemptHTTP2:   boolPtr(false),
+	},
+}
+
+// TransportConfig configures the HTTP connections to the cache API and the archive storage, for example for
+// self-hosted runners behind a TLS-intercepting corporate proxy. Unset fields fall back to env vars.
+type TransportConfig struct {
+	// ProxyURL is the proxy used for all requests.
+	// Defaults to BITRISEIO_DEPENDENCY_CACHE_PROXY_URL, then to the standard HTTPS_PROXY, HTTP_PROXY and NO_PROXY env vars.
+	ProxyURL string
+	// CACertPath is the path of a PEM bundle with root CAs to trust in addition to the system ones.
+	// Defaults to BITRISEIO_DEPENDENCY_CACHE_CA_CERT_PATH.
+	CACertPath string
+	// InsecureSkipVerify disables TLS certificate verification, only use it for debugging.
+	// Defaults to BITRISEIO_DEPENDENCY_CACHE_INSECURE_SKIP_VERIFY.
+	InsecureSkipVerify bool
+	// Profile is the connection tuning preset of both the uploads and the downloads.
+	// Defaults to BITRISEIO_DEPENDENCY_CACHE_TRANSPORT_PROFILE, then to TransportProfileDefault.
+	// The individual tuning env vars (such as BITRISEIO_DEPENDENCY_CACHE_FORCE_ATTEMPT_HTTP2) override the profile.
+	Profile TransportProfile
+}
+
+func (c TransportConfig) withEnvDefaults() TransportConfig {
+	if c.ProxyURL == "" {
+		c.ProxyURL = os.Getenv(proxyURLEnvKey)
+	}
+	if c.CACertPath == "" {
+		c.CACertPath = os.Getenv(caCertPathEnvKey)
+	}
+	if !c.InsecureSkipVerify {
+		env := os.Getenv(insecureSkipVerifyEnvKey)
+		c.InsecureSkipVerify = env == "true" || env == "1"
+	}
+	if c.Profile == "" {
+		c.Profile = TransportProfile(os.Getenv(transportProfileEnvKey))
+	}
+	return c
+}
+
+// configureTransport applies the transport config to the client. It builds the transport of both the uploads and the
+// downloads, and the chunked archive download uses the same transport.
+func configureTransport(httpClient *retryablehttp.Client, config TransportConfig, logger log.Logger) error {
+	config = config.withEnvDefaults()
+	transport := httpClient.HTTPClient.Transport.(*http.Transport)
+
+	tuning, err := profileTuning(config.Profile)
+	if err != nil {
+		return err
+	}
+	if config.Profile != "" {
+		logger.Debugf("Using transport profile: %s", config.Profile)
+	}
+	tuneTransport(transport, tuning.withEnvOverrides())
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		logger.Debugf("Using proxy: %s", proxyURL.Redacted())
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CACertPath == "" && !config.InsecureSkipVerify {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+
+	if config.CACertPath != "" {
+		rootCAs, err := certPoolWithCACerts(config.CACertPath)
+		if err != nil {
+			return err
+		}
+		logger.Debugf("Using additional root CAs from %s", config.CACertPath)
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if config.InsecureSkipVerify {
+		logger.Warnf("TLS certificate verification is disabled for cache connections")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return nil
+}
+
+func profileTuning(profile TransportProfile) (transportTuning, error) {
+	if profile == "" {
+		profile = TransportProfileDefault
+	}
+	tuning, ok := transportProfiles[profile]
+	if !ok {
+		return transportTuning{}, fmt.Errorf("unknown transport profile: %s, available profiles: %s, %s, %s", profile, TransportProfileDefault, TransportProfileHighLatency, TransportProfileManySmallChunks)
+	}
+	return tuning, nil
+}
+
+// withEnvOverrides applies the individual connection tuning env vars, which predate the profiles
+func (t transportTuning) withEnvOverrides() transportTuning {
+	if maxIdleConnsPerHost, err := strconv.Atoi(os.Getenv(maxIdleConnsPerHostEnvKey)); err == nil {
+		t.maxIdleConnsPerHost = maxIdleConnsPerHost
+	}
+	if maxIdleConns, err := strconv.Atoi(os.Getenv(maxIdleConnsEnvKey)); err == nil {
+		t.maxIdleConns = maxIdleConns
+	}
+	if env := os.Getenv(forceAttemptHTTP2EnvKey); env != "" {
+		t.forceAttemptHTTP2 = boolPtr(env == "true" || env == "1")
+	}
+	return t
+}
+
+func tuneTransport(transport *http.Transport, tuning transportTuning) {
+	if tuning.maxIdleConns > 0 {
+		transport.MaxIdleConns = tuning.maxIdleConns
+	}
+	if tuning.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = tuning.maxIdleConnsPerHost
+	}
+	if tuning.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = tuning.idleConnTimeout
+	}
+	if tuning.tlsHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = tuning.tlsHandshakeTimeout
+	}
+	if tuning.forceAttemptHTTP2 != nil {
+		transport.ForceAttemptHTTP2 = *tuning.forceAttemptHTTP2
+	}
+	if tuning.dialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   tuning.dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func certPoolWithCACerts(path string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid PEM certificate found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,171 @@
+package network
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/retryhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_configureTransport_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caCertPath := filepath.Join(t.TempDir(), "ca.pem")
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caCertPath, caCert, 0600))
+
+	invalidCACertPath := filepath.Join(t.TempDir(), "invalid.pem")
+	require.NoError(t, os.WriteFile(invalidCACertPath, []byte("not a certificate"), 0600))
+
+	tests := []struct {
+		name           string
+		config         TransportConfig
+		wantErr        bool
+		wantRequestErr bool
+	}{
+		{name: "Default config", config: TransportConfig{}, wantRequestErr: true},
+		{name: "Custom CA", config: TransportConfig{CACertPath: caCertPath}},
+		{name: "Insecure skip verify", config: TransportConfig{InsecureSkipVerify: true}},
+		{name: "Invalid CA bundle", config: TransportConfig{CACertPath: invalidCACertPath}, wantErr: true},
+		{name: "Missing CA bundle", config: TransportConfig{CACertPath: filepath.Join(t.TempDir(), "missing.pem")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			httpClient := retryhttp.NewClient(log.NewLogger())
+			httpClient.RetryMax = 0
+
+			// When
+			err := configureTransport(httpClient, tt.config, log.NewLogger())
+
+			// Then
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			resp, err := httpClient.Get(server.URL)
+			if tt.wantRequestErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func Test_configureTransport_Proxy(t *testing.T) {
+	// Given
+	var proxiedURL string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedURL = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	httpClient := retryhttp.NewClient(log.NewLogger())
+	httpClient.RetryMax = 0
+
+	// When
+	err := configureTransport(httpClient, TransportConfig{ProxyURL: proxy.URL}, log.NewLogger())
+
+	// Then
+	require.NoError(t, err)
+	resp, err := httpClient.Get("http://cache.example.com/restore")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "http://cache.example.com/restore", proxiedURL)
+}
+
+func Test_TransportConfig_withEnvDefaults(t *testing.T) {
+	t.Setenv(proxyURLEnvKey, "http://proxy.example.com:3128")
+	t.Setenv(caCertPathEnvKey, "/etc/ssl/corporate.pem")
+	t.Setenv(insecureSkipVerifyEnvKey, "true")
+
+	assert.Equal(t, TransportConfig{
+		ProxyURL:           "http://proxy.example.com:3128",
+		CACertPath:         "/etc/ssl/corporate.pem",
+		InsecureSkipVerify: true,
+	}, TransportConfig{}.withEnvDefaults())
+
+	assert.Equal(t, TransportConfig{
+		ProxyURL:           "http://other-proxy.example.com",
+		CACertPath:         "/etc/ssl/corporate.pem",
+		InsecureSkipVerify: true,
+	}, TransportConfig{ProxyURL: "http://other-proxy.example.com"}.withEnvDefaults())
+}
+
+func Test_configureTransport_Profile(t *testing.T) {
+	defaultConns := retryhttp.NewClient(log.NewLogger()).HTTPClient.Transport.(*http.Transport).MaxIdleConnsPerHost
+
+	tests := []struct {
+		name      string
+		profile   TransportProfile
+		envs      map[string]string
+		wantHTTP2 bool
+		wantConns int
+		wantErr   bool
+	}{
+		{name: "Default", wantHTTP2: true, wantConns: defaultConns},
+		{name: "High latency", profile: TransportProfileHighLatency, wantHTTP2: true, wantConns: 16},
+		{name: "Many small chunks", profile: TransportProfileManySmallChunks, wantConns: 64},
+		{name: "Profile from env", envs: map[string]string{transportProfileEnvKey: "many-small-chunks"}, wantConns: 64},
+		{
+			name:      "Env vars override the profile",
+			profile:   TransportProfileHighLatency,
+			envs:      map[string]string{forceAttemptHTTP2EnvKey: "false", maxIdleConnsPerHostEnvKey: "8"},
+			wantConns: 8,
+		},
+		{name: "Unknown profile", profile: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			for key, value := range tt.envs {
+				t.Setenv(key, value)
+			}
+			client := retryhttp.NewClient(log.NewLogger())
+
+			// When
+			err := configureTransport(client, TransportConfig{Profile: tt.profile}, log.NewLogger())
+
+			// Then
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			transport := client.HTTPClient.Transport.(*http.Transport)
+			assert.Equal(t, tt.wantHTTP2, transport.ForceAttemptHTTP2)
+			assert.Equal(t, tt.wantConns, transport.MaxIdleConnsPerHost)
+		})
+	}
+}
+
+func TestDefaultDownloader_newHTTPClient_DefaultProfile(t *testing.T) {
+	// Given
+	defaultTransport := retryhttp.NewClient(log.NewLogger()).HTTPClient.Transport.(*http.Transport)
+
+	// When
+	client, err := DefaultDownloader{}.newHTTPClient(DownloadParams{}, log.NewLogger())
+
+	// Then
+	require.NoError(t, err)
+	transport := client.HTTPClient.Transport.(*http.Transport)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.Equal(t, defaultTransport.MaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, defaultTransport.MaxIdleConns, transport.MaxIdleConns)
+}
@@ -2,56 +2,136 @@ package network
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"path/filepath"
 	"strings"
 
+	"github.com/bitrise-io/go-steputils/v2/cache/progress"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-io/go-utils/v2/retryhttp"
 )
 
 // DefaultUploader ...
-type DefaultUploader struct{}
+type DefaultUploader struct {
+	httpClient *http.Client
+}
+
+// WithHTTPClient returns an uploader sending all requests (cache API calls and the archive upload) with the provided
+// client, for example to add instrumentation or a custom transport. Requests are still retried by the uploader.
+// UploadParams.Transport is not applied to the provided client.
+func (u DefaultUploader) WithHTTPClient(client *http.Client) DefaultUploader {
+	u.httpClient = client
+	return u
+}
 
 // UploadParams ...
 type UploadParams struct {
-	APIBaseURL      string
-	Token           string
-	ArchivePath     string
-	ArchiveChecksum string
-	ArchiveSize     int64
-	CacheKey        string
+	APIBaseURL string
+	// FailoverAPIBaseURLs are tried in order when APIBaseURL is unreachable
+	FailoverAPIBaseURLs []string
+	Token               string
+	ArchivePath         string
+	ArchiveChecksum     string
+	ArchiveSize         int64
+	CacheKey            string
+	// Transport configures the proxy, TLS and connection tuning (see TransportProfile) settings, see TransportConfig
+	Transport TransportConfig
+	// Reporter (if set) receives the retried requests of the upload, see progress.Reporter
+	Reporter progress.Reporter
+	// MaxArchiveSize (if set) is the size limit of the storage backend in bytes. Larger archives fail with
+	// ErrArchiveTooLarge before the upload is prepared, and the upload is aborted as soon as it exceeds the limit.
+	MaxArchiveSize int64
+	// TraceFile (if set) records the requests of the upload with their timing, status codes, retry decisions and
+	// redacted headers, and writes them as JSON to this file if the upload fails.
+	// If not set, the value of BITRISEIO_DEPENDENCY_CACHE_TRACE_FILE is used.
+	TraceFile string
+	// ExpectContinue sends the archive upload with an `Expect: 100-continue` header, so that the archive is only sent
+	// after the storage accepted the request headers. Uploads rejected by the storage (for example because of
+	// an expired URL or the size limit) fail without transferring the archive.
+	// A custom HTTP client (see WithHTTPClient) needs a transport with ExpectContinueTimeout set for this to take effect.
+	ExpectContinue bool
+	// BaseArchiveChecksum (if set) enables optimistic concurrency: it's the checksum of the archive the build
+	// restored for CacheKey, or an empty string if the key had no archive. If the key was saved with another archive
+	// since (for example by a parallel workflow), the cache API rejects the upload with ErrConflict instead of
+	// overwriting the newer archive. If nil, the upload always overwrites the archive of the key.
+	// LocalStorage doesn't support the check.
+	BaseArchiveChecksum *string
+	// VerifyPublish checks that the key matches the uploaded archive after the upload is acknowledged, and that
+	// the storage can serve the archive. If the archive is unreadable, the upload fails with ErrArchiveUnreadable.
+	// It costs two extra requests, so it's meant for verbose mode. LocalStorage doesn't support it.
+	VerifyPublish bool
+	// RetryPolicy (if set) decides which failed requests of the upload are retried and how long to wait before
+	// the retries, such as RetryAfterRetryPolicy for rate limited backends. By default the requests are retried
+	// 4 times with exponential backoff.
+	RetryPolicy RetryPolicy
 }
 
+// ErrArchiveTooLarge means that the archive exceeds UploadParams.MaxArchiveSize
+var ErrArchiveTooLarge = errors.New("archive exceeds the maximum archive size")
+
 // Upload a cache archive and associate it with the provided cache key
-func (u DefaultUploader) Upload(ctx context.Context, params UploadParams, logger log.Logger) error {
+func (u DefaultUploader) Upload(ctx context.Context, params UploadParams, logger log.Logger) (err error) {
+	logger = newOperationLogger(logger, "upload", newCorrelationID(), 0)
 	validatedKey, err := validateKey(params.CacheKey, logger)
 	if err != nil {
 		return err
 	}
+	if err := checkArchiveSize(params.ArchiveSize, params.MaxArchiveSize); err != nil {
+		return err
+	}
 
-	client := newAPIClient(retryhttp.NewClient(logger), params.APIBaseURL, params.Token, logger)
+	httpClient := retryhttp.NewClient(logger)
+	applyRetryPolicy(httpClient, params.RetryPolicy)
+	if u.httpClient != nil {
+		if params.Transport != (TransportConfig{}) {
+			logger.Warnf("Transport config is ignored when a custom HTTP client is used")
+		}
+		httpClient.HTTPClient = u.httpClient
+	} else if err := configureTransport(httpClient, params.Transport, logger); err != nil {
+		return err
+	}
+	reportRetries(httpClient, params.Reporter, progress.PhaseUpload)
+	trace := startTrace(httpClient, params.TraceFile, "upload")
+	defer func() { trace.dumpOnError(err, logger) }()
 
 	logger.Debugf("Get upload URL")
 	prepareUploadRequest := prepareUploadRequest{
-		CacheKey:           validatedKey,
-		ArchiveFileName:    filepath.Base(params.ArchivePath),
-		ArchiveContentType: "application/zstd",
-		ArchiveSizeInBytes: params.ArchiveSize,
+		CacheKey:            validatedKey,
+		ArchiveFileName:     filepath.Base(params.ArchivePath),
+		ArchiveContentType:  "application/zstd",
+		ArchiveSizeInBytes:  params.ArchiveSize,
+		BaseArchiveChecksum: params.BaseArchiveChecksum,
 	}
-	resp, err := client.prepareUpload(prepareUploadRequest)
+	var resp prepareUploadResponse
+	// The upload has to be acknowledged with the same endpoint that prepared it
+	baseURL, err := withAPIFailover(httpClient, params.APIBaseURL, params.FailoverAPIBaseURLs, logger, func(baseURL string) error {
+		client := newAPIClient(httpClient, baseURL, params.Token, logger)
+		var err error
+		resp, err = client.prepareUpload(prepareUploadRequest)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to get upload URL: %w", err)
 	}
 	logger.Debugf("Upload ID: %s", resp.ID)
+	client := newAPIClient(httpClient, baseURL, params.Token, logger)
 
 	logger.Debugf("")
 	logger.Debugf("Upload archive")
-	err = client.uploadArchive(params.ArchivePath, resp.UploadMethod, resp.UploadURL, resp.UploadHeaders)
+	err = client.uploadArchive(ctx, params.ArchivePath, params.MaxArchiveSize, params.ExpectContinue, resp.UploadMethod, resp.UploadURL, resp.UploadHeaders)
 	if err != nil {
 		return fmt.Errorf("failed to upload archive: %w", err)
 	}
 
+	// The key is only published (becomes matchable by restores) when the upload is acknowledged, so a cancelled
+	// upload never leaves a partial archive behind the key
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("upload cancelled before publishing the archive: %w", err)
+	}
+
 	logger.Debugf("")
 	logger.Debugf("Acknowledge upload")
 	response, err := client.acknowledgeUpload(resp.ID)
@@ -62,9 +142,54 @@ func (u DefaultUploader) Upload(ctx context.Context, params UploadParams, logger
 	logger.Debugf("Upload acknowledged")
 	logResponseMessage(response, logger)
 
+	if params.VerifyPublish {
+		logger.Debugf("")
+		logger.Debugf("Verify published archive")
+		if err := verifyPublish(ctx, client, validatedKey, params.ArchivePath, logger); err != nil {
+			return fmt.Errorf("failed to verify published archive: %w", err)
+		}
+	}
+
 	return nil
 }
 
+func checkArchiveSize(size, maxSize int64) error {
+	if maxSize > 0 && size > maxSize {
+		return fmt.Errorf("%w (size: %d bytes, limit: %d bytes)", ErrArchiveTooLarge, size, maxSize)
+	}
+	return nil
+}
+
+// sizeLimitedReader fails the upload with ErrArchiveTooLarge as soon as more than limit bytes are read,
+// for example when the archive grows after its size was checked. It implements io.Seeker, so that retried requests
+// can rewind the body (unless the limit is exceeded).
+type sizeLimitedReader struct {
+	io.ReadSeeker
+	limit int64
+	read  int64
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.read += int64(n)
+	if r.read > r.limit {
+		return n, checkArchiveSize(r.read, r.limit)
+	}
+	return n, err
+}
+
+func (r *sizeLimitedReader) Seek(offset int64, whence int) (int64, error) {
+	if r.read > r.limit {
+		// Rewinding the body for a retry fails, so that a doomed upload is not retried
+		return 0, checkArchiveSize(r.read, r.limit)
+	}
+	pos, err := r.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		r.read = pos
+	}
+	return pos, err
+}
+
 func validateKey(key string, logger log.Logger) (string, error) {
 	if strings.Contains(key, ",") {
 		return "", fmt.Errorf("commas are not allowed in key")
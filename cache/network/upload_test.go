@@ -1,11 +1,21 @@
 package network
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_validateKey(t *testing.T) {
@@ -104,3 +114,234 @@ func Test_logResponseMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestDefaultUploader_WithHTTPClient(t *testing.T) {
+	// Given
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer apiServer.Close()
+
+	transport := &countingRoundTripper{}
+	uploader := DefaultUploader{}.WithHTTPClient(&http.Client{Transport: transport})
+
+	// When
+	err := uploader.Upload(context.Background(), UploadParams{
+		APIBaseURL:  apiServer.URL,
+		Token:       "netok",
+		ArchivePath: "cache.tzst",
+		CacheKey:    "test-cache-key",
+	}, log.NewLogger())
+
+	// Then
+	require.Error(t, err)
+	require.Equal(t, uint64(1), transport.calls.Load())
+}
+
+func TestDefaultUploader_ArchiveTooLarge(t *testing.T) {
+	// Given
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+	}))
+	defer apiServer.Close()
+
+	// When
+	err := DefaultUploader{}.Upload(context.Background(), UploadParams{
+		APIBaseURL:     apiServer.URL,
+		Token:          "netok",
+		ArchivePath:    "cache.tzst",
+		ArchiveSize:    2048,
+		CacheKey:       "test-cache-key",
+		MaxArchiveSize: 1024,
+	}, log.NewLogger())
+
+	// Then
+	require.ErrorIs(t, err, ErrArchiveTooLarge)
+}
+
+func Test_sizeLimitedReader(t *testing.T) {
+	// Given
+	reader := &sizeLimitedReader{ReadSeeker: strings.NewReader("0123456789"), limit: 8}
+
+	// When
+	buf := make([]byte, 6)
+	_, err := reader.Read(buf)
+	require.NoError(t, err)
+	_, err = reader.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+
+	// Then
+	require.ErrorIs(t, err, ErrArchiveTooLarge)
+	_, err = reader.Seek(0, io.SeekStart)
+	require.ErrorIs(t, err, ErrArchiveTooLarge)
+}
+
+func TestDefaultUploader_ExpectContinue(t *testing.T) {
+	// Given
+	var expectHeader atomic.Value
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expectHeader.Store(r.Header.Get("Expect"))
+		// The body is not read, so the client doesn't send it
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer storage.Close()
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, err := fmt.Fprintf(w, `{"id":"upload-id","method":"PUT","url":"%s"}`, storage.URL)
+		require.NoError(t, err)
+	}))
+	defer apiServer.Close()
+
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	require.NoError(t, os.WriteFile(archivePath, make([]byte, 1024*1024), 0644))
+
+	// When
+	err := DefaultUploader{}.Upload(context.Background(), UploadParams{
+		APIBaseURL:     apiServer.URL,
+		Token:          "netok",
+		ArchivePath:    archivePath,
+		ArchiveSize:    1024 * 1024,
+		CacheKey:       "test-cache-key",
+		ExpectContinue: true,
+	}, log.NewLogger())
+
+	// Then
+	require.ErrorContains(t, err, "HTTP 403")
+	require.ErrorIs(t, err, ErrUnauthorized)
+	require.Equal(t, "100-continue", expectHeader.Load())
+}
+
+func TestDefaultUploader_Conflict(t *testing.T) {
+	// Given
+	var requestBody atomic.Value
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		requestBody.Store(string(body))
+		w.WriteHeader(http.StatusConflict)
+		_, err = fmt.Fprint(w, `{"current_archive_checksum":"newer"}`)
+		require.NoError(t, err)
+	}))
+	defer apiServer.Close()
+	baseChecksum := "restored"
+
+	// When
+	err := DefaultUploader{}.Upload(context.Background(), UploadParams{
+		APIBaseURL:          apiServer.URL,
+		Token:               "netok",
+		ArchivePath:         "cache.tzst",
+		ArchiveSize:         1024,
+		CacheKey:            "test-cache-key",
+		BaseArchiveChecksum: &baseChecksum,
+	}, log.NewLogger())
+
+	// Then
+	require.ErrorIs(t, err, ErrConflict)
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, "newer", apiErr.CurrentArchiveChecksum())
+	require.Contains(t, requestBody.Load(), `"base_archive_checksum":"restored"`)
+}
+
+func TestDefaultUploader_VerifyPublish(t *testing.T) {
+	tests := []struct {
+		name          string
+		storageStatus int
+		wantErr       error
+	}{
+		{name: "archive is served", storageStatus: http.StatusPartialContent},
+		{name: "archive is unreadable", storageStatus: http.StatusNotFound, wantErr: ErrArchiveUnreadable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			archiveSize := 1024
+			storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPut {
+					return
+				}
+				require.Equal(t, "bytes=0-0", r.Header.Get("Range"))
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-0/%d", archiveSize))
+				w.WriteHeader(tt.storageStatus)
+			}))
+			defer storage.Close()
+			var acknowledged atomic.Bool
+			apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				switch r.URL.Path {
+				case "/upload":
+					w.WriteHeader(http.StatusCreated)
+					_, err = fmt.Fprintf(w, `{"id":"upload-id","method":"PUT","url":"%s"}`, storage.URL)
+				case "/upload/upload-id/acknowledge":
+					acknowledged.Store(true)
+					_, err = fmt.Fprint(w, `{}`)
+				case "/restore":
+					require.True(t, acknowledged.Load(), "the key is verified after it's published")
+					_, err = fmt.Fprintf(w, `{"url":"%s","matched_cache_key":"test-cache-key"}`, storage.URL)
+				}
+				require.NoError(t, err)
+			}))
+			defer apiServer.Close()
+
+			archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+			require.NoError(t, os.WriteFile(archivePath, make([]byte, archiveSize), 0644))
+
+			// When
+			err := DefaultUploader{}.Upload(context.Background(), UploadParams{
+				APIBaseURL:    apiServer.URL,
+				Token:         "netok",
+				ArchivePath:   archivePath,
+				ArchiveSize:   int64(archiveSize),
+				CacheKey:      "test-cache-key",
+				VerifyPublish: true,
+			}, log.NewLogger())
+
+			// Then
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestDefaultUploader_WhenCancelled_ThenDoesNotPublish(t *testing.T) {
+	// Given
+	ctx, cancel := context.WithCancel(context.Background())
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.Copy(io.Discard, r.Body)
+		require.NoError(t, err)
+		// The build is cancelled right after the archive is uploaded
+		cancel()
+	}))
+	defer storage.Close()
+	var acknowledged atomic.Bool
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			acknowledged.Store(true)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, err := fmt.Fprintf(w, `{"id":"upload-id","method":"PUT","url":"%s"}`, storage.URL)
+		require.NoError(t, err)
+	}))
+	defer apiServer.Close()
+
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	require.NoError(t, os.WriteFile(archivePath, make([]byte, 1024), 0644))
+
+	// When
+	err := DefaultUploader{}.Upload(ctx, UploadParams{
+		APIBaseURL:  apiServer.URL,
+		Token:       "netok",
+		ArchivePath: archivePath,
+		ArchiveSize: 1024,
+		CacheKey:    "test-cache-key",
+	}, log.NewLogger())
+
+	// Then
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, acknowledged.Load())
+}
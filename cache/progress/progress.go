@@ -0,0 +1,177 @@
+// Package progress reports the progress of cache operations (phase transitions, byte counters and retries),
+// so that save and restore steps show consistent progress output, and the Bitrise UI can parse it (see NewJSONLinesReporter).
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/docker/go-units"
+)
+
+// Phase is a step of a cache operation
+type Phase string
+
+// Phases of saving and restoring a cache
+const (
+	PhaseCompression Phase = "compression"
+	PhaseEncryption  Phase = "encryption"
+	PhaseUpload      Phase = "upload"
+	PhaseDownload    Phase = "download"
+	PhaseDecryption  Phase = "decryption"
+	PhaseExtraction  Phase = "extraction"
+)
+
+// Reporter receives the progress of cache operations. Implementations must be safe for concurrent use.
+type Reporter interface {
+	PhaseStarted(phase Phase)
+	// Progress reports the number of bytes processed in the phase, totalBytes is 0 if unknown
+	Progress(phase Phase, doneBytes, totalBytes int64)
+	// Retry reports that the phase (or a request of it) is retried, err is nil if the cause is unknown
+	Retry(phase Phase, attempt uint, err error)
+	// PhaseFinished reports the end of the phase, err is nil if the phase succeeded
+	PhaseFinished(phase Phase, err error)
+}
+
+// OrSilent returns reporter, or a silent reporter if it's nil
+func OrSilent(reporter Reporter) Reporter {
+	if reporter == nil {
+		return NewSilentReporter()
+	}
+	return reporter
+}
+
+type silentReporter struct{}
+
+// NewSilentReporter returns a Reporter that ignores all events
+func NewSilentReporter() Reporter {
+	return silentReporter{}
+}
+
+func (silentReporter) PhaseStarted(Phase)           {}
+func (silentReporter) Progress(Phase, int64, int64) {}
+func (silentReporter) Retry(Phase, uint, error)     {}
+func (silentReporter) PhaseFinished(Phase, error)   {}
+
+type consoleReporter struct {
+	logger log.Logger
+	mu     sync.Mutex
+	starts map[Phase]time.Time
+}
+
+// NewConsoleReporter returns a Reporter that logs the byte counters and the retries in a human-readable form.
+// Phase transitions are only logged in debug mode, as the cache steps log them anyway.
+func NewConsoleReporter(logger log.Logger) Reporter {
+	return &consoleReporter{logger: logger, starts: map[Phase]time.Time{}}
+}
+
+func (r *consoleReporter) PhaseStarted(phase Phase) {
+	r.mu.Lock()
+	r.starts[phase] = time.Now()
+	r.mu.Unlock()
+
+	r.logger.Debugf("Phase started: %s", phase)
+}
+
+func (r *consoleReporter) Progress(phase Phase, doneBytes, totalBytes int64) {
+	done := units.HumanSizeWithPrecision(float64(doneBytes), 3)
+	if totalBytes <= 0 {
+		r.logger.Printf("%s: %s", phase, done)
+		return
+	}
+	total := units.HumanSizeWithPrecision(float64(totalBytes), 3)
+	r.logger.Printf("%s: %s of %s (%d%%)", phase, done, total, doneBytes*100/totalBytes)
+}
+
+func (r *consoleReporter) Retry(phase Phase, attempt uint, err error) {
+	if err != nil {
+		r.logger.Warnf("Retrying %s (attempt %d), reason: %s", phase, attempt+1, err)
+		return
+	}
+	r.logger.Warnf("Retrying %s (attempt %d)", phase, attempt+1)
+}
+
+func (r *consoleReporter) PhaseFinished(phase Phase, err error) {
+	r.mu.Lock()
+	start, ok := r.starts[phase]
+	delete(r.starts, phase)
+	r.mu.Unlock()
+
+	var duration time.Duration
+	if ok {
+		duration = time.Since(start).Round(time.Millisecond)
+	}
+	if err != nil {
+		r.logger.Debugf("Phase failed: %s (%s), error: %s", phase, duration, err)
+		return
+	}
+	r.logger.Debugf("Phase finished: %s (%s)", phase, duration)
+}
+
+// Event is a line written by the JSON lines reporter
+type Event struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Phase Phase     `json:"phase"`
+	// DoneBytes and TotalBytes are set for progress events
+	DoneBytes  int64 `json:"done_bytes,omitempty"`
+	TotalBytes int64 `json:"total_bytes,omitempty"`
+	// Attempt is set for retry events
+	Attempt uint   `json:"attempt,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Event types of the JSON lines reporter
+const (
+	EventPhaseStarted  = "phase_started"
+	EventProgress      = "progress"
+	EventRetry         = "retry"
+	EventPhaseFinished = "phase_finished"
+)
+
+type jsonLinesReporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	now     func() time.Time
+}
+
+// NewJSONLinesReporter returns a Reporter that writes each event as a JSON object on its own line (see Event).
+// Write errors are ignored, so that a broken progress output doesn't fail the cache operation.
+func NewJSONLinesReporter(w io.Writer) Reporter {
+	return &jsonLinesReporter{encoder: json.NewEncoder(w), now: time.Now}
+}
+
+func (r *jsonLinesReporter) PhaseStarted(phase Phase) {
+	r.write(Event{Type: EventPhaseStarted, Phase: phase})
+}
+
+func (r *jsonLinesReporter) Progress(phase Phase, doneBytes, totalBytes int64) {
+	r.write(Event{Type: EventProgress, Phase: phase, DoneBytes: doneBytes, TotalBytes: totalBytes})
+}
+
+func (r *jsonLinesReporter) Retry(phase Phase, attempt uint, err error) {
+	r.write(Event{Type: EventRetry, Phase: phase, Attempt: attempt, Error: errorString(err)})
+}
+
+func (r *jsonLinesReporter) PhaseFinished(phase Phase, err error) {
+	r.write(Event{Type: EventPhaseFinished, Phase: phase, Error: errorString(err)})
+}
+
+func (r *jsonLinesReporter) write(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event.Time = r.now().UTC()
+	_ = r.encoder.Encode(event)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprint(err)
+}
@@ -0,0 +1,38 @@
+package progress
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLinesReporter(t *testing.T) {
+	// Given
+	var out bytes.Buffer
+	reporter := NewJSONLinesReporter(&out).(*jsonLinesReporter)
+	reporter.now = func() time.Time { return time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC) }
+
+	// When
+	reporter.PhaseStarted(PhaseDownload)
+	reporter.Progress(PhaseDownload, 50, 100)
+	reporter.Retry(PhaseDownload, 1, errors.New("connection reset"))
+	reporter.PhaseFinished(PhaseDownload, nil)
+
+	// Then
+	expected := `{"time":"2023-01-01T12:00:00Z","type":"phase_started","phase":"download"}
+{"time":"2023-01-01T12:00:00Z","type":"progress","phase":"download","done_bytes":50,"total_bytes":100}
+{"time":"2023-01-01T12:00:00Z","type":"retry","phase":"download","attempt":1,"error":"connection reset"}
+{"time":"2023-01-01T12:00:00Z","type":"phase_finished","phase":"download"}
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestOrSilent(t *testing.T) {
+	assert.Equal(t, NewSilentReporter(), OrSilent(nil))
+
+	reporter := NewJSONLinesReporter(&bytes.Buffer{})
+	assert.Equal(t, reporter, OrSilent(reporter))
+}
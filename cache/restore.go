@@ -2,21 +2,27 @@ package cache
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bitrise-io/go-steputils/v2/cache/compression"
 	"github.com/bitrise-io/go-steputils/v2/cache/keytemplate"
 	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-steputils/v2/cache/progress"
 	"github.com/bitrise-io/go-steputils/v2/export"
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
 	"github.com/docker/go-units"
 )
 
@@ -27,6 +33,95 @@ type RestoreCacheInput struct {
 	Verbose        bool
 	Keys           []string
 	NumFullRetries int
+	// IncludePaths limits the extraction to the archive entries matching these paths. Paths can contain
+	// "doublestar" glob patterns (such as `~/.gradle/caches/**`), and a directory path restores everything under it.
+	// If not provided, the whole archive is restored.
+	IncludePaths []string
+	// VerifyManifest checks the restored files against the manifest stored in the archive (if there is one).
+	// See SaveCacheInput.GenerateManifest.
+	VerifyManifest bool
+	// EncryptionKey is the key used for encrypting the archive when it was saved, see SaveCacheInput.EncryptionKey.
+	// If not provided, the value of BITRISEIO_DEPENDENCY_CACHE_ENCRYPTION_KEY is used.
+	EncryptionKey string
+	// Validators check the restored content after extraction, see NonEmptyDirValidator, MarkerFileValidator and
+	// VersionFileValidator. If any of them fails, Restore returns an error wrapping ErrRestoreValidationFailed
+	// and the cache hit is not exposed.
+	// When the archive is extracted while downloading (see StreamExtraction), the validators receive only
+	// the include paths (nil if there are none), as there is no archive file to list the restored paths from.
+	Validators []RestoreValidator
+	// StreamExtraction extracts the archive while it's being downloaded, instead of saving it to a temporary file
+	// first. This saves time and disk space for large archives. It requires a downloader implementing
+	// network.StreamDownloader and is not supported for encrypted archives. If streaming fails for any reason,
+	// the archive is downloaded and extracted in the usual way.
+	StreamExtraction bool
+	// ArchiveFormat is the format of the cache archive, set it to ArchiveFormatGitHubActions to restore archives
+	// imported from GitHub Actions. GitHub Actions archives are always downloaded before the extraction,
+	// and the validators receive only the include paths (see StreamExtraction).
+	ArchiveFormat ArchiveFormat
+	// GitHubActionsWorkspace is the GITHUB_WORKSPACE of the job that saved the GitHub Actions archive
+	// (such as /home/runner/work/repo/repo). Required for ArchiveFormatGitHubActions.
+	GitHubActionsWorkspace string
+	// KeyScope adds scope data (such as the stack and the OS) to each evaluated key, it should match
+	// SaveCacheInput.KeyScope of the save step.
+	KeyScope keytemplate.KeyScope
+	// DownloadProgress (if set) is called every second during the archive download, with the overall progress and ETA
+	DownloadProgress func(network.DownloadProgress)
+	// ProgressReporter (if set) receives the phases, byte counters and retries of the restore, see progress.Reporter
+	ProgressReporter progress.Reporter
+	// CacheBustingQueryParam bypasses the CDN cache when the download is retried, see network.DownloadParams
+	CacheBustingQueryParam string
+	// GenerateFallbackKeys adds the fallback keys of each key template after it, see keytemplate.FallbackKeys.
+	// For example `npm-{{ .Branch }}-{{ checksum "package-lock.json" }}` is restored with the fallback keys
+	// `npm-{{.Branch}}-` and `npm-`. Duplicate keys are dropped, and the list is limited to 8 keys.
+	GenerateFallbackKeys bool
+	// MinAvailableMemory (if set) pauses launching new parallel chunk downloads of the streaming extraction while the
+	// available memory (in bytes, of the container if it's limited) is below it, see network.MemoryWatchdog
+	MinAvailableMemory uint64
+	// MetricsDir (if set) is where a Prometheus textfile (MetricsFileName) with the byte counters, durations, retries
+	// and results of the cache operations is written, for node_exporter's textfile collector on self-hosted runners.
+	// The counters are added to the values of the existing file. If not provided, the value of
+	// BITRISE_CACHE_METRICS_DIR is used, and if that's empty too, no metrics are written.
+	MetricsDir string
+	// RestoreStateFile (if set) is a JSON file recording the archives restored on a persistent (self-hosted) runner.
+	// If the matched archive (the same key and checksum, with the same include paths) was already restored, and the
+	// restored paths still exist, the download and the extraction are skipped, and a cache hit is reported.
+	// The restored files are not checked again (validators and VerifyManifest are skipped), so only use it if the
+	// builds don't modify the cached content in a way that breaks the next build.
+	// If not provided, the value of BITRISE_CACHE_RESTORE_STATE_FILE is used, and if that's empty too,
+	// archives are always restored.
+	RestoreStateFile string
+	// WarmupName (if set) restores the freshest warm-up archive of the cache when none of the keys match, see
+	// keytemplate.WarmupKeyPrefix. The warm-up restore key is tried after all the other keys (including the fallback
+	// keys), and if there are already 8 keys, it replaces the last one.
+	WarmupName string
+}
+
+// maxRestoreKeyCount is the number of keys accepted by the cache API
+const maxRestoreKeyCount = 8
+
+// CacheHit is the type of cache hit, as exported in BITRISE_CACHE_HIT
+type CacheHit string
+
+const (
+	// CacheHitExact means that the archive matched the first key
+	CacheHitExact CacheHit = "exact"
+	// CacheHitPartial means that the archive matched one of the other keys
+	CacheHitPartial CacheHit = "partial"
+	// CacheHitNone means that there was no archive for any of the keys
+	CacheHitNone CacheHit = "false"
+)
+
+// RestoreResult summarizes a cache restore, so that steps can export it as outputs or build their own reporting
+type RestoreResult struct {
+	Hit CacheHit
+	// MatchedKey is the key the restored archive was saved with (empty if there was no hit)
+	MatchedKey string
+	// ArchiveSize is the size of the downloaded archive in bytes
+	ArchiveSize    int64
+	DownloadTime   time.Duration
+	ExtractionTime time.Duration
+	// Warmup is true if the restored archive is a warm-up archive, see RestoreCacheInput.WarmupName
+	Warmup bool
 }
 
 // Restorer ...
@@ -41,6 +136,23 @@ type restoreCacheConfig struct {
 	APIAccessToken stepconf.Secret
 	NumFullRetries int
 	MaxConcurrency uint
+	IncludePaths   []string
+	VerifyManifest bool
+	EncryptionKey  stepconf.Secret
+	Validators     []RestoreValidator
+	// StreamExtraction extracts the archive while downloading it
+	StreamExtraction    bool
+	DownloadProgress    func(network.DownloadProgress)
+	ArchiveFormat       ArchiveFormat
+	GitHubActionsLayout compression.GitHubActionsLayout
+	Reporter            progress.Reporter
+	CacheBustingParam   string
+	MinAvailableMemory  uint64
+	RestoreStateFile    string
+	// warmupKey is the evaluated warm-up restore key (empty if RestoreCacheInput.WarmupName is not set)
+	warmupKey string
+	// archiveMatched is called before downloading the matched archive, see network.DownloadParams.ArchiveMatched
+	archiveMatched func(matchedKey, archiveChecksum string) bool
 }
 
 type restorer struct {
@@ -48,98 +160,346 @@ type restorer struct {
 	logger     log.Logger
 	cmdFactory command.Factory
 	downloader network.Downloader
+	// tracker is nil if the default tracker is used, see WithTracker
+	tracker Tracker
 }
 
 type downloadResult struct {
 	filePath   string
 	matchedKey string
+	// checksum of the archive, if it was computed during the download
+	checksum string
 }
 
 // NewRestorer creates a new cache restorer instance. `downloader` can be nil, unless you want to provide a custom `Downloader` implementation.
+// If `downloader` is nil, the storage backend is selected by env vars, see network.NewStorage.
 func NewRestorer(
 	envRepo env.Repository,
 	logger log.Logger,
 	cmdFactory command.Factory,
 	downloader network.Downloader,
+	opts ...Option,
 ) *restorer {
-	var downloaderImpl network.Downloader = downloader
-	if downloader == nil {
-		downloaderImpl = network.DefaultDownloader{}
-	}
-
-	return &restorer{envRepo: envRepo, logger: logger, cmdFactory: cmdFactory, downloader: downloaderImpl}
+	o := applyOptions(opts)
+	return &restorer{envRepo: envRepo, logger: logger, cmdFactory: cmdFactory, downloader: downloader, tracker: o.tracker}
 }
 
 // Restore ...
 func (r *restorer) Restore(input RestoreCacheInput) error {
+	_, err := r.RestoreWithResult(input)
+	return err
+}
+
+// RestoreWithResult works like Restore, and also returns a summary of the restore
+func (r *restorer) RestoreWithResult(input RestoreCacheInput) (RestoreResult, error) {
+	if isEnvFlagSet(r.envRepo, cacheDisableEnvVar) {
+		r.logger.Println()
+		r.logger.Warnf("Skipping cache restore, reason: caching is disabled by %s", cacheDisableEnvVar)
+		exporter := export.NewExporter(r.cmdFactory)
+		return RestoreResult{Hit: CacheHitNone}, exporter.ExportOutput(cacheHitEnvVar, string(CacheHitNone))
+	}
+	breaker := newCircuitBreaker(r.envRepo, r.cmdFactory, r.logger)
+	if breaker.isOpen() {
+		r.logger.Println()
+		r.logger.Warnf("Skipping cache restore, reason: the cache service failed repeatedly in this build, caching is temporarily skipped")
+		exporter := export.NewExporter(r.cmdFactory)
+		return RestoreResult{Hit: CacheHitNone}, exporter.ExportOutput(cacheHitEnvVar, string(CacheHitNone))
+	}
+	input.Verbose = applyVerboseOverride(r.envRepo, r.logger, input.Verbose)
+
 	config, err := r.createConfig(input)
 	if err != nil {
-		return fmt.Errorf("failed to parse inputs: %w", err)
+		return RestoreResult{}, fmt.Errorf("failed to parse inputs: %w", err)
+	}
+	config.Reporter = progress.OrSilent(config.Reporter)
+
+	if r.downloader == nil {
+		storage, err := network.NewStorage(r.envRepo)
+		if err != nil {
+			return RestoreResult{}, fmt.Errorf("failed to create cache storage: %w", err)
+		}
+		r.downloader = storage
+	}
+
+	tracker := r.tracker
+	if tracker == nil {
+		tracker = NewDefaultTracker(input.StepId, r.envRepo, r.logger)
+	}
+	if dir := metricsDir(input.MetricsDir, r.envRepo.Get(metricsDirEnvVar)); dir != "" {
+		collector := newMetricsCollector(dir, UsageOperationRestore, tracker, config.Reporter, r.logger)
+		tracker, config.Reporter = collector, collector
+	}
+	defer tracker.Wait()
+
+	archiver := compression.NewArchiver(
+		r.logger,
+		r.envRepo,
+		compression.NewDependencyChecker(r.logger, r.envRepo))
+
+	var restores *persistentRestores
+	if config.RestoreStateFile != "" {
+		restores = r.loadPersistentRestores(config.RestoreStateFile)
+		config.archiveMatched = restores.archiveMatched(config.IncludePaths)
 	}
 
-	tracker := newStepTracker(input.StepId, r.envRepo, r.logger)
-	defer tracker.wait()
+	if r.shouldStream(config) {
+		r.logger.Println()
+		r.logger.Infof("Downloading and restoring archive...")
+		r.logIncludePaths(config.IncludePaths)
+		streamStartTime := time.Now()
+		config.Reporter.PhaseStarted(progress.PhaseDownload)
+		config.Reporter.PhaseStarted(progress.PhaseExtraction)
+		result, archiveSize, err := r.downloadAndExtract(context.Background(), config, archiver)
+		config.Reporter.PhaseFinished(progress.PhaseExtraction, err)
+		config.Reporter.PhaseFinished(progress.PhaseDownload, err)
+		if err == nil || errors.Is(err, network.ErrCacheNotFound) || errors.Is(err, network.ErrArchiveAlreadyRestored) {
+			// Streaming failures are retried with a regular download, which records its own result
+			breaker.recordResult(err)
+		}
+		switch {
+		case errors.Is(err, network.ErrCacheNotFound):
+			return r.cacheMiss(config.Keys, tracker)
+		case errors.Is(err, network.ErrArchiveAlreadyRestored):
+			return r.alreadyRestored(restores.skipped, config.Keys, tracker)
+		case err != nil:
+			r.logger.Warnf("Failed to restore the archive while downloading it: %s", err)
+			r.logger.Warnf("Falling back to downloading the archive first")
+		default:
+			r.logMatchedKey(result.matchedKey, config.Keys)
+			streamTime := time.Since(streamStartTime).Round(time.Second)
+			config.Reporter.Progress(progress.PhaseDownload, archiveSize, archiveSize)
+			r.logger.Printf("Archive size: %s", units.HumanSizeWithPrecision(float64(archiveSize), 3))
+			r.logger.Donef("Downloaded and restored archive in %s", streamTime)
+			tracker.LogArchiveExtracted(streamTime, len(config.Keys))
+
+			restoreResult := RestoreResult{
+				Hit:            cacheHitType(result.matchedKey, config.Keys),
+				MatchedKey:     result.matchedKey,
+				ArchiveSize:    archiveSize,
+				DownloadTime:   streamTime,
+				ExtractionTime: streamTime,
+				Warmup:         config.isWarmupHit(result.matchedKey),
+			}
+			r.logWarmupHit(restoreResult)
+			if err := r.finishRestore(result, config, archiver, tracker); err != nil {
+				return restoreResult, err
+			}
+			if restores != nil {
+				// Without include paths the restored paths are unknown, as there is no archive file to list them from
+				r.recordRestore(restores, result, config, config.IncludePaths)
+			}
+			return restoreResult, nil
+		}
+	}
 
 	r.logger.Println()
 	r.logger.Infof("Downloading archive...")
 	downloadStartTime := time.Now()
+	config.Reporter.PhaseStarted(progress.PhaseDownload)
 	result, err := r.download(context.Background(), config)
+	config.Reporter.PhaseFinished(progress.PhaseDownload, err)
+	breaker.recordResult(err)
 	if err != nil {
 		if errors.Is(err, network.ErrCacheNotFound) {
-			r.logger.Donef("No cache entry found for the provided key")
-			tracker.logRestoreResult(false, "", config.Keys)
-			exporter := export.NewExporter(r.cmdFactory)
-			return exporter.ExportOutput(cacheHitEnvVar, "false")
+			return r.cacheMiss(config.Keys, tracker)
 		}
-		return fmt.Errorf("download failed: %w", err)
+		if errors.Is(err, network.ErrArchiveAlreadyRestored) {
+			return r.alreadyRestored(restores.skipped, config.Keys, tracker)
+		}
+		if errors.Is(err, network.ErrArchiveUnreadable) {
+			r.logger.Warnf("The key matched an archive that the storage can't serve (for example the save of a cancelled build), it will be replaced by the next save of the key")
+		}
+		return RestoreResult{}, fmt.Errorf("download failed: %w", err)
 	}
-	if result.matchedKey == config.Keys[0] {
-		r.logger.Printf("Exact hit for first key")
-	} else {
-		r.logger.Printf("Cache hit for key: %s", result.matchedKey)
+	restoreResult := RestoreResult{
+		Hit:        cacheHitType(result.matchedKey, config.Keys),
+		MatchedKey: result.matchedKey,
+		Warmup:     config.isWarmupHit(result.matchedKey),
 	}
+	r.logMatchedKey(result.matchedKey, config.Keys)
+	r.logWarmupHit(restoreResult)
 
 	fileInfo, err := os.Stat(result.filePath)
 	if err != nil {
-		return err
+		return restoreResult, err
 	}
 	r.logger.Printf("Archive size: %s", units.HumanSizeWithPrecision(float64(fileInfo.Size()), 3))
 	downloadTime := time.Since(downloadStartTime).Round(time.Second)
+	restoreResult.ArchiveSize = fileInfo.Size()
+	restoreResult.DownloadTime = downloadTime
 	r.logger.Donef("Downloaded archive in %s", downloadTime)
-	tracker.logArchiveDownloaded(downloadTime, fileInfo, len(config.Keys))
+	tracker.LogArchiveDownloaded(downloadTime, fileInfo.Size(), len(config.Keys))
+
+	if config.EncryptionKey != "" {
+		r.logger.Println()
+		r.logger.Infof("Decrypting archive...")
+		decryptionStartTime := time.Now()
+		config.Reporter.PhaseStarted(progress.PhaseDecryption)
+		result.filePath, err = decryptFile(result.filePath, string(config.EncryptionKey))
+		config.Reporter.PhaseFinished(progress.PhaseDecryption, err)
+		if err != nil {
+			return restoreResult, fmt.Errorf("failed to decrypt archive: %w", err)
+		}
+		r.logger.Donef("Archive decrypted in %s", time.Since(decryptionStartTime).Round(time.Second))
+	}
 
 	r.logger.Println()
 	r.logger.Infof("Restoring archive...")
 	extractionStartTime := time.Now()
-	archiver := compression.NewArchiver(
-		r.logger,
-		r.envRepo,
-		compression.NewDependencyChecker(r.logger, r.envRepo))
+	config.Reporter.PhaseStarted(progress.PhaseExtraction)
+	r.logIncludePaths(config.IncludePaths)
 
-	if err := archiver.Decompress(result.filePath, ""); err != nil {
-		return fmt.Errorf("failed to decompress cache archive: %w", err)
+	if config.ArchiveFormat == ArchiveFormatGitHubActions {
+		r.logger.Printf("Restoring GitHub Actions archive, workspace: %s", config.GitHubActionsLayout.Workspace)
+		err = archiver.DecompressGitHubActionsArchive(result.filePath, config.GitHubActionsLayout, config.IncludePaths)
+	} else {
+		err = archiver.DecompressPaths(result.filePath, "", config.IncludePaths)
+	}
+	config.Reporter.PhaseFinished(progress.PhaseExtraction, err)
+	if err != nil {
+		return restoreResult, fmt.Errorf("failed to decompress cache archive: %w", err)
 	}
 	extractionTime := time.Since(extractionStartTime).Round(time.Second)
+	restoreResult.ExtractionTime = extractionTime
 	r.logger.Donef("Restored archive in %s", extractionTime)
-	tracker.logArchiveExtracted(extractionTime, len(config.Keys))
+	tracker.LogArchiveExtracted(extractionTime, len(config.Keys))
+
+	if err := r.finishRestore(result, config, archiver, tracker); err != nil {
+		return restoreResult, err
+	}
+	if restores != nil {
+		r.recordRestore(restores, result, config, r.restoredPaths(archiver, result.filePath, config))
+	}
+	return restoreResult, nil
+}
 
-	err = r.exposeCacheHit(result, config.Keys)
+// finishRestore checks the extracted content and exposes the cache hit
+func (r *restorer) finishRestore(result downloadResult, config restoreCacheConfig, archiver *compression.Archiver, tracker Tracker) error {
+	manifestFile, err := pathutil.NewPathModifier().AbsPath(manifestPath(result.matchedKey))
 	if err != nil {
 		return err
 	}
+	// The extracted manifest is not part of the cached content, and a leftover could be verified against a later archive
+	defer os.Remove(manifestFile) //nolint:errcheck
+
+	if config.VerifyManifest {
+		r.logger.Println()
+		r.logger.Infof("Verifying restored files...")
+		if err := r.verifyManifest(manifestFile, config.IncludePaths); err != nil {
+			return err
+		}
+	}
+
+	if len(config.Validators) > 0 {
+		r.logger.Println()
+		r.logger.Infof("Validating restored cache...")
+		archivePath := result.filePath
+		if config.ArchiveFormat == ArchiveFormatGitHubActions {
+			// The archive paths are not the restored paths
+			archivePath = ""
+		}
+		if err := r.validate(archiver, archivePath, config.IncludePaths, config.Validators); err != nil {
+			return err
+		}
+	}
+
+	if err := r.exposeCacheHit(result, config.Keys); err != nil {
+		return err
+	}
 
-	tracker.logRestoreResult(true, result.matchedKey, config.Keys)
+	tracker.LogRestoreResult(true, result.matchedKey, config.Keys)
 	return nil
 }
 
-func (r *restorer) createConfig(input RestoreCacheInput) (restoreCacheConfig, error) {
-	apiBaseURL := r.envRepo.Get("BITRISEIO_ABCS_API_URL")
-	if apiBaseURL == "" {
-		return restoreCacheConfig{}, fmt.Errorf("the secret 'BITRISEIO_ABCS_API_URL' is not defined")
+func (r *restorer) cacheMiss(keys []string, tracker Tracker) (RestoreResult, error) {
+	r.logger.Donef("No cache entry found for the provided key")
+	tracker.LogRestoreResult(false, "", keys)
+	exporter := export.NewExporter(r.cmdFactory)
+	return RestoreResult{Hit: CacheHitNone}, exporter.ExportOutput(cacheHitEnvVar, string(CacheHitNone))
+}
+
+func (r *restorer) logMatchedKey(matchedKey string, keys []string) {
+	if matchedKey == keys[0] {
+		r.logger.Printf("Exact hit for first key")
+	} else {
+		r.logger.Printf("Cache hit for key: %s", matchedKey)
 	}
-	apiAccessToken := r.envRepo.Get("BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN")
-	if apiAccessToken == "" {
-		return restoreCacheConfig{}, fmt.Errorf("the secret 'BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN' is not defined")
+}
+
+func (r *restorer) logWarmupHit(result RestoreResult) {
+	if result.Warmup {
+		r.logger.Printf("Restored the freshest warm-up archive, as none of the other keys matched")
+	}
+}
+
+func (r *restorer) logIncludePaths(includePaths []string) {
+	if len(includePaths) > 0 {
+		r.logger.Printf("Restoring only the following paths:")
+		for _, path := range includePaths {
+			r.logger.Printf("- %s", path)
+		}
+	}
+}
+
+func (r *restorer) shouldStream(config restoreCacheConfig) bool {
+	if !config.StreamExtraction {
+		return false
+	}
+	if config.ArchiveFormat == ArchiveFormatGitHubActions {
+		r.logger.Debugf("GitHub Actions archives can't be extracted while downloading")
+		return false
+	}
+	if config.EncryptionKey != "" {
+		r.logger.Debugf("Encrypted archives can't be extracted while downloading")
+		return false
+	}
+	if _, ok := r.downloader.(network.StreamDownloader); !ok {
+		r.logger.Debugf("The downloader doesn't support streaming, the archive is extracted after the download")
+		return false
+	}
+	return true
+}
+
+// downloadAndExtract extracts the archive while it's being downloaded, and returns the size of the archive
+func (r *restorer) downloadAndExtract(ctx context.Context, config restoreCacheConfig, archiver *compression.Archiver) (downloadResult, int64, error) {
+	streamDownloader := r.downloader.(network.StreamDownloader)
+	stream, matchedKey, err := streamDownloader.DownloadStream(ctx, r.downloadParams(config, ""), r.logger)
+	if err != nil {
+		return downloadResult{}, 0, err
+	}
+	defer stream.Close() //nolint:errcheck
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	reader := io.TeeReader(stream, io.MultiWriter(hash, counter))
+	if err := archiver.DecompressStream(reader, "", config.IncludePaths); err != nil {
+		return downloadResult{}, 0, fmt.Errorf("failed to decompress cache archive: %w", err)
+	}
+	// The extraction might stop before the end of the stream (for example at the zero padding after the end of
+	// the tar archive), read the rest so that the checksum covers (and the downloader verifies) the whole archive
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return downloadResult{}, 0, fmt.Errorf("failed to download archive: %w", err)
+	}
+
+	return downloadResult{
+		matchedKey: matchedKey,
+		checksum:   hex.EncodeToString(hash.Sum(nil)),
+	}, counter.n, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func (r *restorer) createConfig(input RestoreCacheInput) (restoreCacheConfig, error) {
+	apiBaseURL, apiAccessToken, err := apiCredentials(r.envRepo)
+	if err != nil {
+		return restoreCacheConfig{}, err
 	}
 
 	maxConcurrency := uint(0)
@@ -153,23 +513,130 @@ func (r *restorer) createConfig(input RestoreCacheInput) (restoreCacheConfig, er
 		maxConcurrency = uint(parsedConcurrency)
 	}
 
-	keys, err := r.evaluateKeys(input.Keys)
+	keyTemplates := input.Keys
+	if input.GenerateFallbackKeys {
+		keyTemplates, err = r.withFallbackKeys(input.Keys)
+		if err != nil {
+			return restoreCacheConfig{}, err
+		}
+	}
+
+	if input.WarmupName != "" {
+		if keyTemplates, err = r.withWarmupKey(keyTemplates, input.WarmupName); err != nil {
+			return restoreCacheConfig{}, err
+		}
+	}
+
+	keys, err := r.evaluateKeys(keyTemplates, input.KeyScope)
 	if err != nil {
 		return restoreCacheConfig{}, fmt.Errorf("failed to evaluate keys: %w", err)
 	}
+	warmupKey := ""
+	if input.WarmupName != "" {
+		warmupKey = keys[len(keys)-1]
+	}
+
+	includePaths, err := r.evaluateIncludePaths(input.IncludePaths)
+	if err != nil {
+		return restoreCacheConfig{}, fmt.Errorf("failed to parse include paths: %w", err)
+	}
+
+	encryptionKey := input.EncryptionKey
+	if encryptionKey == "" {
+		encryptionKey = r.envRepo.Get(encryptionKeyEnvVar)
+	}
+
+	var gitHubActionsLayout compression.GitHubActionsLayout
+	switch input.ArchiveFormat {
+	case ArchiveFormatBitrise:
+	case ArchiveFormatGitHubActions:
+		gitHubActionsLayout, err = r.gitHubActionsLayout(input.GitHubActionsWorkspace)
+		if err != nil {
+			return restoreCacheConfig{}, err
+		}
+	default:
+		return restoreCacheConfig{}, fmt.Errorf("unknown archive format: %s", input.ArchiveFormat)
+	}
 
 	return restoreCacheConfig{
-		Verbose:        input.Verbose,
-		Keys:           keys,
-		APIBaseURL:     stepconf.Secret(apiBaseURL),
-		APIAccessToken: stepconf.Secret(apiAccessToken),
-		NumFullRetries: input.NumFullRetries,
-		MaxConcurrency: maxConcurrency,
+		Verbose:             input.Verbose,
+		Keys:                keys,
+		APIBaseURL:          apiBaseURL,
+		APIAccessToken:      apiAccessToken,
+		NumFullRetries:      input.NumFullRetries,
+		MaxConcurrency:      maxConcurrency,
+		IncludePaths:        includePaths,
+		VerifyManifest:      input.VerifyManifest,
+		EncryptionKey:       stepconf.Secret(encryptionKey),
+		Validators:          input.Validators,
+		StreamExtraction:    input.StreamExtraction,
+		ArchiveFormat:       input.ArchiveFormat,
+		GitHubActionsLayout: gitHubActionsLayout,
+		DownloadProgress:    input.DownloadProgress,
+		Reporter:            input.ProgressReporter,
+		CacheBustingParam:   input.CacheBustingQueryParam,
+		MinAvailableMemory:  input.MinAvailableMemory,
+		RestoreStateFile:    restoreStateFile(input.RestoreStateFile, r.envRepo.Get(restoreStateFileEnvVar)),
+		warmupKey:           warmupKey,
 	}, nil
 }
 
-func (r *restorer) evaluateKeys(keys []string) ([]string, error) {
+// withFallbackKeys returns the key templates, each followed by its fallback keys, without duplicates
+func (r *restorer) withFallbackKeys(keyTemplates []string) ([]string, error) {
+	var keys []string
+	seen := map[string]bool{}
+	for _, keyTemplate := range keyTemplates {
+		if keyTemplate == "" {
+			continue
+		}
+		fallbackKeys, err := keytemplate.FallbackKeys(keyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate fallback keys of %s: %w", keyTemplate, err)
+		}
+		for _, key := range fallbackKeys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if len(keys) > maxRestoreKeyCount {
+		r.logger.Warnf("Only the first %d of the %d keys (including the fallback keys) are used, dropped keys: %s", maxRestoreKeyCount, len(keys), strings.Join(keys[maxRestoreKeyCount:], ", "))
+		keys = keys[:maxRestoreKeyCount]
+	}
+	return keys, nil
+}
+
+// withWarmupKey appends the warm-up restore key of the cache to the key templates, replacing the last key if the
+// list is full
+func (r *restorer) withWarmupKey(keyTemplates []string, warmupName string) ([]string, error) {
+	warmupKey, err := keytemplate.WarmupRestoreKey(warmupName)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, key := range keyTemplates {
+		if key != "" && key != warmupKey {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) >= maxRestoreKeyCount {
+		r.logger.Warnf("Only %d keys are accepted, dropped key %s in favor of the warm-up key %s", maxRestoreKeyCount, keys[maxRestoreKeyCount-1], warmupKey)
+		keys = keys[:maxRestoreKeyCount-1]
+	}
+	return append(keys, warmupKey), nil
+}
+
+// isWarmupHit reports whether the matched key belongs to the warm-up archive of the cache
+func (c restoreCacheConfig) isWarmupHit(matchedKey string) bool {
+	return c.warmupKey != "" && strings.HasPrefix(matchedKey, c.warmupKey)
+}
+
+func (r *restorer) evaluateKeys(keys []string, scope keytemplate.KeyScope) ([]string, error) {
 	model := keytemplate.NewModel(r.envRepo, r.logger)
+	keyScopePrefix := model.ScopePrefix(scope)
 
 	var evaluatedKeys []string
 	for _, key := range keys {
@@ -183,6 +650,7 @@ func (r *restorer) evaluateKeys(keys []string) ([]string, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to evaluate key template: %s", err)
 		}
+		evaluatedKey = keyScopePrefix + evaluatedKey
 		r.logger.Donef("Cache key: %s", evaluatedKey)
 		evaluatedKeys = append(evaluatedKeys, evaluatedKey)
 	}
@@ -190,6 +658,75 @@ func (r *restorer) evaluateKeys(keys []string) ([]string, error) {
 	return evaluatedKeys, nil
 }
 
+// evaluateIncludePaths converts the include paths to absolute paths, as the archive stores absolute paths
+func (r *restorer) evaluateIncludePaths(paths []string) ([]string, error) {
+	pathModifier := pathutil.NewPathModifier()
+
+	var absPaths []string
+	for _, path := range paths {
+		if strings.TrimSpace(path) == "" {
+			continue
+		}
+
+		absPath, err := pathModifier.AbsPath(path) // resolves ~/ and expands any envs
+		if err != nil {
+			return nil, err
+		}
+		absPaths = append(absPaths, absPath)
+	}
+
+	return absPaths, nil
+}
+
+func (r *restorer) verifyManifest(path string, includePaths []string) error {
+	if len(includePaths) > 0 {
+		r.logger.Warnf("Only a subset of the archive was restored, skipping manifest verification")
+		return nil
+	}
+
+	manifest, err := ReadManifest(path)
+	if errors.Is(err, os.ErrNotExist) {
+		r.logger.Warnf("The cache archive doesn't contain a manifest, skipping verification")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	mismatches := manifest.Verify()
+	if len(mismatches) > 0 {
+		r.logger.Printf("Files not matching the manifest:")
+		for _, path := range mismatches {
+			r.logger.Printf("- %s", path)
+		}
+		return fmt.Errorf("%d restored files don't match the cache manifest", len(mismatches))
+	}
+	r.logger.Donef("All %d files match the manifest", len(manifest.Files))
+
+	return nil
+}
+
+func (r *restorer) validate(archiver *compression.Archiver, archivePath string, includePaths []string, validators []RestoreValidator) error {
+	restoredPaths := includePaths
+	if len(restoredPaths) == 0 && archivePath != "" {
+		roots, err := archiver.ListRoots(archivePath)
+		if err != nil {
+			return fmt.Errorf("failed to list restored paths: %w", err)
+		}
+		restoredPaths = roots
+	}
+
+	for _, validator := range validators {
+		if err := validator(restoredPaths); err != nil {
+			r.logger.Warnf("Restored cache is invalid: %s", err)
+			return fmt.Errorf("%w: %s", ErrRestoreValidationFailed, err)
+		}
+	}
+	r.logger.Donef("Restored cache is valid")
+
+	return nil
+}
+
 func (r *restorer) download(ctx context.Context, config restoreCacheConfig) (downloadResult, error) {
 	dir, err := os.MkdirTemp("", "restore-cache")
 	if err != nil {
@@ -198,15 +735,7 @@ func (r *restorer) download(ctx context.Context, config restoreCacheConfig) (dow
 	name := fmt.Sprintf("cache-%s.tzst", time.Now().UTC().Format("20060102-150405"))
 	downloadPath := filepath.Join(dir, name)
 
-	params := network.DownloadParams{
-		APIBaseURL:     string(config.APIBaseURL),
-		Token:          string(config.APIAccessToken),
-		CacheKeys:      config.Keys,
-		DownloadPath:   downloadPath,
-		NumFullRetries: config.NumFullRetries,
-		MaxConcurrency: config.MaxConcurrency,
-	}
-	matchedKey, err := r.downloader.Download(ctx, params, r.logger)
+	matchedKey, err := r.downloader.Download(ctx, r.downloadParams(config, downloadPath), r.logger)
 	if err != nil {
 		return downloadResult{}, err
 	}
@@ -216,18 +745,43 @@ func (r *restorer) download(ctx context.Context, config restoreCacheConfig) (dow
 	return downloadResult{filePath: downloadPath, matchedKey: matchedKey}, nil
 }
 
+func (r *restorer) downloadParams(config restoreCacheConfig, downloadPath string) network.DownloadParams {
+	var watchdog *network.MemoryWatchdog
+	if config.MinAvailableMemory > 0 {
+		watchdog = network.NewMemoryWatchdog(config.MinAvailableMemory)
+	}
+	return network.DownloadParams{
+		APIBaseURL:             string(config.APIBaseURL),
+		Token:                  string(config.APIAccessToken),
+		CacheKeys:              config.Keys,
+		DownloadPath:           downloadPath,
+		NumFullRetries:         config.NumFullRetries,
+		MaxConcurrency:         config.MaxConcurrency,
+		ProgressFunc:           config.downloadProgressFunc(),
+		Reporter:               config.Reporter,
+		CacheBustingQueryParam: config.CacheBustingParam,
+		MemoryWatchdog:         watchdog,
+		ArchiveMatched:         config.archiveMatched,
+	}
+}
+
+// downloadProgressFunc reports the download progress both to RestoreCacheInput.DownloadProgress and the progress reporter
+func (c restoreCacheConfig) downloadProgressFunc() func(network.DownloadProgress) {
+	return func(p network.DownloadProgress) {
+		if c.DownloadProgress != nil {
+			c.DownloadProgress(p)
+		}
+		c.Reporter.Progress(progress.PhaseDownload, int64(p.DownloadedBytes), int64(p.TotalBytes))
+	}
+}
+
 func (r *restorer) exposeCacheHit(result downloadResult, evaluatedKeys []string) error {
-	if result.filePath == "" || result.matchedKey == "" || len(evaluatedKeys) == 0 {
+	if (result.filePath == "" && result.checksum == "") || result.matchedKey == "" || len(evaluatedKeys) == 0 {
 		return nil
 	}
 
 	exporter := export.NewExporter(r.cmdFactory)
-	var cacheHitValue string
-	if result.matchedKey == evaluatedKeys[0] {
-		cacheHitValue = "exact"
-	} else {
-		cacheHitValue = "partial"
-	}
+	cacheHitValue := string(cacheHitType(result.matchedKey, evaluatedKeys))
 	err := exporter.ExportOutput(cacheHitEnvVar, cacheHitValue)
 	if err != nil {
 		return err
@@ -237,19 +791,32 @@ func (r *restorer) exposeCacheHit(result downloadResult, evaluatedKeys []string)
 		return err
 	}
 
-	checksum, err := checksumOfFile(result.filePath)
-	if err != nil {
-		return err
+	checksum := result.checksum
+	if checksum == "" {
+		checksum, err = checksumOfFile(result.filePath)
+		if err != nil {
+			return err
+		}
 	}
 
 	r.logger.Debugf("Exposing cache hit info:")
 	r.logger.Debugf("Matched key: %s", result.matchedKey)
 	r.logger.Debugf("Archive checksum: %s", checksum)
 
-	envKey := cacheHitUniqueEnvVarPrefix + result.matchedKey
+	envKey := cacheHitEnvKey(result.matchedKey)
 	err = exporter.ExportOutput(envKey, checksum)
 	if err != nil {
 		return err
 	}
 	return r.envRepo.Set(envKey, checksum)
 }
+
+func cacheHitType(matchedKey string, evaluatedKeys []string) CacheHit {
+	if matchedKey == "" || len(evaluatedKeys) == 0 {
+		return CacheHitNone
+	}
+	if matchedKey == evaluatedKeys[0] {
+		return CacheHitExact
+	}
+	return CacheHitPartial
+}
@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+)
+
+// restoreStateFileEnvVar is the fallback of RestoreCacheInput.RestoreStateFile
+const restoreStateFileEnvVar = "BITRISE_CACHE_RESTORE_STATE_FILE"
+
+// restoreState is the list of archives restored on a persistent runner, see RestoreCacheInput.RestoreStateFile
+type restoreState struct {
+	Restores []restoredArchive `json:"restores"`
+}
+
+// restoredArchive is an archive extracted on the runner, identified by its key and checksum
+type restoredArchive struct {
+	Key string `json:"key"`
+	// ArchiveChecksum is the checksum of the archive reported by the cache API
+	ArchiveChecksum string `json:"archive_checksum"`
+	// HitChecksum is the checksum exposed in the cache hit env var (of the decrypted archive for encrypted archives)
+	HitChecksum  string   `json:"hit_checksum"`
+	IncludePaths []string `json:"include_paths,omitempty"`
+	// Paths are the restored paths, they must all exist for the restore to be skipped
+	Paths      []string  `json:"paths"`
+	RestoredAt time.Time `json:"restored_at"`
+}
+
+// restoreStateFile returns the path of the state file, or an empty string if restores are never skipped
+func restoreStateFile(inputFile, envFile string) string {
+	if inputFile != "" {
+		return inputFile
+	}
+	return strings.TrimSpace(envFile)
+}
+
+// readRestoreState returns the state stored in the file, or an empty state if there is no file yet
+func readRestoreState(path string) (restoreState, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return restoreState{}, nil
+	}
+	if err != nil {
+		return restoreState{}, err
+	}
+
+	var state restoreState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return restoreState{}, err
+	}
+	return state, nil
+}
+
+// find returns the archive restored with the same key, checksum and include paths, if its paths still exist
+func (s restoreState) find(key, archiveChecksum string, includePaths []string) (restoredArchive, bool) {
+	if key == "" || archiveChecksum == "" {
+		return restoredArchive{}, false
+	}
+	for _, archive := range s.Restores {
+		if archive.Key != key || archive.ArchiveChecksum != archiveChecksum || !equalPaths(archive.IncludePaths, includePaths) {
+			continue
+		}
+		for _, path := range archive.Paths {
+			if _, err := os.Lstat(path); err != nil {
+				return restoredArchive{}, false
+			}
+		}
+		return archive, true
+	}
+	return restoredArchive{}, false
+}
+
+// record adds the archive to the state. The previous archives of the same key, and the ones restored to any of
+// the same paths are removed, as their content was overwritten.
+func (s *restoreState) record(archive restoredArchive) {
+	restores := []restoredArchive{archive}
+	for _, previous := range s.Restores {
+		if previous.Key == archive.Key || pathsOverlap(previous.Paths, archive.Paths) {
+			continue
+		}
+		restores = append(restores, previous)
+	}
+	s.Restores = restores
+}
+
+// write replaces the state file in one step, so that a concurrent build never reads a partial file
+func (s restoreState) write(path string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) //nolint:errcheck
+
+	if _, err := tmpFile.Write(content); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pathsOverlap reports whether any path of a is the same as, or is under or above any path of b
+func pathsOverlap(a, b []string) bool {
+	for _, pathA := range a {
+		for _, pathB := range b {
+			if isSameOrNestedPath(filepath.Clean(pathA), filepath.Clean(pathB)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isSameOrNestedPath(a, b string) bool {
+	return a == b ||
+		strings.HasPrefix(a, b+string(filepath.Separator)) ||
+		strings.HasPrefix(b, a+string(filepath.Separator))
+}
+
+// persistentRestores skips the download of archives already restored on a persistent runner,
+// see RestoreCacheInput.RestoreStateFile
+type persistentRestores struct {
+	path  string
+	state restoreState
+	// matchedChecksum is the checksum of the last matched archive
+	matchedChecksum string
+	// skipped is the already restored archive, if the download was skipped
+	skipped restoredArchive
+}
+
+func (r *restorer) loadPersistentRestores(path string) *persistentRestores {
+	state, err := readRestoreState(path)
+	if err != nil {
+		r.logger.Warnf("Failed to read the restore state file, restoring the archive anyway: %s", err)
+		state = restoreState{}
+	}
+	return &persistentRestores{path: path, state: state}
+}
+
+// archiveMatched returns the network.DownloadParams.ArchiveMatched func, skipping the archives found in the state
+func (p *persistentRestores) archiveMatched(includePaths []string) func(matchedKey, archiveChecksum string) bool {
+	return func(matchedKey, archiveChecksum string) bool {
+		p.matchedChecksum = archiveChecksum
+		archive, ok := p.state.find(matchedKey, archiveChecksum, includePaths)
+		if ok {
+			p.skipped = archive
+		}
+		return ok
+	}
+}
+
+// alreadyRestored reports a cache hit for the archive restored by a previous build on this runner
+func (r *restorer) alreadyRestored(archive restoredArchive, keys []string, tracker Tracker) (RestoreResult, error) {
+	r.logMatchedKey(archive.Key, keys)
+	r.logger.Donef("The archive was already restored on this runner at %s, skipping the download and the extraction",
+		archive.RestoredAt.Format(time.RFC3339))
+
+	result := downloadResult{matchedKey: archive.Key, checksum: archive.HitChecksum}
+	if err := r.exposeCacheHit(result, keys); err != nil {
+		return RestoreResult{}, err
+	}
+	tracker.LogRestoreResult(true, archive.Key, keys)
+
+	return RestoreResult{Hit: cacheHitType(archive.Key, keys), MatchedKey: archive.Key}, nil
+}
+
+// recordRestore adds the restored archive to the state file. Failures are only logged, as the restore succeeded.
+func (r *restorer) recordRestore(restores *persistentRestores, result downloadResult, config restoreCacheConfig, paths []string) {
+	if len(paths) == 0 || restores.matchedChecksum == "" {
+		r.logger.Debugf("The restored paths or the archive checksum are unknown, the restore is not recorded")
+		return
+	}
+
+	restores.state.record(restoredArchive{
+		Key:             result.matchedKey,
+		ArchiveChecksum: restores.matchedChecksum,
+		HitChecksum:     r.envRepo.Get(cacheHitEnvKey(result.matchedKey)),
+		IncludePaths:    config.IncludePaths,
+		Paths:           paths,
+		RestoredAt:      time.Now().UTC(),
+	})
+	if err := restores.state.write(restores.path); err != nil {
+		r.logger.Warnf("Failed to update the restore state file: %s", err)
+	}
+}
+
+// restoredPaths returns the paths restored from the archive file, or nil if they are unknown
+func (r *restorer) restoredPaths(archiver *compression.Archiver, archivePath string, config restoreCacheConfig) []string {
+	if len(config.IncludePaths) > 0 {
+		return config.IncludePaths
+	}
+	if config.ArchiveFormat == ArchiveFormatGitHubActions {
+		// The archive paths are not the restored paths
+		return nil
+	}
+	roots, err := archiver.ListRoots(archivePath)
+	if err != nil {
+		r.logger.Debugf("Failed to list the restored paths: %s", err)
+		return nil
+	}
+	return roots
+}
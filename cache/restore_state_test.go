@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentRestores(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state", "restores.json")
+	restoredDir := filepath.Join(dir, "node_modules")
+	require.NoError(t, os.Mkdir(restoredDir, 0755))
+	envRepo := fakeEnvRepo{envVars: map[string]string{cacheHitUniqueEnvVarPrefix + "npm-1": "hit-checksum"}}
+	r := &restorer{envRepo: envRepo, logger: log.NewLogger()}
+
+	restores := r.loadPersistentRestores(stateFile)
+	require.False(t, restores.archiveMatched(nil)("npm-1", "checksum-1"), "nothing is restored yet")
+	r.recordRestore(restores, downloadResult{matchedKey: "npm-1"}, restoreCacheConfig{}, []string{restoredDir})
+
+	// When
+	restores = r.loadPersistentRestores(stateFile)
+
+	// Then
+	require.True(t, restores.archiveMatched(nil)("npm-1", "checksum-1"))
+	require.Equal(t, "hit-checksum", restores.skipped.HitChecksum)
+	require.False(t, restores.archiveMatched(nil)("npm-1", "checksum-2"), "the archive of the key changed")
+	require.False(t, restores.archiveMatched(nil)("npm-2", "checksum-1"), "a different key matched")
+	require.False(t, restores.archiveMatched([]string{restoredDir})("npm-1", "checksum-1"), "the include paths changed")
+
+	require.NoError(t, os.Remove(restoredDir))
+	require.False(t, restores.archiveMatched(nil)("npm-1", "checksum-1"), "the restored paths were deleted")
+}
+
+func TestRestoreState_record(t *testing.T) {
+	// Given
+	state := restoreState{Restores: []restoredArchive{
+		{Key: "npm-1", ArchiveChecksum: "a", Paths: []string{"/workspace/node_modules"}},
+		{Key: "gradle-1", ArchiveChecksum: "b", Paths: []string{"/home/.gradle/caches"}},
+		{Key: "pods-1", ArchiveChecksum: "c", Paths: []string{"/workspace/Pods"}},
+	}}
+
+	// When
+	state.record(restoredArchive{Key: "npm-1", ArchiveChecksum: "d", Paths: []string{"/workspace/node_modules"}})
+	state.record(restoredArchive{Key: "gradle-2", ArchiveChecksum: "e", Paths: []string{"/home/.gradle"}})
+
+	// Then
+	require.Equal(t, []restoredArchive{
+		{Key: "gradle-2", ArchiveChecksum: "e", Paths: []string{"/home/.gradle"}},
+		{Key: "npm-1", ArchiveChecksum: "d", Paths: []string{"/workspace/node_modules"}},
+		{Key: "pods-1", ArchiveChecksum: "c", Paths: []string{"/workspace/Pods"}},
+	}, state.Restores)
+}
@@ -1,13 +1,16 @@
 package cache
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
 	"testing"
 
+	"github.com/bitrise-io/go-steputils/v2/cache/keytemplate"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_ProcessRestoreConfig(t *testing.T) {
@@ -48,6 +51,22 @@ func Test_ProcessRestoreConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Include paths",
+			input: RestoreCacheInput{
+				Verbose:      true,
+				Keys:         []string{"valid-key"},
+				IncludePaths: []string{"/root/.gradle/caches", "", "/root/.gradle/wrapper/**"},
+			},
+			want: restoreCacheConfig{
+				Verbose:        true,
+				Keys:           []string{"valid-key"},
+				APIBaseURL:     "fake service URL",
+				APIAccessToken: "fake access token",
+				IncludePaths:   []string{"/root/.gradle/caches", "/root/.gradle/wrapper/**"},
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, testCase := range tests {
@@ -81,6 +100,7 @@ func Test_ProcessRestoreConfig(t *testing.T) {
 func Test_evaluateKeys(t *testing.T) {
 	type args struct {
 		keys    []string
+		scope   keytemplate.KeyScope
 		envRepo fakeEnvRepo
 	}
 
@@ -136,6 +156,27 @@ func Test_evaluateKeys(t *testing.T) {
 			want:    []string{"npm-cache-"},
 			wantErr: false,
 		},
+		{
+			name: "Scoped keys",
+			args: args{
+				keys: []string{
+					"npm-cache-{{ .Branch }}",
+					"npm-cache-",
+				},
+				scope: keytemplate.KeyScope{Namespace: "web", Stack: true, XcodeVersion: true},
+				envRepo: fakeEnvRepo{
+					envVars: map[string]string{
+						"BITRISE_GIT_BRANCH": "main",
+						"BITRISEIO_STACK_ID": "linux-docker-android-22.04",
+					},
+				},
+			},
+			want: []string{
+				"web-linux-docker-android-22.04-npm-cache-main",
+				"web-linux-docker-android-22.04-npm-cache-",
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, testCase := range tests {
@@ -148,7 +189,7 @@ func Test_evaluateKeys(t *testing.T) {
 			}
 
 			// When
-			evaluatedKeys, err := step.evaluateKeys(testCase.args.keys)
+			evaluatedKeys, err := step.evaluateKeys(testCase.args.keys, testCase.args.scope)
 			if (err != nil) != testCase.wantErr {
 				t.Errorf("evaluateKey() error = %v, wantErr %v", err, testCase.wantErr)
 				return
@@ -160,6 +201,44 @@ func Test_evaluateKeys(t *testing.T) {
 	}
 }
 
+func Test_withFallbackKeys(t *testing.T) {
+	// Given
+	step := restorer{logger: log.NewLogger()}
+
+	// When
+	keys, err := step.withFallbackKeys([]string{
+		`npm-{{ .Branch }}-{{ checksum "package-lock.json" }}`,
+		`npm-{{ .Branch }}-`,
+		"",
+	})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`npm-{{ .Branch }}-{{ checksum "package-lock.json" }}`,
+		`npm-{{.Branch}}-`,
+		`npm-`,
+		`npm-{{ .Branch }}-`,
+	}, keys)
+}
+
+func Test_withFallbackKeys_LimitsKeyCount(t *testing.T) {
+	// Given
+	step := restorer{logger: log.NewLogger()}
+	var templates []string
+	for i := 0; i < 5; i++ {
+		templates = append(templates, fmt.Sprintf(`key%d-{{ .Branch }}-{{ checksum "go.sum" }}`, i))
+	}
+
+	// When
+	keys, err := step.withFallbackKeys(templates)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, keys, maxRestoreKeyCount)
+	require.Equal(t, `key2-{{ .Branch }}-{{ checksum "go.sum" }}`, keys[6])
+}
+
 func Test_exposeCacheHit(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -199,6 +278,18 @@ func Test_exposeCacheHit(t *testing.T) {
 				"BITRISE_CACHE_HIT__my-cache-key=9a30a503b2862c51c3c5acd7fbce2f1f784cf4658ccf8e87d5023a90c21c0714",
 			},
 		},
+		{
+			name:          "cache hit on a key with whitespace",
+			evaluatedKeys: []string{"my cache key"},
+			downloadResult: downloadResult{
+				filePath:   "testdata/dummy_file.txt",
+				matchedKey: "my cache key",
+			},
+			wantEnvs: []string{
+				"BITRISE_CACHE_HIT=exact",
+				"BITRISE_CACHE_HIT__my_cache_key=9a30a503b2862c51c3c5acd7fbce2f1f784cf4658ccf8e87d5023a90c21c0714",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -218,3 +309,61 @@ func Test_exposeCacheHit(t *testing.T) {
 		})
 	}
 }
+
+func Test_cacheHitType(t *testing.T) {
+	tests := []struct {
+		name          string
+		matchedKey    string
+		evaluatedKeys []string
+		want          CacheHit
+	}{
+		{name: "No match", matchedKey: "", evaluatedKeys: []string{"my-cache-key"}, want: CacheHitNone},
+		{name: "First key", matchedKey: "my-cache-key", evaluatedKeys: []string{"my-cache-key", "my-fallback-key"}, want: CacheHitExact},
+		{name: "Fallback key", matchedKey: "my-fallback-key", evaluatedKeys: []string{"my-cache-key", "my-fallback-key"}, want: CacheHitPartial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, cacheHitType(tt.matchedKey, tt.evaluatedKeys))
+		})
+	}
+}
+
+func Test_withWarmupKey(t *testing.T) {
+	// Given
+	step := restorer{logger: log.NewLogger()}
+	var templates []string
+	for i := 0; i < maxRestoreKeyCount; i++ {
+		templates = append(templates, fmt.Sprintf("key%d", i))
+	}
+
+	// When
+	keys, err := step.withWarmupKey(append([]string{""}, templates...), "npm")
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, keys, maxRestoreKeyCount)
+	require.Equal(t, "key6", keys[maxRestoreKeyCount-2])
+	require.Equal(t, "warmup-npm-", keys[maxRestoreKeyCount-1])
+}
+
+func Test_createConfig_WarmupName(t *testing.T) {
+	// Given
+	envRepo := fakeEnvRepo{envVars: map[string]string{
+		"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+		"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+	}}
+	step := restorer{logger: log.NewLogger(), envRepo: envRepo, cmdFactory: command.NewFactory(envRepo)}
+
+	// When
+	config, err := step.createConfig(RestoreCacheInput{
+		Keys:       []string{"npm-main-lockfile"},
+		WarmupName: "npm",
+		KeyScope:   keytemplate.KeyScope{Namespace: "project"},
+	})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"project-npm-main-lockfile", "project-warmup-npm-"}, config.Keys)
+	require.True(t, config.isWarmupHit("project-warmup-npm-abc123"))
+	require.False(t, config.isWarmupHit("project-npm-main-lockfile"))
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"github.com/bitrise-io/go-steputils/v2/cache/compression"
 	"github.com/bitrise-io/go-steputils/v2/cache/keytemplate"
 	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-steputils/v2/cache/progress"
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/log"
@@ -38,8 +40,99 @@ type SaveCacheInput struct {
 	// Example of such key: my-cache-key-{{ checksum "package-lock.json" }}
 	// Example where this is not true: my-cache-key-{{ .OS }}-{{ .Arch }}
 	IsKeyUnique bool
+	// GenerateManifest adds a manifest (the list of cached files with their sizes and checksums) to the archive.
+	// Restore steps can verify the extracted content against it, see RestoreCacheInput.VerifyManifest.
+	GenerateManifest bool
+	// EncryptionKey enables client-side encryption: the archive is encrypted with AES-256-GCM (using a key derived from
+	// this value) before upload. If not provided, the value of BITRISEIO_DEPENDENCY_CACHE_ENCRYPTION_KEY is used, and
+	// if that's empty too, the archive is uploaded unencrypted.
+	// Restore steps need the same key, see RestoreCacheInput.EncryptionKey.
+	EncryptionKey string
+	// DryRun evaluates the key and the paths, calculates the size of the paths and decides whether the save can be
+	// skipped, without creating and uploading the archive. The results are logged and returned in SaveResult.
+	// This helps tuning the list of paths.
+	DryRun bool
+	// SizeBreakdownTopN logs the size of each path with its N largest entries before compression.
+	// If not provided (0), only the size of the paths is logged, and only if LargePathThreshold is set (or in a dry run).
+	SizeBreakdownTopN int
+	// LargePathThreshold warns about the paths and entries that make up more than this fraction (between 0 and 1)
+	// of the cached content, such as 0.5. If not provided (0), there are no warnings.
+	LargePathThreshold float64
+	// RemainingBuildTime is the time left until the build times out. The save is skipped if the upload likely can't
+	// finish in time (keeping a minute for the rest of the build), and the upload is cancelled when the time runs out.
+	// If not provided, the value of BITRISE_BUILD_REMAINING_TIME is used, and if that's empty too, there is no limit.
+	RemainingBuildTime time.Duration
+	// KeyScope adds scope data (such as the stack and the OS) to the evaluated key.
+	// Restore steps need the same scope, see RestoreCacheInput.KeyScope.
+	KeyScope keytemplate.KeyScope
+	// ProgressReporter (if set) receives the phases, byte counters and retries of the save, see progress.Reporter
+	ProgressReporter progress.Reporter
+	// ExcludePaths are "doublestar" globs of files and directories to leave out of the archive (such as `~/.gradle/caches/**/*.lock`).
+	// Relative patterns are relative to the working directory. The patterns of the repository's .cacheignore file
+	// (gitignore syntax, in BITRISE_SOURCE_DIR) are excluded too.
+	ExcludePaths []string
+	// IgnoreFiles are additional gitignore-style files (such as `ios/.cacheignore`), their patterns are relative to
+	// the directory of the file. The .cacheignore files of the cached directories are always applied, and negated
+	// patterns (`!pattern`) re-include paths excluded by other patterns.
+	IgnoreFiles []string
+	// LowDiskSpace is what happens when the free space in the temp dir is likely not enough for the archive
+	// (estimated from the size of the paths) before compression begins. By default a warning is logged.
+	LowDiskSpace LowDiskSpaceAction
+	// DeterministicArchive creates the same archive for the same cached content (see compression.CompressOptions),
+	// so that the upload is skipped when the content didn't change since the restore, even if the key is not unique.
+	// The restored archive needs to be created with this option too.
+	DeterministicArchive bool
+	// MetricsDir (if set) is where a Prometheus textfile (MetricsFileName) with the byte counters, durations, retries
+	// and results of the cache operations is written, for node_exporter's textfile collector on self-hosted runners.
+	// The counters are added to the values of the existing file. If not provided, the value of
+	// BITRISE_CACHE_METRICS_DIR is used, and if that's empty too, no metrics are written.
+	MetricsDir string
+	// ConflictPolicy is what happens when the key was saved by another build (such as a parallel workflow) since this
+	// build restored it. The checksum of the restored archive of the key (or the lack of a restored archive) is sent
+	// with the upload, and the cache API rejects the upload if the key has another archive by then.
+	// By default the check is turned off, and the last upload wins. Encrypted archives are not checked.
+	ConflictPolicy ConflictPolicy
+	// SaveOnBranches (if set) limits saving to the branches (BITRISE_GIT_BRANCH) matching any of these "doublestar"
+	// patterns, such as `main` and `release/**`, so that feature branches restore the caches of the main branch
+	// without overwriting them. Builds with an unknown branch don't save.
+	SaveOnBranches []string
+	// SaveOnWorkflows (if set) limits saving to these workflows (BITRISE_TRIGGERED_WORKFLOW_ID)
+	SaveOnWorkflows []string
+	// SkipOnPR skips saving in pull request builds (when BITRISE_PULL_REQUEST is set), they only restore caches
+	SkipOnPR bool
+	// MaxArchiveSize (if set) is the size limit of the compressed archive in bytes. Larger archives are not uploaded:
+	// the largest entries of the cached paths are logged, and the save fails with network.ErrArchiveTooLarge,
+	// or is skipped, see TooLargeArchive.
+	MaxArchiveSize int64
+	// TooLargeArchive is what happens when the archive exceeds MaxArchiveSize. By default the save fails.
+	TooLargeArchive TooLargeArchiveAction
 }
 
+// SaveResult summarizes a cache save, so that steps can export it as outputs or build their own reporting
+type SaveResult struct {
+	// Key is the evaluated cache key
+	Key string
+	// Skipped is true if the archive was not uploaded, see SkipReason
+	Skipped bool
+	// SkipReason is the reason for skipping (or not skipping) the save, such as `restore_same_unique_key`
+	SkipReason string
+	// ArchiveSize is the size of the uploaded archive in bytes (0 if the save was skipped before creating the archive)
+	ArchiveSize     int64
+	CompressionTime time.Duration
+	UploadTime      time.Duration
+	// DryRun is true if the result is from a dry run (see SaveCacheInput.DryRun), only then are PathSizes and
+	// TotalSize set. A dry run can't tell if the upload would be skipped, Skipped only reflects the save skip decision.
+	DryRun    bool
+	PathSizes []PathSize
+	// TotalSize is the size of the cached files before compression in bytes
+	TotalSize int64
+	// Conflict is true if the key was saved by another build since the restore, see SaveCacheInput.ConflictPolicy
+	Conflict bool
+}
+
+// Time kept for the rest of the build when the remaining build time is known, see SaveCacheInput.RemainingBuildTime
+const buildTimeReserve = time.Minute
+
 // Saver ...
 type Saver interface {
 	Save(input SaveCacheInput) error
@@ -51,8 +144,23 @@ type saveCacheConfig struct {
 	Paths            []string
 	CompressionLevel int
 	CustomTarArgs    []string
+	GenerateManifest bool
+	EncryptionKey    stepconf.Secret
 	APIBaseURL       stepconf.Secret
 	APIAccessToken   stepconf.Secret
+	// SizeBreakdownTopN and LargePathThreshold configure the size breakdown logged before compression
+	SizeBreakdownTopN  int
+	LargePathThreshold float64
+	RemainingBuildTime time.Duration
+	// KeyScopePrefix is the prefix added to Key, see SaveCacheInput.KeyScope
+	KeyScopePrefix  string
+	Reporter        progress.Reporter
+	ExcludePatterns []string
+	LowDiskSpace    LowDiskSpaceAction
+	Deterministic   bool
+	ConflictPolicy  ConflictPolicy
+	MaxArchiveSize  int64
+	TooLargeArchive TooLargeArchiveAction
 }
 
 type saver struct {
@@ -62,9 +170,13 @@ type saver struct {
 	pathModifier pathutil.PathModifier
 	pathChecker  pathutil.PathChecker
 	uploader     network.Uploader
+	// tracker is nil if the default tracker is used, see WithTracker
+	tracker Tracker
 }
 
 // NewSaver creates a new cache saver instance. `uploader` can be nil, unless you want to provide a custom `Uploader` implementation.
+// If `uploader` is nil, the storage backend is selected by env vars, see network.NewStorage.
+// Analytics events are sent to Bitrise, unless another tracker is provided with WithTracker.
 func NewSaver(
 	envRepo env.Repository,
 	logger log.Logger,
@@ -72,37 +184,88 @@ func NewSaver(
 	pathModifier pathutil.PathModifier,
 	pathChecker pathutil.PathChecker,
 	uploader network.Uploader,
+	opts ...Option,
 ) *saver {
-	var uploaderImpl network.Uploader = uploader
-	if uploader == nil {
-		uploaderImpl = network.DefaultUploader{}
-	}
+	o := applyOptions(opts)
 	return &saver{
 		envRepo:      envRepo,
 		logger:       logger,
 		pathProvider: pathProvider,
 		pathModifier: pathModifier,
 		pathChecker:  pathChecker,
-		uploader:     uploaderImpl,
+		uploader:     uploader,
+		tracker:      o.tracker,
 	}
 }
 
 // Save ...
 func (s *saver) Save(input SaveCacheInput) error {
+	_, err := s.SaveWithResult(input)
+	return err
+}
+
+// SaveWithResult works like Save, and also returns a summary of the save
+func (s *saver) SaveWithResult(input SaveCacheInput) (SaveResult, error) {
+	if isEnvFlagSet(s.envRepo, cacheDisableEnvVar) {
+		s.logger.Println()
+		s.logger.Warnf("Skipping cache save, reason: %s", reasonCacheDisabled.description())
+		return SaveResult{Skipped: true, SkipReason: reasonCacheDisabled.String()}, nil
+	}
+	skipByPolicy, reason, err := s.skipBySavePolicy(input)
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("failed to parse inputs: %w", err)
+	}
+	if skipByPolicy {
+		s.logger.Println()
+		s.logger.Donef("Skipping cache save, reason: %s", reason.description())
+		return SaveResult{Skipped: true, SkipReason: reason.String()}, nil
+	}
+	breaker := newCircuitBreaker(s.envRepo, nil, s.logger)
+	if breaker.isOpen() {
+		s.logger.Println()
+		s.logger.Warnf("Skipping cache save, reason: %s", reasonCacheServiceUnavailable.description())
+		return SaveResult{Skipped: true, SkipReason: reasonCacheServiceUnavailable.String()}, nil
+	}
+	input.Verbose = applyVerboseOverride(s.envRepo, s.logger, input.Verbose)
+
 	config, err := s.createConfig(input)
 	if err != nil {
-		return fmt.Errorf("failed to parse inputs: %w", err)
+		return SaveResult{}, fmt.Errorf("failed to parse inputs: %w", err)
+	}
+	config.Reporter = progress.OrSilent(config.Reporter)
+
+	if input.DryRun {
+		return s.dryRun(input, config)
 	}
 
-	tracker := newStepTracker(input.StepId, s.envRepo, s.logger)
-	defer tracker.wait()
+	result := SaveResult{Key: config.Key}
+	budget := network.NewTimeBudget(config.RemainingBuildTime, buildTimeReserve)
 
-	canSkipSave, reason := s.canSkipSave(input.Key, config.Key, input.IsKeyUnique)
-	tracker.logSkipSaveResult(canSkipSave, reason)
+	if s.uploader == nil {
+		storage, err := network.NewStorage(s.envRepo)
+		if err != nil {
+			return result, fmt.Errorf("failed to create cache storage: %w", err)
+		}
+		s.uploader = storage
+	}
+
+	tracker := s.tracker
+	if tracker == nil {
+		tracker = NewDefaultTracker(input.StepId, s.envRepo, s.logger)
+	}
+	if dir := metricsDir(input.MetricsDir, s.envRepo.Get(metricsDirEnvVar)); dir != "" {
+		collector := newMetricsCollector(dir, UsageOperationSave, tracker, config.Reporter, s.logger)
+		tracker, config.Reporter = collector, collector
+	}
+	defer tracker.Wait()
+
+	canSkipSave, reason := s.canSkipSave(config.KeyScopePrefix+input.Key, config.Key, input.IsKeyUnique)
+	tracker.LogSkipSaveResult(canSkipSave, reason.String())
+	result.Skipped, result.SkipReason = canSkipSave, reason.String()
 	s.logger.Println()
 	if canSkipSave {
 		s.logger.Donef("Cache save can be skipped, reason: %s", reason.description())
-		return nil
+		return result, nil
 	} else {
 		s.logger.Infof("Can't skip saving the cache, reason: %s", reason.description())
 		if reason == reasonNoRestoreThisKey {
@@ -110,50 +273,151 @@ func (s *saver) Save(input SaveCacheInput) error {
 		}
 	}
 
+	if budget.IsLimited() && budget.Available() <= 0 {
+		return s.skipForTimeBudget(result, budget), nil
+	}
+
+	// The paths are scanned once for the size breakdown, the disk space estimate, the manifest, the empty path check
+	// and the compression
+	scan, err := compression.ScanPaths(config.Paths, config.ExcludePatterns, 0)
+	if err != nil {
+		s.logger.Debugf("Failed to scan the cached paths: %s", err)
+		scan = nil
+	}
+
+	if config.SizeBreakdownTopN > 0 || config.LargePathThreshold > 0 {
+		s.logger.Println()
+		if _, err := s.sizeBreakdown(config.Paths, scan, config.SizeBreakdownTopN, config.LargePathThreshold); err != nil {
+			s.logger.Warnf("Failed to calculate the size of the cached paths: %s", err)
+		}
+	}
+
+	if config.LowDiskSpace != LowDiskSpaceIgnore && !s.hasEnoughDiskSpace(config.Paths, scan, config.EncryptionKey != "") &&
+		config.LowDiskSpace == LowDiskSpaceSkip {
+		s.logger.Warnf("Skipping cache save, reason: %s", reasonLowDiskSpace.description())
+		result.Skipped, result.SkipReason = true, reasonLowDiskSpace.String()
+		return result, nil
+	}
+
+	if config.GenerateManifest {
+		s.logger.Println()
+		s.logger.Infof("Generating manifest...")
+		manifestFile, err := s.writeManifest(config.Key, config.Paths, scan, config.ExcludePatterns)
+		if err != nil {
+			return result, fmt.Errorf("failed to generate manifest: %w", err)
+		}
+		// The manifest is only needed in the archive
+		defer os.Remove(manifestFile) //nolint:errcheck
+		config.Paths = append(config.Paths, manifestFile)
+	}
+
 	s.logger.Println()
 	s.logger.Infof("Creating archive...")
 	compressionStartTime := time.Now()
-	archivePath, err := s.compress(config.Paths, config.CompressionLevel, config.CustomTarArgs)
+	config.Reporter.PhaseStarted(progress.PhaseCompression)
+	archivePath, err := s.compress(config.Paths, config.CompressionLevel, config.CustomTarArgs, config.ExcludePatterns, config.Deterministic, scan)
+	config.Reporter.PhaseFinished(progress.PhaseCompression, err)
 	if err != nil {
-		return fmt.Errorf("compression failed: %s", err)
+		return result, fmt.Errorf("compression failed: %s", err)
 	}
 	compressionTime := time.Since(compressionStartTime).Round(time.Second)
-	tracker.logArchiveCompressed(compressionTime, len(config.Paths))
+	result.CompressionTime = compressionTime
+	tracker.LogArchiveCompressed(compressionTime, len(config.Paths))
 	s.logger.Donef("Archive created in %s", compressionTime)
 
 	fileInfo, err := os.Stat(archivePath)
 	if err != nil {
-		return err
+		return result, err
 	}
+	result.ArchiveSize = fileInfo.Size()
+	config.Reporter.Progress(progress.PhaseCompression, fileInfo.Size(), fileInfo.Size())
 	s.logger.Printf("Archive size: %s", units.HumanSizeWithPrecision(float64(fileInfo.Size()), 3))
 	s.logger.Debugf("Archive path: %s", archivePath)
 
+	if config.MaxArchiveSize > 0 && fileInfo.Size() > config.MaxArchiveSize {
+		if err := s.handleTooLargeArchive(fileInfo.Size(), config, scan); err != nil {
+			return result, err
+		}
+		tracker.LogSkipUploadResult(true, reasonArchiveTooLarge.String())
+		result.Skipped, result.SkipReason = true, reasonArchiveTooLarge.String()
+		return result, nil
+	}
+
 	archiveChecksum, err := checksumOfFile(archivePath)
 	if err != nil {
 		s.logger.Warnf(err.Error())
 		// fail silently and continue
 	}
 	canSkipUpload, reason := s.canSkipUpload(config.Key, archiveChecksum)
-	tracker.logSkipUploadResult(canSkipUpload, reason)
+	tracker.LogSkipUploadResult(canSkipUpload, reason.String())
+	result.Skipped, result.SkipReason = canSkipUpload, reason.String()
 	s.logger.Println()
 	if canSkipUpload {
 		s.logger.Donef("Cache upload can be skipped, reason: %s", reason.description())
-		return nil
+		return result, nil
 	}
 	s.logger.Infof("Can't skip uploading the cache, reason: %s", reason.description())
 
+	if config.EncryptionKey != "" {
+		s.logger.Println()
+		s.logger.Infof("Encrypting archive...")
+		encryptionStartTime := time.Now()
+		config.Reporter.PhaseStarted(progress.PhaseEncryption)
+		archivePath, err = encryptFile(archivePath, string(config.EncryptionKey))
+		config.Reporter.PhaseFinished(progress.PhaseEncryption, err)
+		if err != nil {
+			return result, fmt.Errorf("failed to encrypt archive: %w", err)
+		}
+		fileInfo, err = os.Stat(archivePath)
+		if err != nil {
+			return result, err
+		}
+		result.ArchiveSize = fileInfo.Size()
+		s.logger.Donef("Archive encrypted in %s", time.Since(encryptionStartTime).Round(time.Second))
+	}
+
+	if !budget.CanUpload(fileInfo.Size()) {
+		return s.skipForTimeBudget(result, budget), nil
+	}
+
 	s.logger.Println()
 	s.logger.Infof("Uploading archive...")
 	uploadStartTime := time.Now()
-	err = s.upload(archivePath, fileInfo.Size(), archiveChecksum, config)
+	uploadCtx, cancel := budget.WithDeadline(context.Background())
+	defer cancel()
+	config.Reporter.PhaseStarted(progress.PhaseUpload)
+	result.Conflict, err = s.upload(uploadCtx, archivePath, fileInfo.Size(), archiveChecksum, config)
+	config.Reporter.PhaseFinished(progress.PhaseUpload, err)
+	if errors.Is(err, network.ErrConflict) && config.ConflictPolicy == ConflictPolicySkip {
+		// The cache service responded, the conflict is handled by skipping the save
+		breaker.recordResult(nil)
+		s.logger.Warnf("Skipping cache save, reason: %s", reasonConflict.description())
+		tracker.LogSkipUploadResult(true, reasonConflict.String())
+		result.Skipped, result.SkipReason = true, reasonConflict.String()
+		return result, nil
+	}
+	breaker.recordResult(err)
 	if err != nil {
-		return fmt.Errorf("cache upload failed: %w", err)
+		if hint := uploadErrorHint(err); hint != "" {
+			s.logger.Warnf(hint)
+		}
+		return result, fmt.Errorf("cache upload failed: %w", err)
 	}
+	config.Reporter.Progress(progress.PhaseUpload, fileInfo.Size(), fileInfo.Size())
 	uploadTime := time.Since(uploadStartTime).Round(time.Second)
+	result.UploadTime = uploadTime
 	s.logger.Donef("Archive uploaded in %s", uploadTime)
-	tracker.logArchiveUploaded(uploadTime, fileInfo, len(config.Paths))
+	tracker.LogArchiveUploaded(uploadTime, fileInfo.Size(), len(config.Paths))
 
-	return nil
+	return result, nil
+}
+
+func (s *saver) skipForTimeBudget(result SaveResult, budget network.TimeBudget) SaveResult {
+	s.logger.Println()
+	s.logger.Warnf("Skipping cache save, reason: %s", reasonNotEnoughBuildTime.description())
+	s.logger.Warnf("Time left for saving the cache: %s", budget.Available().Round(time.Second))
+	result.Skipped, result.SkipReason = true, reasonNotEnoughBuildTime.String()
+	return result
 }
 
 func (s *saver) createConfig(input SaveCacheInput) (saveCacheConfig, error) {
@@ -167,6 +431,8 @@ func (s *saver) createConfig(input SaveCacheInput) (saveCacheConfig, error) {
 	if err != nil {
 		return saveCacheConfig{}, fmt.Errorf("failed to evaluate key template: %s", err)
 	}
+	keyScopePrefix := keytemplate.NewModel(s.envRepo, s.logger).ScopePrefix(input.KeyScope)
+	evaluatedKey = keyScopePrefix + evaluatedKey
 	s.logger.Donef("Cache key: %s", evaluatedKey)
 
 	finalPaths, err := s.evaluatePaths(input.Paths)
@@ -174,13 +440,15 @@ func (s *saver) createConfig(input SaveCacheInput) (saveCacheConfig, error) {
 		return saveCacheConfig{}, fmt.Errorf("failed to parse paths: %w", err)
 	}
 
-	apiBaseURL := s.envRepo.Get("BITRISEIO_ABCS_API_URL")
-	if apiBaseURL == "" {
-		return saveCacheConfig{}, fmt.Errorf("the secret 'BITRISEIO_ABCS_API_URL' is not defined")
+	excludePatterns, err := s.excludePatterns(input.ExcludePaths, finalPaths, input.IgnoreFiles)
+	if err != nil {
+		return saveCacheConfig{}, err
 	}
-	apiAccessToken := s.envRepo.Get("BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN")
-	if apiAccessToken == "" {
-		return saveCacheConfig{}, fmt.Errorf("the secret 'BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN' is not defined")
+	finalPaths = s.filterExcludedPaths(finalPaths, excludePatterns)
+
+	apiBaseURL, apiAccessToken, err := apiCredentials(s.envRepo)
+	if err != nil {
+		return saveCacheConfig{}, err
 	}
 
 	if input.CompressionLevel == 0 {
@@ -190,14 +458,47 @@ func (s *saver) createConfig(input SaveCacheInput) (saveCacheConfig, error) {
 		return saveCacheConfig{}, fmt.Errorf("compression level should be between 1 and 19")
 	}
 
+	if input.SizeBreakdownTopN < 0 {
+		return saveCacheConfig{}, fmt.Errorf("size breakdown top N should not be negative")
+	}
+	if input.LargePathThreshold < 0 || input.LargePathThreshold > 1 {
+		return saveCacheConfig{}, fmt.Errorf("large path threshold should be between 0 and 1")
+	}
+
+	encryptionKey := input.EncryptionKey
+	if encryptionKey == "" {
+		encryptionKey = s.envRepo.Get(encryptionKeyEnvVar)
+	}
+
+	remainingBuildTime := input.RemainingBuildTime
+	if remainingBuildTime == 0 {
+		remainingBuildTime, err = network.RemainingBuildTime(s.envRepo)
+		if err != nil {
+			return saveCacheConfig{}, err
+		}
+	}
+
 	return saveCacheConfig{
-		Verbose:          input.Verbose,
-		Key:              evaluatedKey,
-		Paths:            finalPaths,
-		CompressionLevel: input.CompressionLevel,
-		CustomTarArgs:    input.CustomTarArgs,
-		APIBaseURL:       stepconf.Secret(apiBaseURL),
-		APIAccessToken:   stepconf.Secret(apiAccessToken),
+		Verbose:            input.Verbose,
+		Key:                evaluatedKey,
+		Paths:              finalPaths,
+		CompressionLevel:   input.CompressionLevel,
+		CustomTarArgs:      input.CustomTarArgs,
+		GenerateManifest:   input.GenerateManifest,
+		EncryptionKey:      stepconf.Secret(encryptionKey),
+		APIBaseURL:         apiBaseURL,
+		APIAccessToken:     apiAccessToken,
+		SizeBreakdownTopN:  input.SizeBreakdownTopN,
+		LargePathThreshold: input.LargePathThreshold,
+		RemainingBuildTime: remainingBuildTime,
+		KeyScopePrefix:     keyScopePrefix,
+		Reporter:           input.ProgressReporter,
+		ExcludePatterns:    excludePatterns,
+		LowDiskSpace:       input.LowDiskSpace,
+		Deterministic:      input.DeterministicArchive,
+		ConflictPolicy:     input.ConflictPolicy,
+		MaxArchiveSize:     input.MaxArchiveSize,
+		TooLargeArchive:    input.TooLargeArchive,
 	}, nil
 }
 
@@ -259,8 +560,8 @@ func (s *saver) evaluateKey(keyTemplate string) (string, error) {
 	return model.Evaluate(keyTemplate)
 }
 
-func (s *saver) compress(paths []string, compressionLevel int, customTarArgs []string) (string, error) {
-	if compression.AreAllPathsEmpty(paths) {
+func (s *saver) compress(paths []string, compressionLevel int, customTarArgs []string, excludePatterns []string, deterministic bool, scan *compression.Scan) (string, error) {
+	if allPathsEmpty(paths, scan) {
 		s.logger.Warnf("The provided paths are all empty, skipping compression and upload.")
 		os.Exit(0)
 	}
@@ -277,7 +578,13 @@ func (s *saver) compress(paths []string, compressionLevel int, customTarArgs []s
 		s.envRepo,
 		compression.NewDependencyChecker(s.logger, s.envRepo))
 
-	err = archiver.Compress(archivePath, paths, compressionLevel, customTarArgs)
+	err = archiver.CompressWithOptions(archivePath, paths, compression.CompressOptions{
+		CompressionLevel: compressionLevel,
+		CustomTarArgs:    customTarArgs,
+		ExcludePatterns:  excludePatterns,
+		Deterministic:    deterministic,
+		Scan:             scan,
+	})
 	if err != nil {
 		return "", err
 	}
@@ -285,7 +592,63 @@ func (s *saver) compress(paths []string, compressionLevel int, customTarArgs []s
 	return archivePath, nil
 }
 
-func (s *saver) upload(archivePath string, archiveSize int64, archiveChecksum string, config saveCacheConfig) error {
+// allPathsEmpty checks the scanned paths with the scan (if not nil), and the rest of the paths (such as the manifest)
+// with compression.AreAllPathsEmpty
+func allPathsEmpty(paths []string, scan *compression.Scan) bool {
+	if scan == nil {
+		return compression.AreAllPathsEmpty(paths)
+	}
+
+	var unscanned []string
+	for _, path := range paths {
+		if _, ok := scan.Files(path); !ok {
+			unscanned = append(unscanned, path)
+		}
+	}
+	return scan.IsEmpty() && compression.AreAllPathsEmpty(unscanned)
+}
+
+// writeManifest lists the files of the scan (or the paths without the excluded files if the scan is nil),
+// the same files as the archive
+func (s *saver) writeManifest(key string, paths []string, scan *compression.Scan, excludePatterns []string) (string, error) {
+	manifest, err := newManifest(paths, scan, excludePatterns)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := s.pathModifier.AbsPath(manifestPath(key))
+	if err != nil {
+		return "", err
+	}
+	if err := manifest.Write(path); err != nil {
+		return "", err
+	}
+	s.logger.Donef("Manifest created with %d files", len(manifest.Files))
+	s.logger.Debugf("Manifest path: %s", path)
+
+	return path, nil
+}
+
+// uploadErrorHint returns what the user can do about a typed upload error, or an empty string
+func uploadErrorHint(err error) string {
+	switch {
+	case errors.Is(err, network.ErrUnauthorized):
+		return "The cache service rejected the credentials of the build, check that the workspace has access to the cache"
+	case errors.Is(err, network.ErrQuotaExceeded):
+		return "The cache quota of the workspace is exceeded, reduce the cached paths or delete unused cache entries"
+	case errors.Is(err, network.ErrArchiveTooLarge):
+		return "The cache archive is too large, reduce the cached paths or exclude large files (see ExcludePaths and .cacheignore)"
+	case errors.Is(err, network.ErrConflict):
+		return "The key was saved by another build in the meantime, see the ConflictPolicy of the save"
+	case errors.Is(err, network.ErrServiceUnavailable):
+		return "The cache service is temporarily unavailable, the cache will be saved by a later build"
+	case errors.Is(err, network.ErrArchiveUnreadable):
+		return "The archive was uploaded, but the storage can't serve it, the next save of the key will replace it"
+	}
+	return ""
+}
+
+func (s *saver) upload(ctx context.Context, archivePath string, archiveSize int64, archiveChecksum string, config saveCacheConfig) (bool, error) {
 	params := network.UploadParams{
 		APIBaseURL:      string(config.APIBaseURL),
 		Token:           string(config.APIAccessToken),
@@ -293,6 +656,9 @@ func (s *saver) upload(archivePath string, archiveSize int64, archiveChecksum st
 		ArchiveChecksum: archiveChecksum,
 		ArchiveSize:     archiveSize,
 		CacheKey:        config.Key,
+		Reporter:        config.Reporter,
+		VerifyPublish:   config.Verbose,
+		MaxArchiveSize:  config.MaxArchiveSize,
 	}
-	return s.uploader.Upload(context.Background(), params, s.logger)
+	return s.uploadWithConflictCheck(ctx, params, config)
 }
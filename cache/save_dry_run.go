@@ -0,0 +1,42 @@
+package cache
+
+import "fmt"
+
+// PathSize is the size of a cached path, the total size of the files in case of a directory
+type PathSize struct {
+	Path string
+	Size int64
+}
+
+// dryRun reports what a save would do without creating and uploading the archive
+func (s *saver) dryRun(input SaveCacheInput, config saveCacheConfig) (SaveResult, error) {
+	result := SaveResult{Key: config.Key, DryRun: true}
+
+	s.logger.Println()
+	s.logger.Infof("Dry run, the cache is not saved")
+
+	canSkipSave, reason := s.canSkipSave(config.KeyScopePrefix+input.Key, config.Key, input.IsKeyUnique)
+	result.Skipped, result.SkipReason = canSkipSave, reason.String()
+	if canSkipSave {
+		s.logger.Donef("Cache save would be skipped, reason: %s", reason.description())
+	} else {
+		s.logger.Printf("Cache save would not be skipped, reason: %s", reason.description())
+		s.logger.Printf("The upload can still be skipped if the new archive is the same as the restored one")
+	}
+
+	s.logger.Println()
+	breakdowns, err := s.sizeBreakdown(config.Paths, nil, config.SizeBreakdownTopN, config.LargePathThreshold)
+	if err != nil {
+		return result, fmt.Errorf("failed to calculate the size of the cached paths: %w", err)
+	}
+	for _, breakdown := range breakdowns {
+		result.PathSizes = append(result.PathSizes, PathSize{Path: breakdown.Path, Size: breakdown.Size})
+		result.TotalSize += breakdown.Size
+	}
+
+	if result.TotalSize == 0 {
+		s.logger.Warnf("The provided paths are all empty, nothing would be cached")
+	}
+
+	return result, nil
+}
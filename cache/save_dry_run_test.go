@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaver_DryRun(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "cache", "nested"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cache", "a.txt"), []byte("12345"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cache", "nested", "b.txt"), []byte("123"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "single.txt"), []byte("12"), 0644))
+
+	envRepo := fakeEnvRepo{envVars: map[string]string{
+		"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+		"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+		"BITRISE_GIT_COMMIT":                      "8d722f4cc4e70373bd0b42139fa428d43e0527f0",
+	}}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), nil)
+
+	// When
+	result, err := s.SaveWithResult(SaveCacheInput{
+		Key:    "test-key-{{ .CommitHash }}",
+		Paths:  []string{filepath.Join(dir, "cache"), filepath.Join(dir, "single.txt")},
+		DryRun: true,
+	})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, SaveResult{
+		Key:        "test-key-8d722f4cc4e70373bd0b42139fa428d43e0527f0",
+		Skipped:    false,
+		SkipReason: reasonNoRestore.String(),
+		DryRun:     true,
+		PathSizes: []PathSize{
+			{Path: filepath.Join(dir, "cache"), Size: 8},
+			{Path: filepath.Join(dir, "single.txt"), Size: 2},
+		},
+		TotalSize: 10,
+	}, result)
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const (
+	branchEnvVar      = "BITRISE_GIT_BRANCH"
+	workflowEnvVar    = "BITRISE_TRIGGERED_WORKFLOW_ID"
+	pullRequestEnvVar = "BITRISE_PULL_REQUEST"
+)
+
+// skipBySavePolicy evaluates SkipOnPR, SaveOnBranches and SaveOnWorkflows against the env of the build
+func (s *saver) skipBySavePolicy(input SaveCacheInput) (bool, skipReason, error) {
+	for _, pattern := range input.SaveOnBranches {
+		if !doublestar.ValidatePattern(pattern) {
+			return false, 0, fmt.Errorf("invalid branch pattern: %s", pattern)
+		}
+	}
+
+	if input.SkipOnPR && s.envRepo.Get(pullRequestEnvVar) != "" {
+		return true, reasonPullRequest, nil
+	}
+
+	if len(input.SaveOnBranches) > 0 {
+		branch := s.envRepo.Get(branchEnvVar)
+		if !matchesAnyBranch(branch, input.SaveOnBranches) {
+			s.logger.Printf("Branch (%s) doesn't match the branches caches are saved on: %v", branch, input.SaveOnBranches)
+			return true, reasonBranchNotSaved, nil
+		}
+	}
+
+	if len(input.SaveOnWorkflows) > 0 {
+		workflow := s.envRepo.Get(workflowEnvVar)
+		if !containsString(input.SaveOnWorkflows, workflow) {
+			s.logger.Printf("Workflow (%s) is not one of the workflows caches are saved in: %v", workflow, input.SaveOnWorkflows)
+			return true, reasonWorkflowNotSaved, nil
+		}
+	}
+
+	return false, 0, nil
+}
+
+func matchesAnyBranch(branch string, patterns []string) bool {
+	if branch == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		// The patterns are validated, Match can't fail
+		if match, _ := doublestar.Match(pattern, branch); match {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaver_SavePolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		envVars    map[string]string
+		input      SaveCacheInput
+		wantReason string
+	}{
+		{
+			name:       "pull request",
+			envVars:    map[string]string{branchEnvVar: "main", pullRequestEnvVar: "42"},
+			input:      SaveCacheInput{SaveOnBranches: []string{"main"}, SkipOnPR: true},
+			wantReason: "pull_request",
+		},
+		{
+			name:       "feature branch",
+			envVars:    map[string]string{branchEnvVar: "feature/cache"},
+			input:      SaveCacheInput{SaveOnBranches: []string{"main", "release/**"}},
+			wantReason: "branch_not_saved",
+		},
+		{
+			name:       "unknown branch",
+			envVars:    map[string]string{},
+			input:      SaveCacheInput{SaveOnBranches: []string{"main"}},
+			wantReason: "branch_not_saved",
+		},
+		{
+			name:       "other workflow",
+			envVars:    map[string]string{branchEnvVar: "release/1.2", workflowEnvVar: "deploy"},
+			input:      SaveCacheInput{SaveOnBranches: []string{"main", "release/**"}, SaveOnWorkflows: []string{"primary"}},
+			wantReason: "workflow_not_saved",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			envRepo := fakeEnvRepo{envVars: tt.envVars}
+			s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), nil)
+			tt.input.Key = "my-key"
+			tt.input.Paths = []string{"/dev/null"}
+
+			// When
+			result, err := s.SaveWithResult(tt.input)
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, SaveResult{Skipped: true, SkipReason: tt.wantReason}, result)
+		})
+	}
+}
+
+func TestSaver_skipBySavePolicy(t *testing.T) {
+	// Given
+	envRepo := fakeEnvRepo{envVars: map[string]string{branchEnvVar: "release/1.2", workflowEnvVar: "primary", pullRequestEnvVar: "42"}}
+	s := &saver{envRepo: envRepo, logger: log.NewLogger()}
+
+	// When
+	skip, _, err := s.skipBySavePolicy(SaveCacheInput{SaveOnBranches: []string{"main", "release/**"}, SaveOnWorkflows: []string{"primary"}})
+
+	// Then
+	require.NoError(t, err)
+	require.False(t, skip, "pull requests are saved without SkipOnPR")
+
+	_, _, err = s.skipBySavePolicy(SaveCacheInput{SaveOnBranches: []string{"release/[1"}})
+	require.EqualError(t, err, "invalid branch pattern: release/[1")
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/analytics"
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/docker/go-units"
+)
+
+// TooLargeArchiveAction is what the save does when the archive exceeds SaveCacheInput.MaxArchiveSize
+type TooLargeArchiveAction int
+
+const (
+	// TooLargeArchiveFail fails the save with network.ErrArchiveTooLarge
+	TooLargeArchiveFail TooLargeArchiveAction = iota
+	// TooLargeArchiveSkip logs a warning and skips the upload
+	TooLargeArchiveSkip
+)
+
+// tooLargeArchiveTopN is the number of largest entries listed per path when the archive is too large,
+// unless SaveCacheInput.SizeBreakdownTopN is set
+const tooLargeArchiveTopN = 5
+
+// sizeBreakdown calculates and logs the size of the cached paths with their largest entries,
+// and warns about the paths dominating the cached content (if largePathThreshold is set).
+// The sizes are calculated from the scan if it's not nil, otherwise the paths are walked.
+func (s *saver) sizeBreakdown(paths []string, scan *compression.Scan, topN int, largePathThreshold float64) ([]analytics.Breakdown, error) {
+	var breakdowns []analytics.Breakdown
+	if scan != nil {
+		breakdowns = scanBreakdowns(paths, scan, topN)
+	} else {
+		var err error
+		if breakdowns, err = analytics.SizeBreakdown(paths, topN, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	var total int64
+	s.logger.Printf("Size of cached paths:")
+	for _, breakdown := range breakdowns {
+		total += breakdown.Size
+		s.logger.Printf("- %s (%s)", breakdown.Path, humanSize(breakdown.Size))
+		for _, entry := range breakdown.Largest {
+			s.logger.Printf("  - %s (%s)", entry.Path, humanSize(entry.Size))
+		}
+	}
+	s.logger.Printf("Total size before compression: %s", humanSize(total))
+
+	if largePathThreshold > 0 {
+		for _, entry := range analytics.Dominant(breakdowns, largePathThreshold) {
+			s.logger.Warnf("%s makes up %.0f%% of the cached content (%s), consider excluding it if it's not needed",
+				entry.Path, 100*float64(entry.Size)/float64(total), humanSize(entry.Size))
+		}
+	}
+
+	return breakdowns, nil
+}
+
+// handleTooLargeArchive lists the biggest contributors of the archive, and returns the error of the save
+// (nil if the upload is skipped, see TooLargeArchiveAction)
+func (s *saver) handleTooLargeArchive(size int64, config saveCacheConfig, scan *compression.Scan) error {
+	s.logger.Println()
+	s.logger.Warnf("The archive (%s) exceeds the maximum archive size (%s)", humanSize(size), humanSize(config.MaxArchiveSize))
+	topN := config.SizeBreakdownTopN
+	if topN == 0 {
+		topN = tooLargeArchiveTopN
+	}
+	if _, err := s.sizeBreakdown(config.Paths, scan, topN, 0); err != nil {
+		s.logger.Warnf("Failed to calculate the size of the cached paths: %s", err)
+	}
+	s.logger.Warnf("Exclude the largest entries that are not needed (see ExcludePaths and .cacheignore), or cache fewer paths")
+
+	if config.TooLargeArchive == TooLargeArchiveSkip {
+		s.logger.Warnf("Skipping cache upload, reason: %s", reasonArchiveTooLarge.description())
+		return nil
+	}
+	return fmt.Errorf("%w (size: %d bytes, limit: %d bytes)", network.ErrArchiveTooLarge, size, config.MaxArchiveSize)
+}
+
+// scanBreakdowns works like analytics.SizeBreakdown, but sums the sizes of the scanned files instead of walking the
+// paths again. Excluded files are not counted. Paths missing from the scan have zero size.
+func scanBreakdowns(paths []string, scan *compression.Scan, topN int) []analytics.Breakdown {
+	breakdowns := make([]analytics.Breakdown, 0, len(paths))
+	for _, path := range paths {
+		files, _ := scan.Files(path)
+		breakdown := analytics.Breakdown{Entry: analytics.Entry{Path: path}}
+		if len(files) == 0 {
+			breakdowns = append(breakdowns, breakdown)
+			continue
+		}
+
+		root := files[0]
+		breakdown.IsDir = root.Info.IsDir()
+		if !breakdown.IsDir {
+			breakdown.Size = regularSize(root)
+			breakdowns = append(breakdowns, breakdown)
+			continue
+		}
+
+		// Files are in the order of filepath.Walk, so each direct child comes before its content
+		var children []analytics.Entry
+		childIndex := map[string]int{}
+		for _, file := range files[1:] {
+			rel := strings.TrimPrefix(file.Path, root.Path+string(filepath.Separator))
+			name := rel
+			if i := strings.Index(rel, string(filepath.Separator)); i != -1 {
+				name = rel[:i]
+			}
+			idx, ok := childIndex[name]
+			if !ok {
+				idx = len(children)
+				childIndex[name] = idx
+				children = append(children, analytics.Entry{Path: filepath.Join(root.Path, name), IsDir: file.Info.IsDir()})
+			}
+			children[idx].Size += regularSize(file)
+			breakdown.Size += regularSize(file)
+		}
+
+		sort.SliceStable(children, func(i, j int) bool {
+			return children[i].Size > children[j].Size
+		})
+		if topN < len(children) {
+			children = children[:topN]
+		}
+		if len(children) > 0 {
+			breakdown.Largest = children
+		}
+		breakdowns = append(breakdowns, breakdown)
+	}
+	return breakdowns
+}
+
+func regularSize(file compression.ScannedFile) int64 {
+	if !file.Info.Mode().IsRegular() {
+		return 0
+	}
+	return file.Info.Size()
+}
+
+func humanSize(size int64) string {
+	return units.HumanSizeWithPrecision(float64(size), 3)
+}
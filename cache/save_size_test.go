@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/analytics"
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaver_MaxArchiveSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      TooLargeArchiveAction
+		wantErr     error
+		wantSkipped bool
+	}{
+		{name: "fail", action: TooLargeArchiveFail, wantErr: network.ErrArchiveTooLarge},
+		{name: "skip", action: TooLargeArchiveSkip, wantSkipped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			dir := t.TempDir()
+			// Random content doesn't compress, the archive is larger than the content
+			content := make([]byte, 64*1024)
+			_, err := rand.Read(content)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "large.bin"), content, 0644))
+
+			envRepo := fakeEnvRepo{envVars: map[string]string{
+				"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+				"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+			}}
+			uploader := &fakeUploader{}
+			s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), uploader, WithTracker(NewNoopTracker()))
+
+			// When
+			result, err := s.SaveWithResult(SaveCacheInput{
+				Key:             "test-key",
+				Paths:           []string{dir},
+				MaxArchiveSize:  32 * 1024,
+				TooLargeArchive: tt.action,
+			})
+
+			// Then
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantSkipped, result.Skipped)
+			if tt.wantSkipped {
+				require.Equal(t, "archive_too_large", result.SkipReason)
+			}
+			require.Empty(t, uploader.params.CacheKey, "the archive is not uploaded")
+		})
+	}
+}
+
+func Test_scanBreakdowns(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "large", "nested"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "large", "nested", "file"), make([]byte, 300), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "small"), make([]byte, 100), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "excluded"), make([]byte, 1000), 0644))
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, make([]byte, 50), 0644))
+	paths := []string{dir, file}
+	scan, err := compression.ScanPaths(paths, []string{filepath.Join(dir, "excluded")}, 0)
+	require.NoError(t, err)
+
+	// When
+	breakdowns := scanBreakdowns(paths, scan, 1)
+
+	// Then
+	require.Equal(t, []analytics.Breakdown{
+		{
+			Entry:   analytics.Entry{Path: dir, Size: 400, IsDir: true},
+			Largest: []analytics.Entry{{Path: filepath.Join(dir, "large"), Size: 300, IsDir: true}},
+		},
+		{Entry: analytics.Entry{Path: file, Size: 50}},
+	}, breakdowns)
+}
@@ -14,6 +14,15 @@ const (
 	reasonNoRestoreThisKey
 	reasonNewArchiveChecksumMatch
 	reasonNewArchiveChecksumMismatch
+	reasonNotEnoughBuildTime
+	reasonCacheDisabled
+	reasonLowDiskSpace
+	reasonCacheServiceUnavailable
+	reasonConflict
+	reasonPullRequest
+	reasonBranchNotSaved
+	reasonWorkflowNotSaved
+	reasonArchiveTooLarge
 )
 
 func (r skipReason) String() string {
@@ -32,6 +41,24 @@ func (r skipReason) String() string {
 		return "new_archive_checksum_match"
 	case reasonNewArchiveChecksumMismatch:
 		return "new_archive_checksum_mismatch"
+	case reasonNotEnoughBuildTime:
+		return "not_enough_build_time"
+	case reasonCacheDisabled:
+		return "cache_disabled"
+	case reasonLowDiskSpace:
+		return "low_disk_space"
+	case reasonCacheServiceUnavailable:
+		return "cache_service_unavailable"
+	case reasonConflict:
+		return "conflict"
+	case reasonPullRequest:
+		return "pull_request"
+	case reasonBranchNotSaved:
+		return "branch_not_saved"
+	case reasonWorkflowNotSaved:
+		return "workflow_not_saved"
+	case reasonArchiveTooLarge:
+		return "archive_too_large"
 	default:
 		return "unknown"
 	}
@@ -53,6 +80,24 @@ func (r skipReason) description() string {
 		return "new cache archive is the same as the restored one"
 	case reasonNewArchiveChecksumMismatch:
 		return "new cache archive contains changed files"
+	case reasonNotEnoughBuildTime:
+		return "the build is about to time out, there is not enough time left to save the cache"
+	case reasonCacheDisabled:
+		return "caching is disabled by " + cacheDisableEnvVar
+	case reasonLowDiskSpace:
+		return "there is likely not enough free disk space for creating the archive"
+	case reasonCacheServiceUnavailable:
+		return "the cache service failed repeatedly in this build, caching is temporarily skipped"
+	case reasonConflict:
+		return "the key was saved by another build since the restore, keeping its archive"
+	case reasonPullRequest:
+		return "caches are not saved in pull request builds"
+	case reasonBranchNotSaved:
+		return "caches are not saved on this branch"
+	case reasonWorkflowNotSaved:
+		return "caches are not saved in this workflow"
+	case reasonArchiveTooLarge:
+		return "the archive exceeds the maximum archive size"
 	default:
 		return "unrecognized skipReason"
 	}
@@ -68,7 +113,7 @@ func (s *saver) canSkipSave(keyTemplate, evaluatedKey string, isKeyUnique bool)
 		return false, reasonNoRestore
 	}
 
-	if _, ok := cacheHits[evaluatedKey]; ok {
+	if _, ok := cacheHits[cacheHitKey(evaluatedKey)]; ok {
 		if isKeyUnique {
 			return true, reasonRestoreSameUniqueKey
 		} else {
@@ -86,7 +131,7 @@ func (s *saver) canSkipUpload(newCacheKey, newCacheChecksum string) (bool, skipR
 		return false, reasonNoRestore
 	}
 
-	checksumForNewKey, ok := cacheHits[newCacheKey]
+	checksumForNewKey, ok := cacheHits[cacheHitKey(newCacheKey)]
 	if !ok {
 		return false, reasonNoRestoreThisKey
 	}
@@ -98,7 +143,7 @@ func (s *saver) canSkipUpload(newCacheKey, newCacheChecksum string) (bool, skipR
 }
 
 // Returns cache hit information exposed by previous restore cache steps.
-// The returned map's key is the restored cache key, and the value is the checksum of the cache archive
+// The returned map's key is the restored cache key (see cacheHitKey), and the value is the checksum of the cache archive
 func (s *saver) getCacheHits() map[string]string {
 	cacheHits := map[string]string{}
 	for _, e := range s.envRepo.List() {
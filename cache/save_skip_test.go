@@ -156,6 +156,18 @@ func Test_canSkipUpload(t *testing.T) {
 			want:       false,
 			wantReason: reasonNewArchiveChecksumMismatch,
 		},
+		{
+			name: "Cache hit on same key with whitespace, checksum matches",
+			envs: map[string]string{
+				"BITRISE_CACHE_HIT__my_key_8d722f4cc4e70373bd0b42139fa428d43e0527f0": "9a30a503b2862c51c3c5acd7fbce2f1f784cf4658ccf8e87d5023a90c21c0714",
+			},
+			args: args{
+				newCacheKey:      "my key 8d722f4cc4e70373bd0b42139fa428d43e0527f0",
+				newCacheChecksum: "9a30a503b2862c51c3c5acd7fbce2f1f784cf4658ccf8e87d5023a90c21c0714",
+			},
+			want:       true,
+			wantReason: reasonNewArchiveChecksumMatch,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1,7 +1,6 @@
 package cache
 
 import (
-	"io/fs"
 	"time"
 
 	"github.com/bitrise-io/go-utils/v2/analytics"
@@ -9,12 +8,51 @@ import (
 	"github.com/bitrise-io/go-utils/v2/log"
 )
 
+// Tracker receives the analytics events of cache saves and restores.
+// The default implementation sends them to Bitrise (see NewDefaultTracker), use WithTracker to route them elsewhere
+// (for example on self-hosted runners), or NewNoopTracker to disable them.
+type Tracker interface {
+	LogArchiveCompressed(compressionTime time.Duration, pathCount int)
+	LogArchiveUploaded(uploadTime time.Duration, archiveSize int64, pathCount int)
+	LogArchiveDownloaded(downloadTime time.Duration, archiveSize int64, keyCount int)
+	LogArchiveExtracted(extractionTime time.Duration, keyCount int)
+	LogRestoreResult(isMatch bool, matchedKey string, evaluatedKeys []string)
+	// LogSkipSaveResult and LogSkipUploadResult receive the reason of the decision, such as `restore_same_unique_key`
+	LogSkipSaveResult(isSaveSkipped bool, reason string)
+	LogSkipUploadResult(isUploadSkipped bool, reason string)
+	// Wait is called at the end of each save and restore, it should return when the events are sent
+	Wait()
+}
+
+// Option configures a saver or a restorer
+type Option func(*options)
+
+type options struct {
+	tracker Tracker
+}
+
+// WithTracker sends the analytics events to tracker instead of the default tracker
+func WithTracker(tracker Tracker) Option {
+	return func(o *options) {
+		o.tracker = tracker
+	}
+}
+
+func applyOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 type stepTracker struct {
 	tracker analytics.Tracker
 	logger  log.Logger
 }
 
-func newStepTracker(stepId string, envRepo env.Repository, logger log.Logger) stepTracker {
+// NewDefaultTracker returns the tracker sending the events to Bitrise, with the step ID and build properties
+func NewDefaultTracker(stepId string, envRepo env.Repository, logger log.Logger) Tracker {
 	p := analytics.Properties{
 		"step_id":     stepId,
 		"build_slug":  envRepo.Get("BITRISE_BUILD_SLUG"),
@@ -22,22 +60,24 @@ func newStepTracker(stepId string, envRepo env.Repository, logger log.Logger) st
 		"workflow":    envRepo.Get("BITRISE_TRIGGERED_WORKFLOW_ID"),
 		"is_pr_build": envRepo.Get("PR") == "true",
 	}
-	return stepTracker{
+	return &stepTracker{
 		tracker: analytics.NewDefaultTracker(logger, p),
 		logger:  logger,
 	}
 }
 
-func (t *stepTracker) logArchiveUploaded(uploadTime time.Duration, info fs.FileInfo, pathCount int) {
+// LogArchiveUploaded ...
+func (t *stepTracker) LogArchiveUploaded(uploadTime time.Duration, archiveSize int64, pathCount int) {
 	properties := analytics.Properties{
 		"upload_time_s":     uploadTime.Truncate(time.Second).Seconds(),
-		"upload_size_bytes": info.Size(),
+		"upload_size_bytes": archiveSize,
 		"path_count":        pathCount,
 	}
 	t.tracker.Enqueue("step_save_cache_archive_uploaded", properties)
 }
 
-func (t *stepTracker) logArchiveCompressed(compressionTime time.Duration, pathCount int) {
+// LogArchiveCompressed ...
+func (t *stepTracker) LogArchiveCompressed(compressionTime time.Duration, pathCount int) {
 	properties := analytics.Properties{
 		"compression_time_s": compressionTime.Truncate(time.Second).Seconds(),
 		"path_count":         pathCount,
@@ -45,16 +85,18 @@ func (t *stepTracker) logArchiveCompressed(compressionTime time.Duration, pathCo
 	t.tracker.Enqueue("step_save_cache_archive_compressed", properties)
 }
 
-func (t *stepTracker) logArchiveDownloaded(downloadTime time.Duration, info fs.FileInfo, keyCount int) {
+// LogArchiveDownloaded ...
+func (t *stepTracker) LogArchiveDownloaded(downloadTime time.Duration, archiveSize int64, keyCount int) {
 	properties := analytics.Properties{
 		"download_time_s":     downloadTime.Truncate(time.Second).Seconds(),
-		"download_size_bytes": info.Size(),
+		"download_size_bytes": archiveSize,
 		"key_count":           keyCount,
 	}
 	t.tracker.Enqueue("step_restore_cache_archive_downloaded", properties)
 }
 
-func (t *stepTracker) logArchiveExtracted(extractionTime time.Duration, keyCount int) {
+// LogArchiveExtracted ...
+func (t *stepTracker) LogArchiveExtracted(extractionTime time.Duration, keyCount int) {
 	properties := analytics.Properties{
 		"extraction_time_s": extractionTime.Truncate(time.Second).Seconds(),
 		"key_count":         keyCount,
@@ -62,7 +104,8 @@ func (t *stepTracker) logArchiveExtracted(extractionTime time.Duration, keyCount
 	t.tracker.Enqueue("step_restore_cache_archive_extracted", properties)
 }
 
-func (t *stepTracker) logRestoreResult(isMatch bool, matchedKey string, evaluatedKeys []string) {
+// LogRestoreResult ...
+func (t *stepTracker) LogRestoreResult(isMatch bool, matchedKey string, evaluatedKeys []string) {
 	if len(evaluatedKeys) == 0 {
 		return
 	}
@@ -75,24 +118,43 @@ func (t *stepTracker) logRestoreResult(isMatch bool, matchedKey string, evaluate
 	t.tracker.Enqueue("step_restore_cache_result", properties)
 }
 
-func (t *stepTracker) logSkipSaveResult(isSaveSkipped bool, reason skipReason) {
+// LogSkipSaveResult ...
+func (t *stepTracker) LogSkipSaveResult(isSaveSkipped bool, reason string) {
 
 	properties := analytics.Properties{
 		"is_save_skipped": isSaveSkipped,
-		"reason":          reason.String(),
+		"reason":          reason,
 	}
 	t.tracker.Enqueue("step_save_cache_save_skipped", properties)
 }
 
-func (t *stepTracker) logSkipUploadResult(isUploadSkipped bool, reason skipReason) {
+// LogSkipUploadResult ...
+func (t *stepTracker) LogSkipUploadResult(isUploadSkipped bool, reason string) {
 
 	properties := analytics.Properties{
 		"is_upload_skipped": isUploadSkipped,
-		"reason":            reason.String(),
+		"reason":            reason,
 	}
 	t.tracker.Enqueue("step_save_cache_upload_skipped", properties)
 }
 
-func (t *stepTracker) wait() {
+// Wait ...
+func (t *stepTracker) Wait() {
 	t.tracker.Wait()
 }
+
+type noopTracker struct{}
+
+// NewNoopTracker returns a tracker that drops all events, it disables cache analytics
+func NewNoopTracker() Tracker {
+	return noopTracker{}
+}
+
+func (noopTracker) LogArchiveCompressed(time.Duration, int)        {}
+func (noopTracker) LogArchiveUploaded(time.Duration, int64, int)   {}
+func (noopTracker) LogArchiveDownloaded(time.Duration, int64, int) {}
+func (noopTracker) LogArchiveExtracted(time.Duration, int)         {}
+func (noopTracker) LogRestoreResult(bool, string, []string)        {}
+func (noopTracker) LogSkipSaveResult(bool, string)                 {}
+func (noopTracker) LogSkipUploadResult(bool, string)               {}
+func (noopTracker) Wait()                                          {}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingTracker struct {
+	noopTracker
+	events []string
+	waited bool
+}
+
+func (t *recordingTracker) LogArchiveCompressed(time.Duration, int) {
+	t.events = append(t.events, "compressed")
+}
+
+func (t *recordingTracker) LogArchiveUploaded(_ time.Duration, archiveSize int64, _ int) {
+	if archiveSize > 0 {
+		t.events = append(t.events, "uploaded")
+	}
+}
+
+func (t *recordingTracker) LogSkipSaveResult(isSaveSkipped bool, reason string) {
+	t.events = append(t.events, "save skipped: "+reason)
+}
+
+func (t *recordingTracker) LogSkipUploadResult(isUploadSkipped bool, reason string) {
+	t.events = append(t.events, "upload skipped: "+reason)
+}
+
+func (t *recordingTracker) Wait() {
+	t.waited = true
+}
+
+type fakeUploader struct {
+	params network.UploadParams
+}
+
+func (u *fakeUploader) Upload(_ context.Context, params network.UploadParams, _ log.Logger) error {
+	u.params = params
+	return nil
+}
+
+func TestSaver_WithTracker(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "cached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("cached content"), 0644))
+
+	envRepo := fakeEnvRepo{envVars: map[string]string{
+		"BITRISEIO_ABCS_API_URL":                  "fake service URL",
+		"BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN": "fake access token",
+	}}
+	tracker := &recordingTracker{}
+	uploader := &fakeUploader{}
+	s := NewSaver(envRepo, log.NewLogger(), pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), uploader, WithTracker(tracker))
+
+	// When
+	_, err := s.SaveWithResult(SaveCacheInput{Key: "test-key", Paths: []string{path}})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "test-key", uploader.params.CacheKey)
+	require.Equal(t, []string{
+		"save skipped: " + reasonKeyNotDynamic.String(),
+		"compressed",
+		"upload skipped: " + reasonNoRestore.String(),
+		"uploaded",
+	}, tracker.events)
+	require.True(t, tracker.waited)
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+const (
+	deployDirEnvVar = "BITRISE_DEPLOY_DIR"
+	// UsageReportFileName is the file in BITRISE_DEPLOY_DIR collecting the cache events of the build, one JSON object per line
+	UsageReportFileName = "cache-usage.jsonl"
+)
+
+// UsageOperation is the type of a cache operation in the usage report
+type UsageOperation string
+
+// Cache operations of the usage report
+const (
+	UsageOperationSave    UsageOperation = "save"
+	UsageOperationRestore UsageOperation = "restore"
+)
+
+// UsageEvent is a cache save or restore of a step, as recorded in the usage report of the build
+type UsageEvent struct {
+	StepID    string         `json:"step_id"`
+	Operation UsageOperation `json:"operation"`
+	Key       string         `json:"key"`
+	// Hit is the cache hit type of a restore, see CacheHit
+	Hit CacheHit `json:"hit,omitempty"`
+	// Skipped and SkipReason are set for saves, see SaveResult
+	Skipped     bool          `json:"skipped,omitempty"`
+	SkipReason  string        `json:"skip_reason,omitempty"`
+	ArchiveSize int64         `json:"archive_size"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// NewSaveUsageEvent returns the usage event of a cache save
+func NewSaveUsageEvent(stepID string, result SaveResult) UsageEvent {
+	return UsageEvent{
+		StepID:      stepID,
+		Operation:   UsageOperationSave,
+		Key:         result.Key,
+		Skipped:     result.Skipped,
+		SkipReason:  result.SkipReason,
+		ArchiveSize: result.ArchiveSize,
+		Duration:    result.CompressionTime + result.UploadTime,
+	}
+}
+
+// NewRestoreUsageEvent returns the usage event of a cache restore, key is the first key of the restore
+func NewRestoreUsageEvent(stepID, key string, result RestoreResult) UsageEvent {
+	duration := result.DownloadTime + result.ExtractionTime
+	if result.DownloadTime == result.ExtractionTime {
+		// The archive was extracted while downloading
+		duration = result.DownloadTime
+	}
+	if result.MatchedKey != "" {
+		key = result.MatchedKey
+	}
+	return UsageEvent{
+		StepID:      stepID,
+		Operation:   UsageOperationRestore,
+		Key:         key,
+		Hit:         result.Hit,
+		ArchiveSize: result.ArchiveSize,
+		Duration:    duration,
+	}
+}
+
+// RecordUsage appends the event to the usage report of the build (UsageReportFileName in BITRISE_DEPLOY_DIR),
+// see RenderUsageReport
+func RecordUsage(envRepo env.Repository, event UsageEvent) error {
+	path, err := usageReportPath(envRepo)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open cache usage report: %w", err)
+	}
+	// A single write, so that concurrent steps don't interleave their events
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write cache usage report: %w", err)
+	}
+	return file.Close()
+}
+
+// ReadUsage returns the events recorded in the build so far
+func ReadUsage(envRepo env.Repository) ([]UsageEvent, error) {
+	path, err := usageReportPath(envRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	var events []UsageEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var event UsageEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("invalid cache usage event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// RenderUsageReport renders the cache events of the build as a markdown report, for example for the build summary
+func RenderUsageReport(envRepo env.Repository) (string, error) {
+	events, err := ReadUsage(envRepo)
+	if err != nil {
+		return "", err
+	}
+	return renderUsageReport(events), nil
+}
+
+func renderUsageReport(events []UsageEvent) string {
+	var b strings.Builder
+	b.WriteString("## Cache usage\n\n")
+	if len(events) == 0 {
+		b.WriteString("No cache operations in this build.\n")
+		return b.String()
+	}
+
+	var hits, restores, uploads int
+	var downloaded, uploaded int64
+	var totalDuration time.Duration
+	b.WriteString("| Step | Operation | Key | Result | Archive size | Duration |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+	for _, event := range events {
+		result := ""
+		switch event.Operation {
+		case UsageOperationRestore:
+			restores++
+			result = "miss"
+			if event.Hit != CacheHitNone && event.Hit != "" {
+				hits++
+				downloaded += event.ArchiveSize
+				result = string(event.Hit) + " hit"
+			}
+		case UsageOperationSave:
+			result = "uploaded"
+			if event.Skipped {
+				result = "skipped (" + event.SkipReason + ")"
+			} else {
+				uploads++
+				uploaded += event.ArchiveSize
+			}
+		}
+		totalDuration += event.Duration
+		fmt.Fprintf(&b, "| %s | %s | `%s` | %s | %s | %s |\n",
+			event.StepID, event.Operation, event.Key, result, humanSize(event.ArchiveSize), event.Duration.Round(time.Second))
+	}
+
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "- Restores: %d, hits: %d, downloaded %s\n", restores, hits, humanSize(downloaded))
+	fmt.Fprintf(&b, "- Uploads: %d, uploaded %s\n", uploads, humanSize(uploaded))
+	fmt.Fprintf(&b, "- Total time spent on caching: %s\n", totalDuration.Round(time.Second))
+	return b.String()
+}
+
+func usageReportPath(envRepo env.Repository) (string, error) {
+	deployDir := envRepo.Get(deployDirEnvVar)
+	if deployDir == "" {
+		return "", fmt.Errorf("%s is not set", deployDirEnvVar)
+	}
+	return filepath.Join(deployDir, UsageReportFileName), nil
+}
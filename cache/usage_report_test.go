@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordUsage(t *testing.T) {
+	// Given
+	envRepo := fakeEnvRepo{envVars: map[string]string{deployDirEnvVar: t.TempDir()}}
+	restoreEvent := NewRestoreUsageEvent("restore-gradle-cache", "gradle-{{ .Branch }}", RestoreResult{
+		Hit:            CacheHitPartial,
+		MatchedKey:     "gradle-main",
+		ArchiveSize:    2000,
+		DownloadTime:   3 * time.Second,
+		ExtractionTime: 2 * time.Second,
+	})
+	saveEvent := NewSaveUsageEvent("save-gradle-cache", SaveResult{
+		Key:             "gradle-feature",
+		SkipReason:      reasonNoRestoreThisKey.String(),
+		ArchiveSize:     3000,
+		CompressionTime: 4 * time.Second,
+		UploadTime:      6 * time.Second,
+	})
+
+	// When
+	require.NoError(t, RecordUsage(envRepo, restoreEvent))
+	require.NoError(t, RecordUsage(envRepo, saveEvent))
+	events, err := ReadUsage(envRepo)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []UsageEvent{restoreEvent, saveEvent}, events)
+
+	report, err := RenderUsageReport(envRepo)
+	require.NoError(t, err)
+	require.Equal(t, "## Cache usage\n\n"+
+		"| Step | Operation | Key | Result | Archive size | Duration |\n"+
+		"|---|---|---|---|---|---|\n"+
+		"| restore-gradle-cache | restore | `gradle-main` | partial hit | 2kB | 5s |\n"+
+		"| save-gradle-cache | save | `gradle-feature` | uploaded | 3kB | 10s |\n"+
+		"\n"+
+		"- Restores: 1, hits: 1, downloaded 2kB\n"+
+		"- Uploads: 1, uploaded 3kB\n"+
+		"- Total time spent on caching: 15s\n", report)
+}
+
+func TestRenderUsageReport_WhenNoEvents(t *testing.T) {
+	envRepo := fakeEnvRepo{envVars: map[string]string{deployDirEnvVar: t.TempDir()}}
+
+	report, err := RenderUsageReport(envRepo)
+
+	require.NoError(t, err)
+	require.Equal(t, "## Cache usage\n\nNo cache operations in this build.\n", report)
+}
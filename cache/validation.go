@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// ErrRestoreValidationFailed means that the restored cache content didn't pass one of the RestoreCacheInput.Validators,
+// so it might be stale or mismatched. Steps can fall back to a clean state in this case.
+var ErrRestoreValidationFailed = errors.New("restored cache content is invalid")
+
+// RestoreValidator checks the restored cache content after extraction. restoredPaths are the root paths extracted
+// from the archive (or the include paths in case of a partial restore).
+// Returning an error means that the cache content is unusable.
+type RestoreValidator func(restoredPaths []string) error
+
+// NonEmptyDirValidator checks that the directory exists and it's not empty
+func NonEmptyDirValidator(dir string) RestoreValidator {
+	return func([]string) error {
+		absDir, err := pathutil.NewPathModifier().AbsPath(dir)
+		if err != nil {
+			return err
+		}
+
+		d, err := os.Open(absDir)
+		if err != nil {
+			return fmt.Errorf("directory doesn't exist: %s", absDir)
+		}
+		defer d.Close() //nolint:errcheck
+
+		if _, err := d.Readdirnames(1); err == io.EOF {
+			return fmt.Errorf("directory is empty: %s", absDir)
+		} else if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", absDir, err)
+		}
+		return nil
+	}
+}
+
+// MarkerFileValidator checks that the marker file (such as a lock or completion file written by the build tool) exists
+func MarkerFileValidator(path string) RestoreValidator {
+	return func([]string) error {
+		absPath, err := pathutil.NewPathModifier().AbsPath(path)
+		if err != nil {
+			return err
+		}
+
+		exists, err := pathutil.NewPathChecker().IsPathExists(absPath)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("marker file doesn't exist: %s", absPath)
+		}
+		return nil
+	}
+}
+
+// VersionFileValidator checks that the content of the version file (ignoring surrounding whitespace) is the expected
+// version, for example to detect a cache created with a different version of the build tool
+func VersionFileValidator(path, expectedVersion string) RestoreValidator {
+	return func([]string) error {
+		absPath, err := pathutil.NewPathModifier().AbsPath(path)
+		if err != nil {
+			return err
+		}
+
+		content, err := os.ReadFile(absPath)
+		if err != nil {
+			return fmt.Errorf("failed to read version file: %w", err)
+		}
+		if version := strings.TrimSpace(string(content)); version != strings.TrimSpace(expectedVersion) {
+			return fmt.Errorf("version mismatch in %s: expected %s, got %s", absPath, expectedVersion, version)
+		}
+		return nil
+	}
+}
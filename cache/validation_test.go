@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreValidators(t *testing.T) {
+	dir := t.TempDir()
+	emptyDir := filepath.Join(dir, "empty")
+	require.NoError(t, os.Mkdir(emptyDir, 0700))
+	versionFile := filepath.Join(dir, "version.txt")
+	require.NoError(t, os.WriteFile(versionFile, []byte("7.6\n"), 0600))
+
+	tests := []struct {
+		name      string
+		validator RestoreValidator
+		wantErr   bool
+	}{
+		{name: "Non-empty dir", validator: NonEmptyDirValidator(dir)},
+		{name: "Empty dir", validator: NonEmptyDirValidator(emptyDir), wantErr: true},
+		{name: "Missing dir", validator: NonEmptyDirValidator(filepath.Join(dir, "missing")), wantErr: true},
+		{name: "Marker file exists", validator: MarkerFileValidator(versionFile)},
+		{name: "Marker file is missing", validator: MarkerFileValidator(filepath.Join(dir, "missing.lock")), wantErr: true},
+		{name: "Version matches", validator: VersionFileValidator(versionFile, "7.6")},
+		{name: "Version mismatch", validator: VersionFileValidator(versionFile, "8.0"), wantErr: true},
+		{name: "Version file is missing", validator: VersionFileValidator(filepath.Join(dir, "missing.txt"), "7.6"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validator(nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_validate(t *testing.T) {
+	// Given
+	sourceDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "file.txt"), []byte("hello"), 0600))
+
+	logger := log.NewLogger()
+	archiver := compression.NewArchiver(logger, env.NewRepository(), &compression.ArchiveDependencyCheckerMock{})
+	archivePath := filepath.Join(t.TempDir(), "cache.tzst")
+	require.NoError(t, archiver.Compress(archivePath, []string{sourceDir}, 3, nil))
+
+	r := &restorer{logger: logger}
+	var gotPaths []string
+	recordPaths := func(restoredPaths []string) error {
+		gotPaths = restoredPaths
+		return nil
+	}
+	failing := func([]string) error {
+		return errors.New("stale cache")
+	}
+
+	// When
+	err := r.validate(archiver, archivePath, nil, []RestoreValidator{recordPaths})
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, []string{sourceDir}, gotPaths)
+
+	// When
+	err = r.validate(archiver, archivePath, []string{"/include/path"}, []RestoreValidator{recordPaths, failing})
+
+	// Then
+	assert.ErrorIs(t, err, ErrRestoreValidationFailed)
+	assert.Equal(t, []string{"/include/path"}, gotPaths)
+}
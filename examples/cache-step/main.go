@@ -0,0 +1,94 @@
+// Command cache-step is a minimal cache step built with this module: it parses its inputs with stepconf,
+// saves or restores the cache with the cache package, and exports its outputs with export.
+// Use it as a starting point for new steps.
+//
+// The inputs are env vars, as on Bitrise. To try it out locally, store the archives in a directory instead of
+// the cache API (exporting outputs requires envman):
+//
+//	export BITRISEIO_DEPENDENCY_CACHE_LOCAL_DIR=/tmp/cache-archives
+//	mode=save key='example-{{ .OS }}' paths="$HOME/.gradle/caches" go run ./examples/cache-step
+//	mode=restore key='example-{{ .OS }}' go run ./examples/cache-step
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bitrise-io/go-steputils/v2/cache"
+	"github.com/bitrise-io/go-steputils/v2/export"
+	"github.com/bitrise-io/go-steputils/v2/stepconf"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// skipReasonOutputKey is the output of the save mode, see cache.SaveResult.SkipReason
+const skipReasonOutputKey = "EXAMPLE_CACHE_SKIP_REASON"
+
+type config struct {
+	Mode string `env:"mode,opt[save,restore]"`
+	Key  string `env:"key,required"`
+	// Paths are separated by `|`, only used by the save mode
+	Paths   []string `env:"paths"`
+	Verbose bool     `env:"verbose"`
+}
+
+func main() {
+	logger := log.NewLogger()
+	if err := run(logger); err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+}
+
+func run(logger log.Logger) error {
+	envRepo := env.NewRepository()
+	cmdFactory := command.NewFactory(envRepo)
+
+	var cfg config
+	if err := stepconf.NewInputParser(envRepo).Parse(&cfg); err != nil {
+		return err
+	}
+	stepconf.Print(cfg)
+	logger.EnableDebugLog(cfg.Verbose)
+
+	switch cfg.Mode {
+	case "save":
+		return save(cfg, envRepo, cmdFactory, logger)
+	default:
+		return restore(cfg, envRepo, cmdFactory, logger)
+	}
+}
+
+func save(cfg config, envRepo env.Repository, cmdFactory command.Factory, logger log.Logger) error {
+	saver := cache.NewSaver(envRepo, logger, pathutil.NewPathProvider(), pathutil.NewPathModifier(), pathutil.NewPathChecker(), nil)
+	result, err := saver.SaveWithResult(cache.SaveCacheInput{
+		StepId:  "example-cache-step",
+		Verbose: cfg.Verbose,
+		Key:     cfg.Key,
+		Paths:   cfg.Paths,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save cache: %w", err)
+	}
+
+	exporter := export.NewExporter(cmdFactory)
+	return exporter.ExportOutput(skipReasonOutputKey, result.SkipReason)
+}
+
+func restore(cfg config, envRepo env.Repository, cmdFactory command.Factory, logger log.Logger) error {
+	// The restorer exports the cache hit as BITRISE_CACHE_HIT
+	restorer := cache.NewRestorer(envRepo, logger, cmdFactory, nil)
+	result, err := restorer.RestoreWithResult(cache.RestoreCacheInput{
+		StepId:               "example-cache-step",
+		Verbose:              cfg.Verbose,
+		Keys:                 []string{cfg.Key},
+		GenerateFallbackKeys: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore cache: %w", err)
+	}
+	logger.Donef("Cache hit: %s", result.Hit)
+	return nil
+}
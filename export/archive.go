@@ -0,0 +1,137 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveEntry maps a source file or directory to a path inside the ZIP archive, see ExportOutputArchive
+type ArchiveEntry struct {
+	// SourcePath is the file or directory to add to the archive, directories are added recursively
+	SourcePath string
+	// ArchivePath is the relative slash-separated path of the file or directory inside the archive, such as `symbols/app.dSYM`
+	ArchivePath string
+}
+
+// ExportOutputArchive creates a ZIP archive at zipPath from a mix of files and directories, each placed at its
+// ArchivePath inside the archive, and then exports the absolute path of the ZIP with ExportOutput().
+// This allows building structured artifact bundles, which ExportOutputFilesZip can't do.
+func (e *Exporter) ExportOutputArchive(key string, entries []ArchiveEntry, zipPath string) error {
+	if len(entries) == 0 {
+		return fmt.Errorf("archive entry list is empty")
+	}
+	if err := validateArchiveEntries(entries); err != nil {
+		return err
+	}
+
+	tempZipPath, err := zipFilePath()
+	if err != nil {
+		return err
+	}
+	if err := writeArchive(tempZipPath, entries); err != nil {
+		return err
+	}
+
+	return e.ExportOutputFile(key, tempZipPath, zipPath)
+}
+
+func validateArchiveEntries(entries []ArchiveEntry) error {
+	archivePaths := map[string]bool{}
+	for _, entry := range entries {
+		archivePath := path.Clean(entry.ArchivePath)
+		if entry.ArchivePath == "" || path.IsAbs(archivePath) || archivePath == ".." || strings.HasPrefix(archivePath, "../") {
+			return fmt.Errorf("invalid archive path for %s: %q, it should be a relative path inside the archive", entry.SourcePath, entry.ArchivePath)
+		}
+		if archivePaths[archivePath] {
+			return fmt.Errorf("duplicate archive path: %s", archivePath)
+		}
+		archivePaths[archivePath] = true
+	}
+	return nil
+}
+
+func writeArchive(zipPath string, entries []ArchiveEntry) error {
+	file, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	writer := zip.NewWriter(file)
+
+	for _, entry := range entries {
+		if err := addArchiveEntry(writer, entry); err != nil {
+			_ = writer.Close()
+			_ = file.Close()
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func addArchiveEntry(writer *zip.Writer, entry ArchiveEntry) error {
+	root := filepath.Clean(entry.SourcePath)
+	archiveRoot := path.Clean(entry.ArchivePath)
+
+	return filepath.Walk(root, func(sourcePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, sourcePath)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(archiveRoot, filepath.ToSlash(relPath))
+
+		switch {
+		case info.IsDir():
+			header.Name += "/"
+			_, err := writer.CreateHeader(header)
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(sourcePath)
+			if err != nil {
+				return err
+			}
+			w, err := writer.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, target)
+			return err
+		case info.Mode().IsRegular():
+			header.Method = zip.Deflate
+			w, err := writer.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			return copyFileTo(w, sourcePath)
+		default:
+			return nil
+		}
+	})
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close() //nolint:errcheck
+
+	_, err = io.Copy(w, file)
+	return err
+}
@@ -0,0 +1,70 @@
+package export
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportOutputArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	envmanStorePath := setupEnvman(t)
+
+	dsymDir := filepath.Join(tmpDir, "build", "App.app.dSYM")
+	require.NoError(t, os.MkdirAll(filepath.Join(dsymDir, "Contents"), 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dsymDir, "Contents", "Info.plist"), []byte("plist"), 0644))
+	mappingFile := filepath.Join(tmpDir, "mapping.txt")
+	require.NoError(t, ioutil.WriteFile(mappingFile, []byte("mapping"), 0644))
+
+	destinationZip := filepath.Join(tmpDir, "bundle.zip")
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	// When
+	err := e.ExportOutputArchive("EXPORTED_ZIP_PATH", []ArchiveEntry{
+		{SourcePath: dsymDir, ArchivePath: "symbols/App.app.dSYM"},
+		{SourcePath: mappingFile, ArchivePath: "android/mapping.txt"},
+	}, destinationZip)
+
+	// Then
+	require.NoError(t, err)
+	requireEnvmanContainsValueForKey(t, "EXPORTED_ZIP_PATH", destinationZip, envmanStorePath)
+
+	reader, err := zip.OpenReader(destinationZip)
+	require.NoError(t, err)
+	defer reader.Close() //nolint:errcheck
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{
+		"android/mapping.txt",
+		"symbols/App.app.dSYM/",
+		"symbols/App.app.dSYM/Contents/",
+		"symbols/App.app.dSYM/Contents/Info.plist",
+	}, names)
+}
+
+func TestExportOutputArchive_InvalidArchivePath(t *testing.T) {
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+	sourceFile := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, ioutil.WriteFile(sourceFile, []byte("content"), 0644))
+
+	for _, archivePath := range []string{"", "/abs/file.txt", "../file.txt"} {
+		err := e.ExportOutputArchive("KEY", []ArchiveEntry{{SourcePath: sourceFile, ArchivePath: archivePath}}, "out.zip")
+		require.Error(t, err, archivePath)
+	}
+
+	err := e.ExportOutputArchive("KEY", []ArchiveEntry{
+		{SourcePath: sourceFile, ArchivePath: "file.txt"},
+		{SourcePath: sourceFile, ArchivePath: "./file.txt"},
+	}, "out.zip")
+	require.EqualError(t, err, "duplicate archive path: file.txt")
+}
@@ -0,0 +1,200 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// DeployDirEnvKey is the env var of the directory whose files are deployed as the build's artifacts
+const DeployDirEnvKey = "BITRISE_DEPLOY_DIR"
+
+// ArtifactMetadataSuffix is appended to the file name of an exported artifact to get the name of its metadata file
+const ArtifactMetadataSuffix = ".metadata.json"
+
+// standardArtifactOutputs are the env vars subsequent steps (such as deploy steps) look for the artifact types in
+var standardArtifactOutputs = map[string]string{
+	"apk":       "BITRISE_APK_PATH",
+	"aab":       "BITRISE_AAB_PATH",
+	"ipa":       "BITRISE_IPA_PATH",
+	"dsym":      "BITRISE_DSYM_PATH",
+	"xcarchive": "BITRISE_XCARCHIVE_PATH",
+	"app":       "BITRISE_APP_PATH",
+}
+
+// ArtifactOptions configures ExportArtifact
+type ArtifactOptions struct {
+	// Type is the artifact type (such as "apk" or "ipa"), the extension of the file if not set
+	Type string
+	// Notes are shown with the artifact, such as the release notes of a build
+	Notes string
+	// PublicInstallPage enables the public install page of the artifact
+	PublicInstallPage bool
+	// OutputKey is the env var the deploy dir path of the artifact is exported in. If not set, the standard env var
+	// of the type (such as BITRISE_APK_PATH) is used, and types without a standard env var are not exported.
+	OutputKey string
+	// Move moves the file into the deploy dir instead of copying it (the file is kept if it's skipped as a duplicate)
+	Move bool
+	// DeployDir is where the artifact is exported to, BITRISE_DEPLOY_DIR if not set
+	DeployDir string
+	// Duplicates is what happens if the deploy dir already has a file with the same content, see DuplicatePolicy
+	Duplicates DuplicatePolicy
+}
+
+// ArtifactMetadata is the content of the metadata file written next to an exported artifact
+type ArtifactMetadata struct {
+	FileName          string `json:"file_name"`
+	Type              string `json:"artifact_type"`
+	SizeInBytes       int64  `json:"size_in_bytes"`
+	Notes             string `json:"notes,omitempty"`
+	PublicInstallPage bool   `json:"is_enable_public_page"`
+}
+
+// ExportArtifact copies (or moves) the file into the deploy dir, writes its ArtifactMetadata next to it
+// (with ArtifactMetadataSuffix), exports the deploy dir path of the artifact (see ArtifactOptions.OutputKey)
+// and returns it. If the deploy dir already has a file with the same name (for example an artifact of a previous
+// step), the artifact is exported with a numbered name (like app-release-2.apk) instead of overwriting it.
+func (e *Exporter) ExportArtifact(path string, opts ArtifactOptions) (string, error) {
+	deployDir := opts.DeployDir
+	if deployDir == "" {
+		deployDir = os.Getenv(DeployDirEnvKey)
+	}
+	if deployDir == "" {
+		return "", fmt.Errorf("deploy dir is not set (%s)", DeployDirEnvKey)
+	}
+
+	pathModifier := pathutil.NewPathModifier()
+	absPath, err := pathModifier.AbsPath(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("artifact (%s) is not a regular file, compress directories before exporting them", absPath)
+	}
+	artifactType := opts.Type
+	if artifactType == "" {
+		artifactType = strings.ToLower(strings.TrimPrefix(filepath.Ext(absPath), "."))
+	}
+	outputKey := opts.OutputKey
+	if outputKey == "" {
+		outputKey = standardArtifactOutputs[artifactType]
+	}
+
+	absDeployDir, err := pathModifier.AbsPath(deployDir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(absDeployDir, 0755); err != nil {
+		return "", err
+	}
+
+	destination, err := uniqueArtifactPath(absDeployDir, filepath.Base(absPath))
+	if err != nil {
+		return "", err
+	}
+	existingPath := ""
+	if opts.Duplicates != DuplicateCopy {
+		if existingPath, err = findIdenticalFile(absPath, destination, 0); err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case existingPath != "" && opts.Duplicates == DuplicateSkip:
+		e.logger().Printf("Artifact (%s) is already exported as %s", absPath, existingPath)
+		return existingPath, e.exportArtifactPath(outputKey, existingPath)
+	case existingPath != "" && opts.Duplicates == DuplicateHardLink:
+		if err = os.Link(existingPath, destination); err != nil {
+			e.logger().Warnf("Failed to hard link artifact (%s) to %s, copying it: %s", absPath, existingPath, err)
+			err = copyFile(absPath, destination)
+		}
+		if err == nil && opts.Move {
+			err = os.Remove(absPath)
+		}
+	case opts.Move:
+		err = moveFile(absPath, destination)
+	default:
+		err = copyFile(absPath, destination)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to export artifact (%s): %w", absPath, err)
+	}
+
+	metadata, err := json.MarshalIndent(ArtifactMetadata{
+		FileName:          filepath.Base(destination),
+		Type:              artifactType,
+		SizeInBytes:       info.Size(),
+		Notes:             opts.Notes,
+		PublicInstallPage: opts.PublicInstallPage,
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomically(destination+ArtifactMetadataSuffix, metadata, 0644); err != nil {
+		return "", fmt.Errorf("failed to write artifact metadata: %w", err)
+	}
+
+	return destination, e.exportArtifactPath(outputKey, destination)
+}
+
+func (e *Exporter) exportArtifactPath(outputKey, path string) error {
+	if outputKey == "" {
+		return nil
+	}
+	return e.ExportOutput(outputKey, e.mapPath(path))
+}
+
+// uniqueArtifactPath returns the path of the name in dir, numbered (before the extension) if the name
+// or its metadata file is already taken
+func uniqueArtifactPath(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		path := filepath.Join(dir, name)
+		if i > 1 {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		}
+		taken, err := pathExists(path)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			if taken, err = pathExists(path + ArtifactMetadataSuffix); err != nil {
+				return "", err
+			}
+		}
+		if !taken {
+			return path, nil
+		}
+	}
+}
+
+func pathExists(path string) (bool, error) {
+	_, err := os.Lstat(path)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+// moveFile renames the file, or copies and removes it if it's on another device than the destination
+func moveFile(source, destination string) error {
+	if err := os.Rename(source, destination); err == nil {
+		return nil
+	}
+	if err := copyFile(source, destination); err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
@@ -0,0 +1,73 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// ArtifactGroup is a set of related files exported under one logical artifact name,
+// for example an APK with its mapping.txt and metadata file.
+type ArtifactGroup struct {
+	Name  string         `json:"name"`
+	Files []ArtifactFile `json:"files"`
+}
+
+// ArtifactFile is a member of an ArtifactGroup. Role describes what the file is within the group (such as "apk" or "mapping").
+type ArtifactFile struct {
+	Role string `json:"role"`
+	Path string `json:"path"`
+}
+
+// ExportArtifactGroup writes the manifest of the artifact group (with absolute file paths) to manifestPath as JSON,
+// then exports the absolute manifest path with ExportOutput(). Deploy steps can read the manifest to handle
+// the group's files together. Path mappings (see WithPathMappings) are applied to the paths in the manifest too.
+func (e *Exporter) ExportArtifactGroup(key string, group ArtifactGroup, manifestPath string) error {
+	if group.Name == "" {
+		return fmt.Errorf("artifact group name is empty")
+	}
+	if len(group.Files) == 0 {
+		return fmt.Errorf("artifact group (%s) has no files", group.Name)
+	}
+
+	pathModifier := pathutil.NewPathModifier()
+	pathChecker := pathutil.NewPathChecker()
+	roles := map[string]bool{}
+	manifest := ArtifactGroup{Name: group.Name}
+	for _, file := range group.Files {
+		if roles[file.Role] {
+			return fmt.Errorf("artifact group (%s) contains multiple files with role: %s", group.Name, file.Role)
+		}
+		roles[file.Role] = true
+
+		absPath, err := pathModifier.AbsPath(file.Path)
+		if err != nil {
+			return err
+		}
+		exists, err := pathChecker.IsPathExists(absPath)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("artifact file (%s) does not exist", absPath)
+		}
+
+		manifest.Files = append(manifest.Files, ArtifactFile{Role: file.Role, Path: e.mapPath(absPath)})
+	}
+
+	absManifestPath, err := pathModifier.AbsPath(manifestPath)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(absManifestPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write artifact group manifest: %w", err)
+	}
+
+	return e.ExportOutput(key, e.mapPath(absManifestPath))
+}
@@ -0,0 +1,65 @@
+package export
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportArtifactGroup(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	envmanStorePath := setupEnvman(t)
+
+	apkPath := filepath.Join(tmpDir, "app-release.apk")
+	mappingPath := filepath.Join(tmpDir, "mapping.txt")
+	require.NoError(t, ioutil.WriteFile(apkPath, []byte("apk"), 0700))
+	require.NoError(t, ioutil.WriteFile(mappingPath, []byte("mapping"), 0700))
+	manifestPath := filepath.Join(tmpDir, "artifact-group.json")
+
+	group := ArtifactGroup{
+		Name: "app-release",
+		Files: []ArtifactFile{
+			{Role: "apk", Path: apkPath},
+			{Role: "mapping", Path: mappingPath},
+		},
+	}
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+	require.NoError(t, e.ExportArtifactGroup("ARTIFACT_GROUP_MANIFEST_PATH", group, manifestPath))
+
+	requireEnvmanContainsValueForKey(t, "ARTIFACT_GROUP_MANIFEST_PATH", manifestPath, envmanStorePath)
+
+	content, err := ioutil.ReadFile(manifestPath)
+	require.NoError(t, err)
+	var manifest ArtifactGroup
+	require.NoError(t, json.Unmarshal(content, &manifest))
+	require.Equal(t, group, manifest)
+}
+
+func TestExportArtifactGroup_InvalidGroup(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	_ = setupEnvman(t)
+
+	apkPath := filepath.Join(tmpDir, "app-release.apk")
+	require.NoError(t, ioutil.WriteFile(apkPath, []byte("apk"), 0700))
+	manifestPath := filepath.Join(tmpDir, "artifact-group.json")
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	require.Error(t, e.ExportArtifactGroup("KEY", ArtifactGroup{Name: "app-release"}, manifestPath))
+	require.Error(t, e.ExportArtifactGroup("KEY", ArtifactGroup{
+		Name:  "app-release",
+		Files: []ArtifactFile{{Role: "apk", Path: filepath.Join(tmpDir, "missing.apk")}},
+	}, manifestPath))
+	require.Error(t, e.ExportArtifactGroup("KEY", ArtifactGroup{
+		Name:  "app-release",
+		Files: []ArtifactFile{{Role: "apk", Path: apkPath}, {Role: "apk", Path: apkPath}},
+	}, manifestPath))
+}
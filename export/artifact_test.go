@@ -0,0 +1,140 @@
+package export
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportArtifact(t *testing.T) {
+	tmpDir := t.TempDir()
+	envmanStorePath := setupEnvman(t)
+
+	deployDir := filepath.Join(tmpDir, "deploy")
+	t.Setenv(DeployDirEnvKey, deployDir)
+	apkPath := filepath.Join(tmpDir, "build", "app-release.apk")
+	require.NoError(t, os.MkdirAll(filepath.Dir(apkPath), 0700))
+	require.NoError(t, os.WriteFile(apkPath, []byte("apk"), 0600))
+	require.NoError(t, os.MkdirAll(deployDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(deployDir, "app-release.apk"), []byte("previous apk"), 0600))
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	// When
+	exportedPath, err := e.ExportArtifact(apkPath, ArtifactOptions{Notes: "Release notes", PublicInstallPage: true})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(deployDir, "app-release-2.apk"), exportedPath)
+	require.FileExists(t, apkPath)
+	requireEnvmanContainsValueForKey(t, "BITRISE_APK_PATH", exportedPath, envmanStorePath)
+
+	content, err := os.ReadFile(exportedPath + ArtifactMetadataSuffix)
+	require.NoError(t, err)
+	var metadata ArtifactMetadata
+	require.NoError(t, json.Unmarshal(content, &metadata))
+	require.Equal(t, ArtifactMetadata{
+		FileName:          "app-release-2.apk",
+		Type:              "apk",
+		SizeInBytes:       3,
+		Notes:             "Release notes",
+		PublicInstallPage: true,
+	}, metadata)
+}
+
+func TestExportArtifact_Move(t *testing.T) {
+	tmpDir := t.TempDir()
+	envmanStorePath := setupEnvman(t)
+
+	deployDir := filepath.Join(tmpDir, "deploy")
+	reportPath := filepath.Join(tmpDir, "lint-results.html")
+	require.NoError(t, os.WriteFile(reportPath, []byte("report"), 0600))
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	// When
+	exportedPath, err := e.ExportArtifact(reportPath, ArtifactOptions{Move: true, OutputKey: "LINT_REPORT_PATH", DeployDir: deployDir})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(deployDir, "lint-results.html"), exportedPath)
+	require.NoFileExists(t, reportPath)
+	require.FileExists(t, exportedPath+ArtifactMetadataSuffix)
+	requireEnvmanContainsValueForKey(t, "LINT_REPORT_PATH", exportedPath, envmanStorePath)
+}
+
+func TestExportArtifact_Duplicates(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     DuplicatePolicy
+		wantPath   string
+		wantLinked bool
+	}{
+		{name: "copy", policy: DuplicateCopy, wantPath: "app-2.ipa"},
+		{name: "skip", policy: DuplicateSkip, wantPath: "previous.ipa"},
+		{name: "hard link", policy: DuplicateHardLink, wantPath: "app.ipa", wantLinked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			envmanStorePath := setupEnvman(t)
+
+			deployDir := filepath.Join(tmpDir, "deploy")
+			ipaPath := filepath.Join(tmpDir, "app.ipa")
+			previousPath := filepath.Join(deployDir, "previous.ipa")
+			require.NoError(t, os.MkdirAll(deployDir, 0700))
+			require.NoError(t, os.WriteFile(ipaPath, []byte("ipa"), 0600))
+			require.NoError(t, os.WriteFile(previousPath, []byte("ipa"), 0600))
+			if tt.policy == DuplicateCopy {
+				require.NoError(t, os.WriteFile(filepath.Join(deployDir, "app.ipa"), []byte("other ipa"), 0600))
+			}
+
+			e := NewExporter(command.NewFactory(env.NewRepository()))
+
+			// When
+			exportedPath, err := e.ExportArtifact(ipaPath, ArtifactOptions{DeployDir: deployDir, Duplicates: tt.policy})
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, filepath.Join(deployDir, tt.wantPath), exportedPath)
+			requireEnvmanContainsValueForKey(t, "BITRISE_IPA_PATH", exportedPath, envmanStorePath)
+			exportedInfo, err := os.Stat(exportedPath)
+			require.NoError(t, err)
+			previousInfo, err := os.Stat(previousPath)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantLinked || tt.policy == DuplicateSkip, os.SameFile(exportedInfo, previousInfo))
+		})
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"app.apk":                  "apk",
+		"step-1/app-release.apk":   "apk",
+		"step-2/app-release.apk":   "apk",
+		"mapping.txt":              "map",
+		"step-1/other-mapping.txt": "mop",
+		"app.ipa":                  "ipa-content",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+	require.NoError(t, os.Symlink(filepath.Join(root, "app.ipa"), filepath.Join(root, "latest.ipa")))
+
+	duplicates, err := FindDuplicates(root)
+
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{
+		filepath.Join(root, "app.apk"),
+		filepath.Join(root, "step-1", "app-release.apk"),
+		filepath.Join(root, "step-2", "app-release.apk"),
+	}}, duplicates)
+}
@@ -0,0 +1,196 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CopyConflictPolicy is what CopyDir does with files that already exist in the destination
+type CopyConflictPolicy int
+
+// Conflict policies of CopyDir
+const (
+	// CopyConflictOverwrite replaces the existing file (the default)
+	CopyConflictOverwrite CopyConflictPolicy = iota
+	// CopyConflictSkip keeps the existing file
+	CopyConflictSkip
+	// CopyConflictError fails the copy of the file
+	CopyConflictError
+)
+
+// ErrCopyConflict means that a copied file already exists in the destination, see CopyConflictError
+var ErrCopyConflict = errors.New("file already exists")
+
+// CopyOptions configures CopyDir
+type CopyOptions struct {
+	// FollowSymlinks copies the content symlinks point to, instead of recreating the symlinks. Use it when relative
+	// links would break outside the source tree (such as in exported .app bundles). Symlink loops are copied once.
+	FollowSymlinks bool
+	// OnConflict is what happens with files that already exist in the destination
+	OnConflict CopyConflictPolicy
+	// ContinueOnError copies the rest of the files when a file fails, and returns the failures in a *CopyError
+	ContinueOnError bool
+}
+
+// CopyFailure is a file that CopyDir failed to copy
+type CopyFailure struct {
+	Path string
+	Err  error
+}
+
+// CopyError collects the failed files of CopyDir, see CopyOptions.ContinueOnError
+type CopyError struct {
+	Failures []CopyFailure
+}
+
+// Error ...
+func (e *CopyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to copy %d file(s):", len(e.Failures))
+	for _, failure := range e.Failures {
+		fmt.Fprintf(&b, "\n- %s: %s", failure.Path, failure.Err)
+	}
+	return b.String()
+}
+
+// CopyDir copies the content of the source directory into the destination directory (creating it if needed),
+// keeping the permission bits and modification times of the files. Symlinks are recreated unless
+// CopyOptions.FollowSymlinks is set.
+func CopyDir(source, destination string, opts CopyOptions) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", source)
+	}
+
+	c := dirCopier{opts: opts, visited: map[string]bool{}}
+	if err := c.copyDir(source, destination, info); err != nil {
+		return err
+	}
+	if len(c.failures) > 0 {
+		return &CopyError{Failures: c.failures}
+	}
+	return nil
+}
+
+// ExportOutputDir is a convenience method for copying the sourceDir to destinationDir with CopyDir and then exporting
+// the absolute destination path with ExportOutput()
+func (e *Exporter) ExportOutputDir(key, sourceDir, destinationDir string, opts CopyOptions) error {
+	absDestinationDir, err := filepath.Abs(destinationDir)
+	if err != nil {
+		return err
+	}
+	if err := CopyDir(sourceDir, absDestinationDir, opts); err != nil {
+		return err
+	}
+	return e.ExportOutput(key, e.mapPath(absDestinationDir))
+}
+
+type dirCopier struct {
+	opts CopyOptions
+	// visited are the real paths of the copied directories, to copy symlink loops once
+	visited  map[string]bool
+	failures []CopyFailure
+}
+
+func (c *dirCopier) copyDir(source, destination string, info os.FileInfo) error {
+	if realPath, err := filepath.EvalSymlinks(source); err == nil {
+		if c.visited[realPath] {
+			return nil
+		}
+		c.visited[realPath] = true
+		defer delete(c.visited, realPath)
+	}
+
+	if err := os.MkdirAll(destination, info.Mode().Perm()|0700); err != nil {
+		return c.fail(source, err)
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return c.fail(source, err)
+	}
+	for _, entry := range entries {
+		if err := c.copyEntry(filepath.Join(source, entry.Name()), filepath.Join(destination, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(destination, info.ModTime(), info.ModTime())
+}
+
+func (c *dirCopier) copyEntry(source, destination string) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return c.fail(source, err)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !c.opts.FollowSymlinks {
+			return c.copySymlink(source, destination)
+		}
+		if info, err = os.Stat(source); err != nil {
+			return c.fail(source, fmt.Errorf("broken symlink: %w", err))
+		}
+	}
+
+	if info.IsDir() {
+		return c.copyDir(source, destination, info)
+	}
+	if !info.Mode().IsRegular() {
+		return c.fail(source, fmt.Errorf("unsupported file type: %s", info.Mode().Type()))
+	}
+
+	if copyNeeded, err := c.resolveConflict(destination); err != nil || !copyNeeded {
+		return c.fail(source, err)
+	}
+	return c.fail(source, copyFile(source, destination))
+}
+
+func (c *dirCopier) copySymlink(source, destination string) error {
+	target, err := os.Readlink(source)
+	if err != nil {
+		return c.fail(source, err)
+	}
+	if copyNeeded, err := c.resolveConflict(destination); err != nil || !copyNeeded {
+		return c.fail(source, err)
+	}
+	if err := os.RemoveAll(destination); err != nil {
+		return c.fail(source, err)
+	}
+	return c.fail(source, os.Symlink(target, destination))
+}
+
+// resolveConflict applies the conflict policy if the destination exists, and reports whether the file should be copied
+func (c *dirCopier) resolveConflict(destination string) (bool, error) {
+	if _, err := os.Lstat(destination); errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	switch c.opts.OnConflict {
+	case CopyConflictSkip:
+		return false, nil
+	case CopyConflictError:
+		return false, fmt.Errorf("%w: %s", ErrCopyConflict, destination)
+	default:
+		// A symlink in the destination would redirect the write, and a directory can't be overwritten by a file
+		return true, os.RemoveAll(destination)
+	}
+}
+
+// fail records the failure of the file if ContinueOnError is set, otherwise it returns it. A nil err is returned as-is.
+func (c *dirCopier) fail(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if c.opts.ContinueOnError {
+		c.failures = append(c.failures, CopyFailure{Path: path, Err: err})
+		return nil
+	}
+	return fmt.Errorf("failed to copy %s: %w", path, err)
+}
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func createCopyDirSource(t *testing.T) string {
+	root := t.TempDir()
+	source := filepath.Join(root, "App.app")
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "Frameworks", "Lib.framework"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "App"), []byte("binary"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "Frameworks", "Lib.framework", "Lib"), []byte("lib"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "shared.txt"), []byte("shared"), 0644))
+	require.NoError(t, os.Symlink("Frameworks/Lib.framework/Lib", filepath.Join(source, "Lib")))
+	require.NoError(t, os.Symlink("../shared.txt", filepath.Join(source, "shared.txt")))
+	require.NoError(t, os.Symlink(".", filepath.Join(source, "Frameworks", "loop")))
+	return source
+}
+
+func TestCopyDir_PreservesSymlinks(t *testing.T) {
+	// Given
+	source := createCopyDirSource(t)
+	destination := filepath.Join(t.TempDir(), "export", "App.app")
+
+	// When
+	err := CopyDir(source, destination, CopyOptions{})
+
+	// Then
+	require.NoError(t, err)
+	target, err := os.Readlink(filepath.Join(destination, "shared.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "../shared.txt", target)
+	info, err := os.Stat(filepath.Join(destination, "App"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
+
+func TestCopyDir_FollowSymlinks(t *testing.T) {
+	// Given
+	source := createCopyDirSource(t)
+	destination := filepath.Join(t.TempDir(), "App.app")
+
+	// When
+	err := CopyDir(source, destination, CopyOptions{FollowSymlinks: true})
+
+	// Then
+	require.NoError(t, err)
+	for path, content := range map[string]string{"shared.txt": "shared", "Lib": "lib"} {
+		info, err := os.Lstat(filepath.Join(destination, path))
+		require.NoError(t, err)
+		require.True(t, info.Mode().IsRegular(), path)
+		b, err := ioutil.ReadFile(filepath.Join(destination, path))
+		require.NoError(t, err)
+		require.Equal(t, content, string(b))
+	}
+	require.NoDirExists(t, filepath.Join(destination, "Frameworks", "loop"))
+}
+
+func TestCopyDir_ConflictPolicy(t *testing.T) {
+	// Given
+	source := createCopyDirSource(t)
+	destination := filepath.Join(t.TempDir(), "App.app")
+	require.NoError(t, os.MkdirAll(destination, 0755))
+	existing := filepath.Join(destination, "App")
+
+	// When
+	require.NoError(t, ioutil.WriteFile(existing, []byte("existing"), 0644))
+	err := CopyDir(source, destination, CopyOptions{OnConflict: CopyConflictSkip})
+
+	// Then
+	require.NoError(t, err)
+	b, err := ioutil.ReadFile(existing)
+	require.NoError(t, err)
+	require.Equal(t, "existing", string(b))
+
+	// When
+	err = CopyDir(source, destination, CopyOptions{OnConflict: CopyConflictError, ContinueOnError: true})
+
+	// Then
+	var copyErr *CopyError
+	require.True(t, errors.As(err, &copyErr), err)
+	require.Len(t, copyErr.Failures, 5)
+	for _, failure := range copyErr.Failures {
+		require.True(t, errors.Is(failure.Err, ErrCopyConflict), failure.Err)
+	}
+
+	// When
+	err = CopyDir(source, destination, CopyOptions{})
+
+	// Then
+	require.NoError(t, err)
+	b, err = ioutil.ReadFile(existing)
+	require.NoError(t, err)
+	require.Equal(t, "binary", string(b))
+}
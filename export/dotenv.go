@@ -0,0 +1,110 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// DotenvOptions configures WriteDotenv
+type DotenvOptions struct {
+	// OutputKey (if set) is the output the absolute path of the written file is exported as
+	OutputKey string
+	// AlwaysQuote quotes every value, by default only the values that need it (such as values with whitespace,
+	// quotes, `#`, `$` or newlines) are quoted
+	AlwaysQuote bool
+	// ExportPrefix starts each line with `export `, so that the file can be sourced by shells too
+	ExportPrefix bool
+	// Mode (if set) is the permission of the written file, otherwise 0600 as the values can contain secrets
+	Mode os.FileMode
+}
+
+var (
+	// dotenvKeyRegex matches the keys accepted by dotenv parsers (such as Docker Compose and the dotenv libraries)
+	dotenvKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// dotenvPlainValueRegex matches the values that don't need quoting
+	dotenvPlainValueRegex = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,-]*$`)
+	dotenvValueEscaper    = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`, "\r", `\r`)
+)
+
+// WriteDotenv writes the values into a .env file at path (sorted by key, one `KEY=value` line per value), and
+// exports the absolute path of the file if opts.OutputKey is set. This hands configuration to tools expecting the
+// dotenv format, such as Docker Compose (`env_file`) and the dotenv libraries.
+// Values that need it are double-quoted, with backslashes, quotes, `$` and newlines escaped, so multiline values
+// and values with special characters are read back as-is. Note that `docker run --env-file` doesn't support quoting.
+func (e *Exporter) WriteDotenv(path string, values map[string]string, opts DotenvOptions) error {
+	content, err := renderDotenv(values, opts)
+	if err != nil {
+		return err
+	}
+
+	absPath, err := pathutil.NewPathModifier().AbsPath(path)
+	if err != nil {
+		return err
+	}
+	mode := opts.Mode.Perm()
+	if mode == 0 {
+		mode = 0600
+	}
+	if err := writeFileAtomically(absPath, []byte(content), mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", absPath, err)
+	}
+
+	if opts.OutputKey == "" {
+		return nil
+	}
+	return e.ExportOutput(opts.OutputKey, e.mapPath(absPath))
+}
+
+func renderDotenv(values map[string]string, opts DotenvOptions) (string, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if !dotenvKeyRegex.MatchString(key) {
+			return "", fmt.Errorf("invalid dotenv key: %q, use only letters, digits and underscores, and don't start with a digit", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		if opts.ExportPrefix {
+			b.WriteString("export ")
+		}
+		b.WriteString(key + "=" + dotenvValue(values[key], opts.AlwaysQuote) + "\n")
+	}
+	return b.String(), nil
+}
+
+func dotenvValue(value string, alwaysQuote bool) string {
+	if !alwaysQuote && dotenvPlainValueRegex.MatchString(value) {
+		return value
+	}
+	return `"` + dotenvValueEscaper.Replace(value) + `"`
+}
+
+// writeFileAtomically writes the file next to its final path and renames it, so readers never see a partial file
+func writeFileAtomically(path string, content []byte, mode os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) //nolint:errcheck
+
+	if _, err := tmpFile.Write(content); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,54 @@
+package export
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDotenv(t *testing.T) {
+	// Given
+	envmanStorePath := setupEnvman(t)
+	dotenvPath := filepath.Join(t.TempDir(), "build.env")
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	// When
+	err := e.WriteDotenv(dotenvPath, map[string]string{
+		"VERSION":   "1.2.3",
+		"NOTES":     "first line\nsecond \"line\"",
+		"API_URL":   "https://example.com/api?x=1",
+		"PRICE":     "$5 # not a comment",
+		"WIN_PATH":  `C:\builds`,
+		"EMPTY_VAR": "",
+	}, DotenvOptions{OutputKey: "DOTENV_PATH"})
+
+	// Then
+	require.NoError(t, err)
+	content, err := ioutil.ReadFile(dotenvPath)
+	require.NoError(t, err)
+	require.Equal(t, `API_URL="https://example.com/api?x=1"
+EMPTY_VAR=
+NOTES="first line\nsecond \"line\""
+PRICE="\$5 # not a comment"
+VERSION=1.2.3
+WIN_PATH="C:\\builds"
+`, string(content))
+	info, err := os.Stat(dotenvPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	requireEnvmanContainsValueForKey(t, "DOTENV_PATH", dotenvPath, envmanStorePath)
+}
+
+func Test_renderDotenv(t *testing.T) {
+	content, err := renderDotenv(map[string]string{"B": "b", "A": "a"}, DotenvOptions{AlwaysQuote: true, ExportPrefix: true})
+	require.NoError(t, err)
+	require.Equal(t, "export A=\"a\"\nexport B=\"b\"\n", content)
+
+	_, err = renderDotenv(map[string]string{"MY-KEY": "value"}, DotenvOptions{})
+	require.EqualError(t, err, `invalid dotenv key: "MY-KEY", use only letters, digits and underscores, and don't start with a digit`)
+}
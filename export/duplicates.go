@@ -0,0 +1,71 @@
+package export
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+)
+
+// DuplicatePolicy is what ExportArtifact does when the deploy dir already has a file with the same content
+type DuplicatePolicy int
+
+// Duplicate policies of ExportArtifact
+const (
+	// DuplicateCopy exports the artifact as a separate file (the default)
+	DuplicateCopy DuplicatePolicy = iota
+	// DuplicateSkip exports the path of the existing file, without copying the artifact or writing its metadata
+	DuplicateSkip
+	// DuplicateHardLink exports the artifact as a hard link to the existing file, so that it takes no extra space.
+	// The artifact is copied if the link fails (for example on file systems without hard links).
+	DuplicateHardLink
+)
+
+// FindDuplicates returns the groups of identical regular files (by size and SHA-256 checksum) under root,
+// such as the same binary exported by multiple steps into the deploy dir. The paths of a group are sorted,
+// symlinks are not followed. Only files with the same size as another file are checksummed.
+func FindDuplicates(root string) ([][]string, error) {
+	pathsBySize := map[int64][]string{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		pathsBySize[info.Size()] = append(pathsBySize[info.Size()], path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var duplicates [][]string
+	for _, paths := range pathsBySize {
+		if len(paths) < 2 {
+			continue
+		}
+		pathsByChecksum := map[string][]string{}
+		for _, path := range paths {
+			checksum, err := fileChecksum(path)
+			if err != nil {
+				return nil, err
+			}
+			pathsByChecksum[checksum] = append(pathsByChecksum[checksum], path)
+		}
+		for _, group := range pathsByChecksum {
+			if len(group) > 1 {
+				sort.Strings(group)
+				duplicates = append(duplicates, group)
+			}
+		}
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i][0] < duplicates[j][0]
+	})
+	return duplicates, nil
+}
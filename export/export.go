@@ -3,11 +3,11 @@ package export
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-io/go-utils/v2/pathutil"
 	"github.com/bitrise-io/go-utils/ziputil"
 )
@@ -20,18 +20,43 @@ const (
 
 // Exporter ...
 type Exporter struct {
-	cmdFactory command.Factory
+	cmdFactory   command.Factory
+	pathMappings []PathMapping
+	outputPrefix string
+	// sanitizeNames is set by WithSanitizedOutputNames
+	sanitizeNames bool
+	exported      *exportedOutputs
+	log           log.Logger
 }
 
 // NewExporter ...
 func NewExporter(cmdFactory command.Factory) Exporter {
-	return Exporter{cmdFactory: cmdFactory}
+	return Exporter{cmdFactory: cmdFactory, exported: newExportedOutputs()}
+}
+
+// WithLogger returns a copy of the exporter that logs its warnings (such as an output exported twice) with the logger
+func (e Exporter) WithLogger(logger log.Logger) Exporter {
+	e.log = logger
+	return e
+}
+
+func (e *Exporter) logger() log.Logger {
+	if e.log == nil {
+		return log.NewLogger()
+	}
+	return e.log
 }
 
 // ExportOutput is used for exposing values for other steps.
 // Regular env vars are isolated between steps, so instead of calling `os.Setenv()`, use this to explicitly expose
 // a value for subsequent steps.
+// The key has to be a valid env var name (see ValidateOutputName and WithSanitizedOutputNames), and a warning is
+// logged if the exporter already exported the key with a different value.
 func (e *Exporter) ExportOutput(key, value string) error {
+	key, err := e.outputName(key, value)
+	if err != nil {
+		return err
+	}
 	cmd := e.cmdFactory.Create("envman", []string{"add", "--key", key, "--value", value}, nil)
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
@@ -43,6 +68,10 @@ func (e *Exporter) ExportOutput(key, value string) error {
 // ExportOutputNoExpand works like ExportOutput but does not expand environment variables in the value.
 // This can be used when the value is unstrusted or is beyond the control of the step.
 func (e *Exporter) ExportOutputNoExpand(key, value string) error {
+	key, err := e.outputName(key, value)
+	if err != nil {
+		return err
+	}
 	cmd := e.cmdFactory.Create("envman", []string{"add", "--key", key, "--value", value, "--no-expand"}, nil)
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
@@ -54,23 +83,8 @@ func (e *Exporter) ExportOutputNoExpand(key, value string) error {
 // ExportOutputFile is a convenience method for copying sourcePath to destinationPath and then exporting the
 // absolute destination path with ExportOutput()
 func (e *Exporter) ExportOutputFile(key, sourcePath, destinationPath string) error {
-	pathModifier := pathutil.NewPathModifier()
-	absSourcePath, err := pathModifier.AbsPath(sourcePath)
-	if err != nil {
-		return err
-	}
-	absDestinationPath, err := pathModifier.AbsPath(destinationPath)
-	if err != nil {
-		return err
-	}
-
-	if absSourcePath != absDestinationPath {
-		if err = copyFile(absSourcePath, absDestinationPath); err != nil {
-			return err
-		}
-	}
-
-	return e.ExportOutput(key, absDestinationPath)
+	_, err := e.ExportOutputFileWithOptions(key, sourcePath, destinationPath, ExportFileOptions{})
+	return err
 }
 
 // ExportOutputFilesZip is a convenience method for creating a ZIP archive from sourcePaths at zipPath and then
@@ -94,7 +108,7 @@ func (e *Exporter) ExportOutputFilesZip(key string, sourcePaths []string, zipPat
 	case foldersType:
 		err = ziputil.ZipDirs(sourcePaths, tempZipPath)
 	case mixedFileAndFolderType:
-		return fmt.Errorf("source path list (%s) contains a mix of files and folders", sourcePaths)
+		return fmt.Errorf("source path list (%s) contains a mix of files and folders, use ExportOutputArchive for mixed inputs", sourcePaths)
 	default:
 		return fmt.Errorf("source path list (%s) is empty", sourcePaths)
 	}
@@ -151,6 +165,8 @@ func getInputType(sourcePths []string) (string, error) {
 	return "", nil
 }
 
+// copyFile copies the content, the permission bits and the modification time of source to destination,
+// so that exported executables stay executable
 func copyFile(source, destination string) error {
 	in, err := os.Open(source)
 	if err != nil {
@@ -158,20 +174,26 @@ func copyFile(source, destination string) error {
 	}
 	defer in.Close() //nolint:errcheck
 
-	out, err := os.Create(destination)
+	info, err := in.Stat()
 	if err != nil {
 		return err
 	}
-	defer func(out *os.File) {
-		err := out.Close()
-		if err != nil {
-			log.Fatalf(err.Error())
-		}
-	}(out)
 
-	_, err = io.Copy(out, in)
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
 	if err != nil {
 		return err
 	}
-	return nil
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// The mode of an existing destination is not changed by OpenFile, and new files are affected by the umask
+	if err := os.Chmod(destination, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(destination, info.ModTime(), info.ModTime())
 }
@@ -0,0 +1,123 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// ExportFileOptions configures ExportOutputFileWithOptions
+type ExportFileOptions struct {
+	// Deduplicate skips copying when the destination, or another file in the destination directory, has the same
+	// content (by SHA-256 checksum) as the source. In the latter case the existing file's path is exported.
+	// If Mode is set, other files are only reused if they already have that permission.
+	// This saves the copying of large artifacts (such as dSYMs and mapping files) on reruns.
+	Deduplicate bool
+	// Mode (if set) is the permission of the exported file, otherwise the permissions of the source are kept
+	Mode os.FileMode
+}
+
+// ExportOutputFileWithOptions works like ExportOutputFile, and returns the exported path
+// (which can be an existing identical file, see ExportFileOptions.Deduplicate).
+func (e *Exporter) ExportOutputFileWithOptions(key, sourcePath, destinationPath string, opts ExportFileOptions) (string, error) {
+	pathModifier := pathutil.NewPathModifier()
+	absSourcePath, err := pathModifier.AbsPath(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	absDestinationPath, err := pathModifier.AbsPath(destinationPath)
+	if err != nil {
+		return "", err
+	}
+
+	exportedPath := absDestinationPath
+	if absSourcePath != absDestinationPath {
+		existingPath := ""
+		if opts.Deduplicate {
+			if existingPath, err = findIdenticalFile(absSourcePath, absDestinationPath, opts.Mode); err != nil {
+				return "", err
+			}
+		}
+
+		if existingPath != "" {
+			exportedPath = existingPath
+		} else if err = copyFile(absSourcePath, absDestinationPath); err != nil {
+			return "", err
+		}
+	}
+
+	// Other files found by the deduplication might belong to other exports, they are never changed
+	if opts.Mode != 0 && exportedPath == absDestinationPath {
+		if err := os.Chmod(exportedPath, opts.Mode.Perm()); err != nil {
+			return "", err
+		}
+	}
+
+	return exportedPath, e.ExportOutput(key, e.mapPath(exportedPath))
+}
+
+// findIdenticalFile returns the destination, or another file in the destination directory (with the given permission,
+// if mode is set), with the same content as the source, or an empty string if there is no such file.
+// Only files with the same size are checksummed.
+func findIdenticalFile(sourcePath, destinationPath string, mode os.FileMode) (string, error) {
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	candidates := []string{destinationPath}
+	entries, err := os.ReadDir(filepath.Dir(destinationPath))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(filepath.Dir(destinationPath), entry.Name())
+		if path != destinationPath && path != sourcePath {
+			candidates = append(candidates, path)
+		}
+	}
+
+	sourceChecksum := ""
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != sourceInfo.Size() {
+			continue
+		}
+		if mode != 0 && candidate != destinationPath && info.Mode().Perm() != mode.Perm() {
+			continue
+		}
+
+		if sourceChecksum == "" {
+			if sourceChecksum, err = fileChecksum(sourcePath); err != nil {
+				return "", err
+			}
+		}
+		checksum, err := fileChecksum(candidate)
+		if err != nil {
+			return "", err
+		}
+		if checksum == sourceChecksum {
+			return candidate, nil
+		}
+	}
+
+	return "", nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close() //nolint:errcheck
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,126 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportOutputFileWithOptions_Deduplicate(t *testing.T) {
+	tmpDir := t.TempDir()
+	envmanStorePath := setupEnvman(t)
+
+	sourcePath := filepath.Join(tmpDir, "source", "app.dSYM.zip")
+	deployDir := filepath.Join(tmpDir, "deploy")
+	existingPath := filepath.Join(deployDir, "app-previous.dSYM.zip")
+	require.NoError(t, os.MkdirAll(filepath.Dir(sourcePath), 0700))
+	require.NoError(t, os.MkdirAll(deployDir, 0700))
+	require.NoError(t, os.WriteFile(sourcePath, []byte("dsym"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(deployDir, "other.zip"), []byte("other"), 0600))
+	require.NoError(t, os.WriteFile(existingPath, []byte("dsym"), 0600))
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+	destinationPath := filepath.Join(deployDir, "app.dSYM.zip")
+
+	// When
+	exportedPath, err := e.ExportOutputFileWithOptions("my_key", sourcePath, destinationPath, ExportFileOptions{Deduplicate: true})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, existingPath, exportedPath)
+	require.NoFileExists(t, destinationPath)
+	requireEnvmanContainsValueForKey(t, "my_key", existingPath, envmanStorePath)
+}
+
+func TestExportOutputFileWithOptions_DeduplicateCopiesChangedFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	_ = setupEnvman(t)
+
+	sourcePath := filepath.Join(tmpDir, "mapping.txt")
+	destinationPath := filepath.Join(tmpDir, "deploy", "mapping.txt")
+	require.NoError(t, os.MkdirAll(filepath.Dir(destinationPath), 0700))
+	require.NoError(t, os.WriteFile(sourcePath, []byte("new"), 0600))
+	require.NoError(t, os.WriteFile(destinationPath, []byte("old"), 0600))
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	// When
+	exportedPath, err := e.ExportOutputFileWithOptions("my_key", sourcePath, destinationPath, ExportFileOptions{Deduplicate: true})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, destinationPath, exportedPath)
+	content, err := os.ReadFile(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(content))
+}
+
+func TestExportOutputFile_PreservesExecutableBit(t *testing.T) {
+	tmpDir := t.TempDir()
+	_ = setupEnvman(t)
+
+	sourcePath := filepath.Join(tmpDir, "script.sh")
+	destinationPath := filepath.Join(tmpDir, "deploy", "script.sh")
+	require.NoError(t, os.MkdirAll(filepath.Dir(destinationPath), 0700))
+	require.NoError(t, os.WriteFile(sourcePath, []byte("#!/bin/sh"), 0755))
+	// An existing destination keeps its mode when it's overwritten, unless it's changed explicitly
+	require.NoError(t, os.WriteFile(destinationPath, []byte("old"), 0600))
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	require.NoError(t, e.ExportOutputFile("my_key", sourcePath, destinationPath))
+
+	info, err := os.Stat(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
+
+func TestExportOutputFileWithOptions_Mode(t *testing.T) {
+	tmpDir := t.TempDir()
+	_ = setupEnvman(t)
+
+	sourcePath := filepath.Join(tmpDir, "tool")
+	destinationPath := filepath.Join(tmpDir, "exported-tool")
+	require.NoError(t, os.WriteFile(sourcePath, []byte("binary"), 0600))
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	_, err := e.ExportOutputFileWithOptions("my_key", sourcePath, destinationPath, ExportFileOptions{Mode: 0750})
+
+	require.NoError(t, err)
+	info, err := os.Stat(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0750), info.Mode().Perm())
+}
+
+func TestExportOutputFileWithOptions_DeduplicateKeepsModeOfOtherFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	_ = setupEnvman(t)
+
+	sourcePath := filepath.Join(tmpDir, "tool")
+	deployDir := filepath.Join(tmpDir, "deploy")
+	otherExportPath := filepath.Join(deployDir, "other-tool")
+	require.NoError(t, os.MkdirAll(deployDir, 0700))
+	require.NoError(t, os.WriteFile(sourcePath, []byte("binary"), 0600))
+	require.NoError(t, os.WriteFile(otherExportPath, []byte("binary"), 0600))
+
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+	destinationPath := filepath.Join(deployDir, "tool")
+
+	// When
+	exportedPath, err := e.ExportOutputFileWithOptions("my_key", sourcePath, destinationPath, ExportFileOptions{Deduplicate: true, Mode: 0750})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, destinationPath, exportedPath)
+	info, err := os.Stat(destinationPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	info, err = os.Stat(otherExportPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
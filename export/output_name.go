@@ -0,0 +1,96 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// ErrInvalidOutputName means that the output name is not a valid env var name
+var ErrInvalidOutputName = errors.New("invalid output name")
+
+// invalidOutputNameCharRegex matches the characters that break env vars. Other punctuation (such as `-` and `.`)
+// is accepted by envman, and used by outputs that are read from the environment instead of being referenced in scripts
+// (such as the cache hit outputs of the cache steps).
+var invalidOutputNameCharRegex = regexp.MustCompile(`[\s=[:cntrl:]]`)
+
+// WithOutputPrefix returns a copy of the exporter that adds the prefix to the name of every exported output
+// (such as `XCODE_` for `ARCHIVE_PATH`), to avoid clashing with the outputs of other steps.
+func (e Exporter) WithOutputPrefix(prefix string) Exporter {
+	e.outputPrefix = prefix
+	return e
+}
+
+// WithSanitizedOutputNames returns a copy of the exporter that converts invalid output names to valid env var names
+// (invalid characters are replaced by `_`, and a leading digit is prefixed with `_`), instead of failing the export.
+func (e Exporter) WithSanitizedOutputNames() Exporter {
+	e.sanitizeNames = true
+	return e
+}
+
+// ValidateOutputName checks that the name is a valid env var name: it's not empty, doesn't start with a digit,
+// and doesn't contain whitespace, `=` or control characters. To reference the output in scripts, use only letters,
+// digits and underscores.
+func ValidateOutputName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: the name is empty", ErrInvalidOutputName)
+	case '0' <= name[0] && name[0] <= '9':
+		return fmt.Errorf("%w: %q starts with a digit", ErrInvalidOutputName, name)
+	case invalidOutputNameCharRegex.MatchString(name):
+		return fmt.Errorf("%w: %q contains whitespace, `=` or control characters", ErrInvalidOutputName, name)
+	}
+	return nil
+}
+
+// SanitizeOutputName converts the name to a valid env var name (see ValidateOutputName): invalid characters
+// are replaced by `_`, and a leading digit is prefixed with `_`
+func SanitizeOutputName(name string) string {
+	sanitized := invalidOutputNameCharRegex.ReplaceAllString(name, "_")
+	if sanitized == "" || ('0' <= sanitized[0] && sanitized[0] <= '9') {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+// outputName returns the name the output is exported with: prefixed, and validated or sanitized.
+// It warns when the same output was already exported with a different value by the exporter.
+func (e *Exporter) outputName(key, value string) (string, error) {
+	name := e.outputPrefix + key
+	if err := ValidateOutputName(name); err != nil {
+		if !e.sanitizeNames {
+			return "", err
+		}
+		sanitized := SanitizeOutputName(name)
+		e.logger().Warnf("Output name %q is not a valid env var name, exporting it as %s", name, sanitized)
+		name = sanitized
+	}
+
+	if previous, ok := e.exported.swap(name, value); ok && previous != value {
+		e.logger().Warnf("Output %s was already exported with a different value, the previous value is overwritten", name)
+	}
+	return name, nil
+}
+
+// exportedOutputs records the exported values, it's shared by the copies of an exporter
+type exportedOutputs struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newExportedOutputs() *exportedOutputs {
+	return &exportedOutputs{values: map[string]string{}}
+}
+
+// swap records the value of the output, and returns the previously exported value
+func (o *exportedOutputs) swap(name, value string) (string, bool) {
+	if o == nil {
+		return "", false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	previous, ok := o.values[name]
+	o.values[name] = value
+	return previous, ok
+}
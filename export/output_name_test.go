@@ -0,0 +1,89 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOutputName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "BITRISE_IPA_PATH"},
+		{name: "my_key"},
+		{name: "_private"},
+		{name: "BITRISE_CACHE_HIT__npm-cache-main.1"},
+		{name: "", wantErr: true},
+		{name: "1_KEY", wantErr: true},
+		{name: "MY KEY", wantErr: true},
+		{name: "KEY=VALUE", wantErr: true},
+		{name: "KEY\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOutputName(tt.name)
+			require.Equal(t, tt.wantErr, err != nil, err)
+			if tt.wantErr {
+				require.True(t, errors.Is(err, ErrInvalidOutputName))
+			}
+		})
+	}
+}
+
+func TestSanitizeOutputName(t *testing.T) {
+	require.Equal(t, "MY_KEY", SanitizeOutputName("MY KEY"))
+	require.Equal(t, "_1_KEY", SanitizeOutputName("1_KEY"))
+	require.Equal(t, "KEY_VALUE", SanitizeOutputName("KEY=VALUE"))
+	require.Equal(t, "_", SanitizeOutputName(""))
+}
+
+func TestExportOutput_InvalidName(t *testing.T) {
+	envmanStorePath := setupEnvman(t)
+	e := NewExporter(command.NewFactory(env.NewRepository()))
+
+	require.ErrorIs(t, e.ExportOutput("MY KEY", "value"), ErrInvalidOutputName)
+
+	sanitizing := e.WithSanitizedOutputNames()
+	require.NoError(t, sanitizing.ExportOutput("MY KEY", "value"))
+	requireEnvmanContainsValueForKey(t, "MY_KEY", "value", envmanStorePath)
+}
+
+func TestExportOutput_Prefix(t *testing.T) {
+	envmanStorePath := setupEnvman(t)
+	e := NewExporter(command.NewFactory(env.NewRepository())).WithOutputPrefix("XCODE_")
+
+	require.NoError(t, e.ExportOutput("ARCHIVE_PATH", "/tmp/app.xcarchive"))
+
+	requireEnvmanContainsValueForKey(t, "XCODE_ARCHIVE_PATH", "/tmp/app.xcarchive", envmanStorePath)
+}
+
+func TestExportOutput_Collision(t *testing.T) {
+	setupEnvman(t)
+	logger := &warningRecorder{Logger: log.NewLogger()}
+	e := NewExporter(command.NewFactory(env.NewRepository())).WithLogger(logger)
+
+	require.NoError(t, e.ExportOutput("MY_KEY", "first"))
+	require.NoError(t, e.ExportOutput("MY_KEY", "first"))
+	require.Empty(t, logger.warnings)
+
+	// Copies of the exporter share the exported outputs
+	mapping := e.WithPathMappings()
+	require.NoError(t, mapping.ExportOutputNoExpand("MY_KEY", "second"))
+	require.Equal(t, []string{"Output MY_KEY was already exported with a different value, the previous value is overwritten"}, logger.warnings)
+}
+
+type warningRecorder struct {
+	log.Logger
+	warnings []string
+}
+
+func (l *warningRecorder) Warnf(format string, v ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, v...))
+}
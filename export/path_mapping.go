@@ -0,0 +1,93 @@
+package export
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Bitrise Docker images keep the source, deploy and temp directories under this directory (such as /bitrise/src)
+const bitriseContainerRoot = "/bitrise"
+
+// PathMapping rewrites exported paths under From to the same path under To, for example to convert a path inside
+// a Docker container to the path of the mounted host directory, so that it's valid for the subsequent steps.
+type PathMapping struct {
+	From string
+	To   string
+}
+
+// Reverse returns the mapping for the opposite direction
+func (m PathMapping) Reverse() PathMapping {
+	return PathMapping{From: m.To, To: m.From}
+}
+
+// WithPathMappings returns a copy of the exporter that applies the mappings to the exported file paths
+// (ExportOutputFile, ExportOutputFilesZip and ExportArtifactGroup). The longest matching From prefix wins.
+func (e Exporter) WithPathMappings(mappings ...PathMapping) Exporter {
+	e.pathMappings = append(append([]PathMapping{}, e.pathMappings...), mappings...)
+	return e
+}
+
+func (e *Exporter) mapPath(path string) string {
+	var best PathMapping
+	for _, mapping := range e.pathMappings {
+		from := filepath.Clean(mapping.From)
+		if path != from && !strings.HasPrefix(path, strings.TrimSuffix(from, "/")+"/") {
+			continue
+		}
+		if len(from) > len(best.From) {
+			best = PathMapping{From: from, To: mapping.To}
+		}
+	}
+	if best.From == "" {
+		return path
+	}
+	return filepath.Join(best.To, strings.TrimPrefix(path, best.From))
+}
+
+// DetectContainerPathMappings returns the container to host mappings of the directories mounted under /bitrise
+// when running inside a Docker container, and no mappings otherwise.
+func DetectContainerPathMappings() ([]PathMapping, error) {
+	if _, err := os.Stat("/.dockerenv"); err != nil {
+		return nil, nil
+	}
+
+	mountInfo, err := os.Open("/proc/self/mountinfo")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer mountInfo.Close() //nolint:errcheck
+
+	return parseMountInfo(mountInfo, bitriseContainerRoot)
+}
+
+var mountInfoUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// parseMountInfo returns the bind mounts under containerRoot from /proc/self/mountinfo formatted input
+// (fields: mount ID, parent ID, major:minor, root, mount point, ...).
+func parseMountInfo(r io.Reader, containerRoot string) ([]PathMapping, error) {
+	var mappings []PathMapping
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		root := mountInfoUnescaper.Replace(fields[3])
+		mountPoint := mountInfoUnescaper.Replace(fields[4])
+
+		if mountPoint != containerRoot && !strings.HasPrefix(mountPoint, containerRoot+"/") {
+			continue
+		}
+		if root == "/" || root == mountPoint {
+			continue
+		}
+		mappings = append(mappings, PathMapping{From: mountPoint, To: root})
+	}
+	return mappings, scanner.Err()
+}
@@ -0,0 +1,72 @@
+package export
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter_mapPath(t *testing.T) {
+	e := NewExporter(command.NewFactory(env.NewRepository())).WithPathMappings(
+		PathMapping{From: "/bitrise", To: "/host/bitrise"},
+		PathMapping{From: "/bitrise/deploy/", To: "/host/deploy"},
+	)
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "Not mapped", path: "/tmp/app.apk", want: "/tmp/app.apk"},
+		{name: "Prefix of a path segment", path: "/bitrise-other/app.apk", want: "/bitrise-other/app.apk"},
+		{name: "Mapped", path: "/bitrise/src/app.apk", want: "/host/bitrise/src/app.apk"},
+		{name: "Longest prefix wins", path: "/bitrise/deploy/app.apk", want: "/host/deploy/app.apk"},
+		{name: "Mapped directory itself", path: "/bitrise/deploy", want: "/host/deploy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, e.mapPath(tt.path))
+		})
+	}
+}
+
+func TestExportOutputFile_WithPathMappings(t *testing.T) {
+	tmpDir := t.TempDir()
+	envmanStorePath := setupEnvman(t)
+
+	sourcePath := filepath.Join(tmpDir, "test_file_source")
+	destinationPath := filepath.Join(tmpDir, "test_file_destination")
+	require.NoError(t, ioutil.WriteFile(sourcePath, []byte("hello"), 0700))
+
+	e := NewExporter(command.NewFactory(env.NewRepository())).WithPathMappings(PathMapping{From: tmpDir, To: "/host/dir"})
+	require.NoError(t, e.ExportOutputFile("my_key", sourcePath, destinationPath))
+
+	requireEnvmanContainsValueForKey(t, "my_key", "/host/dir/test_file_destination", envmanStorePath)
+}
+
+func TestPathMapping_Reverse(t *testing.T) {
+	require.Equal(t, PathMapping{From: "/host", To: "/bitrise"}, PathMapping{From: "/bitrise", To: "/host"}.Reverse())
+}
+
+func Test_parseMountInfo(t *testing.T) {
+	mountInfo := `22 1 0:21 / / rw,relatime - overlay overlay rw
+23 22 0:22 / /proc rw,nosuid - proc proc rw
+24 22 8:1 /home/runner/work\040dir /bitrise/src rw,relatime - ext4 /dev/sda1 rw
+25 22 8:1 /home/runner/deploy /bitrise/deploy rw,relatime - ext4 /dev/sda1 rw
+26 22 8:1 / /bitrise/tmp rw,relatime - tmpfs tmpfs rw
+27 22 8:1 /home/runner/cache /root/.cache rw,relatime - ext4 /dev/sda1 rw
+`
+
+	mappings, err := parseMountInfo(strings.NewReader(mountInfo), "/bitrise")
+
+	require.NoError(t, err)
+	require.Equal(t, []PathMapping{
+		{From: "/bitrise/src", To: "/home/runner/work dir"},
+		{From: "/bitrise/deploy", To: "/home/runner/deploy"},
+	}, mappings)
+}
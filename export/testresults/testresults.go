@@ -0,0 +1,197 @@
+// Package testresults exports test results in the layout of the Bitrise Test Reports add-on: every test run
+// is a directory in BITRISE_TEST_RESULT_DIR, with a test-info.json naming the run, the JUnit XML report
+// and the attachments (such as screenshots) of the run.
+package testresults
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// TestResultDirEnvKey is the env var of the directory the Test Reports add-on collects the results of the step from
+const TestResultDirEnvKey = "BITRISE_TEST_RESULT_DIR"
+
+// TestInfoFileName is the file naming the test run in its directory
+const TestInfoFileName = "test-info.json"
+
+// ErrNoTestResultDir means that BITRISE_TEST_RESULT_DIR is not set, for example because the Test Reports add-on
+// is not enabled or the step runs outside of Bitrise
+var ErrNoTestResultDir = errors.New(TestResultDirEnvKey + " is not set")
+
+var unsafeDirNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// TestInfo is the content of TestInfoFileName
+type TestInfo struct {
+	Name string `json:"test-name"`
+}
+
+// Exporter exports test runs into the test result dir of the step
+type Exporter struct {
+	envRepo env.Repository
+}
+
+// NewExporter ...
+func NewExporter(envRepo env.Repository) Exporter {
+	return Exporter{envRepo: envRepo}
+}
+
+// ExportTestRun copies the JUnit XML report and the attachments of a test run into a new directory of
+// BITRISE_TEST_RESULT_DIR, and returns the path of the directory. The name is displayed by the Test Reports add-on,
+// a step exporting multiple runs (such as one per device) should give them distinct names.
+// The directory is assembled next to its final path and renamed, so the add-on never collects a partial test run.
+func (e Exporter) ExportTestRun(name string, junitPath string, attachments []string) (string, error) {
+	resultDir := e.envRepo.Get(TestResultDirEnvKey)
+	if resultDir == "" {
+		return "", ErrNoTestResultDir
+	}
+	if strings.TrimSpace(name) == "" {
+		return "", errors.New("test run name is empty")
+	}
+	if err := validateJUnitReport(junitPath); err != nil {
+		return "", err
+	}
+	for _, attachment := range attachments {
+		if info, err := os.Stat(attachment); err != nil {
+			return "", fmt.Errorf("attachment of test run (%s) is not readable: %w", name, err)
+		} else if info.IsDir() {
+			return "", fmt.Errorf("attachment of test run (%s) is a directory: %s", name, attachment)
+		}
+	}
+
+	if err := os.MkdirAll(resultDir, 0755); err != nil {
+		return "", err
+	}
+	tmpDir, err := os.MkdirTemp(resultDir, ".test-run-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir) //nolint:errcheck
+
+	if err := writeTestRun(tmpDir, name, junitPath, attachments); err != nil {
+		return "", fmt.Errorf("failed to export test run (%s): %w", name, err)
+	}
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return "", err
+	}
+
+	runDir, err := uniqueDir(resultDir, testRunDirName(name))
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpDir, runDir); err != nil {
+		return "", fmt.Errorf("failed to export test run (%s): %w", name, err)
+	}
+	return runDir, nil
+}
+
+func writeTestRun(dir, name, junitPath string, attachments []string) error {
+	info, err := json.Marshal(TestInfo{Name: name})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, TestInfoFileName), info, 0644); err != nil {
+		return err
+	}
+
+	reportName := filepath.Base(junitPath)
+	if !strings.EqualFold(filepath.Ext(reportName), ".xml") {
+		reportName += ".xml"
+	}
+	if err := copyFile(junitPath, filepath.Join(dir, reportName)); err != nil {
+		return err
+	}
+
+	used := map[string]bool{TestInfoFileName: true, reportName: true}
+	for _, attachment := range attachments {
+		attachmentName := uniqueFileName(filepath.Base(attachment), used)
+		if err := copyFile(attachment, filepath.Join(dir, attachmentName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateJUnitReport checks that the report is an XML document with a testsuites or testsuite root element
+func validateJUnitReport(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("JUnit report is not readable: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("JUnit report (%s) is not a valid XML document: %w", path, err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != "testsuites" && start.Name.Local != "testsuite" {
+				return fmt.Errorf("JUnit report (%s) has an unexpected root element: %s", path, start.Name.Local)
+			}
+			return nil
+		}
+	}
+}
+
+func testRunDirName(name string) string {
+	dirName := strings.Trim(unsafeDirNameChars.ReplaceAllString(name, "_"), "._")
+	if dirName == "" {
+		return "test-run"
+	}
+	return dirName
+}
+
+// uniqueDir returns a path in parent with the name, or with a numbered name if it already exists
+func uniqueDir(parent, name string) (string, error) {
+	for i := 1; ; i++ {
+		path := filepath.Join(parent, name)
+		if i > 1 {
+			path = filepath.Join(parent, fmt.Sprintf("%s-%d", name, i))
+		}
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// uniqueFileName numbers the name (before its extension) if it's already used, and marks it as used
+func uniqueFileName(name string, used map[string]bool) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	used[unique] = true
+	return unique
+}
+
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint:errcheck
+
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,83 @@
+package testresults
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+const junitReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites><testsuite name="AppTests" tests="1"><testcase name="testLaunch"/></testsuite></testsuites>`
+
+func TestExportTestRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	resultDir := filepath.Join(tmpDir, "test_results")
+	t.Setenv(TestResultDirEnvKey, resultDir)
+
+	junitPath := filepath.Join(tmpDir, "report.xml")
+	require.NoError(t, os.WriteFile(junitPath, []byte(junitReport), 0600))
+	screenshot := filepath.Join(tmpDir, "screenshot.png")
+	otherScreenshot := filepath.Join(tmpDir, "other", "screenshot.png")
+	require.NoError(t, os.WriteFile(screenshot, []byte("png"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Dir(otherScreenshot), 0755))
+	require.NoError(t, os.WriteFile(otherScreenshot, []byte("other png"), 0600))
+
+	e := NewExporter(env.NewRepository())
+	runDir, err := e.ExportTestRun("iPhone 15 / iOS 17", junitPath, []string{screenshot, otherScreenshot})
+	require.NoError(t, err)
+
+	require.Equal(t, filepath.Join(resultDir, "iPhone_15_iOS_17"), runDir)
+	entries, err := os.ReadDir(runDir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"report.xml", "screenshot-2.png", "screenshot.png", TestInfoFileName}, names)
+
+	content, err := os.ReadFile(filepath.Join(runDir, TestInfoFileName))
+	require.NoError(t, err)
+	var info TestInfo
+	require.NoError(t, json.Unmarshal(content, &info))
+	require.Equal(t, "iPhone 15 / iOS 17", info.Name)
+
+	content, err = os.ReadFile(filepath.Join(runDir, "screenshot-2.png"))
+	require.NoError(t, err)
+	require.Equal(t, "other png", string(content))
+
+	runDir, err = e.ExportTestRun("iPhone 15 / iOS 17", junitPath, nil)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(resultDir, "iPhone_15_iOS_17-2"), runDir, "an exported run is not overwritten")
+}
+
+func TestExportTestRun_Invalid(t *testing.T) {
+	tmpDir := t.TempDir()
+	resultDir := filepath.Join(tmpDir, "test_results")
+	junitPath := filepath.Join(tmpDir, "report.xml")
+	require.NoError(t, os.WriteFile(junitPath, []byte(junitReport), 0600))
+	htmlPath := filepath.Join(tmpDir, "report.html")
+	require.NoError(t, os.WriteFile(htmlPath, []byte("<html></html>"), 0600))
+	e := NewExporter(env.NewRepository())
+
+	t.Setenv(TestResultDirEnvKey, "")
+	_, err := e.ExportTestRun("tests", junitPath, nil)
+	require.ErrorIs(t, err, ErrNoTestResultDir)
+
+	t.Setenv(TestResultDirEnvKey, resultDir)
+	_, err = e.ExportTestRun("tests", htmlPath, nil)
+	require.EqualError(t, err, "JUnit report ("+htmlPath+") has an unexpected root element: html")
+	_, err = e.ExportTestRun("tests", junitPath, []string{filepath.Join(tmpDir, "missing.png")})
+	require.Error(t, err)
+	_, err = e.ExportTestRun(" ", junitPath, nil)
+	require.Error(t, err)
+
+	entries, err := os.ReadDir(resultDir)
+	if !os.IsNotExist(err) {
+		require.NoError(t, err)
+	}
+	require.Empty(t, entries, "failed exports leave nothing behind")
+}
@@ -1,8 +1,12 @@
 package ruby
 
 import (
+	"context"
 	"errors"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
@@ -20,24 +24,73 @@ type CommandFactory interface {
 type commandFactory struct {
 	cmdFactory  command.Factory
 	installType InstallType
+	// workDir and workDirEnvs are set by WithWorkDir
+	workDir     string
+	workDirEnvs []string
+	// commandTimeout is set by WithCommandTimeout
+	commandTimeout time.Duration
+	// rvmSelection is set by WithRVMSelection
+	rvmSelection RVMSelection
+	// ctx is set by WithContext
+	ctx context.Context
+}
+
+// CommandFactoryOption configures the command factory, see NewCommandFactory
+type CommandFactoryOption func(*commandFactory)
+
+// WithWorkDir pins every created command (including the rbenv and asdf rehash commands) to a project directory,
+// so that no command of a sequence runs in the wrong directory. If the directory has a Gemfile (or gems.rb),
+// BUNDLE_GEMFILE points to it as well. Dir and Env of the command options take precedence.
+func WithWorkDir(dir string) CommandFactoryOption {
+	return func(f *commandFactory) {
+		f.workDir = dir
+		for _, name := range []string{"Gemfile", "gems.rb"} {
+			gemfile := filepath.Join(dir, name)
+			if _, err := os.Stat(gemfile); err == nil {
+				f.workDirEnvs = []string{"BUNDLE_GEMFILE=" + gemfile}
+				break
+			}
+		}
+	}
 }
 
 // NewCommandFactory ...
-func NewCommandFactory(cmdFactory command.Factory, cmdLocator env.CommandLocator) (CommandFactory, error) {
+func NewCommandFactory(cmdFactory command.Factory, cmdLocator env.CommandLocator, opts ...CommandFactoryOption) (CommandFactory, error) {
 	installType := rubyInstallType(cmdLocator)
 	if installType == Unknown {
 		return nil, errors.New("unknown Ruby installation")
 	}
 
-	return commandFactory{
+	f := commandFactory{
 		cmdFactory:  cmdFactory,
 		installType: installType,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	return f, nil
 }
 
 // Create ...
 func (f commandFactory) Create(name string, args []string, opts *command.Opts) command.Command {
+	opts = f.withWorkDir(opts)
+	// The timeout depends on the wrapped command, not on the rvm wrapper
+	timeoutNeeded := f.commandTimeout > 0 && needsTimeout(append([]string{name}, args...)...)
+	if f.installType == RVMRuby && f.rvmSelection.Ruby != "" && name != "rvm" {
+		args = append([]string{f.rvmSelection.String(), "do", name}, args...)
+		name = "rvm"
+	}
 	s := append([]string{name}, args...)
+	if timeoutNeeded || f.ctx != nil {
+		timeout := time.Duration(0)
+		if timeoutNeeded {
+			timeout = f.commandTimeout
+		}
+		if sudoNeeded(f.installType, s...) {
+			return newTimeoutCommand("sudo", s, opts, timeout).withContext(f.ctx)
+		}
+		return newTimeoutCommand(name, args, opts, timeout).withContext(f.ctx)
+	}
 	if sudoNeeded(f.installType, s...) {
 		return f.cmdFactory.Create("sudo", s, opts)
 	}
@@ -89,6 +142,24 @@ func (f commandFactory) CreateGemUpdate(gem string, opts *command.Opts) []comman
 	return cmds
 }
 
+// withWorkDir returns a copy of opts with the work dir and its env vars, see WithWorkDir
+func (f commandFactory) withWorkDir(opts *command.Opts) *command.Opts {
+	if f.workDir == "" {
+		return opts
+	}
+
+	scoped := command.Opts{}
+	if opts != nil {
+		scoped = *opts
+	}
+	if scoped.Dir == "" {
+		scoped.Dir = f.workDir
+	}
+	// Later env vars take precedence
+	scoped.Env = append(append([]string{}, f.workDirEnvs...), scoped.Env...)
+	return &scoped
+}
+
 func bundleCommandArgs(args []string, bundlerVersion string) []string {
 	var a []string
 	if bundlerVersion != "" {
@@ -1,6 +1,8 @@
 package ruby
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -149,3 +151,89 @@ func TestFactory_Create(t *testing.T) {
 		})
 	}
 }
+
+type recordingCommandFactory struct {
+	opts []*command.Opts
+}
+
+func (f *recordingCommandFactory) Create(name string, args []string, opts *command.Opts) command.Command {
+	f.opts = append(f.opts, opts)
+	return command.NewFactory(env.NewRepository()).Create(name, args, opts)
+}
+
+func TestFactory_WithWorkDir(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Gemfile"), []byte(""), 0644))
+	cmdFactory := &recordingCommandFactory{}
+	factory := commandFactory{cmdFactory: cmdFactory, installType: RbenvRuby}
+	WithWorkDir(dir)(&factory)
+
+	// When
+	cmds := factory.CreateGemInstall("bundler", "2.4.0", false, false, &command.Opts{Env: []string{"GEM_HOME=/gems"}})
+	factory.Create("bundle", []string{"install"}, &command.Opts{Dir: "/other"})
+
+	// Then
+	require.Len(t, cmds, 2)
+	gemfileEnv := "BUNDLE_GEMFILE=" + filepath.Join(dir, "Gemfile")
+	require.Equal(t, []*command.Opts{
+		{Dir: dir, Env: []string{gemfileEnv, "GEM_HOME=/gems"}},
+		{Dir: dir, Env: []string{gemfileEnv}},
+		{Dir: "/other", Env: []string{gemfileEnv}},
+	}, cmdFactory.opts)
+}
+
+func TestFactory_WithRVMSelection(t *testing.T) {
+	tests := []struct {
+		title       string
+		installType InstallType
+		selection   RVMSelection
+		name        string
+		want        string
+	}{
+		{
+			title:       "RVM ruby with gemset",
+			installType: RVMRuby,
+			selection:   RVMSelection{Ruby: "ruby-3.2.2", Gemset: "my-app"},
+			name:        "bundle",
+			want:        `rvm "ruby-3.2.2@my-app" "do" "bundle" "install"`,
+		},
+		{
+			title:       "RVM ruby without gemset",
+			installType: RVMRuby,
+			selection:   RVMSelection{Ruby: "3.2"},
+			name:        "bundle",
+			want:        `rvm "3.2" "do" "bundle" "install"`,
+		},
+		{
+			title:       "RVM command is not wrapped",
+			installType: RVMRuby,
+			selection:   RVMSelection{Ruby: "3.2"},
+			name:        "rvm",
+			want:        `rvm "install"`,
+		},
+		{
+			title:       "No selection",
+			installType: RVMRuby,
+			name:        "bundle",
+			want:        `bundle "install"`,
+		},
+		{
+			title:       "Other install type",
+			installType: RbenvRuby,
+			selection:   RVMSelection{Ruby: "3.2"},
+			name:        "bundle",
+			want:        `bundle "install"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			factory := commandFactory{cmdFactory: command.NewFactory(env.NewRepository()), installType: tt.installType}
+			WithRVMSelection(tt.selection)(&factory)
+
+			got := factory.Create(tt.name, []string{"install"}, nil)
+
+			require.Equal(t, tt.want, got.PrintableCommandArgs())
+		})
+	}
+}
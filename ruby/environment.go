@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
@@ -37,6 +39,23 @@ const (
 	ASDFRuby
 )
 
+func (t InstallType) String() string {
+	switch t {
+	case SystemRuby:
+		return "system"
+	case BrewRuby:
+		return "brew"
+	case RVMRuby:
+		return "rvm"
+	case RbenvRuby:
+		return "rbenv"
+	case ASDFRuby:
+		return "asdf"
+	default:
+		return "unknown"
+	}
+}
+
 // Environment ...
 type Environment interface {
 	RubyInstallType() InstallType
@@ -45,24 +64,94 @@ type Environment interface {
 	IsSpecifiedASDFRubyInstalled(workdir string) (bool, string, error)
 }
 
+// CachedEnvironment is the Environment returned by NewEnvironment, which remembers its detection results.
+// The methods are not part of Environment, so that other implementations of it (such as mocks) keep compiling.
+type CachedEnvironment interface {
+	Environment
+	IsSpecifiedRVMRubyInstalled(workdir string) (bool, string, error)
+	// InvalidateCache drops the remembered detection results, call it after changing the ruby install
+	// (for example installing a ruby version or a gem).
+	InvalidateCache()
+}
+
+// EnvironmentOption configures the Environment returned by NewEnvironment
+type EnvironmentOption func(*environment)
+
+// WithPrewarmedDetection runs the ruby install type detection when the Environment is created (at step start),
+// and logs the result, so that later calls don't pay for it.
+func WithPrewarmedDetection() EnvironmentOption {
+	return func(m *environment) {
+		m.prewarm = true
+	}
+}
+
+type specifiedRubyResult struct {
+	installed bool
+	version   string
+}
+
+// detectionCache remembers the results of the detections that run external commands,
+// so that steps calling them repeatedly don't pay the subprocess cost every time
+type detectionCache struct {
+	mu            sync.Mutex
+	installType   *InstallType
+	gemList       *string
+	rbenvVersions map[string]specifiedRubyResult
+	asdfVersions  map[string]specifiedRubyResult
+	rvmVersions   map[string]specifiedRubyResult
+}
+
 type environment struct {
 	factory    CommandFactory
 	cmdLocator env.CommandLocator
 	logger     log.Logger
+	prewarm    bool
+	cache      detectionCache
 }
 
-// NewEnvironment ...
-func NewEnvironment(factory CommandFactory, cmdLocator env.CommandLocator, logger log.Logger) Environment {
-	return environment{
+// NewEnvironment creates an Environment. The detection results are cached for the lifetime of the returned instance,
+// see CachedEnvironment.InvalidateCache.
+func NewEnvironment(factory CommandFactory, cmdLocator env.CommandLocator, logger log.Logger, opts ...EnvironmentOption) CachedEnvironment {
+	m := &environment{
 		factory:    factory,
 		cmdLocator: cmdLocator,
 		logger:     logger,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if m.prewarm {
+		startTime := time.Now()
+		installType := m.RubyInstallType()
+		m.logger.Debugf("Ruby install type: %s (detected in %s)", installType, time.Since(startTime).Round(time.Millisecond))
+	}
+
+	return m
+}
+
+// InvalidateCache ...
+func (m *environment) InvalidateCache() {
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+
+	m.cache.installType = nil
+	m.cache.gemList = nil
+	m.cache.rbenvVersions = nil
+	m.cache.asdfVersions = nil
+	m.cache.rvmVersions = nil
 }
 
 // RubyInstallType returns which version manager was used for the ruby install
-func (m environment) RubyInstallType() InstallType {
-	return rubyInstallType(m.cmdLocator)
+func (m *environment) RubyInstallType() InstallType {
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+
+	if m.cache.installType == nil {
+		installType := rubyInstallType(m.cmdLocator)
+		m.cache.installType = &installType
+	}
+	return *m.cache.installType
 }
 
 func rubyInstallType(cmdLocator env.CommandLocator) InstallType {
@@ -90,15 +179,21 @@ func rubyInstallType(cmdLocator env.CommandLocator) InstallType {
 }
 
 // IsGemInstalled returns true if the specified gem version is installed
-func (m environment) IsGemInstalled(gem, version string) (bool, error) {
-	cmd := m.factory.Create("gem", []string{"list"}, nil)
+func (m *environment) IsGemInstalled(gem, version string) (bool, error) {
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
 
-	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
-	if err != nil {
-		return false, fmt.Errorf("%s: error: %s", out, err)
+	if m.cache.gemList == nil {
+		cmd := m.factory.Create("gem", []string{"list"}, nil)
+
+		out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+		if err != nil {
+			return false, fmt.Errorf("%s: error: %s", out, err)
+		}
+		m.cache.gemList = &out
 	}
 
-	return findGemInList(out, gem, version)
+	return findGemInList(*m.cache.gemList, gem, version)
 }
 
 // IsSpecifiedRbenvRubyInstalled checks if the selected ruby version is installed via rbenv.
@@ -110,18 +205,33 @@ func (m environment) IsGemInstalled(gem, version string) (bool, error) {
 // until reaching the root of your filesystem.
 // 4. The global ~/.rbenv/version file. You can modify this file using the rbenv global command.
 // src: https://github.com/rbenv/rbenv#choosing-the-ruby-version
-func (m environment) IsSpecifiedRbenvRubyInstalled(workdir string) (bool, string, error) {
+func (m *environment) IsSpecifiedRbenvRubyInstalled(workdir string) (bool, string, error) {
 	absWorkdir, err := pathutil.NewPathModifier().AbsPath(workdir)
 	if err != nil {
 		return false, "", fmt.Errorf("failed to get absolute path for ( %s ), error: %s", workdir, err)
 	}
 
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+
+	if result, ok := m.cache.rbenvVersions[absWorkdir]; ok {
+		return result.installed, result.version, nil
+	}
+
 	cmd := m.factory.Create("rbenv", []string{"version"}, &command.Opts{Dir: absWorkdir})
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
 		m.logger.Warnf("failed to check installed ruby version, %s error: %s", out, err)
 	}
-	return isSpecifiedRbenvRubyInstalled(out)
+
+	installed, version, err := isSpecifiedRbenvRubyInstalled(out)
+	if err == nil {
+		if m.cache.rbenvVersions == nil {
+			m.cache.rbenvVersions = map[string]specifiedRubyResult{}
+		}
+		m.cache.rbenvVersions[absWorkdir] = specifiedRubyResult{installed: installed, version: version}
+	}
+	return installed, version, err
 }
 
 func isSpecifiedRbenvRubyInstalled(message string) (bool, string, error) {
@@ -156,19 +266,33 @@ func isSpecifiedRbenvRubyInstalled(message string) (bool, string, error) {
 }
 
 // IsSpecifiedASDFRubyInstalled ...
-func (m environment) IsSpecifiedASDFRubyInstalled(workdir string) (isInstalled bool, versionInstalled string, error error) {
+func (m *environment) IsSpecifiedASDFRubyInstalled(workdir string) (isInstalled bool, versionInstalled string, error error) {
 	absWorkdir, err := pathutil.NewPathModifier().AbsPath(workdir)
 	if err != nil {
 		return false, "", fmt.Errorf("failed to get absolute path for ( %s ), error: %s", workdir, err)
 	}
 
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+
+	if result, ok := m.cache.asdfVersions[absWorkdir]; ok {
+		return result.installed, result.version, nil
+	}
+
 	cmd := m.factory.Create("asdf", []string{"current", "ruby"}, &command.Opts{Dir: absWorkdir})
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
 	if err != nil {
 		m.logger.Warnf("failed to check installed ruby version, %s error: %s", out, err)
 	}
 
-	return isSpecifiedASDFRubyInstalled(out)
+	installed, version, err := isSpecifiedASDFRubyInstalled(out)
+	if err == nil {
+		if m.cache.asdfVersions == nil {
+			m.cache.asdfVersions = map[string]specifiedRubyResult{}
+		}
+		m.cache.asdfVersions[absWorkdir] = specifiedRubyResult{installed: installed, version: version}
+	}
+	return installed, version, err
 }
 
 func isSpecifiedASDFRubyInstalled(message string) (isInstalled bool, versionInstalled string, error error) {
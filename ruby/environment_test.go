@@ -310,6 +310,44 @@ func Test_RubyInstallTypeASDF(t *testing.T) {
 	require.Equal(t, installType, ASDFRuby)
 }
 
+func Test_RubyInstallType_IsCachedUntilInvalidated(t *testing.T) {
+	// Given
+	mockCommandLocator := new(mocks.CommandLocator)
+	mockCommandLocator.On("LookPath", "ruby").Return(systemRubyPth, nil)
+	m := NewEnvironment(new(mocks.CommandFactory), mockCommandLocator, log.NewLogger(), WithPrewarmedDetection())
+
+	// When
+	require.Equal(t, SystemRuby, m.RubyInstallType())
+	require.Equal(t, SystemRuby, m.RubyInstallType())
+	m.InvalidateCache()
+	require.Equal(t, SystemRuby, m.RubyInstallType())
+
+	// Then
+	mockCommandLocator.AssertNumberOfCalls(t, "LookPath", 2)
+}
+
+func Test_IsGemInstalled_ReusesGemList(t *testing.T) {
+	// Given
+	gemList := `addressable (2.5.0, 2.4.0, 2.3.8)
+activesupport (5.0.0.1, 4.2.7.1)`
+	mockCommand := new(mocks.Command)
+	mockCommand.On("RunAndReturnTrimmedCombinedOutput").Return(gemList, nil)
+	mockCommandFactory := new(mocks.CommandFactory)
+	mockCommandFactory.On("Create", "gem", []string{"list"}, mock.Anything).Return(mockCommand)
+	m := NewEnvironment(mockCommandFactory, new(mocks.CommandLocator), log.NewLogger())
+
+	// When
+	addressableInstalled, err := m.IsGemInstalled("addressable", "2.4.0")
+	require.NoError(t, err)
+	activesupportInstalled, err := m.IsGemInstalled("activesupport", "4.2.5")
+	require.NoError(t, err)
+
+	// Then
+	require.True(t, addressableInstalled)
+	require.False(t, activesupportInstalled)
+	mockCommand.AssertNumberOfCalls(t, "RunAndReturnTrimmedCombinedOutput", 1)
+}
+
 // Helpers
 
 func createFailingRbenvCommandFactory() CommandFactory {
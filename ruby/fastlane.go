@@ -0,0 +1,159 @@
+package ruby
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/stepconf"
+)
+
+// Env vars read by fastlane
+const (
+	fastlaneSessionEnvKey             = "FASTLANE_SESSION"
+	fastlaneUserEnvKey                = "FASTLANE_USER"
+	fastlanePasswordEnvKey            = "FASTLANE_PASSWORD"
+	fastlaneAppSpecificPasswordEnvKey = "FASTLANE_APPLE_APPLICATION_SPECIFIC_PASSWORD"
+	matchPasswordEnvKey               = "MATCH_PASSWORD"
+	appStoreConnectAPIKeyPathEnvKey   = "APP_STORE_CONNECT_API_KEY_PATH"
+)
+
+// FastlaneCredentials are the credentials of fastlane actions, usually parsed from step inputs.
+// Empty fields are left out of the environment.
+type FastlaneCredentials struct {
+	// AppleID, AppleIDPassword and AppSpecificPassword authenticate with an Apple ID
+	AppleID             string
+	AppleIDPassword     stepconf.Secret
+	AppSpecificPassword stepconf.Secret
+	// Session is the FASTLANE_SESSION of a 2FA-authenticated Apple ID session (the output of `fastlane spaceauth`)
+	Session stepconf.Secret
+	// MatchPassword decrypts the match certificates repository
+	MatchPassword stepconf.Secret
+	// APIKeyID, APIIssuerID and APIPrivateKey (the content of the .p8 file) are an App Store Connect API key.
+	// They are written into a JSON key file, see APP_STORE_CONNECT_API_KEY_PATH.
+	APIKeyID      string
+	APIIssuerID   string
+	APIPrivateKey stepconf.Secret
+	// APIKeyInHouse marks an enterprise (App Store Connect API key of an in-house team) key
+	APIKeyInHouse bool
+}
+
+// FastlaneEnv is the environment of fastlane commands, see NewFastlaneEnv
+type FastlaneEnv struct {
+	// Envs are the `KEY=value` pairs to add to the env of the command, see command.Opts.Env
+	Envs []string
+
+	secrets []string
+	tmpDir  string
+}
+
+// NewFastlaneEnv assembles the env vars of the credentials for fastlane. The App Store Connect API key is written
+// into a file readable only by the current user, call Cleanup to remove it when fastlane finished.
+func NewFastlaneEnv(credentials FastlaneCredentials) (*FastlaneEnv, error) {
+	e := &FastlaneEnv{}
+	e.add(fastlaneUserEnvKey, credentials.AppleID, false)
+	e.add(fastlanePasswordEnvKey, string(credentials.AppleIDPassword), true)
+	e.add(fastlaneAppSpecificPasswordEnvKey, string(credentials.AppSpecificPassword), true)
+	e.add(fastlaneSessionEnvKey, string(credentials.Session), true)
+	e.add(matchPasswordEnvKey, string(credentials.MatchPassword), true)
+
+	keyPath, err := e.writeAPIKey(credentials)
+	if err != nil {
+		return nil, err
+	}
+	e.add(appStoreConnectAPIKeyPathEnvKey, keyPath, false)
+
+	return e, nil
+}
+
+func (e *FastlaneEnv) add(key, value string, secret bool) {
+	if value == "" {
+		return
+	}
+	e.Envs = append(e.Envs, key+"="+value)
+	if secret {
+		e.secrets = append(e.secrets, value)
+	}
+}
+
+// writeAPIKey writes the App Store Connect API key file in fastlane's JSON format, and returns its path
+// (or an empty string if there is no API key)
+func (e *FastlaneEnv) writeAPIKey(credentials FastlaneCredentials) (string, error) {
+	fields := map[string]string{
+		"API key ID":      credentials.APIKeyID,
+		"API issuer ID":   credentials.APIIssuerID,
+		"API private key": string(credentials.APIPrivateKey),
+	}
+	var missing []string
+	for name, value := range fields {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == len(fields) {
+		return "", nil
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("incomplete App Store Connect API key, missing: %s", strings.Join(missing, ", "))
+	}
+	if !strings.Contains(string(credentials.APIPrivateKey), "PRIVATE KEY") {
+		return "", errors.New("the App Store Connect API private key is not the content of a .p8 key file")
+	}
+
+	key, err := json.Marshal(struct {
+		KeyID    string `json:"key_id"`
+		IssuerID string `json:"issuer_id"`
+		Key      string `json:"key"`
+		InHouse  bool   `json:"in_house"`
+	}{
+		KeyID:    credentials.APIKeyID,
+		IssuerID: credentials.APIIssuerID,
+		Key:      string(credentials.APIPrivateKey),
+		InHouse:  credentials.APIKeyInHouse,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	e.tmpDir, err = os.MkdirTemp("", "fastlane-api-key")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(e.tmpDir, "api_key.json")
+	if err := stepconf.Secret(key).WriteToFile(path); err != nil {
+		_ = e.Cleanup()
+		return "", fmt.Errorf("failed to write App Store Connect API key file: %w", err)
+	}
+	e.secrets = append(e.secrets, string(credentials.APIPrivateKey))
+	return path, nil
+}
+
+// Mask replaces the secret values in s (such as the output of fastlane) with asterisks
+func (e *FastlaneEnv) Mask(s string) string {
+	for _, secret := range e.secrets {
+		s = strings.ReplaceAll(s, secret, stepconf.Secret(secret).String())
+	}
+	return s
+}
+
+// String lists the env vars with the secret values masked, for logging
+func (e *FastlaneEnv) String() string {
+	return e.Mask(strings.Join(e.Envs, "\n"))
+}
+
+// Cleanup removes the App Store Connect API key file
+func (e *FastlaneEnv) Cleanup() error {
+	if e.tmpDir == "" {
+		return nil
+	}
+	if err := os.RemoveAll(e.tmpDir); err != nil {
+		return err
+	}
+	e.tmpDir = ""
+	return nil
+}
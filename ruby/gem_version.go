@@ -0,0 +1,153 @@
+package ruby
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// gemRequirement is a RubyGems version requirement, such as `~> 2.1` or `>= 2.0, < 3`
+type gemRequirement []gemRequirementClause
+
+type gemRequirementClause struct {
+	operator string
+	version  string
+}
+
+var requirementClauseRegex = regexp.MustCompile(`^(=|!=|>=|<=|>|<|~>)?\s*([0-9][0-9A-Za-z.]*)$`)
+
+// parseGemRequirement parses a comma separated list of RubyGems requirements. An empty requirement matches any version.
+func parseGemRequirement(requirement string) (gemRequirement, error) {
+	var clauses gemRequirement
+	if strings.TrimSpace(requirement) == "" {
+		return clauses, nil
+	}
+
+	for _, clause := range strings.Split(requirement, ",") {
+		match := requirementClauseRegex.FindStringSubmatch(strings.TrimSpace(clause))
+		if match == nil {
+			return nil, fmt.Errorf("invalid version requirement: %s", clause)
+		}
+		operator := match[1]
+		if operator == "" {
+			operator = "="
+		}
+		clauses = append(clauses, gemRequirementClause{operator: operator, version: match[2]})
+	}
+	return clauses, nil
+}
+
+func (r gemRequirement) isSatisfiedBy(version string) bool {
+	for _, clause := range r {
+		if !clause.isSatisfiedBy(version) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c gemRequirementClause) isSatisfiedBy(version string) bool {
+	cmp := compareGemVersions(version, c.version)
+	switch c.operator {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case "~>":
+		// Pessimistic operator: `~> 2.1` means `>= 2.1, < 3`, `~> 2.1.3` means `>= 2.1.3, < 2.2`
+		return cmp >= 0 && compareGemVersions(version, pessimisticUpperBound(c.version)) < 0
+	default:
+		return false
+	}
+}
+
+func pessimisticUpperBound(version string) string {
+	segments := strings.Split(version, ".")
+	if len(segments) > 1 {
+		segments = segments[:len(segments)-1]
+	}
+	last, err := strconv.Atoi(segments[len(segments)-1])
+	if err != nil {
+		return version
+	}
+	segments[len(segments)-1] = strconv.Itoa(last + 1)
+	return strings.Join(segments, ".")
+}
+
+// compareGemVersions compares two versions segment by segment. Missing segments count as 0,
+// and non-numeric (prerelease) segments sort before numeric ones, like `2.0.0.rc1` < `2.0.0`.
+func compareGemVersions(a, b string) int {
+	aSegments, bSegments := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aSegments) || i < len(bSegments); i++ {
+		aSegment, bSegment := "0", "0"
+		if i < len(aSegments) {
+			aSegment = aSegments[i]
+		}
+		if i < len(bSegments) {
+			bSegment = bSegments[i]
+		}
+
+		aNumber, aErr := strconv.Atoi(aSegment)
+		bNumber, bErr := strconv.Atoi(bSegment)
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNumber != bNumber {
+				if aNumber < bNumber {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return 1
+		case bErr == nil:
+			return -1
+		default:
+			if c := strings.Compare(aSegment, bSegment); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// installedGemVersions parses the output of `gem list --exact <gem>`, such as `bundler (2.4.10, default: 2.3.26)`
+func installedGemVersions(gemList, gem string) []string {
+	for _, line := range strings.Split(gemList, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, gem+" (") || !strings.HasSuffix(line, ")") {
+			continue
+		}
+
+		var versions []string
+		list := strings.TrimSuffix(strings.TrimPrefix(line, gem+" ("), ")")
+		for _, version := range strings.Split(list, ",") {
+			// Platform specific gems are listed like `nokogiri (1.15.4 arm64-darwin)`
+			fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(version), "default:"))
+			if len(fields) > 0 {
+				versions = append(versions, fields[0])
+			}
+		}
+		return versions
+	}
+	return nil
+}
+
+// bestGemVersion returns the highest version satisfying the requirement, or an empty string if none does
+func bestGemVersion(versions []string, requirement gemRequirement) string {
+	best := ""
+	for _, version := range versions {
+		if requirement.isSatisfiedBy(version) && (best == "" || compareGemVersions(version, best) > 0) {
+			best = version
+		}
+	}
+	return best
+}
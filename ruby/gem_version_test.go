@@ -0,0 +1,60 @@
+package ruby
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_gemRequirement_isSatisfiedBy(t *testing.T) {
+	tests := []struct {
+		requirement string
+		version     string
+		want        bool
+	}{
+		{requirement: "", version: "1.0.0", want: true},
+		{requirement: "2.4.10", version: "2.4.10", want: true},
+		{requirement: "= 2.4.10", version: "2.4.1", want: false},
+		{requirement: "!= 2.4.10", version: "2.4.1", want: true},
+		{requirement: "~> 2.1", version: "2.9.3", want: true},
+		{requirement: "~> 2.1", version: "3.0", want: false},
+		{requirement: "~> 2.1.3", version: "2.1.9", want: true},
+		{requirement: "~> 2.1.3", version: "2.2.0", want: false},
+		{requirement: ">= 2.0, < 3", version: "2.5", want: true},
+		{requirement: ">= 2.0, < 3", version: "3.0.0", want: false},
+		{requirement: "> 2.0.0.rc1", version: "2.0.0", want: true},
+		{requirement: "<= 1.17.3", version: "1.17.3", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requirement+" "+tt.version, func(t *testing.T) {
+			requirement, err := parseGemRequirement(tt.requirement)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, requirement.isSatisfiedBy(tt.version))
+		})
+	}
+}
+
+func Test_parseGemRequirement_Invalid(t *testing.T) {
+	_, err := parseGemRequirement("=> 2.0")
+	require.Error(t, err)
+}
+
+func Test_installedGemVersions(t *testing.T) {
+	gemList := `*** LOCAL GEMS ***
+
+bundler (2.4.10, default: 2.3.26)
+bundler-audit (0.9.1)
+nokogiri (1.15.4 arm64-darwin, 1.14.0)`
+
+	require.Equal(t, []string{"2.4.10", "2.3.26"}, installedGemVersions(gemList, "bundler"))
+	require.Equal(t, []string{"1.15.4", "1.14.0"}, installedGemVersions(gemList, "nokogiri"))
+	require.Nil(t, installedGemVersions(gemList, "fastlane"))
+}
+
+func Test_bestGemVersion(t *testing.T) {
+	requirement, err := parseGemRequirement("~> 2.3")
+	require.NoError(t, err)
+
+	require.Equal(t, "2.10.0", bestGemVersion([]string{"2.3.26", "2.10.0", "3.0.0", "1.17.3"}, requirement))
+	require.Equal(t, "", bestGemVersion([]string{"1.17.3"}, requirement))
+}
@@ -0,0 +1,176 @@
+package ruby
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	defaultInstallAttempts  = 3
+	defaultInstallRetryWait = 5 * time.Second
+)
+
+// InstallResult describes the outcome of a gem installation
+type InstallResult struct {
+	Gem string
+	// Requirement is the resolved version requirement, such as `~> 2.4.0` (empty if any version is accepted)
+	Requirement string
+	// Version is the highest installed version satisfying Requirement
+	Version string
+	// AlreadyInstalled is true if a matching version was installed before, and no install command was run
+	AlreadyInstalled bool
+	// Attempts is the number of install attempts (0 if AlreadyInstalled)
+	Attempts int
+}
+
+// Installer installs gems with version resolution: an already installed matching version is reused, otherwise
+// the gem is installed with the commands matching the Ruby install type (including sudo and the rbenv/asdf rehash),
+// retrying failed commands.
+type Installer interface {
+	// InstallBundler installs the target bundler version, such as the `BUNDLED WITH` version of a Gemfile.lock.
+	// A partial version (like `2` or `2.4`) accepts any release of that line, an empty version accepts any version.
+	InstallBundler(targetVersion string) (InstallResult, error)
+	// InstallGem installs a gem version satisfying the RubyGems version constraint (such as `~> 2.1` or `>= 2.0, < 3`).
+	// An empty constraint accepts any version.
+	InstallGem(gem, constraint string) (InstallResult, error)
+}
+
+// InstallerOption configures the Installer returned by NewInstaller
+type InstallerOption func(*installer)
+
+// WithInstallRetries sets the number of attempts of a failing install command and the wait between them
+func WithInstallRetries(attempts int, wait time.Duration) InstallerOption {
+	return func(i *installer) {
+		i.attempts = attempts
+		i.retryWait = wait
+	}
+}
+
+type installer struct {
+	factory   CommandFactory
+	logger    log.Logger
+	attempts  int
+	retryWait time.Duration
+}
+
+// NewInstaller creates an Installer. An Environment created before installing caches the installed gems,
+// call its InvalidateCache (see CachedEnvironment) after the installation.
+func NewInstaller(factory CommandFactory, logger log.Logger, opts ...InstallerOption) Installer {
+	i := &installer{
+		factory:   factory,
+		logger:    logger,
+		attempts:  defaultInstallAttempts,
+		retryWait: defaultInstallRetryWait,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	if i.attempts < 1 {
+		i.attempts = 1
+	}
+	return i
+}
+
+// InstallBundler ...
+func (i *installer) InstallBundler(targetVersion string) (InstallResult, error) {
+	return i.InstallGem("bundler", bundlerRequirement(targetVersion))
+}
+
+// InstallGem ...
+func (i *installer) InstallGem(gem, constraint string) (InstallResult, error) {
+	result := InstallResult{Gem: gem, Requirement: constraint}
+	requirement, err := parseGemRequirement(constraint)
+	if err != nil {
+		return result, err
+	}
+
+	version, err := i.installedVersion(gem, requirement)
+	if err != nil {
+		return result, err
+	}
+	if version != "" {
+		i.logger.Printf("%s %s is already installed", gem, version)
+		result.Version, result.AlreadyInstalled = version, true
+		return result, nil
+	}
+
+	i.logger.Printf("Installing %s %s", gem, constraint)
+	cmds := i.factory.CreateGemInstall(gem, constraint, false, false, nil)
+	for idx := range cmds {
+		idx := idx
+		// A command can only be run once, so each retry creates the install commands again
+		attempts, err := i.runWithRetry(func(attempt int) command.Command {
+			if attempt == 1 {
+				return cmds[idx]
+			}
+			return i.factory.CreateGemInstall(gem, constraint, false, false, nil)[idx]
+		})
+		if attempts > result.Attempts {
+			result.Attempts = attempts
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	version, err = i.installedVersion(gem, requirement)
+	if err != nil {
+		return result, err
+	}
+	if version == "" {
+		return result, fmt.Errorf("%s %s is not installed after running the install commands", gem, constraint)
+	}
+	result.Version = version
+	return result, nil
+}
+
+func (i *installer) installedVersion(gem string, requirement gemRequirement) (string, error) {
+	cmd := i.factory.Create("gem", []string{"list", "--exact", gem}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to list installed %s versions: %s: %w", gem, out, err)
+	}
+	return bestGemVersion(installedGemVersions(out, gem), requirement), nil
+}
+
+// runWithRetry runs the command returned by createCmd for the attempt, until it succeeds or the attempts run out
+func (i *installer) runWithRetry(createCmd func(attempt int) command.Command) (int, error) {
+	var err error
+	for attempt := 1; attempt <= i.attempts; attempt++ {
+		if attempt > 1 {
+			i.logger.Warnf("Retrying in %s (attempt %d/%d)", i.retryWait, attempt, i.attempts)
+			time.Sleep(i.retryWait)
+		}
+
+		cmd := createCmd(attempt)
+		i.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+		var out string
+		out, err = cmd.RunAndReturnTrimmedCombinedOutput()
+		if err == nil {
+			return attempt, nil
+		}
+		err = fmt.Errorf("%s failed: %s: %w", cmd.PrintableCommandArgs(), out, err)
+		i.logger.Warnf("%s", err)
+	}
+	return i.attempts, err
+}
+
+// bundlerRequirement turns a target bundler version into a requirement: a full version (`2.4.10`) is exact,
+// a partial one (`2`, `2.4`) accepts the releases of that line, and explicit requirements are kept as they are.
+func bundlerRequirement(targetVersion string) string {
+	targetVersion = strings.TrimSpace(targetVersion)
+	if targetVersion == "" || !requirementClauseRegex.MatchString(targetVersion) || strings.ContainsAny(targetVersion[:1], "=!<>~") {
+		return targetVersion
+	}
+
+	switch strings.Count(targetVersion, ".") {
+	case 0, 1:
+		return "~> " + targetVersion + ".0"
+	default:
+		return targetVersion
+	}
+}
@@ -0,0 +1,116 @@
+package ruby
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/ruby/mocks"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_bundlerRequirement(t *testing.T) {
+	require.Equal(t, "", bundlerRequirement(""))
+	require.Equal(t, "~> 2.0", bundlerRequirement("2"))
+	require.Equal(t, "~> 2.4.0", bundlerRequirement("2.4"))
+	require.Equal(t, "2.4.10", bundlerRequirement("2.4.10"))
+	require.Equal(t, ">= 2.3", bundlerRequirement(">= 2.3"))
+}
+
+func TestInstaller_InstallBundler_AlreadyInstalled(t *testing.T) {
+	// Given
+	listCmd := new(mocks.Command)
+	listCmd.On("RunAndReturnTrimmedCombinedOutput").Return("bundler (2.4.10, default: 2.3.26)", nil)
+	factory := new(mocks.CommandFactory)
+	factory.On("Create", "gem", []string{"list", "--exact", "bundler"}, mock.Anything).Return(listCmd)
+	installer := NewInstaller(factory, log.NewLogger())
+
+	// When
+	result, err := installer.InstallBundler("2.3")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, InstallResult{Gem: "bundler", Requirement: "~> 2.3.0", Version: "2.3.26", AlreadyInstalled: true}, result)
+	factory.AssertNotCalled(t, "CreateGemInstall", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestInstaller_InstallGem_Retries(t *testing.T) {
+	// Given
+	listCmd := new(mocks.Command)
+	listCmd.On("RunAndReturnTrimmedCombinedOutput").Return("fastlane (2.200.0)", nil).Once()
+	listCmd.On("RunAndReturnTrimmedCombinedOutput").Return("fastlane (2.217.0, 2.200.0)", nil).Once()
+	installCmd := new(mocks.Command)
+	installCmd.On("PrintableCommandArgs").Return(`gem "install" "fastlane"`)
+	installCmd.On("RunAndReturnTrimmedCombinedOutput").Return("network error", errors.New("exit status 2")).Once()
+	installCmd.On("RunAndReturnTrimmedCombinedOutput").Return("", nil).Once()
+	rehashCmd := new(mocks.Command)
+	rehashCmd.On("PrintableCommandArgs").Return(`rbenv "rehash"`)
+	rehashCmd.On("RunAndReturnTrimmedCombinedOutput").Return("", nil)
+
+	factory := new(mocks.CommandFactory)
+	factory.On("Create", "gem", []string{"list", "--exact", "fastlane"}, mock.Anything).Return(listCmd)
+	factory.On("CreateGemInstall", "fastlane", ">= 2.210", false, false, mock.Anything).
+		Return([]command.Command{installCmd, rehashCmd})
+	installer := NewInstaller(factory, log.NewLogger(), WithInstallRetries(3, 0))
+
+	// When
+	result, err := installer.InstallGem("fastlane", ">= 2.210")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, InstallResult{Gem: "fastlane", Requirement: ">= 2.210", Version: "2.217.0", Attempts: 2}, result)
+	installCmd.AssertNumberOfCalls(t, "RunAndReturnTrimmedCombinedOutput", 2)
+	rehashCmd.AssertNumberOfCalls(t, "RunAndReturnTrimmedCombinedOutput", 1)
+}
+
+func TestInstaller_InstallGem_RetriesRealCommand(t *testing.T) {
+	// Given
+	listCmd := new(mocks.Command)
+	listCmd.On("RunAndReturnTrimmedCombinedOutput").Return("", nil).Once()
+	listCmd.On("RunAndReturnTrimmedCombinedOutput").Return("cocoapods (1.15.2)", nil).Once()
+
+	// The install command fails for the first time only
+	marker := filepath.Join(t.TempDir(), "attempted")
+	cmdFactory := command.NewFactory(env.NewRepository())
+	newInstallCmd := func() command.Command {
+		return cmdFactory.Create("sh", []string{"-c", `test -f "$0" || { touch "$0"; echo "network error"; exit 1; }`, marker}, nil)
+	}
+	factory := new(mocks.CommandFactory)
+	factory.On("Create", "gem", []string{"list", "--exact", "cocoapods"}, mock.Anything).Return(listCmd)
+	factory.On("CreateGemInstall", "cocoapods", "", false, false, mock.Anything).Return([]command.Command{newInstallCmd()}).Once()
+	factory.On("CreateGemInstall", "cocoapods", "", false, false, mock.Anything).Return([]command.Command{newInstallCmd()}).Once()
+	installer := NewInstaller(factory, log.NewLogger(), WithInstallRetries(2, 0))
+
+	// When
+	result, err := installer.InstallGem("cocoapods", "")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Attempts)
+	require.Equal(t, "1.15.2", result.Version)
+}
+
+func TestInstaller_InstallGem_Fails(t *testing.T) {
+	// Given
+	listCmd := new(mocks.Command)
+	listCmd.On("RunAndReturnTrimmedCombinedOutput").Return("", nil)
+	installCmd := new(mocks.Command)
+	installCmd.On("PrintableCommandArgs").Return(`gem "install" "xcpretty"`)
+	installCmd.On("RunAndReturnTrimmedCombinedOutput").Return("", errors.New("exit status 1"))
+
+	factory := new(mocks.CommandFactory)
+	factory.On("Create", "gem", []string{"list", "--exact", "xcpretty"}, mock.Anything).Return(listCmd)
+	factory.On("CreateGemInstall", "xcpretty", "", false, false, mock.Anything).Return([]command.Command{installCmd})
+	installer := NewInstaller(factory, log.NewLogger(), WithInstallRetries(2, 0))
+
+	// When
+	result, err := installer.InstallGem("xcpretty", "")
+
+	// Then
+	require.Error(t, err)
+	require.Equal(t, 2, result.Attempts)
+}
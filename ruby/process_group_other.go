@@ -0,0 +1,16 @@
+//go:build windows
+
+package ruby
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(*exec.Cmd) {}
+
+// killProcessGroup only kills the command itself, process groups are not supported on this platform
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+}
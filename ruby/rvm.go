@@ -0,0 +1,162 @@
+package ruby
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+// RVMSelection is a ruby and gemset of RVM, such as ruby-3.2.2@my-app
+type RVMSelection struct {
+	// Ruby is the ruby of RVM, such as ruby-3.2.2, 3.2 or jruby-9.4
+	Ruby string
+	// Gemset is empty for the default gemset
+	Gemset string
+}
+
+// String returns the selection in RVM's format, such as ruby-3.2.2@my-app
+func (s RVMSelection) String() string {
+	if s.Gemset == "" {
+		return s.Ruby
+	}
+	return s.Ruby + "@" + s.Gemset
+}
+
+// ParseRVMCurrent parses the output of `rvm current`, such as `ruby-3.2.2@my-app`
+func ParseRVMCurrent(out string) RVMSelection {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	current := strings.TrimSpace(lines[len(lines)-1])
+	ruby, gemset := splitRVMSelection(current)
+	return RVMSelection{Ruby: ruby, Gemset: gemset}
+}
+
+// splitRVMSelection splits a selection such as ruby-3.2.2@my-app to the ruby and the gemset
+func splitRVMSelection(selection string) (string, string) {
+	parts := strings.SplitN(selection, "@", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// ReadRVMSelection returns the ruby and gemset selected by the project files of the directory: the first
+// .ruby-version and .ruby-gemset files found in the directory or its parents. The fields are empty if there is no
+// such file. A gemset in .ruby-version (such as `3.2.2@my-app`) is used when there is no .ruby-gemset file.
+func ReadRVMSelection(workdir string) (RVMSelection, error) {
+	absWorkdir, err := pathutil.NewPathModifier().AbsPath(workdir)
+	if err != nil {
+		return RVMSelection{}, fmt.Errorf("failed to get absolute path for ( %s ), error: %s", workdir, err)
+	}
+
+	rubyVersion, err := findProjectFile(absWorkdir, ".ruby-version")
+	if err != nil {
+		return RVMSelection{}, err
+	}
+	gemset, err := findProjectFile(absWorkdir, ".ruby-gemset")
+	if err != nil {
+		return RVMSelection{}, err
+	}
+
+	ruby, versionGemset := splitRVMSelection(rubyVersion)
+	if gemset == "" {
+		gemset = versionGemset
+	}
+	return RVMSelection{Ruby: ruby, Gemset: gemset}, nil
+}
+
+// findProjectFile returns the first line of the first file with the name in the directory or its parents,
+// and an empty string if there is no such file
+func findProjectFile(dir, name string) (string, error) {
+	for {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0]), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// IsSpecifiedRVMRubyInstalled checks if the ruby selected for the workdir is installed via RVM.
+// Ruby version is set by
+// 1. The first .ruby-version file found in the workdir or its parent directories
+// 2. The current (default) ruby of RVM, see `rvm current`
+// src: https://rvm.io/workflow/projects
+func (m *environment) IsSpecifiedRVMRubyInstalled(workdir string) (bool, string, error) {
+	absWorkdir, err := pathutil.NewPathModifier().AbsPath(workdir)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to get absolute path for ( %s ), error: %s", workdir, err)
+	}
+
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+
+	if result, ok := m.cache.rvmVersions[absWorkdir]; ok {
+		return result.installed, result.version, nil
+	}
+
+	selection, err := ReadRVMSelection(absWorkdir)
+	if err != nil {
+		return false, "", err
+	}
+
+	var result specifiedRubyResult
+	if selection.Ruby == "" {
+		cmd := m.factory.Create("rvm", []string{"current"}, &command.Opts{Dir: absWorkdir})
+		out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+		if err != nil {
+			return false, "", fmt.Errorf("failed to check the current ruby version, %s error: %s", out, err)
+		}
+		current := ParseRVMCurrent(out)
+		result = specifiedRubyResult{installed: current.Ruby != "" && current.Ruby != "system", version: current.Ruby}
+	} else {
+		cmd := m.factory.Create("rvm", []string{"list", "strings"}, &command.Opts{Dir: absWorkdir})
+		out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+		if err != nil {
+			return false, "", fmt.Errorf("failed to list installed ruby versions, %s error: %s", out, err)
+		}
+		result = specifiedRubyResult{installed: isRVMRubyInstalled(out, selection.Ruby), version: selection.Ruby}
+	}
+
+	if m.cache.rvmVersions == nil {
+		m.cache.rvmVersions = map[string]specifiedRubyResult{}
+	}
+	m.cache.rvmVersions[absWorkdir] = result
+	return result.installed, result.version, nil
+}
+
+// isRVMRubyInstalled reports whether the output of `rvm list strings` (such as `ruby-3.2.2`) contains the ruby.
+// A version without interpreter means MRI ruby, and a partial version (such as 3.2) matches any patch version.
+func isRVMRubyInstalled(installedRubies, ruby string) bool {
+	if ruby != "" && ruby[0] >= '0' && ruby[0] <= '9' {
+		ruby = "ruby-" + ruby
+	}
+	for _, installed := range strings.Split(installedRubies, "\n") {
+		installed = strings.TrimSpace(installed)
+		if installed == ruby || strings.HasPrefix(installed, ruby+".") || strings.HasPrefix(installed, ruby+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// WithRVMSelection runs the commands of an RVM ruby install with the selected ruby and gemset
+// (as `rvm <ruby>@<gemset> do <command>`), as RVM only switches rubies on `cd` in interactive shells.
+// Use ReadRVMSelection to select the ruby and gemset of a project. It has no effect with other install types.
+func WithRVMSelection(selection RVMSelection) CommandFactoryOption {
+	return func(f *commandFactory) {
+		f.rvmSelection = selection
+	}
+}
@@ -0,0 +1,93 @@
+package ruby
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/ruby/mocks"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRVMCurrent(t *testing.T) {
+	require.Equal(t, RVMSelection{Ruby: "ruby-3.2.2", Gemset: "my-app"}, ParseRVMCurrent("ruby-3.2.2@my-app\n"))
+	require.Equal(t, RVMSelection{Ruby: "ruby-3.2.2"}, ParseRVMCurrent("ruby-3.2.2"))
+	require.Equal(t, RVMSelection{Ruby: "system"}, ParseRVMCurrent("Warning! PATH is not properly set up\nsystem"))
+}
+
+func TestReadRVMSelection(t *testing.T) {
+	// Given
+	root := t.TempDir()
+	workdir := filepath.Join(root, "ios", "app")
+	require.NoError(t, os.MkdirAll(workdir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".ruby-version"), []byte("ruby-3.2.2@from-version\n"), 0644))
+
+	// When
+	selection, err := ReadRVMSelection(workdir)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, RVMSelection{Ruby: "ruby-3.2.2", Gemset: "from-version"}, selection)
+
+	// Given
+	require.NoError(t, os.WriteFile(filepath.Join(root, "ios", ".ruby-gemset"), []byte("my-app\n"), 0644))
+
+	// When
+	selection, err = ReadRVMSelection(workdir)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, RVMSelection{Ruby: "ruby-3.2.2", Gemset: "my-app"}, selection)
+	require.Equal(t, "ruby-3.2.2@my-app", selection.String())
+}
+
+func Test_isRVMRubyInstalled(t *testing.T) {
+	installed := "ruby-2.7.8\nruby-3.2.2\njruby-9.4.2.0"
+
+	require.True(t, isRVMRubyInstalled(installed, "3.2.2"))
+	require.True(t, isRVMRubyInstalled(installed, "ruby-3.2"))
+	require.True(t, isRVMRubyInstalled(installed, "jruby-9.4"))
+	require.False(t, isRVMRubyInstalled(installed, "3.1"))
+	require.False(t, isRVMRubyInstalled(installed, "3.2.20"))
+}
+
+func TestIsSpecifiedRVMRubyInstalled(t *testing.T) {
+	// Given
+	workdir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, ".ruby-version"), []byte("3.2.2\n"), 0644))
+	mockCommand := new(mocks.Command)
+	mockCommand.On("RunAndReturnTrimmedCombinedOutput").Return("ruby-2.7.8\nruby-3.2.2", nil)
+	mockCommandFactory := new(mocks.CommandFactory)
+	mockCommandFactory.On("Create", "rvm", []string{"list", "strings"}, mock.Anything).Return(mockCommand)
+	m := NewEnvironment(mockCommandFactory, new(mocks.CommandLocator), log.NewLogger())
+
+	// When
+	isInstalled, version, err := m.IsSpecifiedRVMRubyInstalled(workdir)
+	require.NoError(t, err)
+	_, _, err = m.IsSpecifiedRVMRubyInstalled(workdir)
+	require.NoError(t, err)
+
+	// Then
+	require.True(t, isInstalled)
+	require.Equal(t, "3.2.2", version)
+	mockCommand.AssertNumberOfCalls(t, "RunAndReturnTrimmedCombinedOutput", 1)
+}
+
+func TestIsSpecifiedRVMRubyInstalled_CurrentRuby(t *testing.T) {
+	// Given
+	mockCommand := new(mocks.Command)
+	mockCommand.On("RunAndReturnTrimmedCombinedOutput").Return("ruby-3.2.2@global", nil)
+	mockCommandFactory := new(mocks.CommandFactory)
+	mockCommandFactory.On("Create", "rvm", []string{"current"}, mock.Anything).Return(mockCommand)
+	m := NewEnvironment(mockCommandFactory, new(mocks.CommandLocator), log.NewLogger())
+
+	// When
+	isInstalled, version, err := m.IsSpecifiedRVMRubyInstalled(t.TempDir())
+
+	// Then
+	require.NoError(t, err)
+	require.True(t, isInstalled)
+	require.Equal(t, "ruby-3.2.2", version)
+}
@@ -0,0 +1,88 @@
+package ruby
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// maxStreamingOutputSize is the amount of output (the end of it) captured by RunWithStreaming
+const maxStreamingOutputSize = 1024 * 1024
+
+// WithContext kills every created command (with its process group) when ctx is done, for example to cancel
+// a bundle install on SIGTERM, or to limit a sequence of commands with context.WithTimeout.
+// The cancelled command returns an error wrapping ctx.Err(). Like with WithCommandTimeout, these commands are not
+// created by the wrapped command.Factory.
+func WithContext(ctx context.Context) CommandFactoryOption {
+	return func(f *commandFactory) {
+		f.ctx = ctx
+	}
+}
+
+// RunWithStreaming runs the command returned by create, which receives opts with Stdout and Stderr replaced,
+// and should pass them to one of the methods of a CommandFactory:
+//
+//	out, err := ruby.RunWithStreaming(logger, nil, func(opts *command.Opts) command.Command {
+//		return factory.CreateBundleInstall(bundlerVersion, opts)
+//	})
+//
+// The output (stdout and stderr) is logged line by line as the command runs, and the trimmed output (the last
+// megabyte of it) is returned for parsing, even if the command fails. Use WithContext and WithCommandTimeout
+// on the factory for cancellation and timeouts.
+func RunWithStreaming(logger log.Logger, opts *command.Opts, create func(opts *command.Opts) command.Command) (string, error) {
+	streamingOpts := command.Opts{}
+	if opts != nil {
+		streamingOpts = *opts
+	}
+	writer := &streamingWriter{logger: logger, output: &tailBuffer{limit: maxStreamingOutputSize}}
+	streamingOpts.Stdout = writer
+	streamingOpts.Stderr = writer
+
+	cmd := create(&streamingOpts)
+	logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	err := cmd.Run()
+	writer.flush()
+	return strings.TrimSpace(writer.output.String()), err
+}
+
+// streamingWriter logs the complete lines written to it, and captures the output.
+// It's shared by stdout and stderr, so the writes are serialized.
+type streamingWriter struct {
+	mu     sync.Mutex
+	logger log.Logger
+	output *tailBuffer
+	// line is the incomplete last line
+	line []byte
+}
+
+func (w *streamingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.output.Write(p) //nolint:errcheck
+	w.line = append(w.line, p...)
+	for {
+		idx := bytes.IndexByte(w.line, '\n')
+		if idx == -1 {
+			break
+		}
+		w.logger.Printf("%s", strings.TrimSuffix(string(w.line[:idx]), "\r"))
+		w.line = w.line[idx+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs the last line if it doesn't end with a newline
+func (w *streamingWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.line) > 0 {
+		w.logger.Printf("%s", strings.TrimSuffix(string(w.line), "\r"))
+		w.line = nil
+	}
+}
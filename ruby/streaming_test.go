@@ -0,0 +1,76 @@
+package ruby
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogger struct {
+	log.Logger
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestRunWithStreaming(t *testing.T) {
+	// Given
+	binDir := t.TempDir()
+	script := "#!/bin/sh\necho 'Fetching gem metadata'\necho 'Installing nokogiri' >&2\nprintf 'Bundle complete!'\nexit 3\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "bundle"), []byte(script), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	factory := commandFactory{cmdFactory: command.NewFactory(env.NewRepository()), installType: RbenvRuby}
+	logger := &recordingLogger{Logger: log.NewLogger()}
+
+	// When
+	out, err := RunWithStreaming(logger, nil, func(opts *command.Opts) command.Command {
+		return factory.CreateBundleInstall("", opts)
+	})
+
+	// Then
+	require.Error(t, err)
+	require.Equal(t, "Fetching gem metadata\nInstalling nokogiri\nBundle complete!", out)
+	require.Equal(t, []string{
+		`$ bundle "install" "--jobs" "20" "--retry" "5"`,
+		"Fetching gem metadata",
+		"Installing nokogiri",
+		"Bundle complete!",
+	}, logger.lines)
+}
+
+func TestCommandFactory_WithContext(t *testing.T) {
+	// Given
+	binDir := t.TempDir()
+	script := "#!/bin/sh\necho 'Installing gems'\nsleep 30 &\nsleep 30\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "fastlane"), []byte(script), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	f := commandFactory{cmdFactory: command.NewFactory(env.NewRepository()), installType: RbenvRuby}
+	WithContext(ctx)(&f)
+	logger := &recordingLogger{Logger: log.NewLogger()}
+
+	// When
+	startTime := time.Now()
+	out, err := RunWithStreaming(logger, nil, func(opts *command.Opts) command.Command {
+		return f.Create("fastlane", []string{"ios", "test"}, opts)
+	})
+
+	// Then
+	require.Less(t, time.Since(startTime), 10*time.Second)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Equal(t, "Installing gems", out)
+}
@@ -0,0 +1,270 @@
+package ruby
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// maxTimeoutOutputSize is the amount of output (the end of it) kept for TimeoutError
+const maxTimeoutOutputSize = 64 * 1024
+
+// timeoutErrorOutputLines is the number of output lines included in the TimeoutError message
+const timeoutErrorOutputLines = 20
+
+// WithCommandTimeout limits the runtime of each gem and bundler command (except `bundle exec`, which runs
+// arbitrary tools), so that a hung install (such as a stuck native extension build) fails the step early instead
+// of blocking it until the build times out. On timeout the whole process group of the command is killed,
+// and the command returns a TimeoutError with the end of its output.
+// These commands are not created by the wrapped command.Factory: they inherit the process env,
+// extended with the Env of the command options.
+func WithCommandTimeout(timeout time.Duration) CommandFactoryOption {
+	return func(f *commandFactory) {
+		f.commandTimeout = timeout
+	}
+}
+
+// TimeoutError means that a command was killed because it didn't finish in time
+type TimeoutError struct {
+	Command string
+	Timeout time.Duration
+	// Output is the end of the command's output (stdout and stderr) until it was killed
+	Output string
+}
+
+func (e *TimeoutError) Error() string {
+	msg := fmt.Sprintf("command timed out after %s: %s", e.Timeout, e.Command)
+	if output := lastLines(e.Output, timeoutErrorOutputLines); output != "" {
+		msg += "\nLast output:\n" + output
+	}
+	return msg
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func needsTimeout(cmd ...string) bool {
+	if len(cmd) == 0 {
+		return false
+	}
+	switch cmd[0] {
+	case "gem":
+		return true
+	case "bundle":
+		for _, arg := range cmd[1:] {
+			if !strings.HasPrefix(arg, "_") {
+				return arg != "exec"
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// timeoutCommand runs the command in its own process group, and kills the group when the timeout expires
+// (if there is one) or the context is done (see WithContext)
+type timeoutCommand struct {
+	name    string
+	args    []string
+	opts    command.Opts
+	timeout time.Duration
+	ctx     context.Context
+
+	cmd      *exec.Cmd
+	output   *tailBuffer
+	timer    *time.Timer
+	timedOut atomic.Bool
+	// done is closed when the command exits, to stop watching the context
+	done chan struct{}
+}
+
+func newTimeoutCommand(name string, args []string, opts *command.Opts, timeout time.Duration) *timeoutCommand {
+	c := &timeoutCommand{name: name, args: args, timeout: timeout}
+	if opts != nil {
+		c.opts = *opts
+	}
+	return c
+}
+
+// withContext kills the command when ctx is done
+func (c *timeoutCommand) withContext(ctx context.Context) *timeoutCommand {
+	c.ctx = ctx
+	return c
+}
+
+// PrintableCommandArgs ...
+func (c *timeoutCommand) PrintableCommandArgs() string {
+	s := []string{c.name}
+	for _, arg := range c.args {
+		s = append(s, fmt.Sprintf("\"%s\"", arg))
+	}
+	return strings.Join(s, " ")
+}
+
+// Run ...
+func (c *timeoutCommand) Run() error {
+	if err := c.start(c.opts.Stdout, c.opts.Stderr); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// RunAndReturnExitCode ...
+func (c *timeoutCommand) RunAndReturnExitCode() (int, error) {
+	err := c.Run()
+	if c.cmd == nil || c.cmd.ProcessState == nil {
+		return -1, err
+	}
+	return c.cmd.ProcessState.ExitCode(), err
+}
+
+// RunAndReturnTrimmedOutput ...
+func (c *timeoutCommand) RunAndReturnTrimmedOutput() (string, error) {
+	var stdout bytes.Buffer
+	if err := c.start(&stdout, nil); err != nil {
+		return "", err
+	}
+	err := c.Wait()
+	return strings.TrimSpace(stdout.String()), err
+}
+
+// RunAndReturnTrimmedCombinedOutput ...
+func (c *timeoutCommand) RunAndReturnTrimmedCombinedOutput() (string, error) {
+	var out bytes.Buffer
+	writer := &lockedWriter{w: &out}
+	if err := c.start(writer, writer); err != nil {
+		return "", err
+	}
+	err := c.Wait()
+	return strings.TrimSpace(out.String()), err
+}
+
+// Start ...
+func (c *timeoutCommand) Start() error {
+	return c.start(c.opts.Stdout, c.opts.Stderr)
+}
+
+// Wait ...
+func (c *timeoutCommand) Wait() error {
+	if c.cmd == nil {
+		return errors.New("command is not started")
+	}
+	err := c.cmd.Wait()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	close(c.done)
+
+	if c.timedOut.Load() {
+		return &TimeoutError{Command: c.PrintableCommandArgs(), Timeout: c.timeout, Output: c.output.String()}
+	}
+	if c.ctx != nil && c.ctx.Err() != nil && err != nil {
+		return fmt.Errorf("command cancelled (%s): %w", c.PrintableCommandArgs(), c.ctx.Err())
+	}
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		var errorLines []string
+		if c.opts.ErrorFinder != nil {
+			errorLines = c.opts.ErrorFinder(c.output.String())
+		}
+		return command.NewExitStatusError(c.PrintableCommandArgs(), exitErr, errorLines)
+	}
+	return fmt.Errorf("executing command failed (%s): %w", c.PrintableCommandArgs(), err)
+}
+
+func (c *timeoutCommand) start(stdout, stderr io.Writer) error {
+	if c.ctx != nil && c.ctx.Err() != nil {
+		return fmt.Errorf("command cancelled (%s): %w", c.PrintableCommandArgs(), c.ctx.Err())
+	}
+	c.output = &tailBuffer{limit: maxTimeoutOutputSize}
+	c.cmd = exec.Command(c.name, c.args...)
+	c.cmd.Stdout = teeWriter(stdout, c.output)
+	c.cmd.Stderr = teeWriter(stderr, c.output)
+	c.cmd.Stdin = c.opts.Stdin
+	c.cmd.Dir = c.opts.Dir
+	if c.opts.Env != nil {
+		c.cmd.Env = append(os.Environ(), c.opts.Env...)
+	}
+	setProcessGroup(c.cmd)
+
+	if err := c.cmd.Start(); err != nil {
+		return fmt.Errorf("executing command failed (%s): %w", c.PrintableCommandArgs(), err)
+	}
+	c.done = make(chan struct{})
+	if c.timeout > 0 {
+		c.timer = time.AfterFunc(c.timeout, func() {
+			c.timedOut.Store(true)
+			killProcessGroup(c.cmd)
+		})
+	}
+	if c.ctx != nil {
+		go func(cmd *exec.Cmd, done chan struct{}) {
+			select {
+			case <-c.ctx.Done():
+				killProcessGroup(cmd)
+			case <-done:
+			}
+		}(c.cmd, c.done)
+	}
+	return nil
+}
+
+func teeWriter(w io.Writer, output *tailBuffer) io.Writer {
+	if w == nil {
+		return output
+	}
+	return io.MultiWriter(w, output)
+}
+
+// tailBuffer keeps the last limit bytes written to it, it's safe for concurrent use
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = b.buf[len(b.buf)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// lockedWriter serializes the writes of stdout and stderr into the same writer
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,99 @@
+package ruby
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_needsTimeout(t *testing.T) {
+	require.True(t, needsTimeout("gem", "install", "fastlane"))
+	require.True(t, needsTimeout("bundle", "install"))
+	require.True(t, needsTimeout("bundle", "_2.4.1_", "update"))
+	require.False(t, needsTimeout("bundle", "exec", "fastlane"))
+	require.False(t, needsTimeout("bundle", "_2.4.1_", "exec", "fastlane"))
+	require.False(t, needsTimeout("rbenv", "rehash"))
+}
+
+func TestCommandFactory_WithCommandTimeout(t *testing.T) {
+	// Given
+	binDir := t.TempDir()
+	// The child process keeps the output open, Wait would block until it exits if only gem was killed
+	script := "#!/bin/sh\necho 'Building native extensions. This could take a while...'\nsleep 30 &\nsleep 30\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "gem"), []byte(script), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	factory := commandFactory{
+		cmdFactory:     command.NewFactory(env.NewRepository()),
+		installType:    RbenvRuby,
+		commandTimeout: 500 * time.Millisecond,
+	}
+	cmd := factory.CreateGemInstall("nokogiri", "", false, false, nil)[0]
+
+	// When
+	startTime := time.Now()
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+
+	// Then
+	require.Less(t, time.Since(startTime), 10*time.Second)
+	var timeoutErr *TimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+	require.Equal(t, `gem "install" "nokogiri" "--no-document"`, timeoutErr.Command)
+	require.Contains(t, timeoutErr.Output, "Building native extensions")
+	require.Contains(t, err.Error(), "Building native extensions")
+	require.Equal(t, "Building native extensions. This could take a while...", out)
+}
+
+func TestCommandFactory_WithCommandTimeout_ExitError(t *testing.T) {
+	// Given
+	binDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "bundle"), []byte("#!/bin/sh\necho 'Could not find gem'\nexit 7\n"), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	factory := commandFactory{
+		cmdFactory:     command.NewFactory(env.NewRepository()),
+		installType:    RbenvRuby,
+		commandTimeout: time.Minute,
+	}
+	cmd := factory.CreateBundleInstall("", nil)
+
+	// When
+	exitCode, err := cmd.RunAndReturnExitCode()
+
+	// Then
+	require.Error(t, err)
+	require.Equal(t, 7, exitCode)
+	var timeoutErr *TimeoutError
+	require.False(t, errors.As(err, &timeoutErr))
+}
+
+func TestCommandFactory_WithCommandTimeout_RVMSelection(t *testing.T) {
+	// Given
+	binDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "rvm"), []byte("#!/bin/sh\nsleep 30\n"), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	factory := commandFactory{
+		cmdFactory:     command.NewFactory(env.NewRepository()),
+		installType:    RVMRuby,
+		commandTimeout: 500 * time.Millisecond,
+	}
+	WithRVMSelection(RVMSelection{Ruby: "3.2"})(&factory)
+	cmd := factory.CreateGemInstall("nokogiri", "", false, false, nil)[0]
+
+	// When
+	startTime := time.Now()
+	_, err := cmd.RunAndReturnTrimmedCombinedOutput()
+
+	// Then
+	require.Less(t, time.Since(startTime), 10*time.Second)
+	var timeoutErr *TimeoutError
+	require.True(t, errors.As(err, &timeoutErr))
+	require.Equal(t, `rvm "3.2" "do" "gem" "install" "nokogiri" "--no-document"`, timeoutErr.Command)
+}
@@ -0,0 +1,126 @@
+package stepconf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// InputKeys returns the env var names of the config struct's fields (including the fields of optional sections),
+// conf is a config struct or a pointer to one
+func InputKeys(conf interface{}) ([]string, error) {
+	t := reflect.TypeOf(conf)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, ErrNotStructPtr
+	}
+
+	keys := map[string]bool{}
+	collectInputKeys(t, keys, map[reflect.Type]bool{})
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// collectInputKeys adds the input keys of the struct type and its sections, visited holds the already collected types
+func collectInputKeys(t reflect.Type, keys map[string]bool, visited map[reflect.Type]bool) {
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		if isOptionalSection(t.Field(i)) {
+			if sectionType := t.Field(i).Type.Elem(); !visited[sectionType] {
+				collectInputKeys(sectionType, keys, visited)
+			}
+			continue
+		}
+		tag, ok := t.Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		key, _ := parseTag(tag)
+		keys[key] = true
+	}
+}
+
+// EnvSnapshot is the state of a set of env vars, which can be restored later, see TakeEnvSnapshot
+type EnvSnapshot struct {
+	repo env.Repository
+	// values holds the value of each snapshotted env var, nil if it was not set
+	values map[string]*string
+}
+
+// TakeEnvSnapshot records the env vars of the config struct's inputs (see InputKeys) and the extra keys.
+// Use a separate env.Repository for each goroutine (such as testutil.NewMapRepository) to avoid races on the process env.
+func TakeEnvSnapshot(repo env.Repository, conf interface{}, extraKeys ...string) (EnvSnapshot, error) {
+	keys, err := InputKeys(conf)
+	if err != nil {
+		return EnvSnapshot{}, err
+	}
+
+	set := map[string]string{}
+	for _, e := range repo.List() {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 {
+			set[parts[0]] = parts[1]
+		}
+	}
+
+	snapshot := EnvSnapshot{repo: repo, values: map[string]*string{}}
+	for _, key := range append(keys, extraKeys...) {
+		if value, ok := set[key]; ok {
+			snapshot.values[key] = &value
+		} else {
+			snapshot.values[key] = nil
+		}
+	}
+	return snapshot, nil
+}
+
+// Restore sets the snapshotted env vars to their recorded values, and unsets the ones that were not set
+func (s EnvSnapshot) Restore() error {
+	for key, value := range s.values {
+		var err error
+		if value == nil {
+			err = s.repo.Unset(key)
+		} else {
+			err = s.repo.Set(key, *value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// WithEnv sets the values in the repository, runs fn, and restores the inputs of the config struct and the set values
+// afterwards (even if fn panics). This is useful for running a sub-command or a test with modified inputs.
+func WithEnv(repo env.Repository, conf interface{}, values map[string]string, fn func() error) (err error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	snapshot, err := TakeEnvSnapshot(repo, conf, keys...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if restoreErr := snapshot.Restore(); restoreErr != nil && err == nil {
+			err = restoreErr
+		}
+	}()
+
+	for key, value := range values {
+		if err := repo.Set(key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+	return fn()
+}
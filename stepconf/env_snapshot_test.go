@@ -0,0 +1,67 @@
+package stepconf_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/stepconf"
+	"github.com/bitrise-io/go-steputils/v2/stepconf/testutil"
+)
+
+type snapshotConfig struct {
+	Name    string `env:"name,required"`
+	Number  int    `env:"number"`
+	Section *struct {
+		Token stepconf.Secret `env:"token"`
+	} `env:",section"`
+}
+
+func TestInputKeys(t *testing.T) {
+	keys, err := stepconf.InputKeys(&snapshotConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []string{"name", "number", "token"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("InputKeys() = %v, want %v", keys, want)
+	}
+
+	if _, err := stepconf.InputKeys("not a struct"); !errors.Is(err, stepconf.ErrNotStructPtr) {
+		t.Errorf("InputKeys() error = %v, want %v", err, stepconf.ErrNotStructPtr)
+	}
+}
+
+func TestWithEnv(t *testing.T) {
+	repo := testutil.NewMapRepository(map[string]string{"name": "original", "unrelated": "kept"})
+
+	var cfg snapshotConfig
+	err := stepconf.WithEnv(repo, &cfg, map[string]string{"name": "modified", "number": "2", "extra": "value"}, func() error {
+		return stepconf.NewInputParser(repo).Parse(&cfg)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if cfg.Name != "modified" || cfg.Number != 2 {
+		t.Errorf("parsed config = %+v, want the modified values", cfg)
+	}
+	if want := []string{"name=original", "unrelated=kept"}; !reflect.DeepEqual(repo.List(), want) {
+		t.Errorf("env after WithEnv = %v, want %v", repo.List(), want)
+	}
+}
+
+func TestWithEnv_RestoresOnError(t *testing.T) {
+	repo := testutil.NewMapRepository(map[string]string{"number": "1"})
+	fnErr := errors.New("sub-command failed")
+
+	err := stepconf.WithEnv(repo, snapshotConfig{}, map[string]string{"number": "2", "token": "secret"}, func() error {
+		return fnErr
+	})
+
+	if !errors.Is(err, fnErr) {
+		t.Errorf("WithEnv() error = %v, want %v", err, fnErr)
+	}
+	if want := []string{"number=1"}; !reflect.DeepEqual(repo.List(), want) {
+		t.Errorf("env after WithEnv = %v, want %v", repo.List(), want)
+	}
+}
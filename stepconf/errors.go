@@ -2,7 +2,9 @@ package stepconf
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 )
 
 // ErrNotStructPtr indicates a type is not a pointer to a struct.
@@ -24,3 +26,54 @@ func (e *ParseError) Error() string {
 	segments = append(segments, e.Err.Error())
 	return strings.Join(segments, ": ")
 }
+
+// Unwrap returns the underlying error of the field.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationTimeoutError occurs when a file or dir validation doesn't finish in time,
+// for example because the path is on a hung network mount.
+type ValidationTimeoutError struct {
+	// Input is the env key of the validated input
+	Input   string
+	Path    string
+	Timeout time.Duration
+}
+
+// Error implements builtin errors.Error.
+func (e *ValidationTimeoutError) Error() string {
+	return fmt.Sprintf("validating %s (%s) timed out after %s", e.Input, e.Path, e.Timeout)
+}
+
+// configError is returned when any of the config fields can't be set. Its message lists all the field errors,
+// and errors.Is and errors.As match the field errors (and their underlying errors).
+type configError struct {
+	message string
+	errs    []*ParseError
+}
+
+// Error implements builtin errors.Error.
+func (e *configError) Error() string {
+	return e.message
+}
+
+// As implements the interface used by errors.As.
+func (e *configError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is implements the interface used by errors.Is.
+func (e *configError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
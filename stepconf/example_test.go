@@ -61,6 +61,7 @@ func TestExample(t *testing.T) {
 	for key, value := range envs {
 		envGetter.On("Get", key).Return(value)
 	}
+	envGetter.On("Get", stepconf.InputsFileEnvKey).Return("")
 
 	if err := stepconf.NewInputParser(envGetter).Parse(&cfg); err != nil {
 		t.Errorf("Couldn't create config: %v\n", err)
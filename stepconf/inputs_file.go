@@ -0,0 +1,152 @@
+package stepconf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"gopkg.in/yaml.v3"
+)
+
+// InputsFileEnvKey is the env var pointing to an inputs file, which InputParser.Parse reads the input values from,
+// see ParseFromFile. This helps running steps locally without exporting every input as an env var.
+const InputsFileEnvKey = "STEP_INPUTS_FILE"
+
+// ParseFromFile populates a config struct like InputParser.Parse does, but reads the input values from a YAML or JSON
+// file instead of env vars. The file is a mapping of input keys to values, for example:
+//
+//	project_path: ./ios/App.xcworkspace
+//	build_number: 42
+//	items: [item1, item2]
+//
+// Lists are joined with `|`, the separator of list inputs. All the validations of the struct tags are applied.
+func ParseFromFile(path string, conf interface{}) error {
+	values, err := readInputsFile(path)
+	if err != nil {
+		return err
+	}
+	return parse(conf, inputsFileRepository{values: values})
+}
+
+func readInputsFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inputs file: %w", err)
+	}
+
+	// JSON is valid YAML. The values are read from the nodes, so that they are kept as written (such as `1.10`).
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse inputs file %s: %w", path, err)
+	}
+	if len(document.Content) == 0 {
+		return map[string]string{}, nil
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("failed to parse inputs file %s: not a mapping of input keys to values", path)
+	}
+
+	values := make(map[string]string, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i].Value
+		str, err := inputValueString(mapping.Content[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s in inputs file %s: %w", key, path, err)
+		}
+		values[key] = str
+	}
+	return values, nil
+}
+
+func inputValueString(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			str, err := inputValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, "|"), nil
+	case yaml.AliasNode:
+		return inputValueString(node.Alias)
+	default:
+		return "", fmt.Errorf("nested mappings are not supported")
+	}
+}
+
+// inputsFileRepository is an env.Repository serving the values of an inputs file,
+// falling back to the env vars of `fallback` (if set) for the keys not in the file
+type inputsFileRepository struct {
+	values   map[string]string
+	fallback env.Repository
+}
+
+// List ...
+func (r inputsFileRepository) List() []string {
+	var envs []string
+	for key, value := range r.values {
+		envs = append(envs, key+"="+value)
+	}
+	if r.fallback != nil {
+		for _, keyValue := range r.fallback.List() {
+			if _, ok := r.values[strings.SplitN(keyValue, "=", 2)[0]]; !ok {
+				envs = append(envs, keyValue)
+			}
+		}
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+// Unset ...
+func (r inputsFileRepository) Unset(key string) error {
+	delete(r.values, key)
+	if r.fallback != nil {
+		return r.fallback.Unset(key)
+	}
+	return nil
+}
+
+// Get ...
+func (r inputsFileRepository) Get(key string) string {
+	if value, ok := r.values[key]; ok {
+		return value
+	}
+	if r.fallback != nil {
+		return r.fallback.Get(key)
+	}
+	return ""
+}
+
+// Set ...
+func (r inputsFileRepository) Set(key, value string) error {
+	r.values[key] = value
+	return nil
+}
+
+// NewInputsFileRepository returns a repository serving the values of the inputs file set by InputsFileEnvKey
+// in envRepository (if any), with the env vars of envRepository as a fallback. InputParser.Parse uses it,
+// so that STEP_INPUTS_FILE is read from the parsed repository (and can be mocked), not from the process env.
+func NewInputsFileRepository(envRepository env.Repository) (env.Repository, error) {
+	path := envRepository.Get(InputsFileEnvKey)
+	if path == "" {
+		return envRepository, nil
+	}
+
+	values, err := readInputsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return inputsFileRepository{values: values, fallback: envRepository}, nil
+}
@@ -0,0 +1,117 @@
+package stepconf
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/stepconf/mocks"
+)
+
+type inputsFileConfig struct {
+	Name        string   `env:"name,required"`
+	BuildNumber int      `env:"build_number"`
+	IsUpdate    bool     `env:"is_update"`
+	Items       []string `env:"items"`
+	Workdir     string   `env:"workdir"`
+}
+
+func writeInputsFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write inputs file: %s", err)
+	}
+	return path
+}
+
+func TestParseFromFile(t *testing.T) {
+	want := inputsFileConfig{Name: "Example", BuildNumber: 11, IsUpdate: true, Items: []string{"item1", "item2"}}
+	files := map[string]string{
+		"inputs.yml":  "name: Example\nbuild_number: 11\nis_update: true\nitems: [item1, item2]\n",
+		"inputs.json": `{"name": "Example", "build_number": 11, "is_update": true, "items": "item1|item2"}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			var c inputsFileConfig
+			if err := ParseFromFile(writeInputsFile(t, name, content), &c); err != nil {
+				t.Fatalf("failed to parse inputs file: %s", err)
+			}
+			if !reflect.DeepEqual(c, want) {
+				t.Errorf("expected %#v, got %#v", want, c)
+			}
+		})
+	}
+}
+
+func TestParseFromFile_Invalid(t *testing.T) {
+	var c inputsFileConfig
+	if err := ParseFromFile(writeInputsFile(t, "inputs.yml", "build_number: 11\n"), &c); err == nil {
+		t.Error("no failure when required input is missing from the inputs file")
+	}
+	if err := ParseFromFile(writeInputsFile(t, "inputs.yml", "name:\n  nested: value\n"), &c); err == nil {
+		t.Error("no failure when inputs file has a nested mapping")
+	}
+	if err := ParseFromFile(filepath.Join(t.TempDir(), "missing.yml"), &c); err == nil {
+		t.Error("no failure when inputs file doesn't exist")
+	}
+}
+
+func Test_readInputsFile_KeepsValuesAsWritten(t *testing.T) {
+	content := "xcode_version: 1.10\nsdk_version: 1.0\nbuild_id: 12345678901234567890\nversions: [1.10, 2.0]\n"
+
+	values, err := readInputsFile(writeInputsFile(t, "inputs.yml", content))
+	if err != nil {
+		t.Fatalf("failed to read inputs file: %s", err)
+	}
+
+	want := map[string]string{
+		"xcode_version": "1.10",
+		"sdk_version":   "1.0",
+		"build_id":      "12345678901234567890",
+		"versions":      "1.10|2.0",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("expected %#v, got %#v", want, values)
+	}
+}
+
+func TestParse_InputsFileEnv(t *testing.T) {
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", InputsFileEnvKey).Return(writeInputsFile(t, "inputs.yml", "name: From file\nbuild_number: 11\n"))
+	envGetter.On("Get", "build_number").Return("22")
+	envGetter.On("Get", "workdir").Return("/tmp/workdir")
+	envGetter.On("Get", "is_update").Return("")
+	envGetter.On("Get", "items").Return("")
+
+	var c inputsFileConfig
+	if err := NewInputParser(envGetter).Parse(&c); err != nil {
+		t.Fatalf("failed to parse inputs: %s", err)
+	}
+
+	want := inputsFileConfig{Name: "From file", BuildNumber: 11, Workdir: "/tmp/workdir"}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("expected %#v, got %#v", want, c)
+	}
+}
+
+func TestParse_InputsFileEnvIsReadFromRepository(t *testing.T) {
+	t.Setenv(InputsFileEnvKey, writeInputsFile(t, "inputs.yml", "name: From file\n"))
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", InputsFileEnvKey).Return("")
+	envGetter.On("Get", "name").Return("From repository")
+	envGetter.On("Get", "build_number").Return("")
+	envGetter.On("Get", "workdir").Return("")
+	envGetter.On("Get", "is_update").Return("")
+	envGetter.On("Get", "items").Return("")
+
+	var c inputsFileConfig
+	if err := NewInputParser(envGetter).Parse(&c); err != nil {
+		t.Fatalf("failed to parse inputs: %s", err)
+	}
+
+	if c.Name != "From repository" {
+		t.Errorf("expected the value of the repository, got %s", c.Name)
+	}
+}
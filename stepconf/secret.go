@@ -1,5 +1,16 @@
 package stepconf
 
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
 // Secret variables are not shown in the printed output.
 type Secret string
 
@@ -13,3 +24,88 @@ func (s Secret) String() string {
 	}
 	return secret
 }
+
+// Format implements fmt.Formatter, so that no formatting verb (such as %q, %x or %#v) reveals the value.
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", s.String())
+		return
+	}
+	io.WriteString(f, s.String()) //nolint:errcheck
+}
+
+// MarshalJSON implements json.Marshaler, the value is masked like in the printed output.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Equal compares the secrets in constant time, so that the comparison doesn't leak the value through timing.
+func (s Secret) Equal(other Secret) bool {
+	return subtle.ConstantTimeCompare([]byte(s), []byte(other)) == 1
+}
+
+// WriteToFile writes the secret into a file that only the current user can read (mode 0600), for tools that read
+// credentials (such as API keys) from a file. The permissions of an existing file are restricted before writing.
+func (s Secret) WriteToFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := file.Chmod(0600); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if _, err := file.WriteString(string(s)); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// NamedSecret is a Secret that remembers the input it was parsed from,
+// so that errors and logs can refer to the input without revealing the value.
+type NamedSecret struct {
+	// Input is the env key of the input, set by the parser
+	Input string
+	Secret
+}
+
+// SecretSlice is a list of secrets, parsed from a comma or newline separated input.
+type SecretSlice []Secret
+
+// UnmarshalInput implements InputUnmarshaler. Items are trimmed, and empty items are dropped.
+func (s *SecretSlice) UnmarshalInput(value string) error {
+	var secrets SecretSlice
+	for _, line := range strings.Split(value, "\n") {
+		for _, item := range strings.Split(line, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				secrets = append(secrets, Secret(item))
+			}
+		}
+	}
+	*s = secrets
+	return nil
+}
+
+var (
+	secretType      = reflect.TypeOf(Secret(""))
+	namedSecretType = reflect.TypeOf(NamedSecret{})
+	secretSliceType = reflect.TypeOf(SecretSlice{})
+)
+
+// isSecretField reports whether the field holds secrets, whose values must not show up in parse errors
+func isSecretField(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == secretType || t == namedSecretType || t == secretSliceType
+}
+
+// redactError masks the secret value in the message of err. The returned error doesn't wrap err,
+// so that the value can't be revealed by unwrapping it either.
+func redactError(err error, value string) error {
+	if value == "" {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), value, secret))
+}
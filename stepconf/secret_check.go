@@ -0,0 +1,155 @@
+package stepconf
+
+import (
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// ProbableSecret is a non-Secret input whose value looks like a credential, see FindProbableSecrets
+type ProbableSecret struct {
+	// Field is the name of the config struct field, such as `Token` (or `Section.Field` for optional sections)
+	Field string
+	// Input is the env var the field is parsed from
+	Input string
+	// Reason describes why the value looks like a credential, without the value
+	Reason string
+}
+
+type secretPattern struct {
+	regex  *regexp.Regexp
+	reason string
+}
+
+// secretPatterns are the formats of well-known credentials
+var secretPatterns = []secretPattern{
+	{regexp.MustCompile(`\b(ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{30,}`), "GitHub token prefix"},
+	{regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{30,}`), "GitHub token prefix"},
+	{regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`), "GitLab token prefix"},
+	{regexp.MustCompile(`\b(AKIA|ASIA)[A-Z0-9]{16}\b`), "AWS access key ID prefix"},
+	{regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), "Slack token prefix"},
+	{regexp.MustCompile(`\bAIza[A-Za-z0-9_-]{35}\b`), "Google API key prefix"},
+	{regexp.MustCompile(`\b(sk|rk)_live_[A-Za-z0-9]{20,}`), "Stripe key prefix"},
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`), "private key"},
+}
+
+const (
+	// minSecretEntropy is the Shannon entropy (bits per character) above which a token looks random.
+	// It's above the maximum of hex strings (4 bits), so that checksums and commit hashes are not reported.
+	minSecretEntropy   = 4.2
+	minSecretLength    = 24
+	secretTokenCharset = `^[A-Za-z0-9+/=_.-]+$`
+)
+
+var secretTokenRegex = regexp.MustCompile(secretTokenCharset)
+
+// FindProbableSecrets returns the string inputs of a parsed config (a pointer to a config struct) that are not
+// Secrets, but whose value looks like a credential: it has the prefix of a well-known token (such as ghp_ or AKIA),
+// it's a private key, a URL with a password, or a long random-looking (high entropy) token.
+// It's a heuristic, so it's opt-in: use it to nudge users towards passing credentials as secret inputs,
+// before the values end up in logs.
+func FindProbableSecrets(conf interface{}) []ProbableSecret {
+	v := reflect.ValueOf(conf)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	return findProbableSecrets(v, "")
+}
+
+// WarnProbableSecrets logs a warning for each probable secret of the config (see FindProbableSecrets)
+// and returns them
+func WarnProbableSecrets(conf interface{}, logger log.Logger) []ProbableSecret {
+	secrets := FindProbableSecrets(conf)
+	for _, secret := range secrets {
+		logger.Warnf("The value of %s looks like a credential (%s), but the input is not a secret. "+
+			"Use a Bitrise Secret (and a sensitive step input) for credentials, so that they are not exposed in the logs.",
+			secret.Input, secret.Reason)
+	}
+	return secrets
+}
+
+func findProbableSecrets(v reflect.Value, prefix string) []ProbableSecret {
+	t := v.Type()
+
+	var secrets []ProbableSecret
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isOptionalSection(field) {
+			if !v.Field(i).IsNil() {
+				secrets = append(secrets, findProbableSecrets(v.Field(i).Elem(), prefix+field.Name+".")...)
+			}
+			continue
+		}
+		tag, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		if isSecretField(field.Type) {
+			continue
+		}
+
+		var values []string
+		switch {
+		case field.Type.Kind() == reflect.String:
+			values = []string{v.Field(i).String()}
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String:
+			for j := 0; j < v.Field(i).Len(); j++ {
+				values = append(values, v.Field(i).Index(j).String())
+			}
+		}
+
+		key, _ := parseTag(tag)
+		for _, value := range values {
+			if reason := probableSecretReason(value); reason != "" {
+				secrets = append(secrets, ProbableSecret{Field: prefix + field.Name, Input: key, Reason: reason})
+				break
+			}
+		}
+	}
+	return secrets
+}
+
+// probableSecretReason returns why the value looks like a credential, or an empty string
+func probableSecretReason(value string) string {
+	for _, pattern := range secretPatterns {
+		if pattern.regex.MatchString(value) {
+			return pattern.reason
+		}
+	}
+
+	if u, err := url.Parse(strings.TrimSpace(value)); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return "URL with a password"
+		}
+	}
+
+	token := strings.TrimSpace(value)
+	if len(token) >= minSecretLength && secretTokenRegex.MatchString(token) && !strings.Contains(token, "/") &&
+		shannonEntropy(token) >= minSecretEntropy {
+		return "long random-looking value"
+	}
+	return ""
+}
+
+// shannonEntropy returns the entropy of the characters of s in bits per character
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+
+	var entropy float64
+	length := float64(len([]rune(s)))
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,129 @@
+package stepconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/stepconf/mocks"
+)
+
+func TestSecret_Format(t *testing.T) {
+	s := Secret("my secret")
+	for _, format := range []string{"%v", "%s", "%q", "%x", "%#v", "%+v", "%d"} {
+		if got := fmt.Sprintf(format, s); strings.Contains(got, "my secret") || strings.Contains(got, fmt.Sprintf("%x", "my secret")) {
+			t.Errorf("%s reveals the secret: %s", format, got)
+		}
+	}
+	if got := fmt.Sprintf("%v", SecretSlice{"a1", "b2"}); got != "[***** *****]" {
+		t.Errorf("expected %s, got %s", "[***** *****]", got)
+	}
+}
+
+func TestSecret_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(struct {
+		Token   Secret
+		Empty   Secret
+		Secrets SecretSlice
+	}{Token: "my secret", Secrets: SecretSlice{"a1"}})
+	if err != nil {
+		t.Fatalf("failed to marshal: %s", err)
+	}
+	if want := `{"Token":"*****","Empty":"","Secrets":["*****"]}`; string(b) != want {
+		t.Errorf("expected %s, got %s", want, b)
+	}
+}
+
+func TestSecret_Equal(t *testing.T) {
+	if !Secret("abc").Equal("abc") {
+		t.Error("equal secrets are not equal")
+	}
+	if Secret("abc").Equal("abd") || Secret("abc").Equal("ab") {
+		t.Error("different secrets are equal")
+	}
+}
+
+func TestSecret_WriteToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.p8")
+	if err := os.WriteFile(path, []byte("previous content, longer than the secret"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	if err := Secret("my secret").WriteToFile(path); err != nil {
+		t.Fatalf("failed to write secret: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err)
+	}
+	if string(content) != "my secret" {
+		t.Errorf("expected %s, got %s", "my secret", content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %s", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode %o, got %o", 0600, info.Mode().Perm())
+	}
+}
+
+func TestParse_Secrets(t *testing.T) {
+	var c struct {
+		Token   NamedSecret `env:"api_token,required"`
+		Secrets SecretSlice `env:"secrets"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "api_token").Return("my secret")
+	envGetter.On("Get", "secrets").Return("a1, b2\nc3,\n")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Fatalf("failed to parse secrets: %s", err)
+	}
+	if c.Token.Input != "api_token" || c.Token.Secret != "my secret" {
+		t.Errorf("unexpected named secret: %#v", c.Token)
+	}
+	if want := (SecretSlice{"a1", "b2", "c3"}); !reflect.DeepEqual(c.Secrets, want) {
+		t.Errorf("expected %v, got %v", []string{"a1", "b2", "c3"}, []Secret(c.Secrets))
+	}
+}
+
+func TestParse_NamedSecretPointer(t *testing.T) {
+	var c struct {
+		Token *NamedSecret `env:"api_token,required"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "api_token").Return("my secret")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Fatalf("failed to parse secrets: %s", err)
+	}
+	if c.Token == nil || c.Token.Input != "api_token" || c.Token.Secret != "my secret" {
+		t.Errorf("unexpected named secret: %#v", c.Token)
+	}
+}
+
+func TestParse_SecretNotInError(t *testing.T) {
+	var c struct {
+		Pin Secret `env:"pin,range[0..10]"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "pin").Return("12345")
+
+	err := parse(&c, envGetter)
+	if err == nil {
+		t.Fatal("no failure when secret is out of range")
+	}
+	if strings.Contains(err.Error(), "12345") {
+		t.Errorf("error reveals the secret: %s", err)
+	}
+}
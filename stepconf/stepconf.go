@@ -1,13 +1,17 @@
 package stepconf
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-utils/v2/env"
 )
@@ -19,11 +23,48 @@ const (
 	rangeMaxBracketGroupName = "maxbr"
 	rangeRegex               = `range(?P<` + rangeMinBracketGroupName + `>\[|\])(?P<` + rangeMinimumGroupName + `>.*?)\.\.(?P<` + rangeMaximumGroupName + `>.*?)(?P<` + rangeMaxBracketGroupName + `>\[|\])`
 	multilineConstraintName  = "multiline"
+	// tempFileConstraintName writes the input value to a temporary file and sets the string field to the file's path,
+	// so that large inputs (such as embedded scripts or certificates) can be passed to tools as a file.
+	// Removing the file is the caller's responsibility.
+	tempFileConstraintName = "tempfile"
+	// base64ConstraintName decodes the base64 encoded input value into a []byte or string field.
+	// Whitespace (such as the line breaks of wrapped base64 output) is ignored.
+	base64ConstraintName = "b64"
+	// maxBase64DecodedSize limits the size of base64 decoded inputs
+	maxBase64DecodedSize = 10 * 1024 * 1024
+	// allowEmptyConstraintName accepts entries without a value (such as `KEY=`) in map[string]string fields
+	allowEmptyConstraintName = "allowempty"
+	// hiddenTagOption leaves machine-only inputs (such as internal URLs and feature flags) out of Print. The input is
+	// still parsed and validated, and the option can follow any other option, such as `env:"api_url,required,hidden"`.
+	hiddenTagOption = "hidden"
+	// sectionTagOption marks an optional section (`env:",section"`), see parseFields
+	sectionTagOption = "section"
 )
 
 // parse populates a struct with the retrieved values from environment variables
 // described by struct tags and applies the defined validations.
 func parse(conf interface{}, envRepository env.Repository) error {
+	return parseWithOptions(conf, envRepository, parseOptions{})
+}
+
+// parseOptions configures how the validations are applied while parsing
+type parseOptions struct {
+	// ctx (if set) makes the file and dir validations cancellable, see checkPathWithContext
+	ctx                   context.Context
+	pathValidationTimeout time.Duration
+	// validOptions (if set) supplies the value options of optfrom tags, see ValidOptionsProvider
+	validOptions ValidOptionsProvider
+}
+
+// validatePath applies the file or dir validation of the given input
+func (o parseOptions) validatePath(key, path string, dir bool) error {
+	if o.ctx == nil {
+		return checkPath(path, dir)
+	}
+	return checkPathWithContext(o.ctx, key, path, dir, o.pathValidationTimeout)
+}
+
+func parseWithOptions(conf interface{}, envRepository env.Repository, opts parseOptions) error {
 	c := reflect.ValueOf(conf)
 	if c.Kind() != reflect.Ptr {
 		return ErrNotStructPtr
@@ -32,10 +73,52 @@ func parse(conf interface{}, envRepository env.Repository) error {
 	if c.Kind() != reflect.Struct {
 		return ErrNotStructPtr
 	}
+
+	if provider, ok := conf.(ValidOptionsProvider); ok {
+		opts.validOptions = provider
+	}
+	errs := parseFields(c, envRepository, opts, map[reflect.Type]bool{c.Type(): true})
+	if len(errs) > 0 {
+		errorString := "failed to parse config:"
+		for _, err := range errs {
+			errorString += fmt.Sprintf("\n- %s", err)
+		}
+
+		errorString += fmt.Sprintf("\n\n%s", toString(conf))
+		return &configError{message: errorString, errs: errs}
+	}
+
+	return nil
+}
+
+// parseFields sets the env tagged fields of a struct value. Pointer-to-struct fields tagged with `env:",section"` are
+// optional sections: they stay nil if none of their inputs are set, otherwise they are allocated, populated and
+// validated. sections holds the section types being parsed, as a section can't contain itself.
+func parseFields(c reflect.Value, envRepository env.Repository, opts parseOptions, sections map[reflect.Type]bool) []*ParseError {
 	t := c.Type()
 
 	var errs []*ParseError
 	for i := 0; i < c.NumField(); i++ {
+		if isOptionalSection(t.Field(i)) {
+			sectionType := t.Field(i).Type.Elem()
+			if sections[sectionType] {
+				errs = append(errs, &ParseError{t.Field(i).Name, "", errors.New("recursive input section")})
+				continue
+			}
+			if !isAnyInputSet(sectionType, envRepository, map[reflect.Type]bool{}) {
+				continue
+			}
+
+			sections[sectionType] = true
+			section := reflect.New(sectionType)
+			for _, err := range parseFields(section.Elem(), envRepository, opts, sections) {
+				err.Field = t.Field(i).Name + "." + err.Field
+				errs = append(errs, err)
+			}
+			delete(sections, sectionType)
+			c.Field(i).Set(section)
+			continue
+		}
 		tag, ok := t.Field(i).Tag.Lookup("env")
 		if !ok {
 			continue
@@ -43,33 +126,111 @@ func parse(conf interface{}, envRepository env.Repository) error {
 		key, constraint := parseTag(tag)
 		value := envRepository.Get(key)
 
-		if err := setField(c.Field(i), value, constraint); err != nil {
-			errs = append(errs, &ParseError{t.Field(i).Name, value, err})
+		field := c.Field(i)
+		if field.Kind() == reflect.Ptr && field.Type().Elem() == namedSecretType {
+			// Pointers are allocated like by setField, but the input name is set on the element
+			if field.IsNil() {
+				field.Set(reflect.New(namedSecretType))
+			}
+			field = field.Elem()
 		}
-	}
-	if len(errs) > 0 {
-		errorString := "failed to parse config:"
-		for _, err := range errs {
-			errorString += fmt.Sprintf("\n- %s", err)
+		if field.Type() == namedSecretType {
+			field.FieldByName("Input").SetString(key)
+			field = field.FieldByName("Secret")
 		}
 
-		errorString += fmt.Sprintf("\n\n%s", toString(conf))
-		return errors.New(errorString)
+		validatePath := func(path string, dir bool) error {
+			return opts.validatePath(key, path, dir)
+		}
+		err := setField(field, value, constraint, validatePath)
+		if err == nil {
+			err = validateValidOptions(t.Field(i), value, envRepository, opts.validOptions)
+		}
+		if err != nil {
+			if isSecretField(field.Type()) {
+				errs = append(errs, &ParseError{t.Field(i).Name, Secret(value).String(), redactError(err, value)})
+				continue
+			}
+			errs = append(errs, &ParseError{t.Field(i).Name, value, err})
+		}
 	}
 
-	return nil
+	return errs
+}
+
+// isOptionalSection reports whether the field is an exported pointer-to-struct field tagged with `env:",section"`
+func isOptionalSection(field reflect.StructField) bool {
+	return field.Tag.Get("env") == ","+sectionTagOption && field.PkgPath == "" &&
+		field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct
+}
+
+// isAnyInputSet reports whether any of the env tagged fields of the struct type (including nested sections) has a value.
+// visited holds the already checked section types, so that recursive sections are checked only once.
+func isAnyInputSet(t reflect.Type, envRepository env.Repository, visited map[reflect.Type]bool) bool {
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		if isOptionalSection(t.Field(i)) {
+			sectionType := t.Field(i).Type.Elem()
+			if !visited[sectionType] && isAnyInputSet(sectionType, envRepository, visited) {
+				return true
+			}
+			continue
+		}
+		tag, ok := t.Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		key, _ := parseTag(tag)
+		if envRepository.Get(key) != "" {
+			return true
+		}
+	}
+	return false
 }
 
-// parseTag splits a struct field's env tag into its name and option.
+// parseTag splits a struct field's env tag into its name and option. The hidden option is dropped, see isHiddenTag.
 func parseTag(tag string) (string, string) {
+	tag = strings.TrimSuffix(tag, ","+hiddenTagOption)
 	if idx := strings.Index(tag, ","); idx != -1 {
 		return tag[:idx], tag[idx+1:]
 	}
 	return tag, ""
 }
 
-func setField(field reflect.Value, value, constraint string) error {
-	if err := validateConstraint(value, constraint); err != nil {
+// isHiddenTag reports whether a struct field's env tag has the hidden option
+func isHiddenTag(tag string) bool {
+	return strings.HasSuffix(tag, ","+hiddenTagOption)
+}
+
+// InputUnmarshaler is implemented by types that parse their own input value, such as typed enum constants:
+//
+//	type ExportMethod string
+//
+//	func (m *ExportMethod) UnmarshalInput(value string) error {
+//		switch ExportMethod(value) {
+//		case AppStore, AdHoc:
+//			*m = ExportMethod(value)
+//			return nil
+//		}
+//		return fmt.Errorf("unknown export method: %s", value)
+//	}
+type InputUnmarshaler interface {
+	UnmarshalInput(value string) error
+}
+
+// Unmarshaler is implemented by domain types that parse and validate their own env value, such as version
+// constraints, key-value maps or cron expressions. UnmarshalEnv replaces the built-in parsing of the field's kind,
+// while the tag options (such as required) still apply. It is only called for non-empty values.
+// If a type implements both Unmarshaler and InputUnmarshaler, UnmarshalEnv is used.
+type Unmarshaler interface {
+	UnmarshalEnv(value string) error
+}
+
+// Fields of io.Reader type are set to a reader of the input value, without copying it
+var readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+
+func setField(field reflect.Value, value, constraint string, validatePath func(path string, dir bool) error) error {
+	if err := validateConstraint(value, constraint, validatePath); err != nil {
 		return err
 	}
 
@@ -85,6 +246,35 @@ func setField(field reflect.Value, value, constraint string) error {
 		field = field.Elem()
 	}
 
+	if field.Type() == readerType {
+		field.Set(reflect.ValueOf(strings.NewReader(value)))
+		return nil
+	}
+
+	if constraint == base64ConstraintName {
+		return setBase64Field(field, value)
+	}
+
+	if constraint == tempFileConstraintName {
+		if field.Kind() != reflect.String {
+			return fmt.Errorf("%s option is only supported for string fields", tempFileConstraintName)
+		}
+		path, err := writeTempFile(value)
+		if err != nil {
+			return err
+		}
+		value = path
+	}
+
+	if field.CanAddr() && field.Addr().CanInterface() {
+		switch unmarshaler := field.Addr().Interface().(type) {
+		case Unmarshaler:
+			return unmarshaler.UnmarshalEnv(value)
+		case InputUnmarshaler:
+			return unmarshaler.UnmarshalInput(value)
+		}
+	}
+
 	switch field.Kind() { //nolint:exhaustive
 	case reflect.String:
 		field.SetString(value)
@@ -113,6 +303,8 @@ func setField(field reflect.Value, value, constraint string) error {
 			return errors.New("can't convert to float")
 		}
 		field.SetFloat(f)
+	case reflect.Map:
+		return setMapField(field, value, constraint == allowEmptyConstraintName)
 	case reflect.Slice:
 		if constraint == multilineConstraintName {
 			field.Set(reflect.ValueOf(strings.Split(value, "\n")))
@@ -125,7 +317,92 @@ func setField(field reflect.Value, value, constraint string) error {
 	return nil
 }
 
-func validateConstraint(value, constraint string) error {
+func setBase64Field(field reflect.Value, value string) error {
+	isBytes := field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8
+	if !isBytes && field.Kind() != reflect.String {
+		return fmt.Errorf("%s option is only supported for []byte and string fields", base64ConstraintName)
+	}
+
+	encoded := strings.Join(strings.Fields(value), "")
+	if size := base64.StdEncoding.DecodedLen(len(encoded)); size > maxBase64DecodedSize {
+		return fmt.Errorf("base64 decoded value is too large (%d bytes, maximum: %d bytes)", size, maxBase64DecodedSize)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		var corruptErr base64.CorruptInputError
+		if errors.As(err, &corruptErr) {
+			return fmt.Errorf("invalid base64 value: illegal data at position %d (whitespace excluded)", int64(corruptErr))
+		}
+		return fmt.Errorf("invalid base64 value: %w", err)
+	}
+
+	if isBytes {
+		field.SetBytes(decoded)
+	} else {
+		field.SetString(string(decoded))
+	}
+	return nil
+}
+
+// setMapField parses `KEY=value` entries into a map[string]string field, for inputs like extra env vars or build
+// arguments. Entries are separated by newlines, or by commas if the value is a single line. Whitespace around the
+// entries is trimmed, blank lines are skipped, and the values can contain `=`. Duplicate keys are invalid,
+// and so are empty values, unless allowEmpty is set.
+func setMapField(field reflect.Value, value string, allowEmpty bool) error {
+	if field.Type().Key().Kind() != reflect.String || field.Type().Elem().Kind() != reflect.String {
+		return fmt.Errorf("type is not supported (%s)", field.Type())
+	}
+
+	separator := ","
+	if strings.Contains(value, "\n") {
+		separator = "\n"
+	}
+
+	entries := reflect.MakeMap(field.Type())
+	for _, entry := range strings.Split(value, separator) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		idx := strings.Index(entry, "=")
+		if idx == -1 {
+			return fmt.Errorf("invalid entry (%s), expected KEY=value", entry)
+		}
+		key, val := strings.TrimSpace(entry[:idx]), strings.TrimSpace(entry[idx+1:])
+		if key == "" {
+			return fmt.Errorf("invalid entry (%s), the key is empty", entry)
+		}
+		if val == "" && !allowEmpty {
+			return fmt.Errorf("the value of %s is empty", key)
+		}
+		mapKey := reflect.ValueOf(key).Convert(field.Type().Key())
+		if entries.MapIndex(mapKey).IsValid() {
+			return fmt.Errorf("duplicate key (%s)", key)
+		}
+		entries.SetMapIndex(mapKey, reflect.ValueOf(val).Convert(field.Type().Elem()))
+	}
+
+	field.Set(entries)
+	return nil
+}
+
+func writeTempFile(value string) (string, error) {
+	file, err := os.CreateTemp("", "step-input-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	if _, err := file.WriteString(value); err != nil {
+		_ = file.Close()
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+func validateConstraint(value, constraint string, validatePath func(path string, dir bool) error) error {
 	switch constraint {
 	case "":
 		break
@@ -134,7 +411,7 @@ func validateConstraint(value, constraint string) error {
 			return errors.New("required variable is not present")
 		}
 	case "file", "dir":
-		if err := checkPath(value, constraint == "dir"); err != nil {
+		if err := validatePath(value, constraint == "dir"); err != nil {
 			return err
 		}
 	// TODO: use FindStringSubmatch to distinguish no match and match for empty string.
@@ -147,7 +424,7 @@ func validateConstraint(value, constraint string) error {
 		if err := validateRangeFields(value, constraint); err != nil {
 			return err
 		}
-	case multilineConstraintName:
+	case multilineConstraintName, tempFileConstraintName, base64ConstraintName, allowEmptyConstraintName:
 		break
 	default:
 		return fmt.Errorf("invalid constraint (%s)", constraint)
@@ -409,8 +686,11 @@ func getRegexGroupMap(groups []string, regex *regexp.Regexp) map[string]string {
 	return result
 }
 
+// statPath is replaced in tests to simulate hung file systems
+var statPath = os.Stat
+
 func checkPath(path string, dir bool) error {
-	file, err := os.Stat(path)
+	file, err := statPath(path)
 	if err != nil {
 		// TODO: check case when file exist but os.Stat fails.
 		return os.ErrNotExist
@@ -421,6 +701,31 @@ func checkPath(path string, dir bool) error {
 	return nil
 }
 
+// checkPathWithContext runs checkPath until ctx is done or the timeout (if positive) elapses.
+// The stat call itself can't be interrupted, so on timeout it is left running in the background.
+func checkPathWithContext(ctx context.Context, key, path string, dir bool, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- checkPath(path, dir)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &ValidationTimeoutError{Input: key, Path: path, Timeout: timeout}
+		}
+		return ctx.Err()
+	}
+}
+
 // contains reports whether s is within the value options, where value options
 // are parsed from opt, which format's is opt[item1,item2,item3]. If an option
 // contains commas, it should be single quoted (eg. opt[item1,'item2,item3']).
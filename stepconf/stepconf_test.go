@@ -1,8 +1,18 @@
 package stepconf
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/v2/stepconf/mocks"
 	"github.com/stretchr/testify/mock"
@@ -196,6 +206,32 @@ func TestRequired(t *testing.T) {
 	}
 }
 
+func TestHidden(t *testing.T) {
+	var c struct {
+		Hidden         string `env:"hidden,hidden"`
+		RequiredHidden string `env:"required_hidden,required,hidden"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "hidden").Return("value")
+	envGetter.On("Get", "required_hidden").Return("")
+
+	if err := parse(&c, envGetter); err == nil {
+		t.Error("no failure when required hidden env var is missing")
+	}
+
+	envGetter = new(mocks.Repository)
+	envGetter.On("Get", "hidden").Return("value")
+	envGetter.On("Get", "required_hidden").Return("set")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Errorf("failure when hidden env vars are set: %s", err)
+	}
+	if c.Hidden != "value" || c.RequiredHidden != "set" {
+		t.Errorf("hidden env vars are not parsed: %+v", c)
+	}
+}
+
 func TestValidatePath(t *testing.T) {
 	var c struct {
 		Path string `env:"path,file"`
@@ -288,6 +324,233 @@ func TestValueOptionsWithComma(t *testing.T) {
 	}
 }
 
+type codeSigningConfig struct {
+	Certificate string `env:"certificate,required"`
+	Passphrase  Secret `env:"passphrase"`
+}
+
+func TestOptionalSection(t *testing.T) {
+	var c struct {
+		Name        string             `env:"name"`
+		CodeSigning *codeSigningConfig `env:",section"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "name").Return("Example")
+	envGetter.On("Get", mock.Anything).Return("")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Errorf("failure when optional section inputs are not set: %s", err)
+	}
+	if c.CodeSigning != nil {
+		t.Errorf("expected nil, got %v", c.CodeSigning)
+	}
+
+	envGetter = new(mocks.Repository)
+	envGetter.On("Get", "certificate").Return("cert.p12")
+	envGetter.On("Get", mock.Anything).Return("")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Errorf("failure when optional section inputs are set: %s", err)
+	}
+	if c.CodeSigning == nil || c.CodeSigning.Certificate != "cert.p12" {
+		t.Errorf("expected %s, got %v", "cert.p12", c.CodeSigning)
+	}
+
+	c.CodeSigning = nil
+	envGetter = new(mocks.Repository)
+	envGetter.On("Get", "passphrase").Return("pass1234")
+	envGetter.On("Get", mock.Anything).Return("")
+
+	err := parse(&c, envGetter)
+	if err == nil {
+		t.Errorf("no failure when required input of a set optional section is missing")
+	} else if !strings.Contains(err.Error(), "CodeSigning.Certificate") {
+		t.Errorf("error doesn't reference the section field: %s", err)
+	}
+}
+
+type recursiveSection struct {
+	Value string            `env:"recursive_value"`
+	Next  *recursiveSection `env:",section"`
+}
+
+func TestOptionalSection_Recursive(t *testing.T) {
+	type untaggedNode struct {
+		Name string
+		Next *untaggedNode
+	}
+	var c struct {
+		Name      string `env:"name"`
+		Request   *http.Request
+		Node      *untaggedNode
+		Recursive *recursiveSection `env:",section"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "name").Return("Example")
+	envGetter.On("Get", mock.Anything).Return("")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Errorf("failure when untagged pointer-to-struct fields are present: %s", err)
+	}
+	if c.Request != nil || c.Node != nil || c.Recursive != nil {
+		t.Errorf("untagged or unset sections are allocated: %+v", c)
+	}
+	if keys, err := InputKeys(&c); err != nil || !reflect.DeepEqual(keys, []string{"name", "recursive_value"}) {
+		t.Errorf("InputKeys() = %v, %v", keys, err)
+	}
+
+	envGetter = new(mocks.Repository)
+	envGetter.On("Get", "recursive_value").Return("value")
+	envGetter.On("Get", mock.Anything).Return("")
+
+	err := parse(&c, envGetter)
+	if err == nil || !strings.Contains(err.Error(), "recursive input section") {
+		t.Errorf("no recursive section failure: %v", err)
+	}
+}
+
+type exportMethod string
+
+const (
+	exportMethodAppStore exportMethod = "app-store"
+	exportMethodAdHoc    exportMethod = "ad-hoc"
+)
+
+func (m *exportMethod) UnmarshalInput(value string) error {
+	switch exportMethod(value) {
+	case exportMethodAppStore, exportMethodAdHoc:
+		*m = exportMethod(value)
+		return nil
+	}
+	return fmt.Errorf("unknown export method: %s", value)
+}
+
+func TestInputUnmarshaler(t *testing.T) {
+	var c struct {
+		ExportMethod         exportMethod  `env:"export_method,opt[app-store,ad-hoc]"`
+		FallbackExportMethod *exportMethod `env:"fallback_export_method"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "export_method").Return("ad-hoc")
+	envGetter.On("Get", "fallback_export_method").Return("app-store")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Errorf("failure when value is a valid option: %s", err)
+	}
+	if c.ExportMethod != exportMethodAdHoc {
+		t.Errorf("expected %s, got %v", exportMethodAdHoc, c.ExportMethod)
+	}
+	if c.FallbackExportMethod == nil || *c.FallbackExportMethod != exportMethodAppStore {
+		t.Errorf("expected %s, got %v", exportMethodAppStore, c.FallbackExportMethod)
+	}
+
+	envGetter = new(mocks.Repository)
+	envGetter.On("Get", "export_method").Return("ad-hoc")
+	envGetter.On("Get", "fallback_export_method").Return("enterprise")
+
+	err := parse(&c, envGetter)
+	if err == nil {
+		t.Errorf("no failure when UnmarshalInput fails")
+	} else if !strings.Contains(err.Error(), "unknown export method: enterprise") {
+		t.Errorf("error doesn't contain the UnmarshalInput error: %s", err)
+	}
+}
+
+type keyValues map[string]string
+
+func (kv *keyValues) UnmarshalEnv(value string) error {
+	*kv = keyValues{}
+	for _, line := range strings.Split(value, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid key-value pair: %s", line)
+		}
+		(*kv)[parts[0]] = parts[1]
+	}
+	return nil
+}
+
+func TestUnmarshaler(t *testing.T) {
+	var c struct {
+		Labels   keyValues  `env:"labels,required"`
+		Optional *keyValues `env:"optional"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "labels").Return("os=macos\nstack=xcode-15")
+	envGetter.On("Get", "optional").Return("")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Errorf("failure when value is valid: %s", err)
+	}
+	if want := (keyValues{"os": "macos", "stack": "xcode-15"}); !reflect.DeepEqual(c.Labels, want) {
+		t.Errorf("expected %v, got %v", want, c.Labels)
+	}
+	if c.Optional != nil {
+		t.Errorf("expected nil, got %v", c.Optional)
+	}
+
+	envGetter = new(mocks.Repository)
+	envGetter.On("Get", "labels").Return("")
+	envGetter.On("Get", "optional").Return("invalid")
+
+	err := parse(&c, envGetter)
+	if err == nil {
+		t.Errorf("no failure when required value is empty and UnmarshalEnv fails")
+	} else {
+		if !strings.Contains(err.Error(), "required variable is not present") {
+			t.Errorf("error doesn't contain the required error: %s", err)
+		}
+		if !strings.Contains(err.Error(), "invalid key-value pair: invalid") {
+			t.Errorf("error doesn't contain the UnmarshalEnv error: %s", err)
+		}
+	}
+}
+
+func TestLargeInputs(t *testing.T) {
+	var c struct {
+		Script      io.Reader `env:"script"`
+		Certificate string    `env:"certificate,tempfile"`
+		Unset       io.Reader `env:"unset"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "script").Return("#!/bin/bash\necho hello")
+	envGetter.On("Get", "certificate").Return("-----BEGIN CERTIFICATE-----")
+	envGetter.On("Get", "unset").Return("")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Fatalf("failure when parsing large inputs: %s", err)
+	}
+
+	script, err := io.ReadAll(c.Script)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(script) != "#!/bin/bash\necho hello" {
+		t.Errorf("expected %s, got %s", "#!/bin/bash\necho hello", script)
+	}
+
+	defer os.Remove(c.Certificate) //nolint:errcheck
+	certificate, err := ioutil.ReadFile(c.Certificate)
+	if err != nil {
+		t.Fatalf("failed to read temporary file: %s", err)
+	}
+	if string(certificate) != "-----BEGIN CERTIFICATE-----" {
+		t.Errorf("expected %s, got %s", "-----BEGIN CERTIFICATE-----", certificate)
+	}
+
+	if c.Unset != nil {
+		t.Errorf("expected nil, got %v", c.Unset)
+	}
+	if str := toString(&c); strings.Contains(str, "echo hello") {
+		t.Errorf("reader content is printed: %s", str)
+	}
+}
+
 func Test_GetRangeValues(t *testing.T) {
 	tests := []struct {
 		value     string
@@ -397,3 +660,188 @@ func Test_ValidateRangeFields(t *testing.T) {
 		})
 	}
 }
+
+func TestParseWithContext_PathValidationTimeout(t *testing.T) {
+	var c struct {
+		Path string `env:"path,file"`
+	}
+
+	defer simulateHungStat()()
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "path").Return("/mnt/hung/file")
+	envGetter.On("Get", InputsFileEnvKey).Return("")
+
+	err := NewContextInputParser(envGetter, 10*time.Millisecond).ParseWithContext(context.Background(), &c)
+	var timeoutErr *ValidationTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a validation timeout error, got %v", err)
+	}
+	if timeoutErr.Input != "path" || timeoutErr.Path != "/mnt/hung/file" {
+		t.Errorf("timeout error doesn't reference the input: %#v", timeoutErr)
+	}
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Field != "Path" {
+		t.Errorf("error doesn't reference the field: %v", err)
+	}
+}
+
+func TestParseWithContext_Cancelled(t *testing.T) {
+	var c struct {
+		Dir string `env:"dir,dir"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "dir").Return(t.TempDir())
+	envGetter.On("Get", InputsFileEnvKey).Return("")
+
+	if err := NewContextInputParser(envGetter, 0).ParseWithContext(context.Background(), &c); err != nil {
+		t.Errorf("failure when dir does exist: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	defer simulateHungStat()()
+
+	err := NewContextInputParser(envGetter, 0).ParseWithContext(ctx, &c)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+// simulateHungStat makes the path validations hang until the returned function is called,
+// which releases them and restores statPath once they returned.
+func simulateHungStat() func() {
+	hung := make(chan struct{})
+	var calls sync.WaitGroup
+	calls.Add(1)
+	statPath = func(name string) (os.FileInfo, error) {
+		defer calls.Done()
+		<-hung
+		return nil, os.ErrNotExist
+	}
+	return func() {
+		close(hung)
+		calls.Wait()
+		statPath = os.Stat
+	}
+}
+
+func TestBase64Inputs(t *testing.T) {
+	var c struct {
+		Keystore []byte `env:"keystore,b64"`
+		Password Secret `env:"password,b64"`
+		Unset    []byte `env:"unset,b64"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "keystore").Return("AAEC\n/w==")
+	envGetter.On("Get", "password").Return("cGFzczEyMzQ=")
+	envGetter.On("Get", "unset").Return("")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Fatalf("failure when parsing base64 inputs: %s", err)
+	}
+	if !reflect.DeepEqual(c.Keystore, []byte{0, 1, 2, 255}) {
+		t.Errorf("expected %v, got %v", []byte{0, 1, 2, 255}, c.Keystore)
+	}
+	if c.Password != "pass1234" {
+		t.Errorf("expected %s, got %s", "pass1234", string(c.Password))
+	}
+	if c.Unset != nil {
+		t.Errorf("expected nil, got %v", c.Unset)
+	}
+	if str := toString(&c); !strings.Contains(str, "keystore: <4 bytes>") {
+		t.Errorf("decoded bytes are printed: %s", str)
+	}
+}
+
+func TestBase64Inputs_Invalid(t *testing.T) {
+	var c struct {
+		Keystore []byte `env:"keystore,b64"`
+		Count    int    `env:"count,b64"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "keystore").Return("AAEC*w==")
+	envGetter.On("Get", "count").Return("MQ==")
+
+	err := parse(&c, envGetter)
+	if err == nil {
+		t.Fatal("no failure when base64 inputs are invalid")
+	}
+	for _, want := range []string{"illegal data at position 4", "only supported for []byte and string fields"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't contain %q: %s", want, err)
+		}
+	}
+
+	if err := setBase64Field(reflect.ValueOf(&c.Keystore).Elem(), strings.Repeat("A", maxBase64DecodedSize*2)); err == nil {
+		t.Error("no failure when base64 input is too large")
+	}
+}
+
+func TestMapInputs(t *testing.T) {
+	type BuildArgs map[string]string
+	var c struct {
+		Env        map[string]string `env:"env"`
+		BuildArgs  BuildArgs         `env:"build_args"`
+		Properties map[string]string `env:"properties,allowempty"`
+		Unset      map[string]string `env:"unset"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "env").Return("FOO=bar\n\n  JAVA_OPTS=-Xmx2g -Dfile.encoding=UTF-8  \r\nLIST=a,b\n")
+	envGetter.On("Get", "build_args").Return("VERSION=1.2, CHANNEL=beta")
+	envGetter.On("Get", "properties").Return("org.gradle.caching=true,org.gradle.jvmargs=")
+	envGetter.On("Get", "unset").Return("")
+
+	if err := parse(&c, envGetter); err != nil {
+		t.Fatalf("failure when parsing map inputs: %s", err)
+	}
+	if want := map[string]string{"FOO": "bar", "JAVA_OPTS": "-Xmx2g -Dfile.encoding=UTF-8", "LIST": "a,b"}; !reflect.DeepEqual(c.Env, want) {
+		t.Errorf("expected %v, got %v", want, c.Env)
+	}
+	if want := (BuildArgs{"VERSION": "1.2", "CHANNEL": "beta"}); !reflect.DeepEqual(c.BuildArgs, want) {
+		t.Errorf("expected %v, got %v", want, c.BuildArgs)
+	}
+	if want := map[string]string{"org.gradle.caching": "true", "org.gradle.jvmargs": ""}; !reflect.DeepEqual(c.Properties, want) {
+		t.Errorf("expected %v, got %v", want, c.Properties)
+	}
+	if c.Unset != nil {
+		t.Errorf("expected nil, got %v", c.Unset)
+	}
+}
+
+func TestMapInputs_Invalid(t *testing.T) {
+	var c struct {
+		Duplicate map[string]string `env:"duplicate"`
+		Empty     map[string]string `env:"empty"`
+		NoValue   map[string]string `env:"no_value"`
+		NoKey     map[string]string `env:"no_key"`
+		Ints      map[string]int    `env:"ints"`
+	}
+
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "duplicate").Return("FOO=1\nBAR=2\nFOO=3")
+	envGetter.On("Get", "empty").Return("FOO=")
+	envGetter.On("Get", "no_value").Return("FOO")
+	envGetter.On("Get", "no_key").Return("=1")
+	envGetter.On("Get", "ints").Return("FOO=1")
+
+	err := parse(&c, envGetter)
+	if err == nil {
+		t.Fatal("no failure when map inputs are invalid")
+	}
+	for _, want := range []string{
+		"duplicate key (FOO)",
+		"the value of FOO is empty",
+		"invalid entry (FOO), expected KEY=value",
+		"invalid entry (=1), the key is empty",
+		"type is not supported (map[string]int)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't contain %q: %s", want, err)
+		}
+	}
+}
@@ -1,14 +1,30 @@
 package stepconf
 
-import "github.com/bitrise-io/go-utils/v2/env"
+import (
+	"context"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// DefaultPathValidationTimeout is the time limit of a single file or dir validation in ContextInputParser
+const DefaultPathValidationTimeout = 10 * time.Second
 
 // InputParser ...
 type InputParser interface {
 	Parse(input interface{}) error
 }
 
+// ContextInputParser is an InputParser whose file and dir validations can be cancelled, so that parsing can't hang
+// the step (for example on a hung network mount). A timed out validation fails with a *ValidationTimeoutError.
+type ContextInputParser interface {
+	InputParser
+	ParseWithContext(ctx context.Context, input interface{}) error
+}
+
 type inputParser struct {
-	envRepository env.Repository
+	envRepository         env.Repository
+	pathValidationTimeout time.Duration
 }
 
 // NewInputParser ...
@@ -18,7 +34,34 @@ func NewInputParser(envRepository env.Repository) InputParser {
 	}
 }
 
-// Parse ...
+// Parse populates the input struct from env vars. If STEP_INPUTS_FILE is set in the env repository, the values of
+// that inputs file take precedence over the env vars, see NewInputsFileRepository and ParseFromFile.
 func (p inputParser) Parse(input interface{}) error {
-	return parse(input, p.envRepository)
+	envRepository, err := NewInputsFileRepository(p.envRepository)
+	if err != nil {
+		return err
+	}
+	return parse(input, envRepository)
+}
+
+// NewContextInputParser returns a ContextInputParser that limits each file and dir validation to
+// pathValidationTimeout, or to DefaultPathValidationTimeout if it isn't positive.
+func NewContextInputParser(envRepository env.Repository, pathValidationTimeout time.Duration) ContextInputParser {
+	if pathValidationTimeout <= 0 {
+		pathValidationTimeout = DefaultPathValidationTimeout
+	}
+	return inputParser{
+		envRepository:         envRepository,
+		pathValidationTimeout: pathValidationTimeout,
+	}
+}
+
+// ParseWithContext is like Parse, but the file and dir validations give up when ctx is done
+// or when a single validation exceeds the path validation timeout.
+func (p inputParser) ParseWithContext(ctx context.Context, input interface{}) error {
+	envRepository, err := NewInputsFileRepository(p.envRepository)
+	if err != nil {
+		return err
+	}
+	return parseWithOptions(input, envRepository, parseOptions{ctx: ctx, pathValidationTimeout: p.pathValidationTimeout})
 }
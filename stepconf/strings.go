@@ -10,11 +10,27 @@ import (
 
 // Print the name of the struct with Title case in blue color with followed by a newline,
 // then print all fields formatted as `- field name: field value` separated by newline.
+// Fields with the hidden tag option (such as `env:"api_url,hidden"`) are left out.
 func Print(config interface{}) {
 	fmt.Print(toString(config))
 }
 
 func valueString(v reflect.Value) string {
+	if v.Type() == readerType {
+		if v.IsNil() {
+			return "<unset>"
+		}
+		return "<reader>"
+	}
+
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		// Decoded binary inputs (see the b64 option) are not printed
+		if v.Len() == 0 {
+			return "<unset>"
+		}
+		return fmt.Sprintf("<%d bytes>", v.Len())
+	}
+
 	if v.Kind() != reflect.Ptr {
 		if v.Kind() == reflect.String && v.Len() == 0 {
 			return "<unset>"
@@ -48,6 +64,9 @@ func toString(config interface{}) string {
 	str := fmt.Sprint(colorstring.Bluef("%s:\n", configName))
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
+		if isHiddenTag(field.Tag.Get("env")) {
+			continue
+		}
 		var key, _ = parseTag(field.Tag.Get("env"))
 		if key == "" {
 			key = field.Name
@@ -60,6 +60,7 @@ func Test_PrintFormat(t *testing.T) {
 		SensitiveInput       Secret `env:"sensitive_input"`
 		ValueOptionInput     string `env:"value_option_input,opt[first,second,third]"`
 		RequiredInput        string `env:"required_input,required"`
+		HiddenInput          string `env:"hidden_input,required,hidden"`
 	}
 
 	cfg := testConfig{
@@ -71,6 +72,7 @@ func Test_PrintFormat(t *testing.T) {
 		SensitiveInput:   "my secret",
 		ValueOptionInput: "second",
 		RequiredInput:    "value",
+		HiddenInput:      "internal value",
 	}
 
 	reader, writer, err := os.Pipe()
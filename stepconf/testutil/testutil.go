@@ -0,0 +1,121 @@
+// Package testutil helps testing step configs parsed by stepconf, without setting real env vars or mocking env.Repository.
+package testutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/stepconf"
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+type mapRepository struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// NewMapRepository returns an env.Repository backed by a copy of values
+func NewMapRepository(values map[string]string) env.Repository {
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return &mapRepository{values: copied}
+}
+
+// List returns the env vars in KEY=value form, sorted by key
+func (r *mapRepository) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var envs []string
+	for key, value := range r.values {
+		envs = append(envs, key+"="+value)
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+// Unset ...
+func (r *mapRepository) Unset(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.values, key)
+	return nil
+}
+
+// Get ...
+func (r *mapRepository) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.values[key]
+}
+
+// Set ...
+func (r *mapRepository) Set(key, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.values[key] = value
+	return nil
+}
+
+// MustParse parses the inputs into conf (a pointer to a config struct) and fails the test if parsing fails
+func MustParse(t testing.TB, inputs map[string]string, conf interface{}) {
+	t.Helper()
+
+	if err := stepconf.NewInputParser(NewMapRepository(inputs)).Parse(conf); err != nil {
+		t.Fatalf("failed to parse inputs: %s", err)
+	}
+}
+
+// RequireParseError parses the inputs into conf and fails the test if parsing succeeds,
+// or if any of the given config struct fields (such as `BuildNumber`, or `Section.Field` for optional sections)
+// is missing from the reported invalid fields. It returns the parse error for further assertions.
+func RequireParseError(t testing.TB, inputs map[string]string, conf interface{}, fields ...string) error {
+	t.Helper()
+
+	err := stepconf.NewInputParser(NewMapRepository(inputs)).Parse(conf)
+	if err == nil {
+		t.Fatalf("expected a parse error, got none")
+		return nil
+	}
+
+	for _, field := range fields {
+		if !strings.Contains(err.Error(), fmt.Sprintf("\n- %s: ", field)) {
+			t.Errorf("expected field %s to be invalid, parse error: %s", field, err)
+		}
+	}
+	return err
+}
+
+// SetInputs sets the inputs of the config struct in the repository for the rest of the test: the inputs (and any
+// other set values) are restored when the test finishes, see stepconf.TakeEnvSnapshot
+func SetInputs(t testing.TB, repo env.Repository, conf interface{}, inputs map[string]string) {
+	t.Helper()
+
+	keys := make([]string, 0, len(inputs))
+	for key := range inputs {
+		keys = append(keys, key)
+	}
+	snapshot, err := stepconf.TakeEnvSnapshot(repo, conf, keys...)
+	if err != nil {
+		t.Fatalf("failed to snapshot inputs: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := snapshot.Restore(); err != nil {
+			t.Errorf("failed to restore inputs: %s", err)
+		}
+	})
+
+	for key, value := range inputs {
+		if err := repo.Set(key, value); err != nil {
+			t.Fatalf("failed to set input %s: %s", key, err)
+		}
+	}
+}
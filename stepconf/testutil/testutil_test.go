@@ -0,0 +1,71 @@
+package testutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/stepconf"
+)
+
+type config struct {
+	Name        string `env:"name,required"`
+	BuildNumber int    `env:"build_number"`
+}
+
+func TestNewMapRepository(t *testing.T) {
+	values := map[string]string{"B": "2", "A": "1"}
+	repo := NewMapRepository(values)
+	values["C"] = "3"
+
+	if err := repo.Set("D", "4"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := repo.Unset("B"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got := repo.Get("A"); got != "1" {
+		t.Errorf("Get(A) = %s, want 1", got)
+	}
+	if want := []string{"A=1", "D=4"}; !reflect.DeepEqual(repo.List(), want) {
+		t.Errorf("List() = %v, want %v", repo.List(), want)
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	var conf config
+	MustParse(t, map[string]string{"name": "app", "build_number": "12"}, &conf)
+
+	if want := (config{Name: "app", BuildNumber: 12}); conf != want {
+		t.Errorf("parsed config = %+v, want %+v", conf, want)
+	}
+}
+
+func TestRequireParseError(t *testing.T) {
+	var conf config
+	err := RequireParseError(t, map[string]string{"build_number": "twelve"}, &conf, "Name", "BuildNumber")
+
+	if err == nil {
+		t.Errorf("expected the parse error to be returned")
+	}
+}
+
+func TestSetInputs(t *testing.T) {
+	repo := NewMapRepository(map[string]string{"name": "original"})
+
+	t.Run("with inputs", func(t *testing.T) {
+		SetInputs(t, repo, &config{}, map[string]string{"name": "modified", "build_number": "3"})
+
+		var cfg config
+		if err := stepconf.NewInputParser(repo).Parse(&cfg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if cfg.Name != "modified" || cfg.BuildNumber != 3 {
+			t.Errorf("parsed config = %+v, want the set inputs", cfg)
+		}
+	})
+
+	if want := []string{"name=original"}; !reflect.DeepEqual(repo.List(), want) {
+		t.Errorf("env after the test = %v, want %v", repo.List(), want)
+	}
+}
@@ -0,0 +1,81 @@
+package stepconf
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// validOptionsTagName is the struct tag naming the source of the runtime value options of an input:
+//
+//	Simulator string `env:"simulator,required" optfrom:"AVAILABLE_SIMULATORS"`
+//
+// The options are the lines of the named env var, or its `|` separated items if it's a single line.
+// If the config implements ValidOptionsProvider, it can supply the options of the source instead.
+const validOptionsTagName = "optfrom"
+
+// ValidOptionsProvider is implemented by configs whose value options are only known at runtime, such as the
+// available simulators or the configured flavors. ValidOptions returns the options of the source named by
+// an optfrom tag, and false to fall back to the env var of the same name. It is called while the config is parsed,
+// after the fields declared before the validated field are set.
+type ValidOptionsProvider interface {
+	ValidOptions(source string) ([]string, bool)
+}
+
+// validateValidOptions applies the optfrom validation of the field: like opt[], the value has to be one of the options
+func validateValidOptions(field reflect.StructField, value string, envRepository env.Repository, provider ValidOptionsProvider) error {
+	source, ok := field.Tag.Lookup(validOptionsTagName)
+	if !ok {
+		return nil
+	}
+	if source == "" {
+		return fmt.Errorf("%s tag is empty", validOptionsTagName)
+	}
+
+	options, ok := []string(nil), false
+	if provider != nil {
+		options, ok = provider.ValidOptions(source)
+	}
+	if !ok {
+		options = parseValidOptions(envRepository.Get(source))
+	}
+	if len(options) == 0 {
+		return fmt.Errorf("no value options available (%s)", source)
+	}
+
+	for _, option := range options {
+		if option == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("value is not in value options (%s)", formatValidOptions(options))
+}
+
+func parseValidOptions(value string) []string {
+	separator := "|"
+	if strings.Contains(value, "\n") {
+		separator = "\n"
+	}
+
+	var options []string
+	for _, option := range strings.Split(value, separator) {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// formatValidOptions formats the options like an opt[] constraint, so that both validations fail with the same message
+func formatValidOptions(options []string) string {
+	quoted := make([]string, 0, len(options))
+	for _, option := range options {
+		if strings.Contains(option, ",") {
+			option = "'" + option + "'"
+		}
+		quoted = append(quoted, option)
+	}
+	return "opt[" + strings.Join(quoted, ",") + "]"
+}
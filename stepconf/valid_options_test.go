@@ -0,0 +1,91 @@
+package stepconf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/stepconf/mocks"
+)
+
+type simulatorConfig struct {
+	Platform  string `env:"platform,opt[iOS,tvOS]"`
+	Simulator string `env:"simulator" optfrom:"SIMULATORS"`
+	Flavor    string `env:"flavor" optfrom:"FLAVORS"`
+}
+
+// ValidOptions lists the flavors of the selected platform, the simulators come from the env
+func (c *simulatorConfig) ValidOptions(source string) ([]string, bool) {
+	if source != "FLAVORS" {
+		return nil, false
+	}
+	if c.Platform == "tvOS" {
+		return []string{"tv"}, true
+	}
+	return []string{"free", "paid, with ads"}, true
+}
+
+func TestValidOptions(t *testing.T) {
+	envGetter := new(mocks.Repository)
+	envGetter.On("Get", "platform").Return("iOS")
+	envGetter.On("Get", "simulator").Return("iPhone 15")
+	envGetter.On("Get", "SIMULATORS").Return("iPhone 15\niPad Air\n")
+	envGetter.On("Get", "flavor").Return("paid, with ads")
+
+	var c simulatorConfig
+	if err := parse(&c, envGetter); err != nil {
+		t.Fatalf("failure when parsing inputs with runtime options: %s", err)
+	}
+	if c.Simulator != "iPhone 15" || c.Flavor != "paid, with ads" {
+		t.Errorf("unexpected config: %+v", c)
+	}
+}
+
+func TestValidOptions_Invalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		platform   string
+		simulators string
+		wantErr    string
+	}{
+		{
+			name:       "value is not an option",
+			platform:   "iOS",
+			simulators: "iPhone 15|iPad Air",
+			wantErr:    "Simulator: iPhone 8: value is not in value options (opt[iPhone 15,iPad Air])",
+		},
+		{
+			name:       "options depend on an other input",
+			platform:   "tvOS",
+			simulators: "iPhone 8",
+			wantErr:    "Flavor: free: value is not in value options (opt[tv])",
+		},
+		{
+			name:     "no options",
+			platform: "iOS",
+			wantErr:  "Simulator: iPhone 8: no value options available (SIMULATORS)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envGetter := new(mocks.Repository)
+			envGetter.On("Get", "platform").Return(tt.platform)
+			envGetter.On("Get", "simulator").Return("iPhone 8")
+			envGetter.On("Get", "SIMULATORS").Return(tt.simulators)
+			envGetter.On("Get", "flavor").Return("free")
+
+			var c simulatorConfig
+			err := parse(&c, envGetter)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected ParseError, got %s", err)
+			}
+			if !strings.Contains(err.Error(), "\n- "+tt.wantErr+"\n") {
+				t.Errorf("expected error to contain %q, got %s", tt.wantErr, err)
+			}
+		})
+	}
+}